@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/shawntz/cassh/internal/config"
+)
+
+// runConfigCommand runs `cassh config <subcommand>` and returns the exit code
+func runConfigCommand(args []string) int {
+	if len(args) == 0 || args[0] != "lint" {
+		fmt.Fprintln(os.Stderr, "usage: cassh config lint [--policy FILE] [--user FILE]")
+		return 2
+	}
+	return runConfigLint(args[1:])
+}
+
+// runConfigLint prints every problem in the given config files
+// With no flags it checks the policy and user config this machine would load
+func runConfigLint(args []string) int {
+	fs := flag.NewFlagSet("config lint", flag.ExitOnError)
+	policyPath := fs.String("policy", "", "Client policy file")
+	userPath := fs.String("user", "", "User config file")
+	_ = fs.Parse(args)
+
+	type lintFile struct {
+		path string
+		lint func(string) (config.Problems, error)
+	}
+	var files []lintFile
+	if *policyPath != "" {
+		files = append(files, lintFile{*policyPath, config.LintPolicy})
+	}
+	if *userPath != "" {
+		files = append(files, lintFile{*userPath, config.LintUserConfig})
+	}
+	if len(files) == 0 {
+		if path := config.PolicyPath(); fileExists(path) {
+			files = append(files, lintFile{path, config.LintPolicy})
+		}
+		if path := config.DotfilesConfigPath(); fileExists(path) {
+			files = append(files, lintFile{path, config.LintUserConfig})
+		} else if path, err := config.UserConfigPath(); err == nil && fileExists(path) {
+			files = append(files, lintFile{path, config.LintUserConfig})
+		}
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "No config files found; pass --policy or --user (check cassh-server config with `cassh-server config lint`)")
+		return 2
+	}
+
+	exitCode := 0
+	for _, file := range files {
+		problems, err := file.lint(file.path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %s: %v\n", file.path, err)
+			exitCode = 1
+			continue
+		}
+		if len(problems) == 0 {
+			fmt.Printf("✅ %s\n", file.path)
+			continue
+		}
+		exitCode = 1
+		for _, problem := range problems {
+			if problem.Line > 0 {
+				fmt.Printf("%s:%d: %s\n", file.path, problem.Line, problem.Message)
+			} else {
+				fmt.Printf("%s: %s\n", file.path, problem.Message)
+			}
+		}
+	}
+	return exitCode
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
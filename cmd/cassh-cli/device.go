@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// deviceAuthorization is the server's response to /api/v1/device/authorize
+type deviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// Poll results that keep the device flow waiting
+var (
+	errDevicePending  = errors.New("authorization pending")
+	errDeviceSlowDown = errors.New("slow down")
+)
+
+// deviceFlow gets a cert without a local browser (e.g., over SSH)
+// The user approves the code on any device; we poll the server until the cert is ready
+func deviceFlow(pubKeyData []byte) (string, error) {
+	auth, err := startDeviceAuthorization(pubKeyData)
+	if err != nil {
+		return "", err
+	}
+
+	// The code must reach the user even when stdout is JSON
+	out := os.Stdout
+	if outputJSON {
+		out = os.Stderr
+	}
+	fmt.Fprintln(out, "\n📱 To sign in, open this page on any device:")
+	fmt.Fprintf(out, "   %s\n", auth.VerificationURI)
+	fmt.Fprintf(out, "   and enter the code: %s\n", auth.UserCode)
+	fmt.Fprintf(out, "\n   Or open: %s\n", auth.VerificationURIComplete)
+	fmt.Fprintln(out, "\n⏳ Waiting for approval...")
+
+	interval := time.Duration(auth.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(auth.ExpiresIn) * time.Second)
+
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+
+		cert, err := pollDeviceToken(auth.DeviceCode)
+		switch {
+		case err == nil:
+			return cert, nil
+		case errors.Is(err, errDevicePending):
+			continue
+		case errors.Is(err, errDeviceSlowDown):
+			// RFC 8628 section 3.5: back off by 5 seconds
+			interval += 5 * time.Second
+			continue
+		default:
+			return "", err
+		}
+	}
+
+	return "", fmt.Errorf("device code expired before it was approved")
+}
+
+func startDeviceAuthorization(pubKeyData []byte) (*deviceAuthorization, error) {
+	body, _ := json.Marshal(map[string]string{
+		"public_key":     strings.TrimSpace(string(pubKeyData)),
+		"template":       template,
+		"target":         target,
+		"device_name":    deviceName(),
+		"client_version": clientVersion(),
+	})
+
+	resp, err := http.Post(serverURL+"/api/v1/device/authorize", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to contact server: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("device authorization failed: %s", readError(resp))
+	}
+
+	var auth deviceAuthorization
+	if err := json.NewDecoder(resp.Body).Decode(&auth); err != nil {
+		return nil, fmt.Errorf("invalid device authorization response: %w", err)
+	}
+	return &auth, nil
+}
+
+func pollDeviceToken(deviceCode string) (string, error) {
+	body, _ := json.Marshal(map[string]string{"device_code": deviceCode})
+
+	resp, err := http.Post(serverURL+"/api/v1/device/token", "application/json", bytes.NewReader(body))
+	if err != nil {
+		// Transient network errors shouldn't abort the flow
+		return "", errDevicePending
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	// The server's per-IP poll limit; treat it like slow_down
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return "", errDeviceSlowDown
+	}
+
+	var result struct {
+		Certificate      string `json:"certificate"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("invalid token response (HTTP %d)", resp.StatusCode)
+	}
+
+	switch result.Error {
+	case "":
+		if result.Certificate == "" {
+			return "", fmt.Errorf("server returned no certificate")
+		}
+		return result.Certificate, nil
+	case "authorization_pending":
+		return "", errDevicePending
+	case "slow_down":
+		return "", errDeviceSlowDown
+	case "access_denied":
+		if result.ErrorDescription != "" {
+			return "", fmt.Errorf("access denied: %s", result.ErrorDescription)
+		}
+		return "", fmt.Errorf("access denied")
+	case "expired_token":
+		return "", fmt.Errorf("device code expired before it was approved")
+	default:
+		return "", fmt.Errorf("device authorization failed: %s", result.Error)
+	}
+}
+
+// readError extracts the error message from a JSON error response
+// Rate-limited responses say how long to wait
+func readError(resp *http.Response) string {
+	var result struct {
+		Error string `json:"error"`
+	}
+	msg := resp.Status
+	if err := json.NewDecoder(resp.Body).Decode(&result); err == nil && result.Error != "" {
+		msg = result.Error
+	}
+	if retry := resp.Header.Get("Retry-After"); resp.StatusCode == http.StatusTooManyRequests && retry != "" {
+		msg += fmt.Sprintf(" (try again in %ss)", retry)
+	}
+	return msg
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/shawntz/cassh/internal/ca"
+	"github.com/shawntz/cassh/internal/config"
+	"golang.org/x/crypto/ssh"
+)
+
+// runHostCommand runs `cassh-cli host <subcommand>` and returns the exit code
+func runHostCommand(args []string) int {
+	if len(args) == 0 || args[0] != "renew" {
+		fmt.Fprintln(os.Stderr, "usage: cassh-cli host renew [flags]")
+		return 2
+	}
+
+	fs := flag.NewFlagSet("host renew", flag.ExitOnError)
+	server := fs.String("server", "", "cassh server URL (or set CASSH_SERVER)")
+	key := fs.String("key", "/etc/ssh/ssh_host_ed25519_key", "Host private key path")
+	cert := fs.String("cert", "", "Host certificate path (default: <key>-cert.pub)")
+	token := fs.String("bootstrap-token", "", "Bootstrap token or @FILE, used when there is no valid cert yet (or set CASSH_HOST_BOOTSTRAP_TOKEN)")
+	hostnames := fs.String("hostnames", "", "Comma-separated hostnames to request on enrollment (default: all registered)")
+	renewBefore := fs.Duration("renew-before", 7*24*time.Hour, "Only renew when the current cert expires within this window")
+	force := fs.Bool("force", false, "Renew even if the current cert is not close to expiring")
+	_ = fs.Parse(args[1:])
+
+	if *server == "" {
+		*server = os.Getenv("CASSH_SERVER")
+		if *server == "" {
+			if policy, err := config.LoadEffectivePolicy(config.PolicyPath()); err == nil {
+				*server = policy.ServerBaseURL
+			}
+		}
+	}
+	if *server == "" {
+		fmt.Fprintln(os.Stderr, "Server URL required. Use --server or set CASSH_SERVER")
+		return 1
+	}
+	if *cert == "" {
+		*cert = *key + "-cert.pub"
+	}
+	if *token == "" {
+		*token = os.Getenv("CASSH_HOST_BOOTSTRAP_TOKEN")
+	}
+
+	keyData, err := os.ReadFile(*key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read host key: %v\n", err)
+		return 1
+	}
+	signer, err := ssh.ParsePrivateKey(keyData)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to parse host key: %v\n", err)
+		return 1
+	}
+
+	// Renew with the current cert while it's valid; otherwise enroll with the bootstrap token
+	var issued string
+	current := currentHostCert(*cert, signer.PublicKey())
+	switch {
+	case current != nil && !*force && time.Until(time.Unix(int64(current.ValidBefore), 0)) > *renewBefore:
+		fmt.Printf("Host certificate is valid until %s, nothing to do\n", time.Unix(int64(current.ValidBefore), 0).Format(time.RFC3339))
+		return 0
+	case current != nil:
+		issued, err = renewHostCert(*server, current, signer)
+	case *token != "":
+		issued, err = bootstrapHostCert(*server, *token, signer.PublicKey(), splitHostnames(*hostnames))
+	default:
+		err = fmt.Errorf("no valid host certificate at %s; pass --bootstrap-token to enroll", *cert)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Host certificate renewal failed: %v\n", err)
+		return 1
+	}
+
+	parsed, err := ca.ParseCertificate([]byte(issued))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid host certificate: %v\n", err)
+		return 1
+	}
+	if err := os.WriteFile(*cert, []byte(issued+"\n"), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write host certificate: %v\n", err)
+		return 1
+	}
+
+	info := ca.GetCertInfo(parsed)
+	fmt.Printf("✅ Host certificate written to %s\n", *cert)
+	fmt.Printf("   Hostnames: %v\n", info.Principals)
+	fmt.Printf("   Expires:   %s\n", info.ValidBefore.Format(time.RFC3339))
+	fmt.Printf("   Make sure sshd_config has: HostCertificate %s\n", *cert)
+	return 0
+}
+
+// currentHostCert returns the host cert at path if it's unexpired and for hostKey, or nil
+func currentHostCert(path string, hostKey ssh.PublicKey) *ssh.Certificate {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	cert, err := ca.ParseCertificate(data)
+	if err != nil || cert.CertType != ssh.HostCert {
+		return nil
+	}
+	if !bytes.Equal(cert.Key.Marshal(), hostKey.Marshal()) {
+		return nil
+	}
+	if time.Now().After(time.Unix(int64(cert.ValidBefore), 0)) {
+		return nil
+	}
+	return cert
+}
+
+// bootstrapHostCert enrolls a machine with its bootstrap token
+func bootstrapHostCert(server, token string, hostKey ssh.PublicKey, hostnames []string) (string, error) {
+	if strings.HasPrefix(token, "@") {
+		data, err := os.ReadFile(token[1:])
+		if err != nil {
+			return "", fmt.Errorf("failed to read bootstrap token: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"public_key": strings.TrimSpace(string(ssh.MarshalAuthorizedKey(hostKey))),
+		"hostnames":  hostnames,
+	})
+	req, err := http.NewRequest(http.MethodPost, server+"/api/v1/host/cert", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return postHostRequest(req)
+}
+
+// renewHostCert trades the current host cert for a fresh one, signing the request with the host key
+func renewHostCert(server string, current *ssh.Certificate, signer ssh.Signer) (string, error) {
+	renewal, err := ca.NewHostRenewalRequest(current, signer)
+	if err != nil {
+		return "", err
+	}
+	body, _ := json.Marshal(renewal)
+	req, err := http.NewRequest(http.MethodPost, server+"/api/v1/host/renew", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	return postHostRequest(req)
+}
+
+func postHostRequest(req *http.Request) (string, error) {
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to contact server: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s", readError(resp))
+	}
+
+	var result struct {
+		Certificate string `json:"certificate"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || result.Certificate == "" {
+		return "", fmt.Errorf("invalid host certificate response")
+	}
+	return result.Certificate, nil
+}
+
+func splitHostnames(value string) []string {
+	var out []string
+	for _, hostname := range strings.Split(value, ",") {
+		if hostname = strings.TrimSpace(hostname); hostname != "" {
+			out = append(out, hostname)
+		}
+	}
+	return out
+}
@@ -5,6 +5,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -20,13 +21,25 @@ import (
 	"github.com/shawntz/cassh/internal/config"
 )
 
+// Set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
 var (
-	serverURL  string
-	keyPath    string
-	certPath   string
-	outputJSON bool
-	showStatus bool
-	autoAdd    bool
+	serverURL   string
+	keyPath     string
+	certPath    string
+	outputJSON  bool
+	showStatus  bool
+	autoAdd     bool
+	template    string
+	target      string
+	useBrowser  bool
+	useLoopback bool
+
+	workloadToken    string
+	workloadAudience string
+
+	clientPolicy *config.PolicyConfig // nil without a policy file
 )
 
 func init() {
@@ -36,9 +49,28 @@ func init() {
 	flag.BoolVar(&outputJSON, "json", false, "Output in JSON format")
 	flag.BoolVar(&showStatus, "status", false, "Show current certificate status")
 	flag.BoolVar(&autoAdd, "add", true, "Automatically add key to ssh-agent")
+	flag.StringVar(&template, "template", "", "Certificate template to request (e.g., bastion)")
+	flag.StringVar(&target, "target", "", "Target the certificate is for (e.g., a GitHub org), used to pick the signing CA")
+	flag.BoolVar(&useBrowser, "browser", false, "Open a browser and receive the cert via cassh.app instead of using a device code")
+	flag.BoolVar(&useLoopback, "loopback", false, "Sign in with the identity provider directly from a local browser (if the server allows it)")
+	flag.StringVar(&workloadToken, "workload-token", "", "CI identity token: a JWT, @FILE, or \"github-actions\" (or set CASSH_WORKLOAD_TOKEN)")
+	flag.StringVar(&workloadAudience, "workload-audience", "cassh", "Audience to request with --workload-token github-actions")
 }
 
 func main() {
+	// Subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "host":
+			// Host cert management for sshd (run as root on the server)
+			os.Exit(runHostCommand(os.Args[2:]))
+		case "policy":
+			os.Exit(runPolicyCommand(os.Args[2:]))
+		case "config":
+			os.Exit(runConfigCommand(os.Args[2:]))
+		}
+	}
+
 	flag.Parse()
 	log.SetFlags(0)
 
@@ -56,15 +88,9 @@ func main() {
 	if certPath == "" {
 		certPath = userCfg.SSHCertPath
 	}
-	if serverURL == "" {
-		serverURL = os.Getenv("CASSH_SERVER")
-		if serverURL == "" {
-			// Try to load from policy
-			policy, err := config.LoadPolicy(config.PolicyPath())
-			if err == nil {
-				serverURL = policy.ServerBaseURL
-			}
-		}
+
+	if workloadToken == "" {
+		workloadToken = os.Getenv("CASSH_WORKLOAD_TOKEN")
 	}
 
 	if showStatus {
@@ -72,6 +98,20 @@ func main() {
 		return
 	}
 
+	if serverURL == "" {
+		serverURL = os.Getenv("CASSH_SERVER")
+	}
+
+	// Bundled policy, or the newer signed one the server publishes
+	policy, err := loadPolicy(serverURL)
+	if errors.Is(err, config.ErrPolicySignature) {
+		fatal("%v", err)
+	}
+	clientPolicy = policy
+	if serverURL == "" && policy != nil {
+		serverURL = policy.ServerBaseURL
+	}
+
 	// Validate required params
 	if serverURL == "" {
 		fatal("Server URL required. Use --server or set CASSH_SERVER")
@@ -82,6 +122,54 @@ func main() {
 	}
 }
 
+// loadPolicy returns the effective policy after checking server for a newer signed one
+// server may be empty, in which case the policy's own server_base_url is asked
+func loadPolicy(server string) (*config.PolicyConfig, error) {
+	policy, err := config.LoadEffectivePolicy(config.PolicyPath())
+	if errors.Is(err, config.ErrPolicySignature) {
+		return nil, err
+	}
+	if server == "" && policy != nil {
+		server = policy.ServerBaseURL
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	changed, refreshErr := config.RefreshPolicy(ctx, server)
+	if refreshErr != nil {
+		fmt.Fprintf(os.Stderr, "⚠️  Could not refresh policy from server: %v\n", refreshErr)
+	}
+	if changed {
+		return config.LoadEffectivePolicy(config.PolicyPath())
+	}
+	return policy, err
+}
+
+// deviceName is this machine's name, which the server may put in the cert's key ID
+func deviceName() string {
+	name, _ := os.Hostname()
+	return name
+}
+
+// clientVersion identifies this client in cert key IDs
+func clientVersion() string {
+	return "cassh-cli/" + version
+}
+
+// keyIDFields decodes a cert's key ID with the policy's key_id_format, or nil if it doesn't match
+// Only the local policy is read, so --status works offline
+func keyIDFields(keyID string) ca.KeyIDFields {
+	var format string
+	if policy, err := config.LoadEffectivePolicy(config.PolicyPath()); err == nil {
+		format = policy.KeyIDFormat
+	}
+	fields, err := ca.ParseKeyID(format, keyID)
+	if err != nil {
+		return nil
+	}
+	return fields
+}
+
 func displayStatus() {
 	certData, err := os.ReadFile(certPath)
 	if err != nil {
@@ -114,14 +202,17 @@ func displayStatus() {
 
 	if outputJSON {
 		outputResult(map[string]interface{}{
-			"valid":        !info.IsExpired,
-			"expires_at":   info.ValidBefore,
-			"time_left":    info.TimeLeft.String(),
-			"key_id":       info.KeyID,
-			"principals":   info.Principals,
-			"serial":       info.Serial,
-			"valid_after":  info.ValidAfter,
-			"valid_before": info.ValidBefore,
+			"valid":         !info.IsExpired,
+			"expires_at":    info.ValidBefore,
+			"time_left":     info.TimeLeft.String(),
+			"key_id":        info.KeyID,
+			"key_id_fields": keyIDFields(info.KeyID),
+			"principals":    info.Principals,
+			"github_host":   info.GitHubHost,
+			"github_login":  info.GitHubLogin,
+			"serial":        info.Serial,
+			"valid_after":   info.ValidAfter,
+			"valid_before":  info.ValidBefore,
 		})
 	} else {
 		if info.IsExpired {
@@ -130,7 +221,17 @@ func displayStatus() {
 			fmt.Println("✅ Certificate valid")
 		}
 		fmt.Printf("   Key ID:     %s\n", info.KeyID)
+		if fields := keyIDFields(info.KeyID); fields != nil {
+			for _, name := range ca.KeyIDFieldNames {
+				if value := fields[name]; value != "" {
+					fmt.Printf("     %-15s %s\n", name+":", value)
+				}
+			}
+		}
 		fmt.Printf("   Principals: %v\n", info.Principals)
+		if info.GitHubLogin != "" {
+			fmt.Printf("   GitHub:     %s@%s\n", info.GitHubLogin, info.GitHubHost)
+		}
 		fmt.Printf("   Valid:      %s - %s\n",
 			info.ValidAfter.Format(time.RFC3339),
 			info.ValidBefore.Format(time.RFC3339))
@@ -163,45 +264,29 @@ func generateCert() error {
 		fmt.Printf("   Key:    %s\n", keyPath)
 	}
 
-	// For CLI mode, we use device code flow
-	// Open browser for auth (interactive mode)
-	authURL := fmt.Sprintf("%s/auth/start?pubkey=%s",
-		serverURL,
-		url.QueryEscape(string(pubKeyData)),
-	)
-
-	if !outputJSON {
-		fmt.Println("\n📱 Opening browser for authentication...")
-		fmt.Println("   If browser doesn't open, visit:")
-		fmt.Printf("   %s\n", authURL)
+	var cert string
+	switch {
+	case workloadToken != "":
+		cert, err = workloadFlow(pubKeyData)
+	case useLoopback:
+		cert, err = loopbackFlow(pubKeyData)
+	case useBrowser:
+		cert, err = browserFlow(pubKeyData)
+	default:
+		cert, err = deviceFlow(pubKeyData)
 	}
-
-	// Try to open browser
-	openBrowser(authURL)
-
-	// Poll local loopback for certificate (if menubar is running)
-	// or wait for manual paste
-	if !outputJSON {
-		fmt.Println("\n⏳ Waiting for certificate...")
-		fmt.Println("   Complete authentication in browser, then either:")
-		fmt.Println("   1. Click 'Auto-Install' button (if cassh.app is running)")
-		fmt.Println("   2. Copy certificate and paste below, then press Enter twice:")
+	if err != nil {
+		return err
 	}
 
-	// Try polling loopback first
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-	defer cancel()
-
-	cert, err := pollForCert(ctx)
-	if err != nil {
-		// Fall back to manual input
-		if !outputJSON {
-			cert, err = readCertFromStdin()
-			if err != nil {
-				return err
-			}
-		} else {
-			return fmt.Errorf("certificate not received: %w", err)
+	// Refuse certs from a CA the policy doesn't trust
+	if clientPolicy != nil {
+		parsed, err := ca.ParseCertificate([]byte(cert))
+		if err != nil {
+			return fmt.Errorf("invalid certificate: %w", err)
+		}
+		if err := clientPolicy.CheckCertAuthority(parsed); err != nil {
+			return err
 		}
 	}
 
@@ -245,6 +330,57 @@ func generateCert() error {
 	return nil
 }
 
+// browserFlow signs in through the browser on this machine
+// The cert comes back through cassh.app's loopback listener, or is pasted in by hand
+func browserFlow(pubKeyData []byte) (string, error) {
+	authURL := fmt.Sprintf("%s/auth/start?pubkey=%s",
+		serverURL,
+		url.QueryEscape(string(pubKeyData)),
+	)
+	if template != "" {
+		authURL += "&template=" + url.QueryEscape(template)
+	}
+	if target != "" {
+		authURL += "&target=" + url.QueryEscape(target)
+	}
+	if name := deviceName(); name != "" {
+		authURL += "&device_name=" + url.QueryEscape(name)
+	}
+	authURL += "&client_version=" + url.QueryEscape(clientVersion())
+
+	if !outputJSON {
+		fmt.Println("\n📱 Opening browser for authentication...")
+		fmt.Println("   If browser doesn't open, visit:")
+		fmt.Printf("   %s\n", authURL)
+	}
+
+	// Try to open browser
+	openBrowser(authURL)
+
+	// Poll local loopback for certificate (if menubar is running)
+	// or wait for manual paste
+	if !outputJSON {
+		fmt.Println("\n⏳ Waiting for certificate...")
+		fmt.Println("   Complete authentication in browser, then either:")
+		fmt.Println("   1. Click 'Auto-Install' button (if cassh.app is running)")
+		fmt.Println("   2. Copy certificate and paste below, then press Enter twice:")
+	}
+
+	// Try polling loopback first
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	cert, err := pollForCert(ctx)
+	if err != nil {
+		// Fall back to manual input
+		if outputJSON {
+			return "", fmt.Errorf("certificate not received: %w", err)
+		}
+		return readCertFromStdin()
+	}
+	return cert, nil
+}
+
 func pollForCert(ctx context.Context) (string, error) {
 	ticker := time.NewTicker(2 * time.Second)
 	defer ticker.Stop()
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/shawntz/cassh/internal/ca"
+	"github.com/shawntz/cassh/internal/oidc"
+)
+
+// loopbackFlow signs in with the identity provider directly, as a native public client
+// The browser redirects back to a listener on 127.0.0.1 and the ID token is exchanged for a cert
+func loopbackFlow(pubKeyData []byte) (string, error) {
+	pubKey, err := ca.ParsePublicKey(pubKeyData)
+	if err != nil {
+		return "", fmt.Errorf("invalid public key: %w", err)
+	}
+
+	client, err := fetchNativeClient()
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	// The sign-in link must reach the user even when stdout is JSON
+	out := os.Stdout
+	if outputJSON {
+		out = os.Stderr
+	}
+
+	// The nonce binds the ID token to this key, so the server won't accept it for any other
+	rawIDToken, err := oidc.LoopbackLogin(ctx, client, oidc.KeyNonce(pubKey), func(authURL string) {
+		fmt.Fprintln(out, "\n📱 Opening browser to sign in...")
+		fmt.Fprintln(out, "   If browser doesn't open, visit:")
+		fmt.Fprintf(out, "   %s\n", authURL)
+		fmt.Fprintln(out, "\n⏳ Waiting for sign-in...")
+		openBrowser(authURL)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	body, _ := json.Marshal(map[string]string{
+		"public_key":     strings.TrimSpace(string(pubKeyData)),
+		"template":       template,
+		"target":         target,
+		"device_name":    deviceName(),
+		"client_version": clientVersion(),
+	})
+
+	req, err := http.NewRequest(http.MethodPost, serverURL+"/api/v1/oidc/cert", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+rawIDToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to contact server: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("issuance failed: %s", readError(resp))
+	}
+
+	var result struct {
+		Certificate string `json:"certificate"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || result.Certificate == "" {
+		return "", fmt.Errorf("invalid issuance response")
+	}
+	return result.Certificate, nil
+}
+
+// fetchNativeClient asks the server which provider and client ID to sign in with
+func fetchNativeClient() (*oidc.NativeClient, error) {
+	resp, err := http.Get(serverURL + "/api/v1/oidc/native")
+	if err != nil {
+		return nil, fmt.Errorf("failed to contact server: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("this server doesn't allow signing in from the CLI directly (use the default device code flow)")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get sign-in settings: %s", readError(resp))
+	}
+
+	var client oidc.NativeClient
+	if err := json.NewDecoder(resp.Body).Decode(&client); err != nil || client.Issuer == "" || client.ClientID == "" {
+		return nil, fmt.Errorf("invalid sign-in settings from server")
+	}
+	return &client, nil
+}
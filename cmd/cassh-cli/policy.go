@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/shawntz/cassh/internal/config"
+	"golang.org/x/crypto/ssh"
+)
+
+// runPolicyCommand runs `cassh policy <subcommand>` and returns the exit code
+// IT signs the policy when building the bundle; clients built with the pinned key verify it on load
+func runPolicyCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: cassh policy <sign|verify> [flags] [POLICY_FILE]")
+		return 2
+	}
+
+	switch args[0] {
+	case "sign":
+		return runPolicySign(args[1:])
+	case "verify":
+		return runPolicyVerify(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown policy command %q\n", args[0])
+		return 2
+	}
+}
+
+// runPolicySign embeds an SSHSIG signature in the policy file
+func runPolicySign(args []string) int {
+	fs := flag.NewFlagSet("policy sign", flag.ExitOnError)
+	keyPath := fs.String("key", "", "Policy signing private key (Ed25519 recommended); passphrase from CASSH_POLICY_KEY_PASSPHRASE")
+	output := fs.String("out", "", "Where to write the signed policy (default: overwrite the input)")
+	_ = fs.Parse(args)
+
+	if *keyPath == "" {
+		fmt.Fprintln(os.Stderr, "--key is required")
+		return 2
+	}
+	policyPath := fs.Arg(0)
+	if policyPath == "" {
+		policyPath = "cassh.policy.toml"
+	}
+	if *output == "" {
+		*output = policyPath
+	}
+
+	signer, err := loadPolicySigner(*keyPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load signing key: %v\n", err)
+		return 1
+	}
+
+	data, err := os.ReadFile(policyPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read policy: %v\n", err)
+		return 1
+	}
+	signed, err := config.SignPolicy(data, signer)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to sign policy: %v\n", err)
+		return 1
+	}
+	if err := os.WriteFile(*output, signed, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write policy: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("✅ Signed %s with %s\n", *output, ssh.FingerprintSHA256(signer.PublicKey()))
+	return 0
+}
+
+// runPolicyVerify checks the policy file's signature against the pinned key
+func runPolicyVerify(args []string) int {
+	fs := flag.NewFlagSet("policy verify", flag.ExitOnError)
+	key := fs.String("key", config.PolicySigningKey, "Trusted public key or SHA256 fingerprint (default: the key pinned in this build)")
+	_ = fs.Parse(args)
+
+	if *key == "" {
+		fmt.Fprintln(os.Stderr, "No policy signing key is pinned in this build; pass --key")
+		return 2
+	}
+	policyPath := fs.Arg(0)
+	if policyPath == "" {
+		policyPath = config.PolicyPath()
+	}
+
+	// --key may name a .pub file
+	trusted := *key
+	if data, err := os.ReadFile(trusted); err == nil {
+		trusted = string(data)
+	}
+
+	data, err := os.ReadFile(policyPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read policy: %v\n", err)
+		return 1
+	}
+	signedBy, err := config.VerifyPolicyIntegrity(data, trusted)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %s: %v\n", policyPath, err)
+		return 1
+	}
+
+	fmt.Printf("✅ %s is signed by %s\n", policyPath, ssh.FingerprintSHA256(signedBy))
+	return 0
+}
+
+func loadPolicySigner(path string) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := ssh.ParsePrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		passphrase := os.Getenv("CASSH_POLICY_KEY_PASSPHRASE")
+		if passphrase == "" {
+			return nil, fmt.Errorf("key is encrypted; set CASSH_POLICY_KEY_PASSPHRASE")
+		}
+		return ssh.ParsePrivateKeyWithPassphrase(data, []byte(passphrase))
+	}
+	return signer, err
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// githubActionsToken is the --workload-token value that fetches the job's OIDC token
+// The workflow needs `permissions: id-token: write`
+const githubActionsToken = "github-actions"
+
+// workloadFlow exchanges a CI workload identity token for a short-lived cert
+func workloadFlow(pubKeyData []byte) (string, error) {
+	token, err := resolveWorkloadToken(workloadToken)
+	if err != nil {
+		return "", err
+	}
+
+	body, _ := json.Marshal(map[string]string{
+		"public_key": strings.TrimSpace(string(pubKeyData)),
+		"template":   template,
+		"target":     target,
+	})
+
+	req, err := http.NewRequest(http.MethodPost, serverURL+"/api/v1/workload/cert", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to contact server: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("workload issuance failed: %s", readError(resp))
+	}
+
+	var result struct {
+		Certificate string `json:"certificate"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || result.Certificate == "" {
+		return "", fmt.Errorf("invalid workload issuance response")
+	}
+	return result.Certificate, nil
+}
+
+// resolveWorkloadToken returns the JWT for a --workload-token value:
+//   - "github-actions": requested from the GitHub Actions OIDC provider for --workload-audience
+//   - "@PATH": read from a file (e.g., a Kubernetes projected service account token)
+//   - anything else: the token itself
+func resolveWorkloadToken(value string) (string, error) {
+	switch {
+	case value == githubActionsToken:
+		return fetchGitHubActionsToken(workloadAudience)
+	case strings.HasPrefix(value, "@"):
+		data, err := os.ReadFile(value[1:])
+		if err != nil {
+			return "", fmt.Errorf("failed to read workload token: %w", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	default:
+		return value, nil
+	}
+}
+
+// fetchGitHubActionsToken requests an ID token for audience from the Actions runtime
+func fetchGitHubActionsToken(audience string) (string, error) {
+	requestURL := os.Getenv("ACTIONS_ID_TOKEN_REQUEST_URL")
+	requestToken := os.Getenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN")
+	if requestURL == "" || requestToken == "" {
+		return "", fmt.Errorf("GitHub Actions OIDC is not available (does the workflow have `permissions: id-token: write`?)")
+	}
+
+	u, err := url.Parse(requestURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid ACTIONS_ID_TOKEN_REQUEST_URL: %w", err)
+	}
+	q := u.Query()
+	q.Set("audience", audience)
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+requestToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to request GitHub Actions token: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to request GitHub Actions token: %s", resp.Status)
+	}
+
+	var result struct {
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || result.Value == "" {
+		return "", fmt.Errorf("invalid GitHub Actions token response")
+	}
+	return result.Value, nil
+}
@@ -44,34 +44,41 @@ func setupVisibilityMenu() *systray.MenuItem {
 	// Create "Appearance" submenu
 	menuAppearance := systray.AddMenuItem("Appearance", "App visibility options")
 
-	menuShowInDock = menuAppearance.AddSubMenuItemCheckbox("Show in Dock", "Show cassh icon in the Dock", cfg.User.ShowInDock)
+	var show bool
+	cfg.Read(func(_ *config.PolicyConfig, user *config.UserConfig) { show = user.ShowInDock })
+	menuShowInDock = menuAppearance.AddSubMenuItemCheckbox("Show in Dock", "Show cassh icon in the Dock", show)
 
 	return menuAppearance
 }
 
 // handleShowInDockToggle toggles dock visibility
 func handleShowInDockToggle() {
-	if menuShowInDock.Checked() {
-		// Currently checked, uncheck it (hide from dock)
-		menuShowInDock.Uncheck()
-		hideFromDock()
-		cfg.User.ShowInDock = false
-	} else {
+	show := !menuShowInDock.Checked()
+	if show {
 		// Currently unchecked, check it (show in dock)
 		menuShowInDock.Check()
 		showInDock()
-		cfg.User.ShowInDock = true
+	} else {
+		// Currently checked, uncheck it (hide from dock)
+		menuShowInDock.Uncheck()
+		hideFromDock()
 	}
 
 	// Save preference
-	if err := config.SaveUserConfig(&cfg.User); err != nil {
+	err := cfg.UpdateUser(func(user *config.UserConfig) bool {
+		user.ShowInDock = show
+		return true
+	})
+	if err != nil {
 		log.Printf("Failed to save dock visibility preference: %v", err)
 	}
 }
 
 // applyVisibilitySettings applies saved visibility settings on startup
 func applyVisibilitySettings() {
-	if cfg.User.ShowInDock {
+	var show bool
+	cfg.Read(func(_ *config.PolicyConfig, user *config.UserConfig) { show = user.ShowInDock })
+	if show {
 		showInDock()
 	} else {
 		hideFromDock()
@@ -7,10 +7,12 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"crypto/ed25519"
 	"embed"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
@@ -50,6 +52,7 @@ var (
 )
 
 var (
+	// cfg is shared by the menu, loopback server and refresh goroutines; use its methods once they start
 	cfg        *config.MergedConfig
 	needsSetup bool
 	templates  *template.Template
@@ -105,7 +108,11 @@ func main() {
 		policyPath = "cassh.policy.dev.toml"
 	}
 
-	policy, err := config.LoadPolicy(policyPath)
+	// The server's signed policy, cached from an earlier run, takes precedence over the bundled one
+	policy, err := config.LoadEffectivePolicy(policyPath)
+	if errors.Is(err, config.ErrPolicySignature) {
+		log.Fatalf("Refusing to start with a tampered policy: %v", err)
+	}
 	if err != nil {
 		log.Printf("Warning: Could not load policy, using defaults: %v", err)
 		policy = &config.PolicyConfig{
@@ -168,6 +175,9 @@ func main() {
 		go monitorConnections()
 	}
 
+	// Pick up policy changes IT publishes on the server
+	go refreshPolicyLoop(policyPath)
+
 	// Run systray
 	systray.Run(onReady, onExit)
 }
@@ -263,7 +273,8 @@ func onReady() {
 // buildConnectionMenu creates menu items for all configured connections
 func buildConnectionMenu() {
 	// Add connection status items
-	for i, conn := range cfg.User.Connections {
+	conns := cfg.Connections()
+	for i, conn := range conns {
 		statusText := fmt.Sprintf("%s: Checking...", conn.Name)
 		menuItem := systray.AddMenuItem(statusText, fmt.Sprintf("Status for %s", conn.Name))
 		menuItem.Disable()
@@ -298,7 +309,7 @@ func buildConnectionMenu() {
 		// Update status for this connection
 		go updateConnectionStatus(connIdx)
 
-		if i < len(cfg.User.Connections)-1 {
+		if i < len(conns)-1 {
 			systray.AddSeparator()
 		}
 	}
@@ -391,30 +402,41 @@ func openSetupWizard() {
 
 // handleConnectionAction handles the action for a specific connection
 func handleConnectionAction(connID string) {
-	conn := cfg.User.GetConnection(connID)
-	if conn == nil {
+	conn, ok := cfg.Connection(connID)
+	if !ok {
 		log.Printf("Connection not found: %s", connID)
 		return
 	}
 
 	if conn.Type == config.ConnectionTypeEnterprise {
-		generateCertForConnection(conn)
+		generateCertForConnection(&conn)
 	} else {
-		refreshKeyForConnection(conn)
+		refreshKeyForConnection(&conn)
 	}
 }
 
 // revokeConnectionCert revokes the certificate for a connection
 func revokeConnectionCert(connID string, connIdx int) {
-	conn := cfg.User.GetConnection(connID)
-	if conn == nil {
+	found, ok := cfg.Connection(connID)
+	if !ok {
 		log.Printf("Connection not found: %s", connID)
 		return
 	}
+	conn := &found
 
 	log.Printf("Revoking certificate for connection: %s", conn.Name)
 
-	// Remove key from ssh-agent first
+	// Revoke on the server first so the cert lands in the KRL, not just off this machine
+	revokedOnServer := false
+	if conn.Type == config.ConnectionTypeEnterprise && conn.ServerURL != "" {
+		if err := revokeCertOnServer(conn); err != nil {
+			log.Printf("Server revocation failed: %v", err)
+		} else {
+			revokedOnServer = true
+		}
+	}
+
+	// Remove key from ssh-agent
 	if conn.SSHKeyPath != "" {
 		if err := exec.Command("ssh-add", "-d", conn.SSHKeyPath).Run(); err != nil {
 			log.Printf("Note: Could not remove key from ssh-agent: %v", err)
@@ -440,11 +462,63 @@ func revokeConnectionCert(connID string, connIdx int) {
 	log.Printf("Certificate revoked for connection: %s", conn.Name)
 
 	// Send notification
+	if conn.Type == config.ConnectionTypeEnterprise && !revokedOnServer {
+		sendNotification("Certificate Removed",
+			fmt.Sprintf("%s certificate was removed locally, but the server could not be reached to revoke it.", conn.Name),
+			false)
+		return
+	}
 	sendNotification("Certificate Revoked",
 		fmt.Sprintf("%s certificate has been revoked.", conn.Name),
 		false)
 }
 
+// revokeCertOnServer asks the cassh server to add the connection's cert to its KRL
+// The request is signed with the connection's private key to prove ownership of the cert
+func revokeCertOnServer(conn *config.Connection) error {
+	certData, err := os.ReadFile(conn.SSHCertPath)
+	if err != nil {
+		return fmt.Errorf("failed to read certificate: %w", err)
+	}
+	cert, err := ca.ParseCertificate(certData)
+	if err != nil {
+		return err
+	}
+
+	keyData, err := os.ReadFile(conn.SSHKeyPath)
+	if err != nil {
+		return fmt.Errorf("failed to read private key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(keyData)
+	if err != nil {
+		return fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	revReq, err := ca.NewRevocationRequest(cert, signer)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(revReq)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(strings.TrimSuffix(conn.ServerURL, "/")+"/revoke", "application/json", strings.NewReader(string(body)))
+	if err != nil {
+		return fmt.Errorf("failed to contact server: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned %s", resp.Status)
+	}
+
+	log.Printf("Revoked certificate serial %d on server", cert.Serial)
+	return nil
+}
+
 // generateCertForConnection opens WebView to generate cert for enterprise connection
 func generateCertForConnection(conn *config.Connection) {
 	// Ensure SSH key exists
@@ -461,11 +535,15 @@ func generateCertForConnection(conn *config.Connection) {
 		return
 	}
 
-	// Build URL
-	authURL := fmt.Sprintf("%s/?pubkey=%s",
+	// Build URL; the device name and version may go in the cert's key ID
+	authURL := fmt.Sprintf("%s/?pubkey=%s&client_version=%s",
 		conn.ServerURL,
 		url.QueryEscape(string(pubKeyData)),
+		url.QueryEscape("cassh/"+version),
 	)
+	if hostname, err := os.Hostname(); err == nil {
+		authURL += "&device_name=" + url.QueryEscape(hostname)
+	}
 
 	// Open in native WebView on macOS, fallback to browser on other platforms
 	if runtime.GOOS == "darwin" {
@@ -498,7 +576,7 @@ func refreshKeyForConnection(conn *config.Connection) {
 	}
 
 	// Save updated connection config with new key ID and timestamp
-	if err := config.SaveUserConfig(&cfg.User); err != nil {
+	if err := saveRotatedKeys([]config.Connection{*conn}); err != nil {
 		log.Printf("Failed to save config after key rotation: %v", err)
 	}
 
@@ -508,11 +586,11 @@ func refreshKeyForConnection(conn *config.Connection) {
 
 // updateConnectionStatus checks and updates the status for a specific connection
 func updateConnectionStatus(connIdx int) {
-	if connIdx >= len(cfg.User.Connections) {
+	conn, ok := connectionAt(connIdx)
+	if !ok {
 		return
 	}
 
-	conn := cfg.User.Connections[connIdx]
 	status := connectionStatus[conn.ID]
 	if status == nil {
 		status = &ConnectionStatus{ConnectionID: conn.ID}
@@ -615,13 +693,22 @@ func updateConnectionStatus(connIdx int) {
 	}
 }
 
+// connectionAt returns a copy of the connection at index i of the menu
+func connectionAt(i int) (config.Connection, bool) {
+	conns := cfg.Connections()
+	if i < 0 || i >= len(conns) {
+		return config.Connection{}, false
+	}
+	return conns[i], true
+}
+
 // setConnectionStatusInvalid marks a connection as invalid in the menu
 func setConnectionStatusInvalid(connIdx int, reason string, isExpired bool) {
-	if connIdx >= len(cfg.User.Connections) || connIdx >= len(menuConnections) {
+	conn, ok := connectionAt(connIdx)
+	if !ok || connIdx >= len(menuConnections) {
 		return
 	}
 
-	conn := cfg.User.Connections[connIdx]
 	status := connectionStatus[conn.ID]
 	if status != nil {
 		status.Valid = false
@@ -638,7 +725,10 @@ func setConnectionStatusInvalid(connIdx int, reason string, isExpired bool) {
 
 // monitorConnections periodically checks all connection statuses
 func monitorConnections() {
-	interval := time.Duration(cfg.User.RefreshIntervalSeconds) * time.Second
+	var interval time.Duration
+	cfg.Read(func(_ *config.PolicyConfig, user *config.UserConfig) {
+		interval = time.Duration(user.RefreshIntervalSeconds) * time.Second
+	})
 	if interval == 0 {
 		interval = 30 * time.Second
 	}
@@ -646,12 +736,41 @@ func monitorConnections() {
 	defer ticker.Stop()
 
 	for range ticker.C {
-		for i := range cfg.User.Connections {
+		for i := range cfg.Connections() {
 			updateConnectionStatus(i)
 		}
 	}
 }
 
+// refreshPolicyLoop checks the server for a new signed policy on startup and every hour
+func refreshPolicyLoop(policyPath string) {
+	refreshPolicy(policyPath)
+
+	ticker := time.NewTicker(config.PolicyRefreshInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		refreshPolicy(policyPath)
+	}
+}
+
+// refreshPolicy swaps in a newer verified policy and repoints the enterprise connection
+func refreshPolicy(policyPath string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	changed, err := cfg.RefreshPolicy(ctx, policyPath)
+	if err != nil {
+		log.Printf("Warning: Could not refresh policy: %v", err)
+		return
+	}
+	if changed {
+		cfg.Read(func(policy *config.PolicyConfig, _ *config.UserConfig) {
+			log.Printf("Policy updated from server (version %q)", policy.PolicyVersion)
+		})
+	}
+}
+
 func onExit() {
 	log.Println("cassh-menubar exiting...")
 }
@@ -1193,7 +1312,7 @@ func uninstallCassh() {
 	homeDir, _ := os.UserHomeDir()
 
 	// 1. Delete SSH keys created by cassh for each connection
-	for _, conn := range cfg.User.Connections {
+	for _, conn := range cfg.Connections() {
 		// Delete from GitHub if personal account
 		if conn.Type == config.ConnectionTypePersonal && conn.GitHubKeyID != "" {
 			if err := deleteSSHKeyFromGitHub(conn.GitHubKeyID); err != nil {
@@ -1438,24 +1557,26 @@ func handleInstallCert(w http.ResponseWriter, r *http.Request) {
 	log.Printf("handleInstallCert: received cert (%d bytes), connection_id=%q", len(req.Cert), req.ConnectionID)
 
 	// Validate cert
-	if _, err := ca.ParseCertificate([]byte(req.Cert)); err != nil {
+	parsed, err := ca.ParseCertificate([]byte(req.Cert))
+	if err != nil {
 		log.Printf("handleInstallCert: invalid certificate: %v", err)
 		http.Error(w, "Invalid certificate", http.StatusBadRequest)
 		return
 	}
+	if err := checkCertAuthority(parsed); err != nil {
+		log.Printf("handleInstallCert: %v", err)
+		http.Error(w, "Certificate signed by an untrusted CA", http.StatusForbidden)
+		return
+	}
 
 	// Find the connection to install cert for
 	var conn *config.Connection
-	if req.ConnectionID != "" {
-		conn = cfg.User.GetConnection(req.ConnectionID)
-	} else if len(cfg.User.Connections) > 0 {
-		// Default to first enterprise connection
-		for i := range cfg.User.Connections {
-			if cfg.User.Connections[i].Type == config.ConnectionTypeEnterprise {
-				conn = &cfg.User.Connections[i]
-				break
-			}
-		}
+	if target, ok := installTarget(req.ConnectionID); ok {
+		conn = &target
+	}
+
+	if conn != nil {
+		syncGitHubUsername(conn, parsed)
 	}
 
 	// Fallback to legacy paths if no connection found
@@ -1467,9 +1588,11 @@ func handleInstallCert(w http.ResponseWriter, r *http.Request) {
 		gheURL = "https://" + conn.GitHubHost
 	} else {
 		// Legacy fallback
-		certPath = cfg.User.SSHCertPath
-		keyPath = cfg.User.SSHKeyPath
-		gheURL = cfg.Policy.GitHubEnterpriseURL
+		cfg.Read(func(policy *config.PolicyConfig, user *config.UserConfig) {
+			certPath = user.SSHCertPath
+			keyPath = user.SSHKeyPath
+			gheURL = policy.GitHubEnterpriseURL
+		})
 	}
 
 	// Write cert
@@ -1508,11 +1631,8 @@ func handleInstallCert(w http.ResponseWriter, r *http.Request) {
 
 	// Update connection status
 	if conn != nil {
-		for i, c := range cfg.User.Connections {
-			if c.ID == conn.ID {
-				go updateConnectionStatus(i)
-				break
-			}
+		if i := connectionIndex(conn.ID); i >= 0 {
+			go updateConnectionStatus(i)
 		}
 	}
 
@@ -1520,12 +1640,72 @@ func handleInstallCert(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 }
 
+// syncGitHubUsername sets the connection's GitHub username to the login in the cert's login@ extension
+// The server resolves the user's actual GHE login, so it wins over the username taken from a clone URL at setup
+func syncGitHubUsername(conn *config.Connection, cert *ssh.Certificate) {
+	host, login := ca.GitHubLogin(cert)
+	if login == "" || login == conn.GitHubUsername {
+		return
+	}
+	if conn.GitHubHost != "" && !strings.EqualFold(host, conn.GitHubHost) {
+		log.Printf("Certificate login@%s doesn't match connection host %s; keeping username %q", host, conn.GitHubHost, conn.GitHubUsername)
+		return
+	}
+
+	log.Printf("Setting GitHub username for %s to %q (was %q) from the certificate", conn.Name, login, conn.GitHubUsername)
+	conn.GitHubUsername = login
+	err := cfg.UpdateUser(func(user *config.UserConfig) bool {
+		stored := user.GetConnection(conn.ID)
+		if stored == nil {
+			return false
+		}
+		stored.GitHubUsername = login
+		return true
+	})
+	if err != nil {
+		log.Printf("Failed to save config: %v", err)
+	}
+}
+
+// checkCertAuthority rejects certs not signed by a CA the current policy trusts
+func checkCertAuthority(cert *ssh.Certificate) error {
+	var err error
+	cfg.Read(func(policy *config.PolicyConfig, _ *config.UserConfig) {
+		err = policy.CheckCertAuthority(cert)
+	})
+	return err
+}
+
+// installTarget returns a copy of the connection a cert for connID is installed into
+// Without an ID that's the first enterprise connection
+func installTarget(connID string) (config.Connection, bool) {
+	if connID != "" {
+		return cfg.Connection(connID)
+	}
+	for _, conn := range cfg.Connections() {
+		if conn.Type == config.ConnectionTypeEnterprise {
+			return conn, true
+		}
+	}
+	return config.Connection{}, false
+}
+
+// connectionIndex returns the menu index of the connection with the given ID, or -1
+func connectionIndex(id string) int {
+	for i, conn := range cfg.Connections() {
+		if conn.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
 // handleStatus returns current status for all connections
 func handleStatus(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	statuses := make([]map[string]interface{}, 0)
-	for _, conn := range cfg.User.Connections {
+	for _, conn := range cfg.Connections() {
 		status := connectionStatus[conn.ID]
 
 		// Determine github_host based on connection type
@@ -1569,9 +1749,9 @@ func handleSetup(w http.ResponseWriter, r *http.Request) {
 		HasConnections bool
 		Connections    []config.Connection
 	}{
-		HasConnections: cfg.User.HasConnections(),
-		Connections:    cfg.User.Connections,
+		Connections: cfg.Connections(),
 	}
+	data.HasConnections = len(data.Connections) > 0
 
 	if templates != nil {
 		if err := templates.ExecuteTemplate(w, "setup.html", data); err != nil {
@@ -1643,10 +1823,9 @@ func handleAddEnterprise(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if req.GitHubUsername == "" {
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(map[string]string{"error": "GitHub SSH username is required (from SSH clone URL)"})
-			return
+		// Without a username in the clone URL (git@host), the first cert's login@ extension fills it in
+		if req.GitHubUsername == "git" {
+			req.GitHubUsername = ""
 		}
 
 		// Create connection
@@ -1665,8 +1844,11 @@ func handleAddEnterprise(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Add connection to config
-		cfg.User.AddConnection(conn)
-		if err := config.SaveUserConfig(&cfg.User); err != nil {
+		err := cfg.UpdateUser(func(user *config.UserConfig) bool {
+			user.AddConnection(conn)
+			return true
+		})
+		if err != nil {
 			log.Printf("Failed to save config: %v", err)
 			w.Header().Set("Content-Type", "application/json")
 			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save configuration"})
@@ -1820,8 +2002,11 @@ func handleAddPersonal(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Add connection to config
-		cfg.User.AddConnection(conn)
-		if err := config.SaveUserConfig(&cfg.User); err != nil {
+		err := cfg.UpdateUser(func(user *config.UserConfig) bool {
+			user.AddConnection(conn)
+			return true
+		})
+		if err != nil {
 			log.Printf("Failed to save config: %v", err)
 			w.Header().Set("Content-Type", "application/json")
 			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save configuration"})
@@ -1897,12 +2082,9 @@ func handleDeleteConnection(w http.ResponseWriter, r *http.Request) {
 
 		// Find and remove the connection
 		var removedConn *config.Connection
-		newConnections := make([]config.Connection, 0)
-		for _, conn := range cfg.User.Connections {
+		for _, conn := range cfg.Connections() {
 			if conn.ID == req.ID {
 				removedConn = &conn
-			} else {
-				newConnections = append(newConnections, conn)
 			}
 		}
 
@@ -1936,8 +2118,10 @@ func handleDeleteConnection(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Update config
-		cfg.User.Connections = newConnections
-		if err := config.SaveUserConfig(&cfg.User); err != nil {
+		err := cfg.UpdateUser(func(user *config.UserConfig) bool {
+			return user.RemoveConnection(req.ID)
+		})
+		if err != nil {
 			log.Printf("Failed to save config: %v", err)
 			w.Header().Set("Content-Type", "application/json")
 			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save configuration"})
@@ -1948,7 +2132,7 @@ func handleDeleteConnection(w http.ResponseWriter, r *http.Request) {
 		delete(connectionStatus, req.ID)
 
 		// Update needs setup flag
-		needsSetup = len(cfg.User.Connections) == 0
+		needsSetup = len(cfg.Connections()) == 0
 
 		log.Printf("Deleted connection: %s (%s)", removedConn.Name, removedConn.ID)
 
@@ -2248,6 +2432,21 @@ func rotatePersonalGitHubSSH(conn *config.Connection) error {
 	return nil
 }
 
+// saveRotatedKeys records the new GitHub key IDs and creation times of rotated connections
+func saveRotatedKeys(rotated []config.Connection) error {
+	return cfg.UpdateUser(func(user *config.UserConfig) bool {
+		changed := false
+		for _, r := range rotated {
+			if conn := user.GetConnection(r.ID); conn != nil {
+				conn.GitHubKeyID = r.GitHubKeyID
+				conn.KeyCreatedAt = r.KeyCreatedAt
+				changed = true
+			}
+		}
+		return changed
+	})
+}
+
 // needsKeyRotation checks if a personal connection needs key rotation
 func needsKeyRotation(conn *config.Connection) bool {
 	if conn.Type != config.ConnectionTypePersonal {
@@ -2277,28 +2476,27 @@ func checkAndRotateExpiredKeys() {
 		return // Can't rotate keys without gh CLI
 	}
 
-	rotatedCount := 0
-	for i := range cfg.User.Connections {
-		conn := &cfg.User.Connections[i]
-		if needsKeyRotation(conn) {
+	var rotated []config.Connection
+	for _, conn := range cfg.Connections() {
+		if needsKeyRotation(&conn) {
 			log.Printf("Key rotation needed for %s (age: %v, policy: %dh)",
 				conn.Name,
 				time.Since(time.Unix(conn.KeyCreatedAt, 0)).Round(time.Hour),
 				conn.KeyRotationHours)
 
-			if err := rotatePersonalGitHubSSH(conn); err != nil {
+			if err := rotatePersonalGitHubSSH(&conn); err != nil {
 				log.Printf("Failed to rotate key for %s: %v", conn.Name, err)
 				sendNotification("cassh", fmt.Sprintf("Key rotation failed for %s", conn.Name), false)
 				continue
 			}
 
-			rotatedCount++
+			rotated = append(rotated, conn)
 		}
 	}
 
 	// Save config if any keys were rotated
-	if rotatedCount > 0 {
-		if err := config.SaveUserConfig(&cfg.User); err != nil {
+	if rotatedCount := len(rotated); rotatedCount > 0 {
+		if err := saveRotatedKeys(rotated); err != nil {
 			log.Printf("Failed to save config after key rotation: %v", err)
 		} else {
 			log.Printf("Rotated %d key(s) on startup", rotatedCount)
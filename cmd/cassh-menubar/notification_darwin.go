@@ -192,7 +192,7 @@ func pollNotificationActions() {
 		switch action {
 		case 1: // renew
 			// Open the first enterprise connection for renewal
-			for _, conn := range cfg.User.Connections {
+			for _, conn := range cfg.Connections() {
 				if conn.Type == "enterprise" {
 					handleConnectionAction(conn.ID)
 					break
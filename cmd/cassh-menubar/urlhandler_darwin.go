@@ -166,27 +166,29 @@ func handleInstallCertURL(u *url.URL) {
 	cert := string(certBytes)
 
 	// Validate cert
-	if _, err := ca.ParseCertificate([]byte(cert)); err != nil {
+	parsed, err := ca.ParseCertificate([]byte(cert))
+	if err != nil {
 		log.Printf("Invalid certificate: %v", err)
 		sendNotification("cassh Error", "Invalid certificate received", false)
 		return
 	}
+	if err := checkCertAuthority(parsed); err != nil {
+		log.Printf("Rejected certificate: %v", err)
+		sendNotification("cassh Error", "Certificate signed by an untrusted CA", false)
+		return
+	}
 
 	// Get optional connection ID
 	connectionID := query.Get("connection_id")
 
 	// Find the connection to install cert for
 	var conn *config.Connection
-	if connectionID != "" {
-		conn = cfg.User.GetConnection(connectionID)
-	} else if len(cfg.User.Connections) > 0 {
-		// Default to first enterprise connection
-		for i := range cfg.User.Connections {
-			if cfg.User.Connections[i].Type == config.ConnectionTypeEnterprise {
-				conn = &cfg.User.Connections[i]
-				break
-			}
-		}
+	if target, ok := installTarget(connectionID); ok {
+		conn = &target
+	}
+
+	if conn != nil {
+		syncGitHubUsername(conn, parsed)
 	}
 
 	// Determine paths
@@ -198,9 +200,11 @@ func handleInstallCertURL(u *url.URL) {
 		gheURL = "https://" + conn.GitHubHost
 	} else {
 		// Legacy fallback
-		certPath = cfg.User.SSHCertPath
-		keyPath = cfg.User.SSHKeyPath
-		gheURL = cfg.Policy.GitHubEnterpriseURL
+		cfg.Read(func(policy *config.PolicyConfig, user *config.UserConfig) {
+			certPath = user.SSHCertPath
+			keyPath = user.SSHKeyPath
+			gheURL = policy.GitHubEnterpriseURL
+		})
 	}
 
 	// Write cert
@@ -245,11 +249,8 @@ func handleInstallCertURL(u *url.URL) {
 
 	// Update connection status
 	if conn != nil {
-		for i, c := range cfg.User.Connections {
-			if c.ID == conn.ID {
-				go updateConnectionStatus(i)
-				break
-			}
+		if i := connectionIndex(conn.ID); i >= 0 {
+			go updateConnectionStatus(i)
 		}
 	}
 }
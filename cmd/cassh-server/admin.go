@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shawntz/cassh/internal/ledger"
+	"golang.org/x/crypto/ssh"
+)
+
+// maxCertQueryResults caps /admin/certs responses when no limit is given
+const maxCertQueryResults = 1000
+
+// requireAdmin checks the bearer token against the configured admin token
+func (s *Server) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if s.config.AdminToken == "" {
+		writeJSONError(w, http.StatusNotFound, "admin API disabled")
+		return false
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminToken)) != 1 {
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+		return false
+	}
+	return true
+}
+
+// handleAdminCerts queries the issuance ledger
+// Query params:
+//   - user: requesting identity (e.g., email) or any cert principal
+//   - since, until: certs valid at any point in this window (RFC 3339 or YYYY-MM-DD)
+//   - limit: max results, newest first (default 1000)
+//
+// Example: /admin/certs?user=alice&since=2025-01-07&until=2025-01-08
+func (s *Server) handleAdminCerts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	params := r.URL.Query()
+	q := ledger.Query{
+		User:  params.Get("user"),
+		Limit: maxCertQueryResults,
+	}
+
+	var err error
+	if q.Since, err = parseQueryTime(params.Get("since")); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid since: "+err.Error())
+		return
+	}
+	if q.Until, err = parseQueryTime(params.Get("until")); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid until: "+err.Error())
+		return
+	}
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxCertQueryResults {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxCertQueryResults))
+			return
+		}
+		q.Limit = limit
+	}
+
+	records, err := s.store.Query(r.Context(), q)
+	if err != nil {
+		log.Printf("Ledger query error: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to query ledger")
+		return
+	}
+	if records == nil {
+		records = []*ledger.Record{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"certificates": records,
+		"count":        len(records),
+	})
+}
+
+// parseQueryTime accepts RFC 3339 timestamps or plain dates (midnight UTC)
+func parseQueryTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", v)
+}
+
+// recordIssued writes a newly signed cert to the ledger; clientIP is where it was requested from
+func (s *Server) recordIssued(r *http.Request, cert *ssh.Certificate, user, clientIP string) error {
+	if err := s.store.RecordIssued(r.Context(), ledger.NewRecord(cert, user, clientIP)); err != nil {
+		return fmt.Errorf("failed to record certificate: %w", err)
+	}
+	return nil
+}
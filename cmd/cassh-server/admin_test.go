@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/shawntz/cassh/internal/oidc"
+)
+
+func TestRequireAdmin(t *testing.T) {
+	st := newTestState(t, "[admin]\ntoken = \"s3cret\"\n")
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"bearer token", "Bearer s3cret", http.StatusOK},
+		{"wrong token", "Bearer nope", http.StatusUnauthorized},
+		{"token without a scheme", "s3cret", http.StatusUnauthorized},
+		{"other scheme", "Basic s3cret", http.StatusUnauthorized},
+		{"no header", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/admin/certs", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			st.ServeHTTP(rec, r)
+			if rec.Code != tt.want {
+				t.Errorf("GET /admin/certs = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequesterIP(t *testing.T) {
+	s := newTestState(t, "").current.Load()
+	r := httptest.NewRequest(http.MethodGet, "/auth/callback", nil)
+	r.RemoteAddr = "10.0.0.1:51234"
+
+	// Device flow certs were requested by the device, not the browser that approved them
+	if got := s.requesterIP(r, &oidc.AuthRequest{ClientIP: "10.0.0.2"}); got != "10.0.0.2" {
+		t.Errorf("requesterIP() for a device authorization = %q, want 10.0.0.2", got)
+	}
+	if got := s.requesterIP(r, &oidc.AuthRequest{}); got != "10.0.0.1" {
+		t.Errorf("requesterIP() for a browser sign-in = %q, want 10.0.0.1", got)
+	}
+}
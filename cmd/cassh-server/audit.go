@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/shawntz/cassh/internal/audit"
+	"github.com/shawntz/cassh/internal/policy"
+	"golang.org/x/crypto/ssh"
+)
+
+// emit writes an audit event, filling in the requesting client's details
+// Audit failures are logged but never block the request
+func (s *Server) emit(r *http.Request, e audit.Event) {
+	e.RemoteAddr = s.clientIP(r)
+	e.UserAgent = r.UserAgent()
+	if err := s.audit.Log(e); err != nil {
+		log.Printf("Audit log error: %v", err)
+	}
+}
+
+// certIssuedEvent builds a cert_issued event
+// actor is the user's email or the workload identity
+func certIssuedEvent(cert *ssh.Certificate, actor, subject string, decision policy.Decision) audit.Event {
+	validBefore := time.Unix(int64(cert.ValidBefore), 0).UTC()
+	return audit.Event{
+		Type:        audit.EventCertIssued,
+		Actor:       actor,
+		Subject:     subject,
+		Serial:      strconv.FormatUint(cert.Serial, 10),
+		KeyID:       cert.KeyId,
+		Principals:  cert.ValidPrincipals,
+		Fingerprint: ssh.FingerprintSHA256(cert.Key),
+		ValidBefore: &validBefore,
+		PolicyRule:  decision.Rule,
+		Template:    decision.Template,
+		CA:          caName(decision.CA),
+	}
+}
+
+// runAuditCommand handles `cassh-server audit verify FILE`
+func runAuditCommand(args []string) int {
+	if len(args) != 2 || args[0] != "verify" {
+		fmt.Fprintln(os.Stderr, "Usage: cassh-server audit verify FILE")
+		return 2
+	}
+
+	f, err := os.Open(args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer func() { _ = f.Close() }()
+
+	n, err := audit.Verify(f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Audit log verification failed after %d records: %v\n", n, err)
+		return 1
+	}
+
+	fmt.Printf("✅ Audit log intact: %d records verified\n", n)
+	return 0
+}
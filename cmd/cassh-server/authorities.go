@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/shawntz/cassh/internal/ca"
+	"github.com/shawntz/cassh/internal/config"
+	"github.com/shawntz/cassh/internal/policy"
+	"golang.org/x/crypto/ssh"
+)
+
+// authority is a CA the server can sign with: the top-level [ca] or a named [cas.<name>]
+type authority struct {
+	name       string
+	ca         *ca.CertificateAuthority
+	githubHost string // GHE host for the login@ extension
+}
+
+// openNamedCAs initializes every [cas.<name>] CA
+// The returned closers release HSM sessions and agent connections
+func openNamedCAs(cfg *config.ServerConfig) (map[string]*authority, []io.Closer, error) {
+	authorities := make(map[string]*authority, len(cfg.CAs)+1)
+	var closers []io.Closer
+
+	names := make([]string, 0, len(cfg.CAs))
+	for name := range cfg.CAs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		caCfg := cfg.CAs[name]
+		signer, closer, err := ca.NewSigner(caCfg.SignerConfig())
+		if err != nil {
+			closeAll(closers)
+			return nil, nil, fmt.Errorf("CA %q: %w", name, err)
+		}
+		closers = append(closers, closer)
+
+		validityHours := caCfg.CertValidityHours
+		if validityHours == 0 {
+			validityHours = cfg.CertValidityHours
+		}
+		certAuthority := ca.NewCAFromSigner(signer, validityHours, nil)
+		if err := addRotationKeys(certAuthority, caCfg.NextPublicKeys, caCfg.RetiredPublicKeys); err != nil {
+			closeAll(closers)
+			return nil, nil, fmt.Errorf("CA %q: %w", name, err)
+		}
+
+		githubURL := caCfg.GitHubEnterpriseURL
+		if githubURL == "" {
+			githubURL = cfg.GitHubEnterpriseURL
+		}
+		authorities[name] = &authority{
+			name:       name,
+			ca:         certAuthority,
+			githubHost: config.ExtractHostFromURL(githubURL),
+		}
+
+		signerType := caCfg.Signer
+		if signerType == "" {
+			signerType = ca.SignerFile
+		}
+		log.Printf("CA %q signer: %s (%s)", name, signerType, ssh.FingerprintSHA256(signer.PublicKey()))
+	}
+
+	return authorities, closers, nil
+}
+
+func closeAll(closers []io.Closer) {
+	for _, closer := range closers {
+		_ = closer.Close()
+	}
+}
+
+// checkCARules makes sure the issuance policy only routes signing to configured CAs
+func checkCARules(engine *policy.Engine, defaultCA string, authorities map[string]*authority) error {
+	exists := func(name string) bool {
+		_, ok := authorities[name]
+		return name == "" || ok
+	}
+	if !exists(defaultCA) {
+		return fmt.Errorf("issuance.default_ca %q is not defined in [cas]", defaultCA)
+	}
+	for _, rule := range engine.Rules() {
+		if !exists(rule.CA) {
+			return fmt.Errorf("issuance rule %q refers to undefined CA %q", rule.Name, rule.CA)
+		}
+	}
+	return nil
+}
+
+// caName returns the name a policy decision's CA goes by in logs and key IDs
+func caName(name string) string {
+	if name == "" {
+		return config.DefaultCAName
+	}
+	return name
+}
+
+// sign signs req with the CA the policy decision routes to
+// The CA's GHE host fills in the login@ extension and its name goes in the key ID
+func (s *Server) sign(req *ca.CertRequest, decision policy.Decision) (*ssh.Certificate, *authority, error) {
+	a := s.authorities[caName(decision.CA)]
+	if a == nil {
+		return nil, nil, fmt.Errorf("no CA %q configured", caName(decision.CA))
+	}
+
+	req.GitHubHost = a.githubHost
+	if req.KeyIDFormat != nil {
+		req.KeyIDFields["ca"] = a.name
+	} else {
+		req.KeyID = fmt.Sprintf("%s:ca=%s", req.KeyID, a.name)
+	}
+
+	signStart := time.Now()
+	cert, err := a.ca.Sign(req, s.certTemplates[decision.Template])
+	s.metrics.signingDuration.Observe(time.Since(signStart).Seconds())
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, a, nil
+}
+
+// authorityFor returns the CA that signed cert, or nil if none of ours did
+func (s *Server) authorityFor(cert *ssh.Certificate) *authority {
+	for _, a := range s.authorities {
+		if a.ca.IsAuthorityFor(cert) {
+			return a
+		}
+	}
+	return nil
+}
+
+// otherCAKeys returns every trusted key besides the top-level CA's active key
+// Revocations apply to certs from any of our CAs, so the KRL covers them all
+func (s *Server) otherCAKeys() []ssh.PublicKey {
+	keys := s.ca.RotatedKeys()
+	for _, name := range s.authorityNames() {
+		if name == config.DefaultCAName {
+			continue
+		}
+		for _, key := range s.authorities[name].ca.TrustedKeys() {
+			if !bytes.Equal(key.PublicKey.Marshal(), s.ca.PublicKey().Marshal()) {
+				keys = append(keys, key.PublicKey)
+			}
+		}
+	}
+	return keys
+}
+
+// authorityNames returns the configured CA names in a stable order
+func (s *Server) authorityNames() []string {
+	names := make([]string, 0, len(s.authorities))
+	for name := range s.authorities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
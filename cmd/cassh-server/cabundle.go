@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/shawntz/cassh/internal/ca"
+	"github.com/shawntz/cassh/internal/config"
+	"golang.org/x/crypto/ssh"
+)
+
+// CA bundle formats (?format= on /ca-bundle)
+const (
+	bundleTrustedUserCAKeys = "trusted-user-ca-keys" // sshd TrustedUserCAKeys file (default)
+	bundleCertAuthority     = "cert-authority"       // known_hosts @cert-authority lines
+	bundleJSON              = "json"
+)
+
+// addRotationKeys trusts the configured next and retired CA keys alongside the active one
+func addRotationKeys(certAuthority *ca.CertificateAuthority, next, retired []string) error {
+	add := func(lines []string, state string) error {
+		for _, line := range lines {
+			pubKey, err := ca.ParsePublicKey([]byte(line))
+			if err != nil {
+				return fmt.Errorf("invalid %s CA public key: %w", state, err)
+			}
+			if err := certAuthority.AddTrustedKey(pubKey, state); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := add(next, ca.KeyNext); err != nil {
+		return err
+	}
+	return add(retired, ca.KeyRetired)
+}
+
+// requestedCA returns the CA named by ?ca= (the top-level CA if absent), writing an error if there is none
+func (s *Server) requestedCA(w http.ResponseWriter, r *http.Request) *ca.CertificateAuthority {
+	name := caName(r.URL.Query().Get("ca"))
+	a := s.authorities[name]
+	if a == nil {
+		if name == config.DefaultCAName {
+			http.Error(w, "No CA configured", http.StatusServiceUnavailable)
+		} else {
+			http.Error(w, fmt.Sprintf("Unknown CA %q", name), http.StatusNotFound)
+		}
+		return nil
+	}
+	return a.ca
+}
+
+// handleCAPublicKey serves the active CA public key in authorized_keys format
+// ?ca=NAME selects a named CA instead of the top-level one
+func (s *Server) handleCAPublicKey(w http.ResponseWriter, r *http.Request) {
+	certAuthority := s.requestedCA(w, r)
+	if certAuthority == nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = w.Write(ssh.MarshalAuthorizedKey(certAuthority.PublicKey()))
+}
+
+// handleCABundle serves every currently-trusted CA key (active, next and retired)
+// Hosts and GitHub should trust the whole bundle, so a rotation needs no flag day
+// ?ca=NAME selects a named CA instead of the top-level one
+func (s *Server) handleCABundle(w http.ResponseWriter, r *http.Request) {
+	certAuthority := s.requestedCA(w, r)
+	if certAuthority == nil {
+		return
+	}
+	keys := certAuthority.TrustedKeys()
+
+	w.Header().Set("Cache-Control", "no-cache")
+
+	switch format := r.URL.Query().Get("format"); format {
+	case bundleTrustedUserCAKeys, "":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte(ca.FormatTrustedUserCAKeys(keys)))
+	case bundleCertAuthority:
+		hosts := r.URL.Query().Get("hosts")
+		if strings.ContainsAny(hosts, " \t\r\n#") {
+			http.Error(w, "Invalid hosts pattern", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte(ca.FormatCertAuthority(keys, hosts)))
+	case bundleJSON:
+		type bundleKey struct {
+			State       string `json:"state"`
+			PublicKey   string `json:"public_key"`
+			Fingerprint string `json:"fingerprint"`
+		}
+		resp := struct {
+			Keys []bundleKey `json:"keys"`
+		}{Keys: []bundleKey{}}
+		for _, key := range keys {
+			resp.Keys = append(resp.Keys, bundleKey{
+				State:       key.State,
+				PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key.PublicKey))),
+				Fingerprint: ssh.FingerprintSHA256(key.PublicKey),
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Printf("CA bundle encode error: %v", err)
+		}
+	default:
+		http.Error(w, fmt.Sprintf("Unknown format %q (use %s, %s or %s)", format, bundleTrustedUserCAKeys, bundleCertAuthority, bundleJSON), http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/shawntz/cassh/internal/ca"
+	"github.com/shawntz/cassh/internal/policy"
+)
+
+// loadTemplates validates the configured cert templates and checks that the issuance policy only refers to templates that exist
+func loadTemplates(templates map[string]ca.Template, engine *policy.Engine, defaultTemplate string) (map[string]*ca.Template, error) {
+	out := make(map[string]*ca.Template, len(templates))
+	for name, tmpl := range templates {
+		tmpl := tmpl
+		tmpl.Name = name
+		if err := tmpl.Validate(); err != nil {
+			return nil, err
+		}
+		out[name] = &tmpl
+	}
+
+	exists := func(name string) bool {
+		_, ok := out[name]
+		return name == "" || ok
+	}
+	if !exists(defaultTemplate) {
+		return nil, fmt.Errorf("issuance.default_template %q is not defined in [templates]", defaultTemplate)
+	}
+	for _, rule := range engine.Rules() {
+		for _, name := range rule.Templates {
+			if !exists(name) {
+				return nil, fmt.Errorf("issuance rule %q refers to undefined template %q", rule.Name, name)
+			}
+		}
+	}
+
+	return out, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/shawntz/cassh/internal/config"
+	"golang.org/x/crypto/ssh"
+)
+
+// checkClientPolicy makes sure the published client policy parses and, if a key is configured, is signed by it
+// The server never holds the policy signing key; IT signs the file offline with `cassh policy sign`
+func checkClientPolicy(path, signingKey string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if signingKey == "" {
+		_, err := config.CanonicalPolicy(data)
+		return err
+	}
+	signedBy, err := config.VerifyPolicyIntegrity(data, signingKey)
+	if err != nil {
+		return err
+	}
+	log.Printf("Publishing client policy %s signed by %s", path, ssh.FingerprintSHA256(signedBy))
+	return nil
+}
+
+// handleClientPolicy serves the signed client policy at /.well-known/cassh-policy.toml
+// cassh.app and cassh-cli verify it against their pinned key, cache it, and fall back to the bundled policy
+func (s *Server) handleClientPolicy(w http.ResponseWriter, r *http.Request) {
+	if s.config.ClientPolicyPath == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Read on every request so IT can publish a new policy without restarting the server
+	data, err := os.ReadFile(s.config.ClientPolicyPath)
+	if err != nil {
+		log.Printf("Client policy read error: %v", err)
+		http.Error(w, "Failed to read client policy", http.StatusInternalServerError)
+		return
+	}
+
+	etag := fmt.Sprintf(`"%x"`, sha256.Sum256(data))
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/toml; charset=utf-8")
+	_, _ = w.Write(data)
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/shawntz/cassh/internal/audit"
+	"github.com/shawntz/cassh/internal/ca"
+	"github.com/shawntz/cassh/internal/device"
+	"golang.org/x/crypto/ssh"
+)
+
+// Device authorization grant (RFC 8628) for headless clients such as cassh-cli over SSH:
+//  1. The client POSTs its public key to /api/v1/device/authorize and shows the user code
+//  2. The user opens /device in any browser, enters the code and signs in with SSO as usual
+//  3. The OIDC callback signs the cert and hands it to the authorization instead of the browser
+//  4. The client polls /api/v1/device/token until the cert arrives
+
+// deviceAuthorizationResponse is the RFC 8628 section 3.2 response
+type deviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// deviceTokenResponse is returned once the user has approved the authorization
+type deviceTokenResponse struct {
+	Certificate string `json:"certificate"`
+}
+
+// handleDeviceAuthorize starts a device authorization for a public key
+func (s *Server) handleDeviceAuthorize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		PublicKey     string `json:"public_key"`
+		Template      string `json:"template"`
+		Target        string `json:"target"`
+		DeviceName    string `json:"device_name"`
+		ClientVersion string `json:"client_version"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	pubKey, err := ca.ParsePublicKey([]byte(req.PublicKey))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid public_key")
+		return
+	}
+
+	auth, err := s.devices.Start(device.Request{
+		PubKey:   strings.TrimSpace(req.PublicKey),
+		Template: req.Template,
+		Target:   req.Target,
+		ClientIP: s.clientIP(r),
+
+		DeviceName:    clientField(req.DeviceName),
+		ClientVersion: clientField(req.ClientVersion),
+	})
+	if errors.Is(err, device.ErrTooManyPending) {
+		log.Printf("Device authorization refused: %v", err)
+		s.tooManyRequests(w, r, "pending_devices", pendingStatesRetryAfter)
+		return
+	}
+	if err != nil {
+		log.Printf("Device authorization error: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to start device authorization")
+		return
+	}
+
+	s.emit(r, audit.Event{Type: audit.EventAuthStarted, Fingerprint: ssh.FingerprintSHA256(pubKey)})
+	s.metrics.authStarts.Inc()
+
+	verificationURI := strings.TrimSuffix(s.config.ServerBaseURL, "/") + "/device"
+	userCode := device.FormatUserCode(auth.UserCode)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(deviceAuthorizationResponse{
+		DeviceCode:              auth.DeviceCode,
+		UserCode:                userCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?" + url.Values{"user_code": {userCode}}.Encode(),
+		ExpiresIn:               int(time.Until(auth.ExpiresAt).Seconds()),
+		Interval:                int(auth.Interval.Seconds()),
+	})
+}
+
+// handleDeviceToken is polled by the client until the authorization completes
+// Errors use the RFC 8628 section 3.5 codes (authorization_pending, slow_down, access_denied, expired_token)
+func (s *Server) handleDeviceToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		DeviceCode string `json:"device_code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.DeviceCode == "" {
+		writeJSONError(w, http.StatusBadRequest, "device_code is required")
+		return
+	}
+
+	cert, err := s.devices.Poll(req.DeviceCode)
+	if err != nil {
+		writeDeviceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(deviceTokenResponse{Certificate: cert})
+}
+
+// writeDeviceError writes an OAuth-style error response for a poll failure
+func writeDeviceError(w http.ResponseWriter, err error) {
+	code := device.ErrInvalidGrant.Error()
+	for _, known := range []error{device.ErrAuthorizationPending, device.ErrSlowDown, device.ErrAccessDenied, device.ErrExpiredToken} {
+		if errors.Is(err, known) {
+			code = known.Error()
+			break
+		}
+	}
+
+	resp := map[string]string{"error": code}
+	if description := strings.TrimPrefix(err.Error(), code+": "); description != code {
+		resp["error_description"] = description
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// handleDevicePage asks for the user code, then shows what the user is about to approve
+func (s *Server) handleDevicePage(w http.ResponseWriter, r *http.Request) {
+	userCode := r.URL.Query().Get("user_code")
+	if userCode == "" {
+		s.renderDevicePage(w, devicePage{})
+		return
+	}
+
+	auth, err := s.devices.Lookup(userCode)
+	if err != nil {
+		s.renderDevicePage(w, devicePage{UserCode: userCode, Error: deviceErrorMessage(err)})
+		return
+	}
+
+	page := devicePage{
+		UserCode: device.FormatUserCode(auth.UserCode),
+		Template: auth.Request.Template,
+		Target:   auth.Request.Target,
+		ClientIP: auth.Request.ClientIP,
+		Confirm:  true,
+	}
+	if key, err := ca.ParsePublicKey([]byte(auth.Request.PubKey)); err == nil {
+		page.Fingerprint = ssh.FingerprintSHA256(key)
+	}
+	s.renderDevicePage(w, page)
+}
+
+// handleDeviceApprove starts SSO for a confirmed user code
+// It only accepts POSTs from the confirmation page, so a link alone can't approve someone else's code
+func (s *Server) handleDeviceApprove(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.sameOrigin(r) {
+		http.Error(w, "Cross-origin request rejected", http.StatusForbidden)
+		return
+	}
+
+	auth, err := s.devices.Lookup(r.FormValue("user_code"))
+	if err != nil {
+		s.renderDevicePage(w, devicePage{UserCode: r.FormValue("user_code"), Error: deviceErrorMessage(err)})
+		return
+	}
+
+	// In devel mode, redirect to mock auth
+	if s.devMode {
+		http.Redirect(w, r, "/auth/dev?"+url.Values{"device": {auth.UserCode}}.Encode(), http.StatusFound)
+		return
+	}
+
+	authURL, err := s.auth.StartAuth(r.Context(), w, deviceAuthRequest(auth))
+	if err != nil {
+		s.authStartError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// completeDeviceAuth hands a signed cert to the waiting client and tells the user to go back to it
+func (s *Server) completeDeviceAuth(w http.ResponseWriter, userCode string, cert []byte) {
+	if err := s.devices.Approve(userCode, string(cert)); err != nil {
+		s.renderDevicePage(w, devicePage{UserCode: userCode, Error: deviceErrorMessage(err)})
+		return
+	}
+	s.renderDevicePage(w, devicePage{Done: true})
+}
+
+// denyDeviceAuth fails a device authorization so the polling client stops waiting
+func (s *Server) denyDeviceAuth(userCode, reason string) {
+	if userCode == "" {
+		return
+	}
+	if err := s.devices.Deny(userCode, reason); err != nil {
+		log.Printf("Device authorization %s: %v", userCode, err)
+	}
+}
+
+// sameOrigin reports whether a browser request came from this server's pages
+// Requests without an Origin header (older browsers, non-browser clients) are allowed
+func (s *Server) sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	base, err := url.Parse(s.config.ServerBaseURL)
+	if err != nil {
+		return false
+	}
+	return origin == base.Scheme+"://"+base.Host
+}
+
+// devicePage is the data for templates/device.html
+type devicePage struct {
+	UserCode    string
+	Fingerprint string
+	Template    string
+	Target      string
+	ClientIP    string
+	Error       string
+	Confirm     bool // Show the request details and the sign-in button
+	Done        bool // The cert was handed to the client
+	DevMode     bool
+}
+
+func (s *Server) renderDevicePage(w http.ResponseWriter, page devicePage) {
+	page.DevMode = s.devMode
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := s.tmpl.ExecuteTemplate(w, "device.html", page); err != nil {
+		log.Printf("Template error: %v", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+	}
+}
+
+// deviceErrorMessage explains a user code lookup failure
+func deviceErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, device.ErrExpiredToken):
+		return "This code has expired. Start again from your terminal."
+	default:
+		return "Unknown or already-used code. Check the code shown in your terminal."
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/shawntz/cassh/internal/config"
+	"github.com/shawntz/cassh/internal/config/serverlint"
+	"github.com/shawntz/cassh/internal/github"
+	"github.com/shawntz/cassh/internal/principal"
+)
+
+// githubAPITimeout bounds each GitHub API request of the org membership check
+const githubAPITimeout = 10 * time.Second
+
+// newGitHubClient builds the GitHub API client, or returns nil if neither the org check nor SCIM lookups need it
+func newGitHubClient(cfg *config.ServerConfig) (*github.Client, error) {
+	scim := cfg.GitHubPrincipalSource == principal.SourceSCIM
+	if len(cfg.GitHubAllowedOrgs) == 0 && !scim {
+		return nil, nil
+	}
+	ghCfg := serverlint.GitHubConfig(cfg)
+	ghCfg.HTTPClient = &http.Client{Timeout: githubAPITimeout}
+	client, err := github.New(ghCfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.GitHubAllowedOrgs) > 0 {
+		log.Printf("Requiring membership of GitHub org(s) %v via %s", cfg.GitHubAllowedOrgs, ghCfg.APIURL)
+	}
+	if scim {
+		log.Printf("Looking up GitHub logins from SCIM identities via %s", github.GraphQLURL(ghCfg.APIURL))
+	}
+	return client, nil
+}
+
+// checkGitHubOrgs refuses principals that aren't active, unsuspended members of an allowed GitHub org
+// It fails closed: if GitHub can't be asked, nothing is signed
+func (s *Server) checkGitHubOrgs(ctx context.Context, principal string) error {
+	if len(s.config.GitHubAllowedOrgs) == 0 {
+		return nil
+	}
+	err := s.github.CheckMember(ctx, principal, s.config.GitHubAllowedOrgs)
+	if err == nil || errors.Is(err, github.ErrNotMember) || errors.Is(err, github.ErrSuspended) {
+		return err
+	}
+	log.Printf("GitHub org membership check failed for %s: %v", principal, err)
+	return fmt.Errorf("could not verify GitHub org membership")
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/shawntz/cassh/internal/audit"
+	"github.com/shawntz/cassh/internal/ca"
+	"github.com/shawntz/cassh/internal/hosts"
+	"github.com/shawntz/cassh/internal/policy"
+	"golang.org/x/crypto/ssh"
+)
+
+// hostPrincipalSource labels host certs in cassh_certs_issued_total
+const hostPrincipalSource = "host"
+
+// Host certificates let clients trust servers with a known_hosts @cert-authority line instead of TOFU prompts:
+//  1. A registered machine enrolls: POST /api/v1/host/cert with its bootstrap token and host public key
+//  2. Before the cert expires it renews: POST /api/v1/host/renew, signed with the host key
+//  3. Clients add the output of /ca-bundle?format=cert-authority to known_hosts
+
+// handleHostCert issues a host cert to a machine that presents its bootstrap token
+// POST /api/v1/host/cert with "Authorization: Bearer <token>" and {"public_key": "...", "hostnames": [...]}
+func (s *Server) handleHostCert(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.hosts.Enabled() {
+		writeJSONError(w, http.StatusNotFound, "host certificates are not configured")
+		return
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		writeJSONError(w, http.StatusUnauthorized, "bearer token required")
+		return
+	}
+
+	var req struct {
+		PublicKey string   `json:"public_key"`
+		Hostnames []string `json:"hostnames"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	hostKey, err := ca.ParsePublicKey([]byte(req.PublicKey))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid public_key")
+		return
+	}
+	if _, isCert := hostKey.(*ssh.Certificate); isCert {
+		writeJSONError(w, http.StatusBadRequest, "public_key must be a host key, not a certificate")
+		return
+	}
+
+	machine, err := s.hosts.Authenticate(token)
+	if err != nil {
+		log.Printf("Host bootstrap rejected: %v", err)
+		s.emit(r, audit.Event{Type: audit.EventAuthFailed, Fingerprint: ssh.FingerprintSHA256(hostKey), Reason: err.Error()})
+		writeJSONError(w, http.StatusUnauthorized, "invalid bootstrap token")
+		return
+	}
+
+	hostnames, err := machine.CheckHostnames(req.Hostnames)
+	if err != nil {
+		s.denyHost(w, r, machine.Name, err)
+		return
+	}
+
+	s.issueHostCert(w, r, machine, hostKey, hostnames)
+}
+
+// handleHostRenew issues a fresh host cert to a machine holding a current one
+// POST /api/v1/host/renew with a ca.HostRenewalRequest signed by the host key
+func (s *Server) handleHostRenew(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.hosts.Enabled() {
+		writeJSONError(w, http.StatusNotFound, "host certificates are not configured")
+		return
+	}
+
+	var req ca.HostRenewalRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	// Any of our CAs may have signed the current cert (e.g., before [hosts] ca changed)
+	var signedBy *authority
+	if cert, err := ca.ParseCertificate([]byte(req.Certificate)); err == nil {
+		signedBy = s.authorityFor(cert)
+	}
+	if signedBy == nil {
+		writeJSONError(w, http.StatusForbidden, "certificate was not issued by this server")
+		return
+	}
+
+	current, err := signedBy.ca.VerifyHostRenewal(&req, time.Now())
+	if err != nil {
+		log.Printf("Host renewal rejected: %v", err)
+		s.emit(r, audit.Event{Type: audit.EventAuthFailed, CA: signedBy.name, Reason: err.Error()})
+		writeJSONError(w, http.StatusForbidden, "renewal request could not be verified")
+		return
+	}
+
+	revoked, err := s.isRevoked(r.Context(), current)
+	if err != nil {
+		log.Printf("Revocation lookup error: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to check revocations")
+		return
+	}
+	if revoked {
+		s.denyHost(w, r, current.KeyId, fmt.Errorf("certificate serial %d is revoked", current.Serial))
+		return
+	}
+
+	// The machine must still be registered for every hostname it holds
+	machine := s.hosts.ForHostnames(current.ValidPrincipals)
+	if machine == nil {
+		s.denyHost(w, r, current.KeyId, fmt.Errorf("%w: %v", hosts.ErrHostnameNotAllowed, current.ValidPrincipals))
+		return
+	}
+
+	s.issueHostCert(w, r, machine, current.Key, current.ValidPrincipals)
+}
+
+// hostIdentity keys a machine's signing limit
+func hostIdentity(machine *hosts.Machine) string {
+	return "host:" + machine.Name
+}
+
+// issueHostCert signs hostKey for hostnames with the host CA, records it and returns it
+func (s *Server) issueHostCert(w http.ResponseWriter, r *http.Request, machine *hosts.Machine, hostKey ssh.PublicKey, hostnames []string) {
+	if !s.allowSigning(w, r, hostIdentity(machine)) {
+		return
+	}
+	a := s.authorities[caName(s.hosts.CA())]
+	if a == nil {
+		log.Printf("Host cert signing error: no CA %q configured", caName(s.hosts.CA()))
+		writeJSONError(w, http.StatusServiceUnavailable, "no CA configured")
+		return
+	}
+
+	actor := "host:" + machine.Name
+	signStart := time.Now()
+	cert, err := a.ca.SignHost(&ca.HostCertRequest{
+		PublicKey:   hostKey,
+		KeyIDFormat: s.hostKeyIDFormat,
+		KeyIDFields: s.hostKeyIDFields(r, machine, a.name),
+		Hostnames:   hostnames,
+		Validity:    s.hosts.Validity(),
+	})
+	s.metrics.signingDuration.Observe(time.Since(signStart).Seconds())
+	if err != nil {
+		log.Printf("Host cert signing error: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to generate certificate")
+		return
+	}
+
+	if err := s.recordIssued(r, cert, actor, s.clientIP(r)); err != nil {
+		log.Printf("Ledger error: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to generate certificate")
+		return
+	}
+
+	log.Printf("Signed host cert for %s: serial=%d, hostnames=%v, ca=%s", actor, cert.Serial, cert.ValidPrincipals, a.name)
+	s.emit(r, certIssuedEvent(cert, actor, machine.Name, policy.Decision{CA: s.hosts.CA()}))
+	s.metrics.certsIssued.Inc(hostPrincipalSource)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"certificate": strings.TrimSpace(string(ca.MarshalCertificate(cert))),
+	})
+}
+
+// denyHost records a refused host cert request and returns 403
+func (s *Server) denyHost(w http.ResponseWriter, r *http.Request, actor string, err error) {
+	log.Printf("Host cert denied for %s: %v", actor, err)
+	s.emit(r, audit.Event{
+		Type:   audit.EventIssuanceDenied,
+		Actor:  actor,
+		Reason: err.Error(),
+	})
+	msg := "host certificate denied"
+	if errors.Is(err, hosts.ErrHostnameNotAllowed) {
+		msg = err.Error()
+	}
+	writeJSONError(w, http.StatusForbidden, msg)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/shawntz/cassh/internal/ca"
+	"github.com/shawntz/cassh/internal/memes"
+	"github.com/shawntz/cassh/internal/oidc"
+	"github.com/shawntz/cassh/internal/policy"
+	"golang.org/x/crypto/ssh"
+)
+
+// issuanceDenied is returned by issueUserCert when the user may not have the cert
+type issuanceDenied struct {
+	decision policy.Decision
+}
+
+func (e *issuanceDenied) Error() string {
+	return e.decision.Reason
+}
+
+// issuanceFailed is returned by issueUserCert when the server couldn't sign or record an allowed cert
+// reason labels the callback failure metric
+type issuanceFailed struct {
+	reason string
+	err    error
+}
+
+func (e *issuanceFailed) Error() string {
+	return fmt.Sprintf("%s: %v", e.reason, e.err)
+}
+
+func (e *issuanceFailed) Unwrap() error {
+	return e.err
+}
+
+// issueUserCert signs a user cert for pubKey once the user has signed in, whichever way they did
+// It resolves the principal, applies the issuance policy and org check, signs with the CA the policy picked, and records, audits and counts the cert
+// Errors are *issuanceDenied or *issuanceFailed; via names the sign-in for the log
+func (s *Server) issueUserCert(r *http.Request, via string, userInfo *oidc.UserInfo, pubKey ssh.PublicKey, authReq *oidc.AuthRequest) (*ssh.Certificate, error) {
+	// Find the GitHub login to issue the cert for
+	principal, err := s.resolvePrincipal(r.Context(), userInfo)
+	if err != nil {
+		return nil, &issuanceDenied{decision: policy.Decision{Reason: err.Error()}}
+	}
+	log.Printf("User authenticated via %s: %s (principal: %s)", via, userInfo.Email, principal)
+
+	// Apply the issuance policy
+	decision := s.policy.Evaluate(policyInput(userInfo, principal, authReq.Template, authReq.Target))
+	if !decision.Allowed {
+		return nil, &issuanceDenied{decision: decision}
+	}
+	if err := s.checkGitHubOrgs(r.Context(), principal); err != nil {
+		return nil, &issuanceDenied{decision: policy.Decision{Rule: decision.Rule, Reason: err.Error()}}
+	}
+
+	// Generate cert with GitHub login extension, signed by the CA the policy picked
+	cert, signedBy, err := s.sign(&ca.CertRequest{
+		PublicKey:      pubKey,
+		KeyIDFormat:    s.keyIDFormat,
+		KeyIDFields:    s.userKeyIDFields(r, userInfo, principal, authReq),
+		Principals:     decision.Principals,
+		GitHubUsername: principal,
+		Validity:       decision.Validity,
+		Extensions:     decision.Extensions,
+	}, decision)
+	if err != nil {
+		if errors.Is(err, ca.ErrPrincipalNotAllowed) {
+			return nil, &issuanceDenied{decision: policy.Decision{Rule: decision.Rule, Reason: err.Error()}}
+		}
+		log.Printf("Cert signing error: %v", err)
+		return nil, &issuanceFailed{reason: "signing_failed", err: err}
+	}
+
+	if err := s.recordIssued(r, cert, userInfo.Email, s.requesterIP(r, authReq)); err != nil {
+		log.Printf("Ledger error: %v", err)
+		return nil, &issuanceFailed{reason: "ledger_error", err: err}
+	}
+
+	log.Printf("Signed cert for %s: serial=%d, principal=%s, login@%s=%s, ca=%s", userInfo.Email, cert.Serial, principal, signedBy.githubHost, principal, signedBy.name)
+	s.emit(r, certIssuedEvent(cert, userInfo.Email, userInfo.Subject, decision))
+	s.metrics.certsIssued.Inc(principalSourceLabel(s.config.GitHubPrincipalSource))
+	return cert, nil
+}
+
+// finishBrowserIssuance answers a browser sign-in with the outcome of issueUserCert
+// A device authorization gets the cert or denial; otherwise the success page shows the cert
+func (s *Server) finishBrowserIssuance(w http.ResponseWriter, r *http.Request, userInfo *oidc.UserInfo, authReq *oidc.AuthRequest, cert *ssh.Certificate, err error) {
+	var denied *issuanceDenied
+	if errors.As(err, &denied) {
+		s.denyDeviceAuth(authReq.UserCode, denied.decision.Reason)
+		s.denyIssuance(w, r, userInfo, denied.decision)
+		return
+	}
+	if err != nil {
+		var failed *issuanceFailed
+		if errors.As(err, &failed) {
+			s.metrics.callbackFailures.Inc(failed.reason)
+		}
+		http.Error(w, "Failed to generate certificate", http.StatusInternalServerError)
+		return
+	}
+
+	certData := ca.MarshalCertificate(cert)
+	if authReq.UserCode != "" {
+		s.completeDeviceAuth(w, authReq.UserCode, certData)
+		return
+	}
+
+	// Render success page with cert
+	data := struct {
+		Meme     memes.MemeData
+		Cert     string
+		CertInfo *ca.CertInfo
+		User     *oidc.UserInfo
+		DevMode  bool
+	}{
+		Meme:     memes.GetMemeData("random"),
+		Cert:     string(certData),
+		CertInfo: ca.GetCertInfo(cert),
+		User:     userInfo,
+		DevMode:  s.devMode,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := s.tmpl.ExecuteTemplate(w, "success.html", data); err != nil {
+		log.Printf("Template error: %v", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shawntz/cassh/internal/ca"
+	"github.com/shawntz/cassh/internal/device"
+	"github.com/shawntz/cassh/internal/hosts"
+	"github.com/shawntz/cassh/internal/oidc"
+	"github.com/shawntz/cassh/internal/workload"
+)
+
+// maxClientFieldLen caps the device name and version a client can put in its key ID
+const maxClientFieldLen = 64
+
+// clientField cleans up a value the client reported about itself
+func clientField(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) > maxClientFieldLen {
+		value = value[:maxClientFieldLen]
+	}
+	return value
+}
+
+// deviceAuthRequest is the auth request for a pending device authorization
+func deviceAuthRequest(auth *device.Authorization) *oidc.AuthRequest {
+	return &oidc.AuthRequest{
+		PubKey:        auth.Request.PubKey,
+		Template:      auth.Request.Template,
+		Target:        auth.Request.Target,
+		UserCode:      auth.UserCode,
+		DeviceName:    auth.Request.DeviceName,
+		ClientVersion: auth.Request.ClientVersion,
+		ClientIP:      auth.Request.ClientIP,
+	}
+}
+
+// requesterIP is where the client asked for the cert, which for device flow isn't the browser that signed in
+func (s *Server) requesterIP(r *http.Request, authReq *oidc.AuthRequest) string {
+	if authReq.ClientIP != "" {
+		return authReq.ClientIP
+	}
+	return s.clientIP(r)
+}
+
+// userKeyIDFields are the key ID fields of a user cert; sign adds the CA and ca.Sign the serial
+func (s *Server) userKeyIDFields(r *http.Request, userInfo *oidc.UserInfo, principal string, authReq *oidc.AuthRequest) ca.KeyIDFields {
+	return ca.KeyIDFields{
+		"sub":            userInfo.Subject,
+		"email":          userInfo.Email,
+		"name":           userInfo.Name,
+		"username":       userInfo.Username,
+		"principal":      principal,
+		"device":         authReq.DeviceName,
+		"client_version": authReq.ClientVersion,
+		"ip":             s.requesterIP(r, authReq),
+		"template":       authReq.Template,
+		"target":         authReq.Target,
+		"time":           strconv.FormatInt(time.Now().Unix(), 10),
+	}
+}
+
+// workloadKeyIDFields are the key ID fields of a workload cert; sign adds the CA and ca.Sign the serial
+func (s *Server) workloadKeyIDFields(r *http.Request, id *workload.Identity, principal, template, target string) ca.KeyIDFields {
+	return ca.KeyIDFields{
+		"issuer":    id.Issuer,
+		"sub":       id.Subject,
+		"principal": principal,
+		"ip":        s.clientIP(r),
+		"template":  template,
+		"target":    target,
+		"time":      strconv.FormatInt(time.Now().Unix(), 10),
+	}
+}
+
+// hostKeyIDFields are the key ID fields of a host cert signed by the CA named caName
+func (s *Server) hostKeyIDFields(r *http.Request, machine *hosts.Machine, caName string) ca.KeyIDFields {
+	return ca.KeyIDFields{
+		"host": machine.Name,
+		"ip":   s.clientIP(r),
+		"ca":   caName,
+		"time": strconv.FormatInt(time.Now().Unix(), 10),
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/shawntz/cassh/internal/ca"
+	"github.com/shawntz/cassh/internal/hosts"
+	"github.com/shawntz/cassh/internal/policy"
+	"github.com/shawntz/cassh/internal/workload"
+	"golang.org/x/crypto/ssh"
+)
+
+// caConfig is a [ca] table with a freshly generated CA key
+func caConfig(t *testing.T) string {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "ca_key")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprintf("[ca]\nprivate_key_path = %q\n", path)
+}
+
+func testPublicKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sshPub
+}
+
+func TestWorkloadKeyID(t *testing.T) {
+	s := newTestState(t, caConfig(t)).current.Load()
+	r := requestFrom(http.MethodPost, "/api/v1/workload/cert", "10.0.0.1")
+	id := &workload.Identity{Issuer: "github-actions", Subject: "repo:corp/app:ref:refs/heads/main"}
+
+	cert, _, err := s.sign(&ca.CertRequest{
+		PublicKey:      testPublicKey(t),
+		KeyIDFormat:    s.workloadKeyIDFormat,
+		KeyIDFields:    s.workloadKeyIDFields(r, id, "deploy-bot", "", ""),
+		Principals:     []string{"deploy-bot"},
+		GitHubUsername: "deploy-bot",
+	}, policy.Decision{})
+	if err != nil {
+		t.Fatalf("sign() error = %v", err)
+	}
+
+	fields, err := ca.ParseKeyID(ca.DefaultWorkloadKeyIDFormat, cert.KeyId)
+	if err != nil {
+		t.Fatalf("ParseKeyID(%q) error = %v", cert.KeyId, err)
+	}
+	if fields["issuer"] != id.Issuer || fields["sub"] != id.Subject || fields["ca"] != "default" {
+		t.Errorf("key ID fields = %v, want the issuer, subject and CA", fields)
+	}
+}
+
+func TestHostKeyID(t *testing.T) {
+	const format = "host/{host}/{serial}/{ca}"
+	s := newTestState(t, caConfig(t)+"\n[hosts]\nkey_id_format = \""+format+"\"\n").current.Load()
+
+	rec := httptest.NewRecorder()
+	s.issueHostCert(rec, requestFrom(http.MethodPost, "/api/v1/hosts/cert", "10.0.0.1"), &hosts.Machine{Name: "web-1"}, testPublicKey(t), []string{"web-1.corp.example.com"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("issueHostCert() = %d %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Certificate string `json:"certificate"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	cert, err := ca.ParseCertificate([]byte(resp.Certificate))
+	if err != nil {
+		t.Fatalf("ParseCertificate() error = %v", err)
+	}
+
+	fields, err := ca.ParseKeyID(format, cert.KeyId)
+	if err != nil {
+		t.Fatalf("ParseKeyID(%q) error = %v", cert.KeyId, err)
+	}
+	if fields["host"] != "web-1" || fields["serial"] != strconv.FormatUint(cert.Serial, 10) || fields["ca"] != "default" {
+		t.Errorf("key ID fields = %v, want the machine, serial and CA", fields)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/shawntz/cassh/internal/config"
+	"github.com/shawntz/cassh/internal/config/serverlint"
+)
+
+// runConfigCommand handles `cassh-server config lint [FILE]`
+// Prints every problem in the server config (the one the server would load by default), with env vars applied
+func runConfigCommand(args []string) int {
+	if len(args) < 1 || args[0] != "lint" || len(args) > 2 {
+		fmt.Fprintln(os.Stderr, "Usage: cassh-server config lint [FILE]")
+		return 2
+	}
+	path := config.ServerConfigPath()
+	if len(args) == 2 {
+		path = args[1]
+	}
+
+	problems, err := serverlint.Lint(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %s: %v\n", path, err)
+		return 1
+	}
+	if len(problems) == 0 {
+		fmt.Printf("✅ %s\n", path)
+		return 0
+	}
+	for _, problem := range problems {
+		if problem.Line > 0 {
+			fmt.Printf("%s:%d: %s\n", path, problem.Line, problem.Message)
+		} else {
+			fmt.Printf("%s: %s\n", path, problem.Message)
+		}
+	}
+	return 1
+}
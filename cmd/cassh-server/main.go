@@ -1,26 +1,34 @@
 // cassh-server is the certificate authority web service
-// Serves the meme landing page and handles OIDC authentication with Entra ID
+// Serves the meme landing page and handles OIDC authentication (Entra ID, Okta, Google, Keycloak, ...)
 package main
 
 import (
 	"context"
 	"embed"
 	"encoding/json"
-	"fmt"
+	"flag"
 	"html/template"
 	"io/fs"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/shawntz/cassh/internal/audit"
 	"github.com/shawntz/cassh/internal/ca"
 	"github.com/shawntz/cassh/internal/config"
+	"github.com/shawntz/cassh/internal/config/serverlint"
+	"github.com/shawntz/cassh/internal/device"
+	"github.com/shawntz/cassh/internal/ledger"
 	"github.com/shawntz/cassh/internal/memes"
 	"github.com/shawntz/cassh/internal/oidc"
+	"golang.org/x/crypto/ssh"
 )
 
 //go:embed templates/*
@@ -29,116 +37,135 @@ var templatesFS embed.FS
 //go:embed static/*
 var staticFS embed.FS
 
-// Holds the cassh server state
+// Server serves requests with one snapshot of the components built from config
+// Each install makes a new Server sharing serverState, so a reload never lands halfway through a request
 type Server struct {
-	config  *config.ServerConfig
-	auth    *oidc.Authenticator
-	ca      *ca.CertificateAuthority
-	tmpl    *template.Template
-	devMode bool
+	*serverState
+	*components
+	handler http.Handler // Routes bound to this snapshot
 }
 
-func main() {
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	log.Println("Starting cassh-server...")
+// serverState is what outlives config reloads
+type serverState struct {
+	current    atomic.Pointer[Server] // The snapshot new requests are served with
+	configPath string
+	states     oidc.StateStore
+	devices    *device.Manager
+	store      ledger.Store
+	audit      *audit.Logger
+	metrics    *serverMetrics
+	tmpl       *template.Template
+	devMode    bool
+}
 
-	// Load server config (file + env var overrides)
-	policyPath := os.Getenv("CASSH_POLICY_PATH")
-	if policyPath == "" {
-		// Try default locations
-		if _, err := os.Stat("cassh.policy.toml"); err == nil {
-			policyPath = "cassh.policy.toml"
+func main() {
+	// Offline subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "audit":
+			os.Exit(runAuditCommand(os.Args[2:]))
+		case "policy":
+			os.Exit(runPolicyCommand(os.Args[2:]))
+		case "config":
+			os.Exit(runConfigCommand(os.Args[2:]))
 		}
 	}
 
-	cfg, err := config.LoadServerConfig(policyPath)
+	printConfig := flag.Bool("print-config", false, "Print the effective config and where each value came from, then exit")
+	flag.Parse()
+
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+
+	// Load server config (file + env var overrides)
+	configPath := config.ServerConfigPath()
+	cfg, err := config.LoadServerConfig(configPath)
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
+	if *printConfig {
+		printServerConfig(os.Stdout, configPath, cfg)
+		return
+	}
+
+	log.Println("Starting cassh-server...")
 
 	devMode := cfg.IsDevMode()
 	if devMode {
 		log.Println("⚠️  DEVELOPMENT MODE - authentication is mocked!")
 	} else {
 		// Validate required config in production mode
-		if err := cfg.Validate(); err != nil {
+		if err := serverlint.Validate(cfg); err != nil {
 			log.Fatalf("Configuration error: %v", err)
 		}
-	}
-
-	// Initialize CA (skip in dev mode if no key)
-	var certAuthority *ca.CertificateAuthority
-	if cfg.CAPrivateKey != "" {
-		certAuthority, err = ca.NewCA([]byte(cfg.CAPrivateKey), cfg.CertValidityHours, nil)
-		if err != nil {
-			log.Fatalf("Failed to initialize CA: %v", err)
+		if cfg.OIDCClientSecret == "" {
+			log.Println("No OIDC client_secret configured - signing in as a public client (PKCE only)")
 		}
-	} else if !devMode {
-		log.Fatalf("CA private key is required in production mode")
 	}
 
-	// Initialize OIDC authenticator (only if not in devel mode)
-	var auth *oidc.Authenticator
+	// The OIDC state store is opened once so logins in flight survive config reloads
+	var states oidc.StateStore
 	if !devMode {
-		ctx := context.Background()
-		redirectURL := cfg.OIDCRedirectURL
-		if redirectURL == "" {
-			redirectURL = cfg.ServerBaseURL + "/auth/callback"
-		}
-		auth, err = oidc.NewAuthenticator(ctx, &oidc.EntraConfig{
-			TenantID:     cfg.OIDCTenant,
-			ClientID:     cfg.OIDCClientID,
-			ClientSecret: cfg.OIDCClientSecret,
-			RedirectURL:  redirectURL,
-		})
-		if err != nil {
-			log.Fatalf("Failed to initialize OIDC: %v", err)
+		if states, err = openStateStore(cfg); err != nil {
+			log.Fatalf("Failed to open OIDC state store: %v", err)
 		}
 	}
 
-	// Parse templates
-	tmpl, err := template.ParseFS(templatesFS, "templates/*.html")
+	// CAs, issuance policy, templates, OIDC, workload issuers and hosts
+	built, err := buildComponents(cfg, states)
 	if err != nil {
-		log.Fatalf("Failed to parse templates: %v", err)
+		log.Fatalf("Configuration error: %v", err)
 	}
 
-	server := &Server{
-		config:  cfg,
-		auth:    auth,
-		ca:      certAuthority,
-		tmpl:    tmpl,
-		devMode: devMode,
+	// Open the ledger of issued certs and revocations
+	store, err := ledger.Open(cfg.StoreDriver, cfg.StorePath)
+	if err != nil {
+		log.Fatalf("Failed to open ledger: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+	if cfg.StorePath == "" {
+		log.Println("⚠️  No store_path configured - issued certs and revocations are kept in memory only")
 	}
 
-	// Setup routes
-	mux := http.NewServeMux()
-
-	// Static files
-	staticContent, _ := fs.Sub(staticFS, "static")
-	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.FS(staticContent))))
+	// Open the audit log
+	auditLog, err := audit.Open(cfg.AuditSink, cfg.AuditPath)
+	if err != nil {
+		log.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer func() { _ = auditLog.Close() }()
 
-	// Routes
-	mux.HandleFunc("/", server.handleLanding)
-	mux.HandleFunc("/auth/start", server.handleAuthStart)
-	mux.HandleFunc("/auth/callback", server.handleAuthCallback)
-	mux.HandleFunc("/auth/dev", server.handleDevAuth) // Dev mode mock auth
-	mux.HandleFunc("/cert/issue", server.handleCertIssue)
-	mux.HandleFunc("/health", server.handleHealth)
+	// Parse templates
+	tmpl, err := template.ParseFS(templatesFS, "templates/*.html")
+	if err != nil {
+		log.Fatalf("Failed to parse templates: %v", err)
+	}
 
-	// Start server
-	addr := os.Getenv("CASSH_LISTEN_ADDR")
-	if addr == "" {
-		addr = ":8080"
+	state := &serverState{
+		configPath: configPath,
+		states:     states,
+		devices:    device.NewManager(0, 0, cfg.DeviceMaxPending),
+		store:      store,
+		audit:      auditLog,
+		tmpl:       tmpl,
+		devMode:    devMode,
 	}
+	state.metrics = newServerMetrics(state)
+	state.install(built)
+	defer func() { state.current.Load().retire() }()
 
+	// Start server
 	httpServer := &http.Server{
-		Addr:         addr,
-		Handler:      logMiddleware(mux),
+		Addr:         cfg.ListenAddr,
+		Handler:      state,
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
 
+	// Reload config on SIGHUP or when the config file changes
+	reloadCtx, stopReloads := context.WithCancel(context.Background())
+	defer stopReloads()
+	go state.watchReloads(reloadCtx)
+
 	// Graceful shutdown
 	go func() {
 		sigChan := make(chan os.Signal, 1)
@@ -152,9 +179,9 @@ func main() {
 		}
 	}()
 
-	log.Printf("cassh-server listening on %s", addr)
+	log.Printf("cassh-server listening on %s", cfg.ListenAddr)
 	if devMode {
-		log.Printf("🌐 Open http://localhost%s in your browser", addr)
+		log.Printf("🌐 Open http://localhost%s in your browser", cfg.ListenAddr)
 	}
 	if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
 		log.Fatalf("Server error: %v", err)
@@ -168,22 +195,32 @@ func (s *Server) handleLanding(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get pubkey from query params (sent by menubar app)
+	// Get pubkey (and optional cert template) from query params (sent by menubar app or CLI)
 	pubKey := r.URL.Query().Get("pubkey")
+	template := r.URL.Query().Get("template")
+	target := r.URL.Query().Get("target")
 
 	// Get random meme data
 	memeData := memes.GetMemeData("random")
 
 	data := struct {
-		Meme       memes.MemeData
-		PubKey     string
-		ServerName string
-		DevMode    bool
+		Meme          memes.MemeData
+		PubKey        string
+		Template      string
+		Target        string
+		DeviceName    string
+		ClientVersion string
+		ServerName    string
+		DevMode       bool
 	}{
-		Meme:       memeData,
-		PubKey:     pubKey,
-		ServerName: s.config.ServerBaseURL,
-		DevMode:    s.devMode,
+		Meme:          memeData,
+		PubKey:        pubKey,
+		Template:      template,
+		Target:        target,
+		DeviceName:    clientField(r.URL.Query().Get("device_name")),
+		ClientVersion: clientField(r.URL.Query().Get("client_version")),
+		ServerName:    s.config.ServerBaseURL,
+		DevMode:       s.devMode,
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -201,16 +238,42 @@ func (s *Server) handleAuthStart(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	startEvent := audit.Event{Type: audit.EventAuthStarted}
+	if key, err := ca.ParsePublicKey([]byte(pubKey)); err == nil {
+		startEvent.Fingerprint = ssh.FingerprintSHA256(key)
+	}
+	s.emit(r, startEvent)
+	s.metrics.authStarts.Inc()
+
+	authReq := &oidc.AuthRequest{
+		PubKey:        pubKey,
+		Template:      r.URL.Query().Get("template"),
+		Target:        r.URL.Query().Get("target"),
+		DeviceName:    clientField(r.URL.Query().Get("device_name")),
+		ClientVersion: clientField(r.URL.Query().Get("client_version")),
+		ClientIP:      s.clientIP(r),
+	}
+
 	// In devel mode, redirect to mock auth
 	if s.devMode {
-		http.Redirect(w, r, "/auth/dev?pubkey="+pubKey, http.StatusFound)
+		params := url.Values{"pubkey": {pubKey}}
+		for name, value := range map[string]string{
+			"template":       authReq.Template,
+			"target":         authReq.Target,
+			"device_name":    authReq.DeviceName,
+			"client_version": authReq.ClientVersion,
+		} {
+			if value != "" {
+				params.Set(name, value)
+			}
+		}
+		http.Redirect(w, r, "/auth/dev?"+params.Encode(), http.StatusFound)
 		return
 	}
 
-	authURL, err := s.auth.StartAuth(pubKey)
+	authURL, err := s.auth.StartAuth(r.Context(), w, authReq)
 	if err != nil {
-		log.Printf("Auth start error: %v", err)
-		http.Error(w, "Failed to start authentication", http.StatusInternalServerError)
+		s.authStartError(w, r, err)
 		return
 	}
 
@@ -224,8 +287,25 @@ func (s *Server) handleDevAuth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	pubKey := r.URL.Query().Get("pubkey")
-	if pubKey == "" {
+	authReq := &oidc.AuthRequest{
+		PubKey:        r.URL.Query().Get("pubkey"),
+		Template:      r.URL.Query().Get("template"),
+		Target:        r.URL.Query().Get("target"),
+		DeviceName:    clientField(r.URL.Query().Get("device_name")),
+		ClientVersion: clientField(r.URL.Query().Get("client_version")),
+	}
+
+	// Device flow: the key and template come from the pending authorization
+	if userCode := r.URL.Query().Get("device"); userCode != "" {
+		auth, err := s.devices.Lookup(userCode)
+		if err != nil {
+			s.renderDevicePage(w, devicePage{UserCode: userCode, Error: deviceErrorMessage(err)})
+			return
+		}
+		authReq = deviceAuthRequest(auth)
+	}
+
+	if authReq.PubKey == "" {
 		http.Error(w, "Missing pubkey parameter", http.StatusBadRequest)
 		return
 	}
@@ -238,59 +318,33 @@ func (s *Server) handleDevAuth(w http.ResponseWriter, r *http.Request) {
 		Name:          "Local Developer",
 		Username:      "devuser",
 	}
+	userInfo.Claims = map[string]interface{}{
+		"sub":                userInfo.Subject,
+		"email":              userInfo.Email,
+		"name":               userInfo.Name,
+		"preferred_username": userInfo.Username,
+	}
 
-	// Extract principal from OIDC claims based on config
-	principal := extractPrincipal(userInfo, s.config.GitHubPrincipalSource)
-	log.Printf("🔓 DEV AUTH: Mock user authenticated: %s (principal: %s)", userInfo.Email, principal)
+	s.emit(r, audit.Event{Type: audit.EventDevAuthUsed, Actor: userInfo.Email, Subject: userInfo.Subject})
+	if !s.allowSigning(w, r, userIdentity(userInfo)) {
+		s.denyDeviceAuth(authReq.UserCode, "rate limit exceeded")
+		return
+	}
 
 	// Parse the user's public key
-	sshPubKey, err := ca.ParsePublicKey([]byte(pubKey))
+	sshPubKey, err := ca.ParsePublicKey([]byte(authReq.PubKey))
 	if err != nil {
 		log.Printf("Invalid public key: %v", err)
+		s.emit(r, audit.Event{Type: audit.EventAuthFailed, Actor: userInfo.Email, Subject: userInfo.Subject, Reason: "invalid public key"})
 		http.Error(w, "Invalid public key format", http.StatusBadRequest)
 		return
 	}
 
-	// Generate cert with GitHub login extension
-	// Extract GitHub hostname from URL (e.g., "https://github.mycompany.com" -> "github.mycompany.com")
-	githubHost := config.ExtractHostFromURL(s.config.GitHubEnterpriseURL)
-	keyID := fmt.Sprintf("cassh:dev:%s:%d", userInfo.Email, time.Now().Unix())
-	cert, err := s.ca.SignPublicKeyForGitHub(sshPubKey, keyID, principal, githubHost)
-	if err != nil {
-		log.Printf("Cert signing error: %v", err)
-		http.Error(w, "Failed to generate certificate", http.StatusInternalServerError)
-		return
-	}
-
-	log.Printf("🔓 DEV AUTH: Signed cert for principal=%s, login@%s=%s", principal, githubHost, principal)
-
-	certData := ca.MarshalCertificate(cert)
-	certInfo := ca.GetCertInfo(cert)
-
-	// Render success page with cert
-	memeData := memes.GetMemeData("random")
-	data := struct {
-		Meme     memes.MemeData
-		Cert     string
-		CertInfo *ca.CertInfo
-		User     *oidc.UserInfo
-		DevMode  bool
-	}{
-		Meme:     memeData,
-		Cert:     string(certData),
-		CertInfo: certInfo,
-		User:     userInfo,
-		DevMode:  true,
-	}
-
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	if err := s.tmpl.ExecuteTemplate(w, "success.html", data); err != nil {
-		log.Printf("Template error: %v", err)
-		http.Error(w, "Internal error", http.StatusInternalServerError)
-	}
+	cert, err := s.issueUserCert(r, "dev auth", userInfo, sshPubKey, authReq)
+	s.finishBrowserIssuance(w, r, userInfo, authReq, cert, err)
 }
 
-// handleAuthCallback processes the OIDC callback from Entra ID
+// handleAuthCallback processes the OIDC callback from the identity provider
 func (s *Server) handleAuthCallback(w http.ResponseWriter, r *http.Request) {
 	if s.devMode {
 		http.Error(w, "Use /auth/dev in development mode", http.StatusBadRequest)
@@ -299,92 +353,83 @@ func (s *Server) handleAuthCallback(w http.ResponseWriter, r *http.Request) {
 
 	ctx := r.Context()
 
-	userInfo, pubKey, err := s.auth.HandleCallback(ctx, r)
+	userInfo, authReq, err := s.auth.HandleCallback(ctx, w, r)
 	if err != nil {
 		log.Printf("Auth callback error: %v", err)
+		s.emit(r, audit.Event{Type: audit.EventAuthFailed, Reason: err.Error()})
+		s.metrics.callbackFailures.Inc(callbackFailureReason(err))
 		http.Error(w, "Authentication failed: "+err.Error(), http.StatusUnauthorized)
 		return
 	}
-
-	// Extract principal from OIDC claims based on config
-	principal := extractPrincipal(userInfo, s.config.GitHubPrincipalSource)
-	log.Printf("User authenticated: %s (principal: %s)", userInfo.Email, principal)
+	if !s.allowSigning(w, r, userIdentity(userInfo)) {
+		s.denyDeviceAuth(authReq.UserCode, "rate limit exceeded")
+		return
+	}
 
 	// Parse the user's public key
-	sshPubKey, err := ca.ParsePublicKey([]byte(pubKey))
+	sshPubKey, err := ca.ParsePublicKey([]byte(authReq.PubKey))
 	if err != nil {
 		log.Printf("Invalid public key: %v", err)
+		s.emit(r, audit.Event{Type: audit.EventAuthFailed, Actor: userInfo.Email, Subject: userInfo.Subject, Reason: "invalid public key"})
+		s.metrics.callbackFailures.Inc("invalid_pubkey")
 		http.Error(w, "Invalid public key format", http.StatusBadRequest)
 		return
 	}
 
-	// Generate cert with GitHub login extension
-	// Extract GitHub hostname from URL (e.g., "https://github.mycompany.com" -> "github.mycompany.com")
-	githubHost := config.ExtractHostFromURL(s.config.GitHubEnterpriseURL)
-	keyID := fmt.Sprintf("cassh:%s:%d", userInfo.Email, time.Now().Unix())
-	cert, err := s.ca.SignPublicKeyForGitHub(sshPubKey, keyID, principal, githubHost)
-	if err != nil {
-		log.Printf("Cert signing error: %v", err)
-		http.Error(w, "Failed to generate certificate", http.StatusInternalServerError)
-		return
-	}
-
-	log.Printf("Signed cert for %s: principal=%s, login@%s=%s", userInfo.Email, principal, githubHost, principal)
-
-	certData := ca.MarshalCertificate(cert)
-	certInfo := ca.GetCertInfo(cert)
-
-	// Render success page with cert
-	memeData := memes.GetMemeData("random")
-	data := struct {
-		Meme     memes.MemeData
-		Cert     string
-		CertInfo *ca.CertInfo
-		User     *oidc.UserInfo
-		DevMode  bool
-	}{
-		Meme:     memeData,
-		Cert:     string(certData),
-		CertInfo: certInfo,
-		User:     userInfo,
-		DevMode:  false,
-	}
-
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	if err := s.tmpl.ExecuteTemplate(w, "success.html", data); err != nil {
-		log.Printf("Template error: %v", err)
-		http.Error(w, "Internal error", http.StatusInternalServerError)
-	}
+	cert, err := s.issueUserCert(r, "browser", userInfo, sshPubKey, authReq)
+	s.finishBrowserIssuance(w, r, userInfo, authReq, cert, err)
 }
 
-// handleCertIssue is the API endpoint for menubar loopback listener
-// This allows the browser to POST the cert directly to the local app
-func (s *Server) handleCertIssue(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	// This endpoint returns JSON for the local menubar app
-	w.Header().Set("Content-Type", "application/json")
-
-	var req struct {
-		PubKey string `json:"pubkey"`
-		Token  string `json:"token"` // OIDC token from authenticated session
-	}
-
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request"})
-		return
-	}
+// routes builds the handler for requests served with s
+func (s *Server) routes() http.Handler {
+	mux := http.NewServeMux()
 
-	// In production, verify the token here
-	// For now, this endpoint requires the auth flow to have completed
+	// Static files
+	staticContent, _ := fs.Sub(staticFS, "static")
+	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.FS(staticContent))))
 
-	_ = json.NewEncoder(w).Encode(map[string]string{
-		"status": "ok",
-		"note":   "Use /auth/start flow for certificate generation",
-	})
+	// Routes
+	mux.HandleFunc("/", s.handleLanding)
+	mux.HandleFunc("/auth/start", s.limitByIP(s.handleAuthStart))
+	mux.HandleFunc("/auth/callback", s.limitByIP(s.handleAuthCallback))
+	mux.HandleFunc("/auth/dev", s.limitByIP(s.handleDevAuth)) // Dev mode mock auth
+	mux.HandleFunc("/health", s.handleHealth)
+	mux.Handle("/metrics", s.metrics.registry.Handler())
+
+	// Device authorization flow (headless clients)
+	mux.HandleFunc("/api/v1/device/authorize", s.limitByIP(s.handleDeviceAuthorize))
+	mux.HandleFunc("/api/v1/device/token", s.limitDevicePolls(s.handleDeviceToken))
+	mux.HandleFunc("/device", s.handleDevicePage)
+	mux.HandleFunc("/device/approve", s.limitByIP(s.handleDeviceApprove))
+
+	// Native client sign-in (cassh-cli talks to the IdP itself)
+	mux.HandleFunc("/api/v1/oidc/native", s.handleNativeClient)
+	mux.HandleFunc("/api/v1/oidc/cert", s.limitByIP(s.handleNativeCert))
+
+	// Workload identity (CI tokens)
+	mux.HandleFunc("/api/v1/workload/cert", s.limitByIP(s.handleWorkloadCert))
+
+	// Host certs for registered machines
+	mux.HandleFunc("/api/v1/host/cert", s.limitByIP(s.handleHostCert))
+	mux.HandleFunc("/api/v1/host/renew", s.limitByIP(s.handleHostRenew))
+
+	// CA public keys (active, and every key trusted during a rotation)
+	mux.HandleFunc("/ca.pub", s.handleCAPublicKey)
+	mux.HandleFunc("/ca-bundle", s.handleCABundle)
+
+	// Signed client policy
+	mux.HandleFunc(config.PolicyWellKnownPath, s.handleClientPolicy)
+
+	// Revocation
+	mux.HandleFunc("/krl", s.handleKRL)
+	mux.HandleFunc("/krl.sig", s.handleKRLSignature)
+	mux.HandleFunc("/revoke", s.limitByIP(s.handleRevoke))
+	mux.HandleFunc("/admin/revoke", s.handleAdminRevoke)
+
+	// Admin
+	mux.HandleFunc("/admin/certs", s.handleAdminCerts)
+
+	return logMiddleware(mux, s.metrics)
 }
 
 // handleHealth is the health check endpoint
@@ -397,41 +442,39 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func logMiddleware(next http.Handler) http.Handler {
+func logMiddleware(mux *http.ServeMux, m *serverMetrics) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		next.ServeHTTP(w, r)
-		log.Printf("%s %s %s", r.Method, r.URL.Path, time.Since(start))
+		rec := &statusRecorder{ResponseWriter: w}
+		mux.ServeHTTP(rec, r)
+		elapsed := time.Since(start)
+		log.Printf("%s %s %s", r.Method, r.URL.Path, elapsed)
+
+		// Label by the matched route pattern, not the raw path, to keep cardinality bounded
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "other"
+		}
+		m.httpDuration.Observe(elapsed.Seconds(), route, methodLabel(r.Method), rec.code())
 	})
 }
 
-// extractPrincipal derives the SSH certificate principal from OIDC user info based on config
-// principalSource options:
-//   - "email_prefix" (default): extract username before @ from email/UPN (e.g., "shawn@schwartz.so" -> "shawn")
-//   - "email": use full email as principal
-//   - "username": use the preferred_username claim as-is
-func extractPrincipal(userInfo *oidc.UserInfo, principalSource string) string {
-	switch principalSource {
-	case "email":
-		return userInfo.Email
-	case "username":
-		return userInfo.Username
-	case "email_prefix", "":
-		// Default: extract username part from email/UPN
-		emailOrUPN := userInfo.Username
-		if emailOrUPN == "" {
-			emailOrUPN = userInfo.Email
-		}
-		if idx := strings.Index(emailOrUPN, "@"); idx != -1 {
-			return emailOrUPN[:idx]
-		}
-		return emailOrUPN
-	default:
-		// Fallback to email_prefix behavior
-		emailOrUPN := userInfo.Username
-		if idx := strings.Index(emailOrUPN, "@"); idx != -1 {
-			return emailOrUPN[:idx]
+// clientIP returns the requesting client's IP address
+// X-Forwarded-For is only honored when trust_proxy_headers is set, since clients can forge it
+func (s *Server) clientIP(r *http.Request) string {
+	if s.config.TrustProxyHeaders {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			// Use the right-most entry: it was appended by our proxy, earlier ones are client-supplied
+			if idx := strings.LastIndex(fwd, ","); idx != -1 {
+				fwd = fwd[idx+1:]
+			}
+			return strings.TrimSpace(fwd)
 		}
-		return emailOrUPN
 	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }
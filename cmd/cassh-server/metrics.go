@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/shawntz/cassh/internal/metrics"
+	"github.com/shawntz/cassh/internal/oidc"
+)
+
+// serverMetrics are the Prometheus metrics exposed at /metrics
+type serverMetrics struct {
+	registry         *metrics.Registry
+	authStarts       *metrics.Counter
+	callbackFailures *metrics.Counter
+	certsIssued      *metrics.Counter
+	signingDuration  *metrics.Histogram
+	httpDuration     *metrics.Histogram
+	reloads          *metrics.Counter
+	rateLimited      *metrics.Counter
+	lastReload       atomic.Int64 // Unix time of the last successful reload
+}
+
+func newServerMetrics(s *serverState) *serverMetrics {
+	reg := metrics.NewRegistry()
+	m := &serverMetrics{
+		registry: reg,
+		authStarts: reg.NewCounter("cassh_auth_starts_total",
+			"Authentication flows started"),
+		callbackFailures: reg.NewCounter("cassh_auth_callback_failures_total",
+			"OIDC callbacks that did not produce a certificate, by reason", "reason"),
+		certsIssued: reg.NewCounter("cassh_certs_issued_total",
+			"Certificates issued, by principal source", "principal_source"),
+		signingDuration: reg.NewHistogram("cassh_cert_signing_duration_seconds",
+			"Time spent signing certificates", nil),
+		httpDuration: reg.NewHistogram("cassh_http_request_duration_seconds",
+			"HTTP request latency by route", nil, "route", "method", "code"),
+		reloads: reg.NewCounter("cassh_config_reloads_total",
+			"Config reloads, by result", "result"),
+		rateLimited: reg.NewCounter("cassh_rate_limited_total",
+			"Requests refused with 429, by limit (ip, device_poll, identity, pending_states or pending_devices)", "limit"),
+	}
+
+	reg.NewGaugeFunc("cassh_oidc_pending_states",
+		"Auth flows waiting for an OIDC callback",
+		func() float64 {
+			current := s.current.Load()
+			if current == nil || current.auth == nil {
+				return 0
+			}
+			return float64(current.auth.PendingStates(context.Background()))
+		})
+
+	reg.NewGaugeFunc("cassh_device_pending_authorizations",
+		"Device authorizations waiting for the user to approve them",
+		func() float64 {
+			return float64(s.devices.Pending())
+		})
+
+	reg.NewGaugeFunc("cassh_config_last_reload_success_timestamp_seconds",
+		"Unix time of the last successful config reload (0 if none since start)",
+		func() float64 {
+			return float64(m.lastReload.Load())
+		})
+
+	return m
+}
+
+// callbackFailureReason maps an OIDC callback error to a bounded metric label
+func callbackFailureReason(err error) string {
+	switch {
+	case errors.Is(err, oidc.ErrInvalidState):
+		return "invalid_state"
+	case errors.Is(err, oidc.ErrNonceMismatch):
+		return "nonce_mismatch"
+	case errors.Is(err, oidc.ErrExchangeFailed):
+		return "exchange_failed"
+	default:
+		return "other"
+	}
+}
+
+// principalSourceLabel normalizes the configured principal source for metric labels
+// Custom claims and templates are counted as "claim" and "template" so admin-chosen names don't become labels
+func principalSourceLabel(source string) string {
+	switch {
+	case source == "":
+		return "email_prefix"
+	case source == "email_prefix", source == "email", source == "username", source == "mapping", source == "scim":
+		return source
+	case strings.Contains(source, "{"):
+		return "template"
+	default:
+		return "claim"
+	}
+}
+
+// methodLabel keeps arbitrary client-supplied methods out of metric labels
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	default:
+		return "other"
+	}
+}
+
+// statusRecorder captures the response status for logging and metrics
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) code() string {
+	if r.status == 0 {
+		return "200"
+	}
+	return strconv.Itoa(r.status)
+}
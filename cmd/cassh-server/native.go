@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/shawntz/cassh/internal/audit"
+	"github.com/shawntz/cassh/internal/ca"
+	"github.com/shawntz/cassh/internal/oidc"
+	"github.com/shawntz/cassh/internal/policy"
+)
+
+// nativeClient returns the public client cassh-cli may sign in with, or nil if it's disabled
+func (s *Server) nativeClient() *oidc.NativeClient {
+	if s.auth == nil {
+		return nil
+	}
+	return s.auth.NativeClient()
+}
+
+// handleNativeClient tells cassh-cli which provider and client ID to sign in with
+// GET /api/v1/oidc/native
+func (s *Server) handleNativeClient(w http.ResponseWriter, r *http.Request) {
+	client := s.nativeClient()
+	if client == nil {
+		writeJSONError(w, http.StatusNotFound, "native client sign-in is not enabled")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(client)
+}
+
+// handleNativeCert issues a cert for an ID token cassh-cli got from the provider itself
+// POST /api/v1/oidc/cert with "Authorization: Bearer <ID token>" and {"public_key": "...", "template": "..."}
+// The token's nonce must be oidc.KeyNonce of the public key
+func (s *Server) handleNativeCert(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.nativeClient() == nil {
+		writeJSONError(w, http.StatusNotFound, "native client sign-in is not enabled")
+		return
+	}
+
+	rawIDToken, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || rawIDToken == "" {
+		writeJSONError(w, http.StatusUnauthorized, "bearer token required")
+		return
+	}
+
+	var req struct {
+		PublicKey     string `json:"public_key"`
+		Template      string `json:"template"`
+		Target        string `json:"target"`
+		DeviceName    string `json:"device_name"`
+		ClientVersion string `json:"client_version"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	sshPubKey, err := ca.ParsePublicKey([]byte(req.PublicKey))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid public_key")
+		return
+	}
+
+	userInfo, err := s.auth.VerifyNativeToken(r.Context(), rawIDToken, sshPubKey)
+	if err != nil {
+		log.Printf("Native client token rejected: %v", err)
+		s.emit(r, audit.Event{Type: audit.EventAuthFailed, Reason: err.Error()})
+		writeJSONError(w, http.StatusUnauthorized, "invalid ID token")
+		return
+	}
+	if !s.allowSigning(w, r, userIdentity(userInfo)) {
+		return
+	}
+
+	cert, err := s.issueUserCert(r, "native client", userInfo, sshPubKey, &oidc.AuthRequest{
+		Template:      req.Template,
+		Target:        req.Target,
+		DeviceName:    clientField(req.DeviceName),
+		ClientVersion: clientField(req.ClientVersion),
+	})
+	var denied *issuanceDenied
+	if errors.As(err, &denied) {
+		s.denyNative(w, r, userInfo, denied.decision)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "failed to generate certificate")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"certificate": strings.TrimSpace(string(ca.MarshalCertificate(cert))),
+	})
+}
+
+// denyNative records a policy denial for a native client and returns 403
+func (s *Server) denyNative(w http.ResponseWriter, r *http.Request, userInfo *oidc.UserInfo, decision policy.Decision) {
+	log.Printf("Issuance denied for %s: %s", userInfo.Email, decision.Reason)
+	s.emit(r, audit.Event{
+		Type:       audit.EventIssuanceDenied,
+		Actor:      userInfo.Email,
+		Subject:    userInfo.Subject,
+		PolicyRule: decision.Rule,
+		Reason:     decision.Reason,
+	})
+	writeJSONError(w, http.StatusForbidden, "certificate issuance denied by policy")
+}
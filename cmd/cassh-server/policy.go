@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/shawntz/cassh/internal/audit"
+	"github.com/shawntz/cassh/internal/config"
+	"github.com/shawntz/cassh/internal/oidc"
+	"github.com/shawntz/cassh/internal/policy"
+)
+
+// policyInput builds the issuance policy input for an authenticated user
+func policyInput(userInfo *oidc.UserInfo, principal, template, target string) policy.Input {
+	return policy.Input{
+		Subject:   userInfo.Subject,
+		Email:     userInfo.Email,
+		Name:      userInfo.Name,
+		Username:  userInfo.Username,
+		Principal: principal,
+		Claims:    userInfo.Claims,
+		Template:  template,
+		Target:    target,
+	}
+}
+
+// denyIssuance records a policy denial and tells the user
+func (s *Server) denyIssuance(w http.ResponseWriter, r *http.Request, userInfo *oidc.UserInfo, decision policy.Decision) {
+	log.Printf("Issuance denied for %s: %s", userInfo.Email, decision.Reason)
+	s.emit(r, audit.Event{
+		Type:       audit.EventIssuanceDenied,
+		Actor:      userInfo.Email,
+		Subject:    userInfo.Subject,
+		PolicyRule: decision.Rule,
+		Reason:     decision.Reason,
+	})
+	s.metrics.callbackFailures.Inc("policy_denied")
+
+	http.Error(w, "Certificate issuance denied by policy - contact your administrator", http.StatusForbidden)
+}
+
+// runPolicyCommand handles `cassh-server policy eval [--template NAME] [--target TARGET] [CLAIMS_FILE]`
+// Evaluates the configured issuance rules against ID token claims (JSON) without issuing anything
+func runPolicyCommand(args []string) int {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "Usage: cassh-server policy eval [--template NAME] [--target TARGET] [CLAIMS_FILE]")
+		fmt.Fprintln(os.Stderr, "Reads ID token claims as JSON from CLAIMS_FILE or stdin and prints the issuance decision")
+	}
+	if len(args) < 1 || args[0] != "eval" {
+		usage()
+		return 2
+	}
+
+	fs := flag.NewFlagSet("policy eval", flag.ContinueOnError)
+	fs.Usage = usage
+	template := fs.String("template", "", "cert template the client requests")
+	target := fs.String("target", "", "target the client requests (e.g., a GHE org)")
+	if err := fs.Parse(args[1:]); err != nil || fs.NArg() > 1 {
+		return 2
+	}
+
+	cfg, err := config.LoadServerConfig(config.ServerConfigPath())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to load config: %v\n", err)
+		return 2
+	}
+	engine, err := policy.New(cfg.Issuance)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	var in io.Reader = os.Stdin
+	if fs.NArg() == 1 && fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 2
+		}
+		defer func() { _ = f.Close() }()
+		in = f
+	}
+
+	var claims map[string]interface{}
+	if err := json.NewDecoder(in).Decode(&claims); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid claims JSON: %v\n", err)
+		return 2
+	}
+
+	userInfo := oidc.NewUserInfo(claims, oidc.ClaimMapping{
+		Email:    cfg.OIDCEmailClaim,
+		Name:     cfg.OIDCNameClaim,
+		Username: cfg.OIDCUsernameClaim,
+	})
+	// Mapping and SCIM principal sources look the user up, as the server would
+	gh, err := newGitHubClient(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid GitHub config: %v\n", err)
+		return 2
+	}
+	resolver, err := newPrincipalResolver(cfg, gh)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid principal source: %v\n", err)
+		return 2
+	}
+	principal, err := resolver.Resolve(context.Background(), principalUser(userInfo))
+	if err != nil {
+		fmt.Printf("User:       %s\n", userInfo.Email)
+		fmt.Printf("Decision:   ❌ DENY (%v)\n", err)
+		return 1
+	}
+	decision := engine.Evaluate(policyInput(userInfo, principal, *template, *target))
+
+	fmt.Printf("User:       %s (principal: %s)\n", userInfo.Email, principal)
+	fmt.Printf("Groups:     %s\n", listOrNone(decision.Groups))
+	fmt.Printf("Roles:      %s\n", listOrNone(decision.Roles))
+	if !decision.Allowed {
+		fmt.Printf("Decision:   ❌ DENY (%s)\n", decision.Reason)
+		return 1
+	}
+
+	fmt.Printf("Decision:   ✅ ALLOW (%s)\n", decision.Reason)
+	fmt.Printf("Template:   %s\n", templateName(decision.Template))
+	fmt.Printf("CA:         %s\n", caName(decision.CA))
+	fmt.Printf("Principals: %s\n", strings.Join(decision.Principals, ", "))
+	if decision.Validity > 0 {
+		fmt.Printf("Validity:   %s\n", decision.Validity)
+	} else {
+		fmt.Printf("Validity:   %dh (server default)\n", cfg.CertValidityHours)
+	}
+	if decision.Extensions != nil {
+		fmt.Printf("Extensions: %s\n", listOrNone(decision.Extensions))
+	} else {
+		fmt.Printf("Extensions: default\n")
+	}
+	return 0
+}
+
+func templateName(name string) string {
+	if name == "" {
+		return "(built-in default)"
+	}
+	return name
+}
+
+func listOrNone(items []string) string {
+	if len(items) == 0 {
+		return "(none)"
+	}
+	return strings.Join(items, ", ")
+}
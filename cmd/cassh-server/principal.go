@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/shawntz/cassh/internal/config"
+	"github.com/shawntz/cassh/internal/github"
+	"github.com/shawntz/cassh/internal/oidc"
+	"github.com/shawntz/cassh/internal/principal"
+)
+
+// newPrincipalResolver builds the principal source of cfg; gh is needed for the scim source
+func newPrincipalResolver(cfg *config.ServerConfig, gh *github.Client) (principal.Resolver, error) {
+	var dir principal.Directory
+	if gh != nil && gh.SCIMConfigured() {
+		dir = gh
+	}
+	resolver, err := principal.New(principal.Config{
+		Source:      cfg.GitHubPrincipalSource,
+		MappingPath: cfg.GitHubPrincipalMappingPath,
+	}, dir)
+	if err != nil {
+		return nil, err
+	}
+	if m, ok := resolver.(*principal.Mapping); ok {
+		log.Printf("Loaded %d GitHub login mapping(s) from %s", m.Len(), cfg.GitHubPrincipalMappingPath)
+	}
+	return resolver, nil
+}
+
+// principalUser is the identity the principal is resolved from
+func principalUser(userInfo *oidc.UserInfo) principal.User {
+	return principal.User{
+		Subject:  userInfo.Subject,
+		Email:    userInfo.Email,
+		Name:     userInfo.Name,
+		Username: userInfo.Username,
+		Claims:   userInfo.Claims,
+	}
+}
+
+// resolvePrincipal finds the GitHub login userInfo's certs are issued for
+// Lookup failures are logged and reported without detail, and issuance stops
+func (s *Server) resolvePrincipal(ctx context.Context, userInfo *oidc.UserInfo) (string, error) {
+	login, err := s.principals.Resolve(ctx, principalUser(userInfo))
+	if err == nil && login == "" {
+		err = fmt.Errorf("%w: empty %s", principal.ErrNotFound, principalSourceLabel(s.config.GitHubPrincipalSource))
+	}
+	if err == nil || errors.Is(err, principal.ErrNotFound) {
+		return login, err
+	}
+	log.Printf("GitHub login lookup failed for %s: %v", userInfo.Email, err)
+	return "", fmt.Errorf("could not look up GitHub login")
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/shawntz/cassh/internal/config"
+)
+
+// printServerConfig writes every setting's effective value and where it came from, secrets redacted
+func printServerConfig(w io.Writer, configPath string, cfg *config.ServerConfig) {
+	if configPath == "" {
+		configPath = "(none)"
+	}
+	fmt.Fprintf(w, "# Config file: %s\n", configPath)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tVALUE\tSOURCE\tENV")
+	for _, setting := range cfg.Settings() {
+		value, source := setting.Value, setting.Source
+		if value == "" {
+			value = "-"
+		}
+		switch {
+		case source == "":
+			source = "-"
+		case setting.Line > 0:
+			source = fmt.Sprintf("%s:%d", source, setting.Line)
+		}
+		env := setting.Env
+		if env == "" || env == setting.Key {
+			env = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", setting.Key, value, source, env)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shawntz/cassh/internal/config"
+	"github.com/shawntz/cassh/internal/device"
+	"github.com/shawntz/cassh/internal/oidc"
+	"github.com/shawntz/cassh/internal/ratelimit"
+)
+
+// pendingStatesRetryAfter is how long clients are asked to wait when too many sign-ins or device
+// authorizations are in flight
+const pendingStatesRetryAfter = time.Minute
+
+// newRateLimiters builds the per-IP limits on sign-in and cert endpoints and on device token polls,
+// and the per-identity signing limit
+func newRateLimiters(cfg *config.ServerConfig) (byIP, polls, byIdentity *ratelimit.Limiter) {
+	return ratelimit.New(cfg.RateLimitAuthPerMinute, cfg.RateLimitAuthBurst),
+		newPollLimiter(cfg),
+		ratelimit.New(cfg.RateLimitSignPerMinute, cfg.RateLimitSignBurst)
+}
+
+// newPollLimiter limits device token polls per IP, or returns nil when auth_per_minute is 0
+// Clients poll every device.DefaultInterval, so auth_burst of them can wait behind one address at once
+func newPollLimiter(cfg *config.ServerConfig) *ratelimit.Limiter {
+	if cfg.RateLimitAuthPerMinute <= 0 {
+		return nil
+	}
+	clients := cfg.RateLimitAuthBurst
+	if clients < 1 {
+		clients = 1
+	}
+	return ratelimit.New(clients*int(time.Minute/device.DefaultInterval), clients)
+}
+
+// keepRateLimiters carries prev's limiters over to c where their settings didn't change,
+// so a reload doesn't give every client a fresh burst
+func (c *components) keepRateLimiters(prev *components) {
+	if prev.config.RateLimitAuthPerMinute == c.config.RateLimitAuthPerMinute &&
+		prev.config.RateLimitAuthBurst == c.config.RateLimitAuthBurst {
+		c.ipLimiter, c.pollLimiter = prev.ipLimiter, prev.pollLimiter
+	}
+	if prev.config.RateLimitSignPerMinute == c.config.RateLimitSignPerMinute &&
+		prev.config.RateLimitSignBurst == c.config.RateLimitSignBurst {
+		c.signLimiter = prev.signLimiter
+	}
+}
+
+// limitByIP applies the per-IP limit before next
+// Behind a proxy this needs trust_proxy_headers, or every client shares the proxy's address
+func (s *Server) limitByIP(next http.HandlerFunc) http.HandlerFunc {
+	return s.limitClientIP(s.ipLimiter, "ip", next)
+}
+
+// limitDevicePolls applies the per-IP device token poll limit before next
+func (s *Server) limitDevicePolls(next http.HandlerFunc) http.HandlerFunc {
+	return s.limitClientIP(s.pollLimiter, "device_poll", next)
+}
+
+// limitClientIP answers 429 when the client IP has no tokens left in l; limit labels the metric
+func (s *Server) limitClientIP(l *ratelimit.Limiter, limit string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := l.Allow(s.clientIP(r)); !ok {
+			s.tooManyRequests(w, r, limit, wait)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// allowSigning takes a token from identity's signing limit, answering 429 if there's none left
+func (s *Server) allowSigning(w http.ResponseWriter, r *http.Request, identity string) bool {
+	ok, wait := s.signLimiter.Allow(identity)
+	if !ok {
+		log.Printf("Signing rate limit reached for %s", identity)
+		s.tooManyRequests(w, r, "identity", wait)
+	}
+	return ok
+}
+
+// userIdentity keys a user's signing limit; sub is stable where email and username may not be
+func userIdentity(userInfo *oidc.UserInfo) string {
+	return "user:" + userInfo.Subject
+}
+
+// authStartError answers a failed StartAuth, with 429 when too many sign-ins are waiting for a callback
+func (s *Server) authStartError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, oidc.ErrTooManyStates) {
+		log.Printf("Auth start refused: %v", err)
+		s.tooManyRequests(w, r, "pending_states", pendingStatesRetryAfter)
+		return
+	}
+	log.Printf("Auth start error: %v", err)
+	http.Error(w, "Failed to start authentication", http.StatusInternalServerError)
+}
+
+// tooManyRequests answers 429 with Retry-After in whole seconds; limit labels the metric
+func (s *Server) tooManyRequests(w http.ResponseWriter, r *http.Request, limit string, wait time.Duration) {
+	s.metrics.rateLimited.Inc(limit)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Max(1, math.Ceil(wait.Seconds())))))
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		writeJSONError(w, http.StatusTooManyRequests, "rate limit exceeded, retry later")
+		return
+	}
+	http.Error(w, "Too many requests - try again shortly", http.StatusTooManyRequests)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shawntz/cassh/internal/hosts"
+	"github.com/shawntz/cassh/internal/oidc"
+	"github.com/shawntz/cassh/internal/workload"
+	"golang.org/x/crypto/ssh"
+)
+
+// rateLimitConfig allows 2 requests per IP at once, one more every 10s, and one cert per identity a minute
+const rateLimitConfig = `
+[rate_limit]
+auth_per_minute = 6
+auth_burst = 2
+sign_per_minute = 1
+sign_burst = 1
+`
+
+// requestFrom returns a request for path from the client at ip
+func requestFrom(method, path, ip string) *http.Request {
+	r := httptest.NewRequest(method, path, strings.NewReader("{}"))
+	r.RemoteAddr = ip + ":51234"
+	return r
+}
+
+func okHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestLimitByIP(t *testing.T) {
+	st := newTestState(t, rateLimitConfig)
+	h := st.current.Load().limitByIP(okHandler)
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		h(rec, requestFrom(http.MethodPost, "/api/v1/workload/cert", "10.0.0.1"))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d within the burst = %d, want 200", i+1, rec.Code)
+		}
+	}
+
+	// API clients get JSON with when to retry
+	rec := httptest.NewRecorder()
+	h(rec, requestFrom(http.MethodPost, "/api/v1/workload/cert", "10.0.0.1"))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("request after the burst = %d, want 429", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "10" {
+		t.Errorf("Retry-After = %q, want 10", got)
+	}
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.Error == "" {
+		t.Errorf("429 body = %q, %v, want a JSON error", rec.Body.String(), err)
+	}
+
+	// Browser endpoints get plain text
+	rec = httptest.NewRecorder()
+	h(rec, requestFrom(http.MethodGet, "/auth/start", "10.0.0.1"))
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("/auth/start after the burst = %d with Retry-After %q, want 429 with a Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+		t.Error("/auth/start got a JSON 429")
+	}
+
+	// Other clients have their own budget
+	rec = httptest.NewRecorder()
+	h(rec, requestFrom(http.MethodPost, "/api/v1/workload/cert", "10.0.0.2"))
+	if rec.Code != http.StatusOK {
+		t.Errorf("request from another IP = %d, want 200", rec.Code)
+	}
+}
+
+func TestLimitedRoutes(t *testing.T) {
+	tests := []struct {
+		path  string
+		limit int // Requests from one IP before a 429
+	}{
+		{"/revoke", 2},
+		{"/api/v1/device/authorize", 2},
+		// Device token polls get auth_burst clients' worth of polls
+		{"/api/v1/device/token", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			st := newTestState(t, rateLimitConfig)
+			for i := 0; i < tt.limit; i++ {
+				rec := httptest.NewRecorder()
+				st.ServeHTTP(rec, requestFrom(http.MethodPost, tt.path, "10.0.0.1"))
+				if rec.Code == http.StatusTooManyRequests {
+					t.Fatalf("request %d = 429, want it allowed", i+1)
+				}
+			}
+			rec := httptest.NewRecorder()
+			st.ServeHTTP(rec, requestFrom(http.MethodPost, tt.path, "10.0.0.1"))
+			if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+				t.Errorf("request %d = %d with Retry-After %q, want 429 with a Retry-After", tt.limit+1, rec.Code, rec.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
+
+func TestDeviceAuthorizeMaxPending(t *testing.T) {
+	st := newTestState(t, "[device]\nmax_pending = 1\n")
+	body, err := json.Marshal(map[string]string{"public_key": string(ssh.MarshalAuthorizedKey(testPublicKey(t)))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorize := func(ip string) *httptest.ResponseRecorder {
+		r := requestFrom(http.MethodPost, "/api/v1/device/authorize", ip)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		rec := httptest.NewRecorder()
+		st.ServeHTTP(rec, r)
+		return rec
+	}
+
+	if rec := authorize("10.0.0.1"); rec.Code != http.StatusOK {
+		t.Fatalf("first authorization = %d %s, want 200", rec.Code, rec.Body)
+	}
+	// The cap counts every client, not one IP
+	rec := authorize("10.0.0.2")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "60" {
+		t.Errorf("authorization at the cap = %d with Retry-After %q, want 429 with 60", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
+
+func TestPollLimiterFitsPollInterval(t *testing.T) {
+	st := newTestState(t, rateLimitConfig)
+	s := st.current.Load()
+
+	// auth_burst (2) clients polling every 5s need a poll every 2.5s, where other requests refill every 10s
+	for i := 0; i < 2; i++ {
+		if ok, _ := s.pollLimiter.Allow("10.0.0.1"); !ok {
+			t.Fatalf("poll %d within the burst was limited", i+1)
+		}
+	}
+	if ok, wait := s.pollLimiter.Allow("10.0.0.1"); ok || wait < 2400*time.Millisecond || wait > 2500*time.Millisecond {
+		t.Errorf("poll after the burst = %v, wait %v, want refused for about 2.5s", ok, wait)
+	}
+
+	// Polling isn't limited when the IP limit is off
+	st = newTestState(t, "[rate_limit]\nauth_per_minute = 0\n")
+	if st.current.Load().pollLimiter != nil {
+		t.Error("poll limiter set with auth_per_minute = 0")
+	}
+}
+
+func TestAllowSigning(t *testing.T) {
+	st := newTestState(t, rateLimitConfig)
+	s := st.current.Load()
+
+	identities := []string{
+		userIdentity(&oidc.UserInfo{Subject: "alice-sub"}),
+		userIdentity(&oidc.UserInfo{Subject: "bob-sub"}),
+		workloadIdentity(&workload.Identity{Issuer: "github-actions", Subject: "repo:corp/app:ref:refs/heads/main"}),
+		workloadIdentity(&workload.Identity{Issuer: "gitlab", Subject: "repo:corp/app:ref:refs/heads/main"}),
+		hostIdentity(&hosts.Machine{Name: "web-1"}),
+	}
+	for _, id := range identities {
+		rec := httptest.NewRecorder()
+		if !s.allowSigning(rec, requestFrom(http.MethodPost, "/api/v1/workload/cert", "10.0.0.1"), id) {
+			t.Errorf("first cert for %s was limited (%d)", id, rec.Code)
+		}
+	}
+
+	// Each identity has used its one cert; the IP they came from doesn't matter
+	for _, id := range identities {
+		rec := httptest.NewRecorder()
+		if s.allowSigning(rec, requestFrom(http.MethodPost, "/api/v1/workload/cert", "10.0.0.9"), id) {
+			t.Errorf("second cert for %s was allowed", id)
+			continue
+		}
+		if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "60" {
+			t.Errorf("second cert for %s = %d with Retry-After %q, want 429 with 60", id, rec.Code, rec.Header().Get("Retry-After"))
+		}
+	}
+}
+
+func TestReloadKeepsRateLimiters(t *testing.T) {
+	st := newTestState(t, "cert_validity_hours = 12\n"+rateLimitConfig)
+	before := st.current.Load()
+
+	// Settings other than rate_limit keep the buckets, so a reload doesn't hand out a fresh burst
+	writeConfig(t, st.configPath, devConfig+"cert_validity_hours = 4\n"+rateLimitConfig)
+	if err := st.reload(); err != nil {
+		t.Fatalf("reload() error = %v", err)
+	}
+	after := st.current.Load()
+	if after.ipLimiter != before.ipLimiter || after.pollLimiter != before.pollLimiter || after.signLimiter != before.signLimiter {
+		t.Error("reload without rate_limit changes replaced the limiters")
+	}
+
+	// Changing one limit's settings replaces only that limit
+	writeConfig(t, st.configPath, devConfig+strings.Replace(rateLimitConfig, "sign_per_minute = 1", "sign_per_minute = 5", 1))
+	if err := st.reload(); err != nil {
+		t.Fatalf("reload() error = %v", err)
+	}
+	changed := st.current.Load()
+	if changed.signLimiter == before.signLimiter {
+		t.Error("reload kept the signing limiter after sign_per_minute changed")
+	}
+	if changed.ipLimiter != before.ipLimiter || changed.pollLimiter != before.pollLimiter {
+		t.Error("reload replaced the IP limiters when only the signing limit changed")
+	}
+}
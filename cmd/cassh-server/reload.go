@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/shawntz/cassh/internal/ca"
+	"github.com/shawntz/cassh/internal/config"
+	"github.com/shawntz/cassh/internal/config/serverlint"
+	"github.com/shawntz/cassh/internal/github"
+	"github.com/shawntz/cassh/internal/hosts"
+	"github.com/shawntz/cassh/internal/oidc"
+	"github.com/shawntz/cassh/internal/policy"
+	"github.com/shawntz/cassh/internal/principal"
+	"github.com/shawntz/cassh/internal/ratelimit"
+	"github.com/shawntz/cassh/internal/workload"
+	"golang.org/x/crypto/ssh"
+)
+
+// configPollInterval is how often the config file is checked for changes
+const configPollInterval = 5 * time.Second
+
+// components are the parts of the server built from config, swapped together on reload
+type components struct {
+	config              *config.ServerConfig
+	auth                *oidc.Authenticator
+	workloads           *workload.Verifier
+	hosts               *hosts.Registry
+	ca                  *ca.CertificateAuthority // Top-level CA
+	authorities         map[string]*authority    // Every CA by name, including the top-level one
+	policy              *policy.Engine
+	certTemplates       map[string]*ca.Template
+	keyIDFormat         *ca.KeyIDFormat // User cert key IDs
+	workloadKeyIDFormat *ca.KeyIDFormat // Workload cert key IDs
+	hostKeyIDFormat     *ca.KeyIDFormat // Host cert key IDs
+	github              *github.Client  // Org membership check and SCIM lookups (nil if unused)
+	principals          principal.Resolver
+	ipLimiter           *ratelimit.Limiter // Sign-in and cert endpoints, per client IP (nil when disabled)
+	pollLimiter         *ratelimit.Limiter // Device token polls, per client IP
+	signLimiter         *ratelimit.Limiter // Certs signed, per identity
+	krlSignature        krlSignatureCache  // Signature over the last KRL served by /krl.sig
+	closers             []io.Closer        // CA signers, closed once the components are retired and idle
+
+	mu      sync.Mutex
+	active  int  // Requests being served with these components
+	retired bool // Replaced by a reload; no new requests start on them
+}
+
+// buildComponents validates cfg and builds everything that depends on it
+// states is shared across reloads so logins in flight survive them; it's nil in dev mode
+func buildComponents(cfg *config.ServerConfig, states oidc.StateStore) (_ *components, err error) {
+	c := &components{config: cfg}
+	defer func() {
+		if err != nil {
+			closeAll(c.closers)
+		}
+	}()
+
+	// Top-level CA (optional in dev mode)
+	if cfg.CAPrivateKey != "" || (cfg.CASigner != "" && cfg.CASigner != ca.SignerFile) {
+		signer, closer, err := ca.NewSigner(cfg.CASignerConfig())
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize CA: %w", err)
+		}
+		c.closers = append(c.closers, closer)
+		c.ca = ca.NewCAFromSigner(signer, cfg.CertValidityHours, nil)
+
+		signerType := cfg.CASigner
+		if signerType == "" {
+			signerType = ca.SignerFile
+		}
+		log.Printf("CA signer: %s (%s)", signerType, ssh.FingerprintSHA256(signer.PublicKey()))
+
+		if err := addRotationKeys(c.ca, cfg.CANextPublicKeys, cfg.CARetiredPublicKeys); err != nil {
+			return nil, fmt.Errorf("invalid CA rotation keys: %w", err)
+		}
+		for _, key := range c.ca.TrustedKeys()[1:] {
+			log.Printf("Trusting %s CA key %s", key.State, ssh.FingerprintSHA256(key.PublicKey))
+		}
+	} else if !cfg.IsDevMode() {
+		return nil, fmt.Errorf("CA private key is required in production mode")
+	}
+
+	// Named CAs that issuance rules can route signing to
+	authorities, caClosers, err := openNamedCAs(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize CA: %w", err)
+	}
+	c.closers = append(c.closers, caClosers...)
+	if c.ca != nil {
+		authorities[config.DefaultCAName] = &authority{
+			name:       config.DefaultCAName,
+			ca:         c.ca,
+			githubHost: config.ExtractHostFromURL(cfg.GitHubEnterpriseURL),
+		}
+	}
+	c.authorities = authorities
+
+	// Compile the issuance policy
+	if c.policy, err = policy.New(cfg.Issuance); err != nil {
+		return nil, fmt.Errorf("invalid issuance policy: %w", err)
+	}
+	if n := len(c.policy.Rules()); n > 0 {
+		log.Printf("Loaded %d issuance rule(s)", n)
+	}
+	if c.certTemplates, err = loadTemplates(cfg.Templates, c.policy, cfg.Issuance.DefaultTemplate); err != nil {
+		return nil, fmt.Errorf("invalid cert templates: %w", err)
+	}
+	if err := checkCARules(c.policy, cfg.Issuance.DefaultCA, authorities); err != nil {
+		return nil, fmt.Errorf("invalid issuance policy: %w", err)
+	}
+
+	if c.keyIDFormat, err = ca.ParseKeyIDFormat(cfg.KeyIDFormat); err != nil {
+		return nil, err
+	}
+	if c.workloadKeyIDFormat, err = ca.ParseKeyIDFormat(cfg.WorkloadKeyIDFormat); err != nil {
+		return nil, err
+	}
+	if c.hostKeyIDFormat, err = ca.ParseKeyIDFormat(cfg.HostKeyIDFormat); err != nil {
+		return nil, err
+	}
+
+	if c.github, err = newGitHubClient(cfg); err != nil {
+		return nil, fmt.Errorf("invalid GitHub config: %w", err)
+	}
+	if c.principals, err = newPrincipalResolver(cfg, c.github); err != nil {
+		return nil, fmt.Errorf("invalid principal source: %w", err)
+	}
+
+	c.ipLimiter, c.pollLimiter, c.signLimiter = newRateLimiters(cfg)
+
+	// OIDC authenticator (only if not in dev mode)
+	if states != nil {
+		// An explicit issuer wins; otherwise use the Entra ID preset for the tenant
+		issuer := cfg.OIDCIssuer
+		if issuer == "" {
+			issuer = oidc.EntraIssuer(cfg.OIDCTenant)
+		}
+		c.auth, err = oidc.NewAuthenticator(context.Background(), &oidc.Config{
+			Issuer:         issuer,
+			ClientID:       cfg.OIDCClientID,
+			ClientSecret:   cfg.OIDCClientSecret,
+			RedirectURL:    oidcRedirectURL(cfg),
+			NativeClientID: cfg.OIDCNativeClientID,
+			Scopes:         cfg.OIDCScopes,
+			Claims: oidc.ClaimMapping{
+				Email:    cfg.OIDCEmailClaim,
+				Name:     cfg.OIDCNameClaim,
+				Username: cfg.OIDCUsernameClaim,
+			},
+			States:           states,
+			MaxPendingStates: cfg.OIDCStateMaxPending,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize OIDC: %w", err)
+		}
+	}
+
+	// Trusted CI token issuers for workload certs
+	if c.workloads, err = workload.NewVerifier(context.Background(), cfg.WorkloadIssuers); err != nil {
+		return nil, fmt.Errorf("invalid workload issuers: %w", err)
+	}
+	if err := checkWorkloadRules(c.policy, cfg.WorkloadIssuers); err != nil {
+		return nil, fmt.Errorf("invalid issuance policy: %w", err)
+	}
+	if n := len(cfg.WorkloadIssuers); n > 0 {
+		log.Printf("Trusting %d workload issuer(s)", n)
+	}
+
+	// Machines that can enroll for host certs
+	if c.hosts, err = hosts.New(cfg.Hosts); err != nil {
+		return nil, fmt.Errorf("invalid hosts config: %w", err)
+	}
+	if c.hosts.Enabled() {
+		if _, ok := authorities[caName(c.hosts.CA())]; !ok {
+			return nil, fmt.Errorf("invalid hosts config: CA %q is not configured", caName(c.hosts.CA()))
+		}
+		log.Printf("Registered %d host(s) for host certificates", len(c.hosts.Machines()))
+	}
+
+	// Client policy published for cassh.app and cassh-cli
+	if cfg.ClientPolicyPath != "" {
+		if err := checkClientPolicy(cfg.ClientPolicyPath, cfg.ClientPolicySigningKey); err != nil {
+			return nil, fmt.Errorf("invalid client policy: %w", err)
+		}
+	}
+
+	return c, nil
+}
+
+// oidcRedirectURL is the configured OIDC callback, defaulting to /auth/callback on the server
+func oidcRedirectURL(cfg *config.ServerConfig) string {
+	if cfg.OIDCRedirectURL != "" {
+		return cfg.OIDCRedirectURL
+	}
+	return cfg.ServerBaseURL + "/auth/callback"
+}
+
+// openStateStore opens the store for in-flight OIDC logins
+// Auth flows must be shared when running more than one replica
+func openStateStore(cfg *config.ServerConfig) (oidc.StateStore, error) {
+	return oidc.OpenStateStore(oidc.StateStoreConfig{
+		Driver:       cfg.OIDCStateDriver,
+		Path:         cfg.OIDCStatePath,
+		URL:          cfg.OIDCStateURL,
+		CookieKey:    cfg.OIDCStateCookieKey,
+		SecureCookie: strings.HasPrefix(oidcRedirectURL(cfg), "https://"),
+	})
+}
+
+// install makes c the components new requests are served with, retiring the ones it replaces
+func (st *serverState) install(c *components) {
+	s := &Server{serverState: st, components: c}
+	s.handler = s.routes()
+	if old := st.current.Swap(s); old != nil {
+		old.retire()
+	}
+}
+
+// ServeHTTP serves r with the components current when it arrived, however long it takes
+// Reloads don't wait for it, and it never sees half of one
+func (st *serverState) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s := st.snapshot()
+	defer s.release()
+	s.handler.ServeHTTP(w, r)
+}
+
+// snapshot holds the current components for a request, which must release them
+func (st *serverState) snapshot() *Server {
+	for {
+		// A reload may retire the components between loading and holding them; the next load sees its replacement
+		if s := st.current.Load(); s.hold() {
+			return s
+		}
+	}
+}
+
+// hold marks c as in use by a request, failing once c is retired
+func (c *components) hold() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.retired {
+		return false
+	}
+	c.active++
+	return true
+}
+
+// release ends a request's use of c, closing its CA signers if c is retired and this was the last one
+func (c *components) release() {
+	c.mu.Lock()
+	c.active--
+	idle := c.retired && c.active == 0
+	c.mu.Unlock()
+	if idle {
+		closeAll(c.closers)
+	}
+}
+
+// retire stops new requests from using c and closes its CA signers once requests in flight finish
+func (c *components) retire() {
+	c.mu.Lock()
+	c.retired = true
+	idle := c.active == 0
+	c.mu.Unlock()
+	if idle {
+		closeAll(c.closers)
+	}
+}
+
+// reload re-reads the config file and swaps in the new config, CAs and authenticator
+// On any error the running config is kept
+func (st *serverState) reload() error {
+	cfg, err := config.LoadServerConfig(st.configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if cfg.IsDevMode() != st.devMode {
+		return fmt.Errorf("switching development mode requires a restart")
+	}
+	if !st.devMode {
+		if err := serverlint.Validate(cfg); err != nil {
+			return fmt.Errorf("configuration error: %w", err)
+		}
+	}
+
+	// Requests keep being served with the running components while CAs and OIDC discovery load
+	c, err := buildComponents(cfg, st.states)
+	if err != nil {
+		return err
+	}
+
+	prev := st.current.Load()
+	for _, setting := range restartOnlyChanges(prev.config, cfg) {
+		log.Printf("⚠️  %s changed; restart cassh-server to apply it", setting)
+	}
+	c.keepRateLimiters(prev.components)
+	st.install(c)
+	return nil
+}
+
+// restartOnlyChanges lists settings that differ between prev and next but only take effect on restart
+func restartOnlyChanges(prev, next *config.ServerConfig) []string {
+	var changed []string
+	if prev.ListenAddr != next.ListenAddr {
+		changed = append(changed, "listen_addr")
+	}
+	if prev.DeviceMaxPending != next.DeviceMaxPending {
+		changed = append(changed, "device.max_pending")
+	}
+	check := func(setting string, a, b []string) {
+		if strings.Join(a, "\x00") != strings.Join(b, "\x00") {
+			changed = append(changed, setting)
+		}
+	}
+	check("store", []string{prev.StoreDriver, prev.StorePath}, []string{next.StoreDriver, next.StorePath})
+	check("audit", []string{prev.AuditSink, prev.AuditPath}, []string{next.AuditSink, next.AuditPath})
+	check("oidc.state",
+		[]string{prev.OIDCStateDriver, prev.OIDCStatePath, prev.OIDCStateURL, prev.OIDCStateCookieKey},
+		[]string{next.OIDCStateDriver, next.OIDCStatePath, next.OIDCStateURL, next.OIDCStateCookieKey})
+	return changed
+}
+
+// handleReload runs a reload and records the outcome in logs and metrics
+func (st *serverState) handleReload(trigger string) {
+	log.Printf("Reloading config (%s)...", trigger)
+	if err := st.reload(); err != nil {
+		log.Printf("❌ Config reload failed, keeping the running config: %v", err)
+		st.metrics.reloads.Inc("failure")
+		return
+	}
+	log.Println("✅ Config reloaded")
+	st.metrics.reloads.Inc("success")
+	st.metrics.lastReload.Store(time.Now().Unix())
+}
+
+// watchReloads reloads the config on SIGHUP and whenever the config file changes
+func (st *serverState) watchReloads(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	// Poll rather than rely on inotify so edits through symlinks (e.g. Kubernetes ConfigMaps) are seen
+	var ticks <-chan time.Time
+	var last os.FileInfo
+	if st.configPath != "" {
+		last, _ = os.Stat(st.configPath)
+		ticker := time.NewTicker(configPollInterval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			st.handleReload("SIGHUP")
+			last, _ = os.Stat(st.configPath)
+		case <-ticks:
+			info, err := os.Stat(st.configPath)
+			if err != nil || !fileChanged(last, info) {
+				continue
+			}
+			last = info
+			st.handleReload("config file changed")
+		}
+	}
+}
+
+func fileChanged(last, current os.FileInfo) bool {
+	return last == nil || !last.ModTime().Equal(current.ModTime()) || last.Size() != current.Size()
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shawntz/cassh/internal/audit"
+	"github.com/shawntz/cassh/internal/config"
+	"github.com/shawntz/cassh/internal/device"
+	"github.com/shawntz/cassh/internal/ledger"
+)
+
+type discardCloser struct{ io.Writer }
+
+func (discardCloser) Close() error { return nil }
+
+// devConfig is the start of a dev mode config file
+const devConfig = "dev_mode = true\nserver_base_url = \"http://localhost:8080\"\n"
+
+// newTestState starts a dev mode server from a config file holding toml, without listening
+func newTestState(t *testing.T, toml string) *serverState {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "cassh.policy.toml")
+	writeConfig(t, path, devConfig+toml)
+
+	cfg, err := config.LoadServerConfig(path)
+	if err != nil {
+		t.Fatalf("LoadServerConfig() error = %v", err)
+	}
+	c, err := buildComponents(cfg, nil)
+	if err != nil {
+		t.Fatalf("buildComponents() error = %v", err)
+	}
+	store, err := ledger.Open(ledger.DriverSQLite, "")
+	if err != nil {
+		t.Fatalf("ledger.Open() error = %v", err)
+	}
+
+	st := &serverState{
+		configPath: path,
+		devices:    device.NewManager(0, 0, cfg.DeviceMaxPending),
+		store:      store,
+		audit:      audit.New(discardCloser{io.Discard}, "", 0),
+		devMode:    true,
+	}
+	st.metrics = newServerMetrics(st)
+	st.install(c)
+	t.Cleanup(func() {
+		st.current.Load().retire()
+		_ = store.Close()
+	})
+	return st
+}
+
+func writeConfig(t *testing.T, path, toml string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(toml), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// countingCloser counts how often it's closed
+type countingCloser struct{ closed atomic.Int32 }
+
+func (c *countingCloser) Close() error {
+	c.closed.Add(1)
+	return nil
+}
+
+func TestInstallKeepsSnapshotUntilReleased(t *testing.T) {
+	st := newTestState(t, "")
+	closer := &countingCloser{}
+	first := st.current.Load()
+	first.closers = append(first.closers, closer)
+
+	s := st.snapshot()
+	st.install(&components{config: first.config})
+
+	// The request in flight keeps its components, and their signers stay open
+	if s.components != first.components {
+		t.Fatal("snapshot changed when new components were installed")
+	}
+	if closer.closed.Load() != 0 {
+		t.Fatal("signers closed while a request was still using them")
+	}
+	// New requests get the new components
+	next := st.snapshot()
+	if next.components == first.components {
+		t.Error("snapshot after install returned the retired components")
+	}
+	next.release()
+	if first.hold() {
+		t.Error("retired components accepted a new request")
+	}
+
+	s.release()
+	if n := closer.closed.Load(); n != 1 {
+		t.Errorf("signers closed %d times after the last request finished, want 1", n)
+	}
+}
+
+func TestInstallClosesIdleComponents(t *testing.T) {
+	st := newTestState(t, "")
+	closer := &countingCloser{}
+	st.current.Load().closers = append(st.current.Load().closers, closer)
+
+	st.install(&components{config: st.current.Load().config})
+	if n := closer.closed.Load(); n != 1 {
+		t.Errorf("idle signers closed %d times on install, want 1", n)
+	}
+}
+
+func TestReloadDoesNotWaitForRequests(t *testing.T) {
+	st := newTestState(t, "cert_validity_hours = 12\n")
+
+	// A slow request (e.g. an OIDC token exchange) holds the running components
+	started, finish := make(chan struct{}), make(chan struct{})
+	var validity atomic.Int32
+	slow := st.current.Load()
+	slow.handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-finish
+		validity.Store(int32(slow.config.CertValidityHours))
+	})
+	done := make(chan struct{})
+	go func() {
+		st.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		close(done)
+	}()
+	<-started
+
+	writeConfig(t, st.configPath, devConfig+"cert_validity_hours = 4\n")
+	reloaded := make(chan error, 1)
+	go func() { reloaded <- st.reload() }()
+	select {
+	case err := <-reloaded:
+		if err != nil {
+			t.Fatalf("reload() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reload() waited for a request in flight")
+	}
+
+	// New requests see the new config while the slow one finishes with the old
+	rec := httptest.NewRecorder()
+	st.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET /health during the slow request = %d, want 200", rec.Code)
+	}
+	if got := st.current.Load().config.CertValidityHours; got != 4 {
+		t.Errorf("cert_validity_hours after reload = %d, want 4", got)
+	}
+	close(finish)
+	<-done
+	if got := validity.Load(); got != 12 {
+		t.Errorf("request in flight saw cert_validity_hours = %d, want 12", got)
+	}
+}
+
+func TestReloadKeepsConfigOnError(t *testing.T) {
+	st := newTestState(t, "cert_validity_hours = 8\n")
+
+	tests := []struct {
+		name    string
+		toml    string
+		wantErr string
+	}{
+		{"invalid value", devConfig + "cert_validity_hours = \"eight\"\n", "failed to load config"},
+		{"unknown CA", devConfig + "[[issuance.rules]]\nname = \"r\"\nca = \"missing\"\n", "invalid issuance policy"},
+		{"leaving dev mode", "server_base_url = \"https://cassh.example.com\"\n[oidc]\nissuer = \"https://idp.example.com\"\n", "requires a restart"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := st.current.Load()
+			writeConfig(t, st.configPath, tt.toml)
+
+			err := st.reload()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("reload() error = %v, want it to mention %q", err, tt.wantErr)
+			}
+			if st.current.Load() != before {
+				t.Error("a failed reload replaced the running components")
+			}
+			if got := st.current.Load().config.CertValidityHours; got != 8 {
+				t.Errorf("cert_validity_hours = %d, want 8", got)
+			}
+		})
+	}
+}
+
+func TestRestartOnlyChanges(t *testing.T) {
+	base := config.ServerConfig{
+		ListenAddr:      ":8080",
+		StoreDriver:     "sqlite",
+		StorePath:       "/var/lib/cassh/ledger.db",
+		AuditSink:       "file",
+		AuditPath:       "/var/log/cassh/audit.log",
+		OIDCStateDriver: "memory",
+	}
+
+	tests := []struct {
+		name   string
+		change func(*config.ServerConfig)
+		want   []string
+	}{
+		{"nothing", func(*config.ServerConfig) {}, nil},
+		{"reloadable setting", func(c *config.ServerConfig) { c.CertValidityHours = 4 }, nil},
+		{"listen address", func(c *config.ServerConfig) { c.ListenAddr = ":9090" }, []string{"listen_addr"}},
+		{"store path", func(c *config.ServerConfig) { c.StorePath = "/tmp/ledger.db" }, []string{"store"}},
+		{"audit sink", func(c *config.ServerConfig) { c.AuditSink = "stdout" }, []string{"audit"}},
+		{"device cap", func(c *config.ServerConfig) { c.DeviceMaxPending = 10 }, []string{"device.max_pending"}},
+		{"state store and listen address", func(c *config.ServerConfig) {
+			c.OIDCStateDriver = "redis"
+			c.ListenAddr = ":9090"
+		}, []string{"listen_addr", "oidc.state"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := base
+			tt.change(&next)
+			got := restartOnlyChanges(&base, &next)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("restartOnlyChanges() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
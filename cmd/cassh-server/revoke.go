@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shawntz/cassh/internal/audit"
+	"github.com/shawntz/cassh/internal/ca"
+	"github.com/shawntz/cassh/internal/config"
+	"github.com/shawntz/cassh/internal/ledger"
+	"golang.org/x/crypto/ssh"
+)
+
+// krlSignatureNamespace is the SSHSIG namespace for the detached KRL signature
+// Verify with: ssh-keygen -Y verify -n cassh-krl -f allowed_signers -I cassh-ca -s revoked.krl.sig < revoked.krl
+const krlSignatureNamespace = "cassh-krl"
+
+// krlSignatureCache keeps the last KRL signature, so /krl.sig only signs again once the KRL changes
+// It's keyed on the KRL itself rather than its version, which two revocations in one second share
+type krlSignatureCache struct {
+	mu     sync.Mutex
+	digest [sha256.Size]byte
+	sig    []byte
+}
+
+// handleKRL serves the current Key Revocation List in OpenSSH format
+// Point sshd's RevokedKeys at a periodically-refreshed copy of this file
+func (s *Server) handleKRL(w http.ResponseWriter, r *http.Request) {
+	krl, err := s.buildKRL(r)
+	if err != nil {
+		log.Printf("KRL build error: %v", err)
+		http.Error(w, "Failed to build KRL", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = w.Write(krl)
+}
+
+// handleKRLSignature serves a detached SSHSIG signature over the KRL made with the CA key
+func (s *Server) handleKRLSignature(w http.ResponseWriter, r *http.Request) {
+	krl, err := s.buildKRL(r)
+	if err != nil {
+		log.Printf("KRL build error: %v", err)
+		http.Error(w, "Failed to build KRL", http.StatusInternalServerError)
+		return
+	}
+
+	sig, err := s.signKRL(krl)
+	if err != nil {
+		log.Printf("KRL signing error: %v", err)
+		http.Error(w, "Failed to sign KRL", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = w.Write(sig)
+}
+
+// signKRL returns the signature over krl, signing with the CA key only when it isn't cached
+func (s *Server) signKRL(krl []byte) ([]byte, error) {
+	c := &s.krlSignature
+	digest := sha256.Sum256(krl)
+
+	// Held while signing so concurrent requests for a new KRL sign it once
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sig != nil && c.digest == digest {
+		return c.sig, nil
+	}
+
+	sig, err := s.ca.SignData(krlSignatureNamespace, krl)
+	if err != nil {
+		return nil, err
+	}
+	c.digest, c.sig = digest, sig
+	return sig, nil
+}
+
+func (s *Server) buildKRL(r *http.Request) ([]byte, error) {
+	if s.ca == nil {
+		return nil, fmt.Errorf("no CA configured")
+	}
+
+	revocations, err := s.store.Revocations(r.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	krl := &ca.KRL{
+		Comment:     "cassh revoked certificates",
+		CAKey:       s.ca.PublicKey(),
+		OtherCAKeys: s.otherCAKeys(),
+	}
+
+	for _, rev := range revocations {
+		// Version and date only move forward as revocations are appended
+		if t := rev.RevokedAt.Unix(); uint64(t) > krl.Version {
+			krl.Version = uint64(t)
+			krl.GeneratedAt = rev.RevokedAt
+		}
+
+		switch rev.Kind {
+		case ledger.RevokeBySerial:
+			serial, err := strconv.ParseUint(rev.Value, 10, 64)
+			if err != nil {
+				log.Printf("Skipping invalid revoked serial %q: %v", rev.Value, err)
+				continue
+			}
+			krl.Serials = append(krl.Serials, serial)
+		case ledger.RevokeByKeyID:
+			krl.KeyIDs = append(krl.KeyIDs, rev.Value)
+		case ledger.RevokeByPublicKey:
+			pub, err := ca.ParsePublicKey([]byte(rev.Value))
+			if err != nil {
+				log.Printf("Skipping invalid revoked public key: %v", err)
+				continue
+			}
+			krl.Keys = append(krl.Keys, pub)
+		}
+	}
+
+	return krl.Marshal(), nil
+}
+
+// handleRevoke lets a user revoke their own cert, proving possession by signing with the cert's key
+func (s *Server) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.ca == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "no CA configured")
+		return
+	}
+
+	var req ca.RevocationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+
+	// Any of our CAs may have signed the cert
+	var a *authority
+	if cert, err := ca.ParseCertificate([]byte(req.Certificate)); err == nil {
+		a = s.authorityFor(cert)
+	}
+	if a == nil {
+		a = s.authorities[config.DefaultCAName]
+	}
+
+	cert, err := a.ca.VerifyRevocationRequest(&req, time.Now())
+	if err != nil {
+		log.Printf("Revocation rejected: %v", err)
+		writeJSONError(w, http.StatusForbidden, "revocation request could not be verified")
+		return
+	}
+
+	rev := ledger.SerialRevocation(cert.Serial)
+	rev.Reason = "self-service"
+	rev.RevokedBy = cert.KeyId
+	rev.RevokedAt = time.Now().UTC()
+
+	if err := s.store.Revoke(r.Context(), rev); err != nil {
+		log.Printf("Revocation store error: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to record revocation")
+		return
+	}
+
+	log.Printf("Revoked cert serial=%d key_id=%s (self-service)", cert.Serial, cert.KeyId)
+	s.emit(r, audit.Event{
+		Type:            audit.EventRevoked,
+		Actor:           cert.KeyId,
+		Serial:          rev.Value,
+		KeyID:           cert.KeyId,
+		Fingerprint:     ssh.FingerprintSHA256(cert.Key),
+		RevocationKind:  string(rev.Kind),
+		RevocationValue: rev.Value,
+		Reason:          rev.Reason,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "revoked",
+		"serial": strconv.FormatUint(cert.Serial, 10),
+	})
+}
+
+// handleAdminRevoke revokes certs by serial, key ID or public key
+// Exactly one of serial, key_id or public_key must be set
+func (s *Server) handleAdminRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	var req struct {
+		Serial    string `json:"serial"`
+		KeyID     string `json:"key_id"`
+		PublicKey string `json:"public_key"`
+		Reason    string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+
+	var rev ledger.Revocation
+	set := 0
+	if req.Serial != "" {
+		serial, err := strconv.ParseUint(req.Serial, 10, 64)
+		if err != nil || serial == 0 {
+			writeJSONError(w, http.StatusBadRequest, "invalid serial")
+			return
+		}
+		rev = ledger.SerialRevocation(serial)
+		set++
+	}
+	if req.KeyID != "" {
+		rev = ledger.Revocation{Kind: ledger.RevokeByKeyID, Value: req.KeyID}
+		set++
+	}
+	if req.PublicKey != "" {
+		pub, err := ca.ParsePublicKey([]byte(req.PublicKey))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid public key")
+			return
+		}
+		// Revoke the underlying key if a cert was given
+		if cert, ok := pub.(*ssh.Certificate); ok {
+			pub = cert.Key
+		}
+		rev = ledger.Revocation{Kind: ledger.RevokeByPublicKey, Value: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))}
+		set++
+	}
+	if set != 1 {
+		writeJSONError(w, http.StatusBadRequest, "exactly one of serial, key_id or public_key is required")
+		return
+	}
+
+	rev.Reason = req.Reason
+	rev.RevokedBy = "admin"
+	rev.RevokedAt = time.Now().UTC()
+
+	if err := s.store.Revoke(r.Context(), rev); err != nil {
+		log.Printf("Revocation store error: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to record revocation")
+		return
+	}
+
+	log.Printf("Revoked %s=%s (admin, reason: %q)", rev.Kind, rev.Value, rev.Reason)
+	s.emit(r, audit.Event{
+		Type:            audit.EventRevoked,
+		Actor:           rev.RevokedBy,
+		RevocationKind:  string(rev.Kind),
+		RevocationValue: rev.Value,
+		Reason:          rev.Reason,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{"status": "revoked"})
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// isRevoked reports whether the ledger revokes cert by serial, key ID or public key
+func (s *Server) isRevoked(ctx context.Context, cert *ssh.Certificate) (bool, error) {
+	revocations, err := s.store.Revocations(ctx)
+	if err != nil {
+		return false, err
+	}
+	serial := strconv.FormatUint(cert.Serial, 10)
+	publicKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(cert.Key)))
+	for _, rev := range revocations {
+		switch {
+		case rev.Kind == ledger.RevokeBySerial && rev.Value == serial,
+			rev.Kind == ledger.RevokeByKeyID && rev.Value == cert.KeyId,
+			rev.Kind == ledger.RevokeByPublicKey && rev.Value == publicKey:
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/shawntz/cassh/internal/ca"
+	"github.com/shawntz/cassh/internal/ledger"
+	"golang.org/x/crypto/ssh"
+)
+
+// countingSigner counts the signatures made with the CA key
+type countingSigner struct {
+	ssh.Signer
+	signs int
+}
+
+func (c *countingSigner) Sign(r io.Reader, data []byte) (*ssh.Signature, error) {
+	c.signs++
+	return c.Signer.Sign(r, data)
+}
+
+func TestKRLSignatureCached(t *testing.T) {
+	s := newTestState(t, "").current.Load()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keySigner, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := &countingSigner{Signer: keySigner}
+	s.ca = ca.NewCAFromSigner(signer, 12, nil)
+
+	fetch := func() string {
+		rec := httptest.NewRecorder()
+		s.handleKRLSignature(rec, httptest.NewRequest(http.MethodGet, "/krl.sig", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /krl.sig = %d, want 200", rec.Code)
+		}
+		return rec.Body.String()
+	}
+
+	first := fetch()
+	if second := fetch(); second != first || signer.signs != 1 {
+		t.Errorf("second GET /krl.sig signed %d times in all, want the first signature reused", signer.signs)
+	}
+
+	// A revocation changes the KRL, so it's signed again
+	if err := s.store.Revoke(context.Background(), ledger.SerialRevocation(42)); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	if fetch() == first || signer.signs != 2 {
+		t.Errorf("GET /krl.sig after a revocation signed %d times in all, want a new signature", signer.signs)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/shawntz/cassh/internal/audit"
+	"github.com/shawntz/cassh/internal/ca"
+	"github.com/shawntz/cassh/internal/policy"
+	"github.com/shawntz/cassh/internal/workload"
+)
+
+// workloadPrincipalSource labels workload certs in cassh_certs_issued_total
+const workloadPrincipalSource = "workload"
+
+// checkWorkloadRules makes sure issuance rules only name configured workload issuers
+func checkWorkloadRules(engine *policy.Engine, issuers []workload.IssuerConfig) error {
+	defined := make(map[string]bool, len(issuers))
+	for _, issuer := range issuers {
+		defined[issuer.Name] = true
+	}
+	for _, rule := range engine.Rules() {
+		for _, name := range rule.Workloads {
+			if name != "*" && !defined[name] {
+				return fmt.Errorf("issuance rule %q refers to undefined workload issuer %q", rule.Name, name)
+			}
+		}
+	}
+	return nil
+}
+
+// handleWorkloadCert issues a short-lived cert to a CI job that presents a workload identity token
+// POST /api/v1/workload/cert with "Authorization: Bearer <JWT>" and {"public_key": "...", "template": "..."}
+func (s *Server) handleWorkloadCert(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.workloads.Enabled() {
+		writeJSONError(w, http.StatusNotFound, "workload issuance is not configured")
+		return
+	}
+
+	rawToken, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || rawToken == "" {
+		writeJSONError(w, http.StatusUnauthorized, "bearer token required")
+		return
+	}
+
+	var req struct {
+		PublicKey string `json:"public_key"`
+		Template  string `json:"template"`
+		Target    string `json:"target"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	sshPubKey, err := ca.ParsePublicKey([]byte(req.PublicKey))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid public_key")
+		return
+	}
+
+	id, err := s.workloads.Verify(r.Context(), rawToken)
+	if err != nil {
+		log.Printf("Workload token rejected: %v", err)
+		s.emit(r, audit.Event{Type: audit.EventAuthFailed, Reason: err.Error()})
+		writeJSONError(w, http.StatusUnauthorized, "invalid workload token")
+		return
+	}
+
+	actor := workloadIdentity(id)
+	if !s.allowSigning(w, r, actor) {
+		return
+	}
+
+	decision := s.policy.Evaluate(policy.Input{
+		Subject:   id.Subject,
+		Principal: id.Subject,
+		Username:  id.Subject,
+		Claims:    id.Claims,
+		Template:  req.Template,
+		Target:    req.Target,
+		Workload:  id.Issuer,
+	})
+	if !decision.Allowed {
+		s.denyWorkload(w, r, actor, id.Subject, decision)
+		return
+	}
+
+	// Workload certs are always short-lived: the rule's validity, capped at the issuer's maximum
+	validity := id.MaxValidity
+	if decision.Validity > 0 && decision.Validity < validity {
+		validity = decision.Validity
+	}
+
+	// There's no human GitHub login; the login@ extension gets the first principal (e.g., a machine user)
+	githubUsername := id.Subject
+	if len(decision.Principals) > 0 {
+		githubUsername = decision.Principals[0]
+	}
+
+	cert, signedBy, err := s.sign(&ca.CertRequest{
+		PublicKey:      sshPubKey,
+		KeyIDFormat:    s.workloadKeyIDFormat,
+		KeyIDFields:    s.workloadKeyIDFields(r, id, githubUsername, req.Template, req.Target),
+		Principals:     decision.Principals,
+		GitHubUsername: githubUsername,
+		Validity:       validity,
+		Extensions:     decision.Extensions,
+	}, decision)
+	if err != nil {
+		if errors.Is(err, ca.ErrPrincipalNotAllowed) {
+			s.denyWorkload(w, r, actor, id.Subject, policy.Decision{Rule: decision.Rule, Reason: err.Error()})
+			return
+		}
+		log.Printf("Cert signing error: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to generate certificate")
+		return
+	}
+
+	if err := s.recordIssued(r, cert, actor, s.clientIP(r)); err != nil {
+		log.Printf("Ledger error: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to generate certificate")
+		return
+	}
+
+	log.Printf("Signed workload cert for %s: serial=%d, principals=%v, ca=%s", actor, cert.Serial, cert.ValidPrincipals, signedBy.name)
+	s.emit(r, certIssuedEvent(cert, actor, id.Subject, decision))
+	s.metrics.certsIssued.Inc(workloadPrincipalSource)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"certificate": strings.TrimSpace(string(ca.MarshalCertificate(cert))),
+	})
+}
+
+// workloadIdentity names a workload in the ledger, audit log and signing limit
+func workloadIdentity(id *workload.Identity) string {
+	return fmt.Sprintf("workload:%s:%s", id.Issuer, id.Subject)
+}
+
+// denyWorkload records a policy denial for a workload and returns 403
+func (s *Server) denyWorkload(w http.ResponseWriter, r *http.Request, actor, subject string, decision policy.Decision) {
+	log.Printf("Issuance denied for %s: %s", actor, decision.Reason)
+	s.emit(r, audit.Event{
+		Type:       audit.EventIssuanceDenied,
+		Actor:      actor,
+		Subject:    subject,
+		PolicyRule: decision.Rule,
+		Reason:     decision.Reason,
+	})
+	writeJSONError(w, http.StatusForbidden, "certificate issuance denied by policy")
+}
@@ -0,0 +1,276 @@
+// Structured, tamper-evident audit log for authentication and signing events
+// Each record is a single JSON line, hash-chained to the previous record so deletions
+// and edits can be detected with Verify
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventType identifies the kind of audit event
+// These names are stable; SIEM rules key off them
+type EventType string
+
+const (
+	EventAuthStarted    EventType = "auth_started"
+	EventAuthFailed     EventType = "auth_failed"
+	EventCertIssued     EventType = "cert_issued"
+	EventIssuanceDenied EventType = "issuance_denied"
+	EventDevAuthUsed    EventType = "dev_auth_used"
+	EventRevoked        EventType = "revoked"
+	EventAuditStarted   EventType = "audit_started"
+)
+
+// Event is a single audit record
+// Field names are part of the SIEM contract - add new fields, never rename existing ones
+type Event struct {
+	Seq        uint64    `json:"seq"`
+	Time       time.Time `json:"time"`
+	Type       EventType `json:"event"`
+	Actor      string    `json:"actor,omitempty"`       // Authenticated identity (e.g., email) or "admin"
+	Subject    string    `json:"subject,omitempty"`     // OIDC subject
+	RemoteAddr string    `json:"remote_addr,omitempty"` // Client IP
+	UserAgent  string    `json:"user_agent,omitempty"`
+
+	// Certificate details (cert_issued, revoked)
+	Serial      string     `json:"serial,omitempty"`
+	KeyID       string     `json:"key_id,omitempty"`
+	Principals  []string   `json:"principals,omitempty"`
+	Fingerprint string     `json:"fingerprint,omitempty"` // SHA256 fingerprint of the user's public key
+	ValidBefore *time.Time `json:"valid_before,omitempty"`
+
+	// Issuance policy rule that allowed or denied the request, the cert template used and the CA that signed
+	PolicyRule string `json:"policy_rule,omitempty"`
+	Template   string `json:"template,omitempty"`
+	CA         string `json:"ca,omitempty"`
+
+	// Revocations: what was matched (serial, key_id, public_key) and its value
+	RevocationKind  string `json:"revocation_kind,omitempty"`
+	RevocationValue string `json:"revocation_value,omitempty"`
+
+	// Failure or revocation reason
+	Reason string `json:"reason,omitempty"`
+
+	// Hash chain
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+// hashField is appended to every record; Verify strips it to recompute the hash
+const hashField = `,"hash":"`
+
+// Logger writes hash-chained audit events to a sink
+type Logger struct {
+	mu       sync.Mutex
+	sink     io.WriteCloser
+	seq      uint64
+	lastHash string
+	now      func() time.Time
+}
+
+// New creates a logger that continues the chain from lastHash/seq
+// Use an empty lastHash and zero seq to start a new chain
+func New(sink io.WriteCloser, lastHash string, seq uint64) *Logger {
+	return &Logger{
+		sink:     sink,
+		seq:      seq,
+		lastHash: lastHash,
+		now:      time.Now,
+	}
+}
+
+// Open creates a logger for the named sink:
+//   - "stdout": JSON lines on standard output
+//   - "file": JSON lines appended to path; the chain continues across restarts
+//   - "syslog": one record per syslog message (facility AUTH)
+//
+// An "audit_started" event is written first so restarts are visible in the trail
+func Open(sink, path string) (*Logger, error) {
+	var logger *Logger
+
+	switch sink {
+	case "stdout", "":
+		logger = New(nopCloser{os.Stdout}, "", 0)
+	case "file":
+		if path == "" {
+			return nil, fmt.Errorf("audit file sink requires a path")
+		}
+		lastHash, seq, err := lastRecord(path)
+		if err != nil {
+			return nil, err
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open audit log: %w", err)
+		}
+		logger = New(f, lastHash, seq)
+	case "syslog":
+		w, err := newSyslogWriter()
+		if err != nil {
+			return nil, err
+		}
+		logger = New(w, "", 0)
+	default:
+		return nil, fmt.Errorf("unknown audit sink %q (use stdout, file or syslog)", sink)
+	}
+
+	if err := logger.Log(Event{Type: EventAuditStarted}); err != nil {
+		return nil, err
+	}
+	return logger, nil
+}
+
+// Log appends an event to the chain
+// Seq, Time, PrevHash and Hash are filled in by the logger
+func (l *Logger) Log(e Event) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e.Seq = l.seq + 1
+	if e.Time.IsZero() {
+		e.Time = l.now()
+	}
+	e.Time = e.Time.UTC()
+	e.PrevHash = l.lastHash
+	e.Hash = ""
+
+	line, hash, err := encode(&e)
+	if err != nil {
+		return err
+	}
+
+	if _, err := l.sink.Write(line); err != nil {
+		return fmt.Errorf("failed to write audit event: %w", err)
+	}
+
+	l.seq = e.Seq
+	l.lastHash = hash
+	return nil
+}
+
+// Close closes the underlying sink
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.sink.Close()
+}
+
+// encode serializes e and appends its hash
+// The hash covers the JSON body (including prev_hash), so each record commits to the whole chain before it
+func encode(e *Event) ([]byte, string, error) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode audit event: %w", err)
+	}
+
+	sum := sha256.Sum256(body)
+	hash := hex.EncodeToString(sum[:])
+
+	line := make([]byte, 0, len(body)+len(hashField)+len(hash)+3)
+	line = append(line, body[:len(body)-1]...)
+	line = append(line, hashField...)
+	line = append(line, hash...)
+	line = append(line, "\"}\n"...)
+	return line, hash, nil
+}
+
+// decode splits a record into its event and verifies the record's own hash
+func decode(line []byte) (*Event, error) {
+	idx := bytes.LastIndex(line, []byte(hashField))
+	if idx == -1 || !bytes.HasSuffix(line, []byte("\"}")) {
+		return nil, fmt.Errorf("record has no hash")
+	}
+
+	hash := string(line[idx+len(hashField) : len(line)-2])
+	body := append(append([]byte(nil), line[:idx]...), '}')
+
+	sum := sha256.Sum256(body)
+	if hex.EncodeToString(sum[:]) != hash {
+		return nil, fmt.Errorf("record hash mismatch")
+	}
+
+	var e Event
+	if err := json.Unmarshal(body, &e); err != nil {
+		return nil, fmt.Errorf("invalid record: %w", err)
+	}
+	e.Hash = hash
+	return &e, nil
+}
+
+// Verify checks every record in r and that each one chains to the previous
+// Returns the number of records verified
+func Verify(r io.Reader) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	count := 0
+	prevHash := ""
+	var prevSeq uint64
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		e, err := decode(line)
+		if err != nil {
+			return count, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if count > 0 {
+			if e.PrevHash != prevHash {
+				return count, fmt.Errorf("line %d: chain broken (prev_hash does not match previous record - records removed or reordered)", lineNo)
+			}
+			if e.Seq != prevSeq+1 {
+				return count, fmt.Errorf("line %d: sequence gap (%d after %d)", lineNo, e.Seq, prevSeq)
+			}
+		}
+
+		prevHash = e.Hash
+		prevSeq = e.Seq
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return count, err
+	}
+
+	return count, nil
+}
+
+// lastRecord returns the hash and sequence number of the last record in an audit file
+func lastRecord(path string) (string, uint64, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", 0, nil
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	last := strings.TrimSpace(lines[len(lines)-1])
+	if last == "" {
+		return "", 0, nil
+	}
+
+	e, err := decode([]byte(last))
+	if err != nil {
+		return "", 0, fmt.Errorf("audit log %s is corrupt, refusing to extend it: %w", path, err)
+	}
+	return e.Hash, e.Seq, nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,151 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type bufferSink struct {
+	bytes.Buffer
+}
+
+func (b *bufferSink) Close() error { return nil }
+
+func writeTestEvents(t *testing.T, logger *Logger) {
+	t.Helper()
+	events := []Event{
+		{Type: EventAuthStarted, RemoteAddr: "10.0.0.1"},
+		{Type: EventCertIssued, Actor: "alice@example.com", Serial: "42", Principals: []string{"alice"}},
+		{Type: EventRevoked, Actor: "admin", RevocationKind: "serial", RevocationValue: "42", Reason: "laptop stolen"},
+	}
+	for _, e := range events {
+		if err := logger.Log(e); err != nil {
+			t.Fatalf("Log() error = %v", err)
+		}
+	}
+}
+
+func TestLogFormat(t *testing.T) {
+	sink := &bufferSink{}
+	logger := New(sink, "", 0)
+	logger.now = func() time.Time { return time.Date(2025, 1, 7, 9, 0, 0, 0, time.UTC) }
+
+	if err := logger.Log(Event{Type: EventCertIssued, Actor: "alice@example.com", Serial: "42"}); err != nil {
+		t.Fatalf("Log() error = %v", err)
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(sink.Bytes(), &fields); err != nil {
+		t.Fatalf("record is not valid JSON: %v", err)
+	}
+
+	want := map[string]interface{}{
+		"seq":       float64(1),
+		"time":      "2025-01-07T09:00:00Z",
+		"event":     "cert_issued",
+		"actor":     "alice@example.com",
+		"serial":    "42",
+		"prev_hash": "",
+	}
+	for k, v := range want {
+		if fields[k] != v {
+			t.Errorf("field %q = %v, want %v", k, fields[k], v)
+		}
+	}
+	if hash, _ := fields["hash"].(string); len(hash) != 64 {
+		t.Errorf("hash = %q, want 64 hex chars", hash)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	sink := &bufferSink{}
+	writeTestEvents(t, New(sink, "", 0))
+
+	n, err := Verify(bytes.NewReader(sink.Bytes()))
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if n != 3 {
+		t.Errorf("Verify() = %d records, want 3", n)
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	sink := &bufferSink{}
+	writeTestEvents(t, New(sink, "", 0))
+	lines := strings.SplitAfter(strings.TrimSpace(sink.String()), "\n")
+
+	tests := []struct {
+		name string
+		log  string
+	}{
+		{"Deleted record", lines[0] + lines[2]},
+		{"Reordered records", lines[1] + lines[0] + lines[2]},
+		{"Edited record", strings.Replace(sink.String(), "laptop stolen", "routine", 1)},
+		{"Missing hash", `{"seq":1,"event":"auth_started","prev_hash":""}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Verify(strings.NewReader(tt.log)); err == nil {
+				t.Error("Verify() expected error")
+			}
+		})
+	}
+}
+
+func TestOpenFileContinuesChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	logger, err := Open("file", path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	writeTestEvents(t, logger)
+	_ = logger.Close()
+
+	// Reopen (simulating a restart) and keep writing
+	logger, err = Open("file", path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	writeTestEvents(t, logger)
+	_ = logger.Close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	// 2 audit_started + 6 events, one unbroken chain
+	n, err := Verify(f)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if n != 8 {
+		t.Errorf("Verify() = %d records, want 8", n)
+	}
+}
+
+func TestOpenFileRejectsCorruptLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	if err := os.WriteFile(path, []byte("not an audit record\n"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	if _, err := Open("file", path); err == nil {
+		t.Error("Open() expected error for corrupt log")
+	}
+}
+
+func TestOpenUnknownSink(t *testing.T) {
+	if _, err := Open("kafka", ""); err == nil {
+		t.Error("Open() expected error for unknown sink")
+	}
+}
@@ -0,0 +1,12 @@
+//go:build windows || plan9
+
+package audit
+
+import (
+	"fmt"
+	"io"
+)
+
+func newSyslogWriter() (io.WriteCloser, error) {
+	return nil, fmt.Errorf("syslog audit sink is not supported on this platform")
+}
@@ -0,0 +1,34 @@
+//go:build !windows && !plan9
+
+package audit
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/syslog"
+)
+
+// syslogWriter sends each audit record as a single syslog message
+type syslogWriter struct {
+	w *syslog.Writer
+}
+
+func newSyslogWriter() (io.WriteCloser, error) {
+	w, err := syslog.New(syslog.LOG_AUTH|syslog.LOG_INFO, "cassh-audit")
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+	}
+	return &syslogWriter{w: w}, nil
+}
+
+func (s *syslogWriter) Write(p []byte) (int, error) {
+	if err := s.w.Info(string(bytes.TrimRight(p, "\n"))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *syslogWriter) Close() error {
+	return s.w.Close()
+}
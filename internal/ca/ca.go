@@ -3,12 +3,15 @@
 package ca
 
 import (
+	"bytes"
 	"crypto/ed25519"
 	"crypto/rand"
 	"encoding/binary"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/shawntz/cassh/internal/sshsig"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -17,6 +20,9 @@ type CertificateAuthority struct {
 	signer        ssh.Signer
 	validityHours int
 	principals    []string
+
+	// Next and retired CA keys, trusted alongside signer during a rotation
+	otherKeys []TrustedKey
 }
 
 // NewCA creates a new certificate authority from a private key
@@ -26,11 +32,38 @@ func NewCA(privateKeyPEM []byte, validityHours int, principals []string) (*Certi
 		return nil, fmt.Errorf("failed to parse CA private key: %w", err)
 	}
 
+	return NewCAFromSigner(signer, validityHours, principals), nil
+}
+
+// NewCAFromSigner creates a certificate authority that signs with any ssh.Signer
+// (see NewSigner for HSM and agent-backed keys that never enter this process)
+func NewCAFromSigner(signer ssh.Signer, validityHours int, principals []string) *CertificateAuthority {
 	return &CertificateAuthority{
 		signer:        signer,
 		validityHours: validityHours,
 		principals:    principals,
-	}, nil
+	}
+}
+
+// PublicKey returns the CA's active public key
+func (ca *CertificateAuthority) PublicKey() ssh.PublicKey {
+	return ca.signer.PublicKey()
+}
+
+// IsAuthorityFor returns true if the cert was signed by any of this CA's trusted keys
+func (ca *CertificateAuthority) IsAuthorityFor(cert *ssh.Certificate) bool {
+	for _, key := range ca.TrustedKeys() {
+		if bytes.Equal(cert.SignatureKey.Marshal(), key.PublicKey.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+// SignData creates a detached SSHSIG signature over data with the CA key
+// Used to sign artifacts the CA publishes (e.g., the KRL) so clients can verify their origin
+func (ca *CertificateAuthority) SignData(namespace string, data []byte) ([]byte, error) {
+	return sshsig.Sign(ca.signer, namespace, data)
 }
 
 // SignPublicKey signs a user's public key, creating an SSH cert
@@ -43,53 +76,107 @@ func (ca *CertificateAuthority) SignPublicKey(userPubKey ssh.PublicKey, keyID st
 // The githubHost should be the GHE hostname (e.g., "github.yourcompany.com") or empty for github.com
 // The githubUsername is the user's GitHub/GHE username for the login extension
 func (ca *CertificateAuthority) SignPublicKeyForGitHub(userPubKey ssh.PublicKey, keyID string, githubUsername string, githubHost string) (*ssh.Certificate, error) {
-	// Generate random serial
-	serialBytes := make([]byte, 8)
-	if _, err := rand.Read(serialBytes); err != nil {
-		return nil, fmt.Errorf("failed to generate serial: %w", err)
+	return ca.Sign(&CertRequest{
+		PublicKey:      userPubKey,
+		KeyID:          keyID,
+		GitHubUsername: githubUsername,
+		GitHubHost:     githubHost,
+	}, nil)
+}
+
+// DefaultExtensions are granted when a request doesn't specify any
+// These are REQUIRED for GitHub Enterprise
+// See: https://docs.github.com/en/enterprise-cloud@latest/organizations/managing-git-access-to-your-organizations-repositories/about-ssh-certificate-authorities
+var DefaultExtensions = []string{
+	"permit-agent-forwarding",
+	"permit-port-forwarding",
+	"permit-pty",
+	"permit-user-rc",
+}
+
+// CertRequest describes a user cert to sign
+type CertRequest struct {
+	PublicKey ssh.PublicKey
+	KeyID     string
+
+	// KeyIDFormat, if set, builds the key ID from KeyIDFields and the cert's serial instead of using KeyID
+	KeyIDFormat *KeyIDFormat
+	KeyIDFields KeyIDFields
+
+	// Principals for the cert; empty uses the CA's principals, then GitHubUsername
+	Principals []string
+
+	// GitHub login extension: login@GitHubHost=GitHubUsername (github.com if GitHubHost is empty)
+	GitHubUsername string
+	GitHubHost     string
+
+	// Validity overrides the CA's default lifetime when non-zero (capped by the template)
+	Validity time.Duration
+
+	// Extensions to grant (values are empty); nil grants everything the template allows
+	Extensions []string
+}
+
+// Sign issues a user cert for req using tmpl
+// A nil template grants DefaultExtensions with no critical options or caps
+func (ca *CertificateAuthority) Sign(req *CertRequest, tmpl *Template) (*ssh.Certificate, error) {
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	validity := req.Validity
+	if validity == 0 {
+		validity = time.Duration(ca.validityHours) * time.Hour
 	}
-	serial := binary.BigEndian.Uint64(serialBytes)
+	validity = tmpl.validity(validity)
 
 	now := time.Now()
 	validAfter := uint64(now.Unix())
-	validBefore := uint64(now.Add(time.Duration(ca.validityHours) * time.Hour).Unix())
+	validBefore := uint64(now.Add(validity).Unix())
 
 	// Determine principals
-	principals := ca.principals
+	principals := req.Principals
+	if len(principals) == 0 {
+		principals = ca.principals
+	}
 	if len(principals) == 0 {
-		principals = []string{githubUsername}
+		principals = []string{req.GitHubUsername}
+	}
+	if err := tmpl.checkPrincipals(principals); err != nil {
+		return nil, err
 	}
 
-	// Build extensions - these are REQUIRED for GitHub Enterprise
-	// See: https://docs.github.com/en/enterprise-cloud@latest/organizations/managing-git-access-to-your-organizations-repositories/about-ssh-certificate-authorities
-	extensions := map[string]string{
-		"permit-agent-forwarding": "",
-		"permit-port-forwarding":  "",
-		"permit-pty":              "",
-		"permit-user-rc":          "",
+	names := tmpl.extensions(req.Extensions)
+	extensions := make(map[string]string, len(names)+1)
+	for _, name := range names {
+		extensions[name] = ""
 	}
 
 	// Add the GitHub login extension - this is CRITICAL for GHE
 	// Format: login@HOSTNAME=USERNAME
 	// For github.com: login@github.com=username
 	// For GHE: login@github.yourcompany.com=username
-	if githubHost != "" {
-		extensions[fmt.Sprintf("login@%s", githubHost)] = githubUsername
-	} else {
-		// Default to github.com
-		extensions["login@github.com"] = githubUsername
+	if tmpl == nil || !tmpl.OmitGitHubLogin {
+		if req.GitHubHost != "" {
+			extensions[fmt.Sprintf("login@%s", req.GitHubHost)] = req.GitHubUsername
+		} else {
+			// Default to github.com
+			extensions["login@github.com"] = req.GitHubUsername
+		}
 	}
 
 	cert := &ssh.Certificate{
-		Key:             userPubKey,
+		Key:             req.PublicKey,
 		Serial:          serial,
 		CertType:        ssh.UserCert,
-		KeyId:           keyID,
+		KeyId:           keyID(req.KeyID, req.KeyIDFormat, req.KeyIDFields, serial),
 		ValidPrincipals: principals,
 		ValidAfter:      validAfter,
 		ValidBefore:     validBefore,
 		Permissions: ssh.Permissions{
-			Extensions: extensions,
+			CriticalOptions: tmpl.criticalOptions(),
+			Extensions:      extensions,
 		},
 	}
 
@@ -100,6 +187,15 @@ func (ca *CertificateAuthority) SignPublicKeyForGitHub(userPubKey ssh.PublicKey,
 	return cert, nil
 }
 
+// newSerial generates a random cert serial
+func newSerial() (uint64, error) {
+	serialBytes := make([]byte, 8)
+	if _, err := rand.Read(serialBytes); err != nil {
+		return 0, fmt.Errorf("failed to generate serial: %w", err)
+	}
+	return binary.BigEndian.Uint64(serialBytes), nil
+}
+
 // GenerateKeyPair creates a new Ed25519 keypair for the user
 func GenerateKeyPair() (ed25519.PublicKey, ed25519.PrivateKey, error) {
 	pub, priv, err := ed25519.GenerateKey(rand.Reader)
@@ -142,6 +238,8 @@ type CertInfo struct {
 	ValidBefore time.Time
 	IsExpired   bool
 	TimeLeft    time.Duration
+	GitHubHost  string // From the login@ extension; empty if the cert has none
+	GitHubLogin string
 }
 
 // GetCertInfo extracts info from a cert for display
@@ -150,6 +248,7 @@ func GetCertInfo(cert *ssh.Certificate) *CertInfo {
 	validBefore := time.Unix(int64(cert.ValidBefore), 0)
 	validAfter := time.Unix(int64(cert.ValidAfter), 0)
 
+	host, login := GitHubLogin(cert)
 	return &CertInfo{
 		Serial:      cert.Serial,
 		KeyID:       cert.KeyId,
@@ -158,7 +257,19 @@ func GetCertInfo(cert *ssh.Certificate) *CertInfo {
 		ValidBefore: validBefore,
 		IsExpired:   now.After(validBefore),
 		TimeLeft:    validBefore.Sub(now),
+		GitHubHost:  host,
+		GitHubLogin: login,
+	}
+}
+
+// GitHubLogin returns the host and login of a cert's login@HOST extension, or empty strings if it has none
+func GitHubLogin(cert *ssh.Certificate) (host, login string) {
+	for name, value := range cert.Extensions {
+		if h, ok := strings.CutPrefix(name, "login@"); ok && value != "" {
+			return h, value
+		}
 	}
+	return "", ""
 }
 
 // ParseCertificate parses an SSH cert from file
@@ -178,6 +178,49 @@ func TestSignPublicKeyWithPrincipals(t *testing.T) {
 	}
 }
 
+func TestSignRequestOverrides(t *testing.T) {
+	ca, err := NewCA(generateTestCAKey(t), 12, []string{"ignored"})
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+
+	userPub, _ := generateTestUserKey(t)
+
+	cert, err := ca.Sign(&CertRequest{
+		PublicKey:      userPub,
+		KeyID:          "test-key-id",
+		Principals:     []string{"alice", "deploy"},
+		GitHubUsername: "alice_corp",
+		GitHubHost:     "github.corp.com",
+		Validity:       time.Hour,
+		Extensions:     []string{"permit-pty"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	if len(cert.ValidPrincipals) != 2 || cert.ValidPrincipals[0] != "alice" || cert.ValidPrincipals[1] != "deploy" {
+		t.Errorf("ValidPrincipals = %v, want [alice deploy]", cert.ValidPrincipals)
+	}
+
+	if got := time.Duration(cert.ValidBefore-cert.ValidAfter) * time.Second; got != time.Hour {
+		t.Errorf("validity = %v, want 1h", got)
+	}
+
+	wantExtensions := map[string]string{
+		"permit-pty":            "",
+		"login@github.corp.com": "alice_corp",
+	}
+	if len(cert.Extensions) != len(wantExtensions) {
+		t.Errorf("Extensions = %v, want %v", cert.Extensions, wantExtensions)
+	}
+	for k, v := range wantExtensions {
+		if got, ok := cert.Extensions[k]; !ok || got != v {
+			t.Errorf("Extensions[%q] = %q, want %q", k, got, v)
+		}
+	}
+}
+
 func TestGenerateKeyPair(t *testing.T) {
 	pub, priv, err := GenerateKeyPair()
 	if err != nil {
@@ -335,6 +378,10 @@ func TestGetCertInfo(t *testing.T) {
 	if info.TimeLeft < expectedTimeLeft-time.Minute || info.TimeLeft > expectedTimeLeft+time.Minute {
 		t.Errorf("TimeLeft = %v, want approximately %v", info.TimeLeft, expectedTimeLeft)
 	}
+
+	if info.GitHubHost != "github.com" || info.GitHubLogin != "testuser" {
+		t.Errorf("GitHub login = %s@%s, want testuser@github.com", info.GitHubLogin, info.GitHubHost)
+	}
 }
 
 func TestGetCertInfoExpired(t *testing.T) {
@@ -0,0 +1,128 @@
+package ca
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// HostCertRequest describes a host cert to sign
+type HostCertRequest struct {
+	PublicKey ssh.PublicKey // The host's key (e.g., /etc/ssh/ssh_host_ed25519_key.pub)
+	KeyID     string
+
+	// KeyIDFormat, if set, builds the key ID from KeyIDFields and the cert's serial instead of using KeyID
+	KeyIDFormat *KeyIDFormat
+	KeyIDFields KeyIDFields
+
+	Hostnames []string // Valid principals: the names clients connect to
+	Validity  time.Duration
+}
+
+// SignHost issues a host cert, so clients that trust the CA with @cert-authority skip TOFU prompts
+func (ca *CertificateAuthority) SignHost(req *HostCertRequest) (*ssh.Certificate, error) {
+	if len(req.Hostnames) == 0 {
+		return nil, fmt.Errorf("host certificate needs at least one hostname")
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	cert := &ssh.Certificate{
+		Key:             req.PublicKey,
+		Serial:          serial,
+		CertType:        ssh.HostCert,
+		KeyId:           keyID(req.KeyID, req.KeyIDFormat, req.KeyIDFields, serial),
+		ValidPrincipals: req.Hostnames,
+		ValidAfter:      uint64(now.Unix()),
+		ValidBefore:     uint64(now.Add(req.Validity).Unix()),
+	}
+
+	if err := cert.SignCert(rand.Reader, ca.signer); err != nil {
+		return nil, fmt.Errorf("failed to sign certificate: %w", err)
+	}
+
+	return cert, nil
+}
+
+// HostRenewalRequest asks for a fresh host cert, proving possession of the host key with its current cert
+type HostRenewalRequest struct {
+	Certificate string `json:"certificate"` // Current host cert, authorized_keys format
+	Timestamp   int64  `json:"timestamp"`   // Unix seconds
+	Signature   string `json:"signature"`   // base64 SSH wire-format signature by the host key
+}
+
+// NewHostRenewalRequest builds a signed renewal request for cert using the host's private key
+func NewHostRenewalRequest(cert *ssh.Certificate, signer ssh.Signer) (*HostRenewalRequest, error) {
+	if string(signer.PublicKey().Marshal()) != string(cert.Key.Marshal()) {
+		return nil, fmt.Errorf("signer does not match certificate key")
+	}
+
+	ts := time.Now().Unix()
+	sig, err := signer.Sign(rand.Reader, hostRenewalMessage(cert.Serial, ts))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign renewal request: %w", err)
+	}
+
+	return &HostRenewalRequest{
+		Certificate: string(MarshalCertificate(cert)),
+		Timestamp:   ts,
+		Signature:   base64.StdEncoding.EncodeToString(ssh.Marshal(sig)),
+	}, nil
+}
+
+// VerifyHostRenewal checks that req carries an unexpired host cert from this CA, signed by the cert's key
+// Returns the current cert; the caller decides whether its hostnames are still registered
+func (ca *CertificateAuthority) VerifyHostRenewal(req *HostRenewalRequest, now time.Time) (*ssh.Certificate, error) {
+	cert, err := ParseCertificate([]byte(req.Certificate))
+	if err != nil {
+		return nil, err
+	}
+
+	if cert.CertType != ssh.HostCert {
+		return nil, fmt.Errorf("not a host certificate")
+	}
+	if !ca.IsAuthorityFor(cert) {
+		return nil, fmt.Errorf("certificate was not issued by this CA")
+	}
+	if len(cert.ValidPrincipals) == 0 {
+		return nil, fmt.Errorf("certificate has no hostnames")
+	}
+
+	// Checks the CA signature and the validity window, not just who claims to have signed it
+	checker := &ssh.CertChecker{Clock: func() time.Time { return now }}
+	if err := checker.CheckCert(cert.ValidPrincipals[0], cert); err != nil {
+		return nil, err
+	}
+
+	skew := now.Sub(time.Unix(req.Timestamp, 0))
+	if skew > revocationMaxSkew || skew < -revocationMaxSkew {
+		return nil, fmt.Errorf("renewal request timestamp out of range")
+	}
+
+	sigBytes, err := base64.StdEncoding.DecodeString(req.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature encoding: %w", err)
+	}
+
+	var sig ssh.Signature
+	if err := ssh.Unmarshal(sigBytes, &sig); err != nil {
+		return nil, fmt.Errorf("invalid signature: %w", err)
+	}
+
+	if err := cert.Key.Verify(hostRenewalMessage(cert.Serial, req.Timestamp), &sig); err != nil {
+		return nil, fmt.Errorf("signature verification failed: %w", err)
+	}
+
+	return cert, nil
+}
+
+func hostRenewalMessage(serial uint64, ts int64) []byte {
+	return []byte(fmt.Sprintf("cassh-host-renew:%d:%d", serial, ts))
+}
@@ -0,0 +1,149 @@
+package ca
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSignHost(t *testing.T) {
+	certAuthority := newTestCA(t)
+	hostPub, _ := generateTestUserKey(t)
+
+	cert, err := certAuthority.SignHost(&HostCertRequest{
+		PublicKey: hostPub,
+		KeyID:     "cassh:host:web-1",
+		Hostnames: []string{"web-1.corp.example.com", "web-1"},
+		Validity:  24 * time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("SignHost() error = %v", err)
+	}
+
+	if cert.CertType != ssh.HostCert {
+		t.Errorf("CertType = %d, want HostCert", cert.CertType)
+	}
+	if len(cert.ValidPrincipals) != 2 || cert.ValidPrincipals[0] != "web-1.corp.example.com" {
+		t.Errorf("ValidPrincipals = %v, want hostnames", cert.ValidPrincipals)
+	}
+	if len(cert.Extensions) != 0 || len(cert.CriticalOptions) != 0 {
+		t.Errorf("Permissions = %+v, want none on a host cert", cert.Permissions)
+	}
+	if got := cert.ValidBefore - cert.ValidAfter; got != uint64((24 * time.Hour).Seconds()) {
+		t.Errorf("validity = %ds, want 24h", got)
+	}
+
+	// A client trusting the CA with @cert-authority accepts the host key without a TOFU prompt
+	checker := &ssh.CertChecker{
+		IsHostAuthority: func(auth ssh.PublicKey, address string) bool {
+			return string(auth.Marshal()) == string(certAuthority.PublicKey().Marshal())
+		},
+	}
+	if err := checker.CheckHostKey("web-1:22", nil, cert); err != nil {
+		t.Errorf("CheckHostKey() error = %v", err)
+	}
+
+	if _, err := certAuthority.SignHost(&HostCertRequest{PublicKey: hostPub, Validity: time.Hour}); err == nil {
+		t.Error("expected error for host cert without hostnames")
+	}
+}
+
+func TestHostRenewalRequest(t *testing.T) {
+	certAuthority := newTestCA(t)
+	hostPub, hostPriv := generateTestUserKey(t)
+
+	cert, err := certAuthority.SignHost(&HostCertRequest{
+		PublicKey: hostPub,
+		KeyID:     "cassh:host:web-1",
+		Hostnames: []string{"web-1.corp.example.com"},
+		Validity:  24 * time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("SignHost() error = %v", err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+
+	req, err := NewHostRenewalRequest(cert, signer)
+	if err != nil {
+		t.Fatalf("NewHostRenewalRequest() error = %v", err)
+	}
+
+	got, err := certAuthority.VerifyHostRenewal(req, time.Now())
+	if err != nil {
+		t.Fatalf("VerifyHostRenewal() error = %v", err)
+	}
+	if got.Serial != cert.Serial {
+		t.Errorf("Serial = %d, want %d", got.Serial, cert.Serial)
+	}
+
+	t.Run("Stale timestamp", func(t *testing.T) {
+		if _, err := certAuthority.VerifyHostRenewal(req, time.Now().Add(time.Hour)); err == nil {
+			t.Error("expected error for stale request")
+		}
+	})
+
+	t.Run("Expired cert", func(t *testing.T) {
+		// Fresh proof of possession, but the cert itself ran out
+		later := time.Now().Add(48 * time.Hour)
+		sig, err := signer.Sign(rand.Reader, hostRenewalMessage(cert.Serial, later.Unix()))
+		if err != nil {
+			t.Fatalf("Sign() error = %v", err)
+		}
+		expired := &HostRenewalRequest{
+			Certificate: req.Certificate,
+			Timestamp:   later.Unix(),
+			Signature:   base64.StdEncoding.EncodeToString(ssh.Marshal(sig)),
+		}
+		if _, err := certAuthority.VerifyHostRenewal(expired, later); err == nil {
+			t.Error("expected error for expired host cert")
+		}
+	})
+
+	t.Run("User cert", func(t *testing.T) {
+		userCert, err := certAuthority.SignPublicKey(hostPub, "user", "testuser")
+		if err != nil {
+			t.Fatalf("SignPublicKey() error = %v", err)
+		}
+		userReq, err := NewHostRenewalRequest(userCert, signer)
+		if err != nil {
+			t.Fatalf("NewHostRenewalRequest() error = %v", err)
+		}
+		if _, err := certAuthority.VerifyHostRenewal(userReq, time.Now()); err == nil {
+			t.Error("expected error for a user cert")
+		}
+	})
+
+	t.Run("Forged CA signature", func(t *testing.T) {
+		forged := *cert
+		forged.ValidPrincipals = []string{"db-1.corp.example.com"}
+		tampered := *req
+		tampered.Certificate = string(MarshalCertificate(&forged))
+		if _, err := certAuthority.VerifyHostRenewal(&tampered, time.Now()); err == nil {
+			t.Error("expected error when the cert was altered after signing")
+		}
+	})
+
+	t.Run("Different CA", func(t *testing.T) {
+		if _, err := newTestCA(t).VerifyHostRenewal(req, time.Now()); err == nil {
+			t.Error("expected error for cert issued by another CA")
+		}
+	})
+
+	t.Run("Wrong signer", func(t *testing.T) {
+		_, otherPriv := generateTestUserKey(t)
+		otherSigner, err := ssh.NewSignerFromKey(otherPriv)
+		if err != nil {
+			t.Fatalf("Failed to create signer: %v", err)
+		}
+		if _, err := NewHostRenewalRequest(cert, otherSigner); err == nil {
+			t.Error("expected error when signer doesn't match cert key")
+		}
+	})
+}
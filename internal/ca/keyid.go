@@ -0,0 +1,188 @@
+package ca
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// DefaultKeyIDFormat is the key ID of user certs unless the server sets key_id_format
+const DefaultKeyIDFormat = "cassh:{email}:{time}:ca={ca}"
+
+// DefaultWorkloadKeyIDFormat is the key ID of workload certs unless the server sets workload.key_id_format
+const DefaultWorkloadKeyIDFormat = "cassh:workload:{issuer}:{sub}:{time}:ca={ca}"
+
+// DefaultHostKeyIDFormat is the key ID of host certs unless the server sets hosts.key_id_format
+const DefaultHostKeyIDFormat = "cassh:host:{host}:{time}:ca={ca}"
+
+// KeyIDFieldNames are the {fields} a key ID format may use
+var KeyIDFieldNames = []string{
+	"sub", "email", "name", "username", "principal", // Identity, from OIDC claims
+	"device", "client_version", // Reported by the client
+	"issuer", "host", // Workload issuer and machine name, for workload and host certs
+	"ip", "template", "target", "ca", "serial", "time", // Request and cert
+}
+
+// KeyIDFields are the values filled into a key ID format, by field name
+type KeyIDFields map[string]string
+
+// KeyIDFormat is a parsed key ID template such as "{sub}/{email}/{device}/{serial}"
+// Values are %XX-escaped where they'd clash with the template's separators, so key IDs parse back unambiguously
+type KeyIDFormat struct {
+	format   string
+	literals []string // Text around the fields; len(fields)+1
+	fields   []string
+	reserved string // Characters escaped in values: the separators' punctuation
+}
+
+// ParseKeyIDFormat parses a key ID template
+// Every field must be known, appear once and be followed by text with some punctuation (or end the template)
+func ParseKeyIDFormat(format string) (*KeyIDFormat, error) {
+	f := &KeyIDFormat{format: format}
+	rest := format
+	for {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("key ID format %q: unclosed {", format)
+		}
+		literal, field := rest[:start], rest[start+1:start+end]
+		if len(f.fields) > 0 && literal == "" {
+			return nil, fmt.Errorf("key ID format %q: {%s} must be separated from the field before it", format, field)
+		}
+		if !knownKeyIDField(field) {
+			return nil, fmt.Errorf("key ID format %q: unknown field {%s} (use %s)", format, field, strings.Join(KeyIDFieldNames, ", "))
+		}
+		for _, seen := range f.fields {
+			if seen == field {
+				return nil, fmt.Errorf("key ID format %q: {%s} appears twice", format, field)
+			}
+		}
+		f.literals = append(f.literals, literal)
+		f.fields = append(f.fields, field)
+		rest = rest[start+end+1:]
+	}
+	if strings.IndexByte(rest, '}') >= 0 {
+		return nil, fmt.Errorf("key ID format %q: } without {", format)
+	}
+	if len(f.fields) == 0 {
+		return nil, fmt.Errorf("key ID format %q has no {fields}", format)
+	}
+	f.literals = append(f.literals, rest)
+
+	// Values escape the separators' punctuation, so a separator can't appear inside a value
+	f.reserved = "%"
+	for i, literal := range f.literals {
+		separators := strings.Map(func(r rune) rune {
+			if isAlphanumeric(r) {
+				return -1
+			}
+			return r
+		}, literal)
+		if i > 0 && literal != "" && separators == "" {
+			return nil, fmt.Errorf("key ID format %q: {%s} must be followed by a separator such as / or :", format, f.fields[i-1])
+		}
+		f.reserved += separators
+	}
+	return f, nil
+}
+
+func isAlphanumeric(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9'
+}
+
+func knownKeyIDField(name string) bool {
+	for _, known := range KeyIDFieldNames {
+		if name == known {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *KeyIDFormat) String() string {
+	return f.format
+}
+
+// Format builds a key ID from fields; missing fields are left empty
+func (f *KeyIDFormat) Format(fields KeyIDFields) string {
+	var b strings.Builder
+	for i, field := range f.fields {
+		b.WriteString(f.literals[i])
+		f.escape(&b, fields[field])
+	}
+	b.WriteString(f.literals[len(f.fields)])
+	return b.String()
+}
+
+// keyID returns keyID, or format filled in with fields and serial if there is a format
+func keyID(keyID string, format *KeyIDFormat, fields KeyIDFields, serial uint64) string {
+	if format == nil {
+		return keyID
+	}
+	all := KeyIDFields{"serial": strconv.FormatUint(serial, 10)}
+	for name, value := range fields {
+		if name != "serial" {
+			all[name] = value
+		}
+	}
+	return format.Format(all)
+}
+
+// escape writes value with separator punctuation, %, spaces and control characters %XX-escaped
+func (f *KeyIDFormat) escape(b *strings.Builder, value string) {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(f.reserved, c) >= 0 {
+			fmt.Fprintf(b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+}
+
+// Parse decodes a key ID built by Format back into its fields
+func (f *KeyIDFormat) Parse(keyID string) (KeyIDFields, error) {
+	rest, ok := strings.CutPrefix(keyID, f.literals[0])
+	if !ok {
+		return nil, fmt.Errorf("key ID %q does not match format %q", keyID, f.format)
+	}
+
+	fields := KeyIDFields{}
+	for i, field := range f.fields {
+		value := rest
+		rest = ""
+		if next := f.literals[i+1]; next != "" {
+			end := strings.Index(value, next)
+			if end < 0 {
+				return nil, fmt.Errorf("key ID %q does not match format %q", keyID, f.format)
+			}
+			value, rest = value[:end], value[end+len(next):]
+		}
+		unescaped, err := url.PathUnescape(value)
+		if err != nil {
+			return nil, fmt.Errorf("key ID %q: invalid {%s}: %w", keyID, field, err)
+		}
+		fields[field] = unescaped
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("key ID %q does not match format %q", keyID, f.format)
+	}
+	return fields, nil
+}
+
+// ParseKeyID decodes a key ID issued with format (DefaultKeyIDFormat if empty) into its fields
+func ParseKeyID(format, keyID string) (KeyIDFields, error) {
+	if format == "" {
+		format = DefaultKeyIDFormat
+	}
+	f, err := ParseKeyIDFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	return f.Parse(keyID)
+}
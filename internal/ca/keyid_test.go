@@ -0,0 +1,165 @@
+package ca
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseKeyIDFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		wantErr bool
+	}{
+		{name: "default", format: DefaultKeyIDFormat},
+		{name: "path style", format: "{sub}/{email}/{device}/{serial}/{client_version}"},
+		{name: "no fields", format: "cassh", wantErr: true},
+		{name: "unknown field", format: "{sub}/{department}", wantErr: true},
+		{name: "adjacent fields", format: "{sub}{email}", wantErr: true},
+		{name: "letters only between fields", format: "{sub}and{email}", wantErr: true},
+		{name: "repeated field", format: "{sub}/{sub}", wantErr: true},
+		{name: "unclosed", format: "{sub}/{email", wantErr: true},
+		{name: "stray close", format: "{sub}/email}", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseKeyIDFormat(tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseKeyIDFormat(%q) error = %v, wantErr %v", tt.format, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestKeyIDRoundTrip(t *testing.T) {
+	format, err := ParseKeyIDFormat("{sub}/{email}/{device}/{serial}/{client_version}")
+	if err != nil {
+		t.Fatalf("ParseKeyIDFormat() error = %v", err)
+	}
+
+	fields := KeyIDFields{
+		"sub":            "00u1/abc%",
+		"email":          "alice@example.com",
+		"device":         "Alice's MacBook Pro",
+		"serial":         "42",
+		"client_version": "cassh-cli/1.2.0",
+	}
+	keyID := format.Format(fields)
+	if want := "00u1%2Fabc%25/alice@example.com/Alice's%20MacBook%20Pro/42/cassh-cli%2F1.2.0"; keyID != want {
+		t.Errorf("Format() = %q, want %q", keyID, want)
+	}
+
+	got, err := format.Parse(keyID)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, fields) {
+		t.Errorf("Parse() = %v, want %v", got, fields)
+	}
+
+	if _, err := format.Parse("cassh:alice@example.com:1700000000:ca=default"); err == nil {
+		t.Error("Parse() of a key ID in another format should fail")
+	}
+}
+
+func TestKeyIDDefaultFormat(t *testing.T) {
+	format, err := ParseKeyIDFormat(DefaultKeyIDFormat)
+	if err != nil {
+		t.Fatalf("ParseKeyIDFormat() error = %v", err)
+	}
+	// Letters of the template text (cassh, ca) aren't escaped in values
+	keyID := format.Format(KeyIDFields{"email": "alice@localhost", "time": "1700000000", "ca": "default"})
+	if want := "cassh:alice@localhost:1700000000:ca=default"; keyID != want {
+		t.Errorf("Format() = %q, want %q", keyID, want)
+	}
+}
+
+func TestParseKeyIDDefault(t *testing.T) {
+	// Key IDs issued before formats were configurable still parse
+	fields, err := ParseKeyID("", "cassh:alice@example.com:1700000000:ca=default")
+	if err != nil {
+		t.Fatalf("ParseKeyID() error = %v", err)
+	}
+	want := KeyIDFields{"email": "alice@example.com", "time": "1700000000", "ca": "default"}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("ParseKeyID() = %v, want %v", fields, want)
+	}
+}
+
+func TestSignKeyIDFormat(t *testing.T) {
+	ca, err := NewCA(generateTestCAKey(t), 12, nil)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	format, err := ParseKeyIDFormat("{email}/{serial}")
+	if err != nil {
+		t.Fatalf("ParseKeyIDFormat() error = %v", err)
+	}
+
+	userPub, _ := generateTestUserKey(t)
+	cert, err := ca.Sign(&CertRequest{
+		PublicKey:      userPub,
+		KeyID:          "ignored",
+		KeyIDFormat:    format,
+		KeyIDFields:    KeyIDFields{"email": "alice@example.com", "serial": "spoofed"},
+		GitHubUsername: "alice",
+	}, nil)
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	if want := "alice@example.com/" + strconv.FormatUint(cert.Serial, 10); cert.KeyId != want {
+		t.Errorf("KeyId = %q, want %q", cert.KeyId, want)
+	}
+}
+
+func TestSignHostKeyIDFormat(t *testing.T) {
+	format, err := ParseKeyIDFormat(DefaultHostKeyIDFormat)
+	if err != nil {
+		t.Fatalf("ParseKeyIDFormat() error = %v", err)
+	}
+
+	hostPub, _ := generateTestUserKey(t)
+	cert, err := newTestCA(t).SignHost(&HostCertRequest{
+		PublicKey:   hostPub,
+		KeyIDFormat: format,
+		KeyIDFields: KeyIDFields{"host": "web-1", "time": "1700000000", "ca": "hosts"},
+		Hostnames:   []string{"web-1.corp.example.com"},
+		Validity:    time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("SignHost() error = %v", err)
+	}
+
+	if want := "cassh:host:web-1:1700000000:ca=hosts"; cert.KeyId != want {
+		t.Errorf("KeyId = %q, want %q", cert.KeyId, want)
+	}
+	fields, err := ParseKeyID(DefaultHostKeyIDFormat, cert.KeyId)
+	if err != nil || fields["host"] != "web-1" || fields["ca"] != "hosts" {
+		t.Errorf("ParseKeyID() = %v, %v, want the host and CA back", fields, err)
+	}
+}
+
+func TestWorkloadKeyIDFormat(t *testing.T) {
+	format, err := ParseKeyIDFormat(DefaultWorkloadKeyIDFormat)
+	if err != nil {
+		t.Fatalf("ParseKeyIDFormat() error = %v", err)
+	}
+
+	// Token subjects are full of the template's colons; they're escaped so the key ID still parses
+	want := KeyIDFields{"issuer": "github-actions", "sub": "repo:corp/app:ref:refs/heads/main", "time": "1700000000", "ca": "default"}
+	keyID := format.Format(want)
+	if keyID != "cassh:workload:github-actions:repo%3Acorp/app%3Aref%3Arefs/heads/main:1700000000:ca=default" {
+		t.Errorf("Format() = %q", keyID)
+	}
+	fields, err := ParseKeyID(DefaultWorkloadKeyIDFormat, keyID)
+	if err != nil {
+		t.Fatalf("ParseKeyID() error = %v", err)
+	}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("ParseKeyID() = %v, want %v", fields, want)
+	}
+}
@@ -0,0 +1,141 @@
+package ca
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// KRL format constants
+// See: https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.krl
+const (
+	krlMagic         = "SSHKRL\n\x00"
+	krlFormatVersion = 1
+
+	krlSectionCertificates = 1
+	krlSectionExplicitKey  = 2
+
+	krlSectionCertSerialList = 0x20
+	krlSectionCertKeyID      = 0x23
+)
+
+// KRL is an OpenSSH Key Revocation List
+// The marshaled form can be used directly with sshd's RevokedKeys option
+type KRL struct {
+	Version     uint64
+	GeneratedAt time.Time
+	Comment     string
+
+	// CAKey is the CA that Serials and KeyIDs are scoped to
+	// OtherCAKeys get the same entries, so revocations also cover certs from rotated keys
+	CAKey       ssh.PublicKey
+	OtherCAKeys []ssh.PublicKey
+	Serials     []uint64
+	KeyIDs      []string
+
+	// Keys are revoked outright, including any certificate issued for them
+	Keys []ssh.PublicKey
+}
+
+// Marshal encodes the KRL in OpenSSH binary format
+func (k *KRL) Marshal() []byte {
+	var buf bytes.Buffer
+
+	buf.WriteString(krlMagic)
+	writeUint32(&buf, krlFormatVersion)
+	writeUint64(&buf, k.Version)
+	writeUint64(&buf, uint64(k.GeneratedAt.Unix()))
+	writeUint64(&buf, 0) // flags
+	writeString(&buf, nil)
+	writeString(&buf, []byte(k.Comment))
+
+	if k.CAKey != nil && (len(k.Serials) > 0 || len(k.KeyIDs) > 0) {
+		for _, caKey := range append([]ssh.PublicKey{k.CAKey}, k.OtherCAKeys...) {
+			k.marshalCertSection(&buf, caKey)
+		}
+	}
+
+	if len(k.Keys) > 0 {
+		blobs := make([][]byte, 0, len(k.Keys))
+		for _, key := range k.Keys {
+			blobs = append(blobs, key.Marshal())
+		}
+		sort.Slice(blobs, func(i, j int) bool { return bytes.Compare(blobs[i], blobs[j]) < 0 })
+
+		var section bytes.Buffer
+		for _, blob := range blobs {
+			writeString(&section, blob)
+		}
+		buf.WriteByte(krlSectionExplicitKey)
+		writeString(&buf, section.Bytes())
+	}
+
+	return buf.Bytes()
+}
+
+// marshalCertSection writes a certificate section revoking Serials and KeyIDs issued by caKey
+func (k *KRL) marshalCertSection(buf *bytes.Buffer, caKey ssh.PublicKey) {
+	var section bytes.Buffer
+	writeString(&section, caKey.Marshal())
+	writeString(&section, nil)
+
+	if serials := sortedSerials(k.Serials); len(serials) > 0 {
+		var list bytes.Buffer
+		for _, serial := range serials {
+			writeUint64(&list, serial)
+		}
+		section.WriteByte(krlSectionCertSerialList)
+		writeString(&section, list.Bytes())
+	}
+
+	if len(k.KeyIDs) > 0 {
+		keyIDs := append([]string(nil), k.KeyIDs...)
+		sort.Strings(keyIDs)
+
+		var list bytes.Buffer
+		for _, keyID := range keyIDs {
+			writeString(&list, []byte(keyID))
+		}
+		section.WriteByte(krlSectionCertKeyID)
+		writeString(&section, list.Bytes())
+	}
+
+	buf.WriteByte(krlSectionCertificates)
+	writeString(buf, section.Bytes())
+}
+
+// sortedSerials returns unique, non-zero serials in ascending order
+// Serial 0 is reserved by OpenSSH and rejected in KRLs
+func sortedSerials(serials []uint64) []uint64 {
+	out := make([]uint64, 0, len(serials))
+	seen := make(map[uint64]bool, len(serials))
+	for _, serial := range serials {
+		if serial == 0 || seen[serial] {
+			continue
+		}
+		seen[serial] = true
+		out = append(out, serial)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+func writeUint32(buf *bytes.Buffer, v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	buf.Write(b[:])
+}
+
+func writeUint64(buf *bytes.Buffer, v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	buf.Write(b[:])
+}
+
+func writeString(buf *bytes.Buffer, s []byte) {
+	writeUint32(buf, uint32(len(s)))
+	buf.Write(s)
+}
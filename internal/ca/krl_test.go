@@ -0,0 +1,187 @@
+package ca
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestKRLMarshalHeader(t *testing.T) {
+	krl := &KRL{
+		Version:     42,
+		GeneratedAt: time.Unix(1700000000, 0),
+		Comment:     "test",
+	}
+
+	data := krl.Marshal()
+
+	if !bytes.HasPrefix(data, []byte("SSHKRL\n\x00")) {
+		t.Fatalf("KRL missing magic header: %q", data[:8])
+	}
+	if v := binary.BigEndian.Uint32(data[8:12]); v != krlFormatVersion {
+		t.Errorf("format version = %d, want %d", v, krlFormatVersion)
+	}
+	if v := binary.BigEndian.Uint64(data[12:20]); v != 42 {
+		t.Errorf("krl version = %d, want 42", v)
+	}
+	if v := binary.BigEndian.Uint64(data[20:28]); v != 1700000000 {
+		t.Errorf("generated date = %d, want 1700000000", v)
+	}
+}
+
+func TestKRLMarshalSections(t *testing.T) {
+	caKey := generateTestCAKey(t)
+	certAuthority, err := NewCA(caKey, 12, nil)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	userPub, _ := generateTestUserKey(t)
+
+	empty := (&KRL{}).Marshal()
+
+	krl := &KRL{
+		CAKey:   certAuthority.PublicKey(),
+		Serials: []uint64{3, 1, 3, 0},
+		KeyIDs:  []string{"cassh:bob", "cassh:alice"},
+		Keys:    []ssh.PublicKey{userPub},
+	}
+	data := krl.Marshal()
+
+	if len(data) <= len(empty) {
+		t.Fatal("KRL with revocations should be larger than an empty KRL")
+	}
+
+	sections := data[len(empty):]
+	if sections[0] != krlSectionCertificates {
+		t.Errorf("first section type = %d, want %d", sections[0], krlSectionCertificates)
+	}
+	if !bytes.Contains(sections, certAuthority.PublicKey().Marshal()) {
+		t.Error("certificate section should contain the CA key")
+	}
+	if !bytes.Contains(sections, userPub.Marshal()) {
+		t.Error("KRL should contain the explicitly revoked key")
+	}
+	for _, keyID := range krl.KeyIDs {
+		if !bytes.Contains(sections, []byte(keyID)) {
+			t.Errorf("KRL should contain key ID %q", keyID)
+		}
+	}
+}
+
+func TestKRLMarshalRotatedCAKeys(t *testing.T) {
+	certAuthority, err := NewCA(generateTestCAKey(t), 12, nil)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	retired, err := NewCA(generateTestCAKey(t), 12, nil)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+
+	single := (&KRL{CAKey: certAuthority.PublicKey(), Serials: []uint64{7}}).Marshal()
+	data := (&KRL{
+		CAKey:       certAuthority.PublicKey(),
+		OtherCAKeys: []ssh.PublicKey{retired.PublicKey()},
+		Serials:     []uint64{7},
+	}).Marshal()
+
+	// One certificate section per CA key, each revoking the same serials
+	if !bytes.Contains(data, retired.PublicKey().Marshal()) {
+		t.Error("KRL should have a certificate section for the retired CA key")
+	}
+	serialList := []byte{krlSectionCertSerialList, 0, 0, 0, 8, 0, 0, 0, 0, 0, 0, 0, 7}
+	if got := bytes.Count(single, serialList); got != 1 {
+		t.Fatalf("single-CA KRL has %d serial lists, want 1", got)
+	}
+	if got := bytes.Count(data, serialList); got != 2 {
+		t.Errorf("KRL has %d serial lists, want one per CA key", got)
+	}
+}
+
+func TestSortedSerials(t *testing.T) {
+	got := sortedSerials([]uint64{5, 0, 2, 5, 9, 2})
+	want := []uint64{2, 5, 9}
+
+	if len(got) != len(want) {
+		t.Fatalf("sortedSerials() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("sortedSerials()[%d] = %d, want %d", i, got[i], want[i])
+		}
+	}
+}
+
+func TestRevocationRequest(t *testing.T) {
+	caKey := generateTestCAKey(t)
+	certAuthority, err := NewCA(caKey, 12, nil)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+
+	userPub, userPriv := generateTestUserKey(t)
+	cert, err := certAuthority.SignPublicKey(userPub, "test-key-id", "testuser")
+	if err != nil {
+		t.Fatalf("SignPublicKey() error = %v", err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(userPriv)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+
+	req, err := NewRevocationRequest(cert, signer)
+	if err != nil {
+		t.Fatalf("NewRevocationRequest() error = %v", err)
+	}
+
+	got, err := certAuthority.VerifyRevocationRequest(req, time.Now())
+	if err != nil {
+		t.Fatalf("VerifyRevocationRequest() error = %v", err)
+	}
+	if got.Serial != cert.Serial {
+		t.Errorf("Serial = %d, want %d", got.Serial, cert.Serial)
+	}
+
+	t.Run("Stale timestamp", func(t *testing.T) {
+		if _, err := certAuthority.VerifyRevocationRequest(req, time.Now().Add(time.Hour)); err == nil {
+			t.Error("expected error for stale request")
+		}
+	})
+
+	t.Run("Tampered serial", func(t *testing.T) {
+		other, err := certAuthority.SignPublicKey(userPub, "other-key-id", "testuser")
+		if err != nil {
+			t.Fatalf("SignPublicKey() error = %v", err)
+		}
+		tampered := *req
+		tampered.Certificate = string(MarshalCertificate(other))
+		if _, err := certAuthority.VerifyRevocationRequest(&tampered, time.Now()); err == nil {
+			t.Error("expected error when signature doesn't cover the cert serial")
+		}
+	})
+
+	t.Run("Different CA", func(t *testing.T) {
+		otherCA, err := NewCA(generateTestCAKey(t), 12, nil)
+		if err != nil {
+			t.Fatalf("Failed to create CA: %v", err)
+		}
+		if _, err := otherCA.VerifyRevocationRequest(req, time.Now()); err == nil {
+			t.Error("expected error for cert issued by another CA")
+		}
+	})
+
+	t.Run("Wrong signer", func(t *testing.T) {
+		_, otherPriv := generateTestUserKey(t)
+		otherSigner, err := ssh.NewSignerFromKey(otherPriv)
+		if err != nil {
+			t.Fatalf("Failed to create signer: %v", err)
+		}
+		if _, err := NewRevocationRequest(cert, otherSigner); err == nil {
+			t.Error("expected error when signer doesn't match cert key")
+		}
+	})
+}
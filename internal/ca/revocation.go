@@ -0,0 +1,78 @@
+package ca
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// revocationMaxSkew bounds how old (or far in the future) a signed revocation request may be
+const revocationMaxSkew = 5 * time.Minute
+
+// RevocationRequest is a self-service request to revoke a certificate
+// It is signed with the certificate's own private key, proving possession without any other credential
+type RevocationRequest struct {
+	Certificate string `json:"certificate"` // authorized_keys format
+	Timestamp   int64  `json:"timestamp"`   // Unix seconds
+	Signature   string `json:"signature"`   // base64 SSH wire-format signature
+}
+
+// NewRevocationRequest builds a signed revocation request for cert using the cert's private key
+func NewRevocationRequest(cert *ssh.Certificate, signer ssh.Signer) (*RevocationRequest, error) {
+	if string(signer.PublicKey().Marshal()) != string(cert.Key.Marshal()) {
+		return nil, fmt.Errorf("signer does not match certificate key")
+	}
+
+	ts := time.Now().Unix()
+	sig, err := signer.Sign(rand.Reader, revocationMessage(cert.Serial, ts))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign revocation request: %w", err)
+	}
+
+	return &RevocationRequest{
+		Certificate: string(MarshalCertificate(cert)),
+		Timestamp:   ts,
+		Signature:   base64.StdEncoding.EncodeToString(ssh.Marshal(sig)),
+	}, nil
+}
+
+// VerifyRevocationRequest checks that req was issued by this CA and signed by the cert's key
+// Returns the certificate to revoke
+func (ca *CertificateAuthority) VerifyRevocationRequest(req *RevocationRequest, now time.Time) (*ssh.Certificate, error) {
+	cert, err := ParseCertificate([]byte(req.Certificate))
+	if err != nil {
+		return nil, err
+	}
+
+	if !ca.IsAuthorityFor(cert) {
+		return nil, fmt.Errorf("certificate was not issued by this CA")
+	}
+
+	skew := now.Sub(time.Unix(req.Timestamp, 0))
+	if skew > revocationMaxSkew || skew < -revocationMaxSkew {
+		return nil, fmt.Errorf("revocation request timestamp out of range")
+	}
+
+	sigBytes, err := base64.StdEncoding.DecodeString(req.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature encoding: %w", err)
+	}
+
+	var sig ssh.Signature
+	if err := ssh.Unmarshal(sigBytes, &sig); err != nil {
+		return nil, fmt.Errorf("invalid signature: %w", err)
+	}
+
+	if err := cert.Key.Verify(revocationMessage(cert.Serial, req.Timestamp), &sig); err != nil {
+		return nil, fmt.Errorf("signature verification failed: %w", err)
+	}
+
+	return cert, nil
+}
+
+func revocationMessage(serial uint64, ts int64) []byte {
+	return []byte(fmt.Sprintf("cassh-revoke:%d:%d", serial, ts))
+}
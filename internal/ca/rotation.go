@@ -0,0 +1,107 @@
+package ca
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// CA key states, in the order keys move through a rotation
+const (
+	KeyActive  = "active"  // Signs new certs
+	KeyNext    = "next"    // Trusted ahead of a cutover; doesn't sign yet
+	KeyRetired = "retired" // Still trusted until the certs it signed expire
+)
+
+// TrustedKey is a CA public key that hosts and GitHub should trust
+type TrustedKey struct {
+	PublicKey ssh.PublicKey
+	State     string
+}
+
+// AddTrustedKey trusts pubKey alongside the signing key, as the next or a retired CA key
+// The signing key itself is always trusted as the active key
+func (ca *CertificateAuthority) AddTrustedKey(pubKey ssh.PublicKey, state string) error {
+	if state != KeyNext && state != KeyRetired {
+		return fmt.Errorf("invalid CA key state %q (use %q or %q)", state, KeyNext, KeyRetired)
+	}
+	for _, key := range ca.TrustedKeys() {
+		if bytes.Equal(key.PublicKey.Marshal(), pubKey.Marshal()) {
+			return fmt.Errorf("CA key %s is already trusted as %s", ssh.FingerprintSHA256(pubKey), key.State)
+		}
+	}
+
+	ca.otherKeys = append(ca.otherKeys, TrustedKey{PublicKey: pubKey, State: state})
+	return nil
+}
+
+// TrustedKeys returns every CA key to trust: the active key, then next, then retired keys
+func (ca *CertificateAuthority) TrustedKeys() []TrustedKey {
+	keys := []TrustedKey{{PublicKey: ca.signer.PublicKey(), State: KeyActive}}
+	for _, state := range []string{KeyNext, KeyRetired} {
+		for _, key := range ca.otherKeys {
+			if key.State == state {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+// RotatedKeys returns the trusted keys other than the active one
+func (ca *CertificateAuthority) RotatedKeys() []ssh.PublicKey {
+	var keys []ssh.PublicKey
+	for _, key := range ca.TrustedKeys()[1:] {
+		keys = append(keys, key.PublicKey)
+	}
+	return keys
+}
+
+// Comment returns the comment written after the key in bundles (e.g., "cassh-ca-active")
+func (k TrustedKey) Comment() string {
+	return "cassh-ca-" + k.State
+}
+
+// FormatTrustedUserCAKeys renders keys for sshd's TrustedUserCAKeys file
+func FormatTrustedUserCAKeys(keys []TrustedKey) string {
+	var b strings.Builder
+	b.WriteString("# cassh CA keys for sshd TrustedUserCAKeys\n")
+	for _, key := range keys {
+		fmt.Fprintf(&b, "%s %s\n", authorizedKey(key.PublicKey), key.Comment())
+	}
+	return b.String()
+}
+
+// FormatCertAuthority renders keys as known_hosts @cert-authority lines for hostPattern
+// ("*" if empty), so clients trust host certs from any of them
+func FormatCertAuthority(keys []TrustedKey, hostPattern string) string {
+	if hostPattern == "" {
+		hostPattern = "*"
+	}
+
+	var b strings.Builder
+	b.WriteString("# cassh CA keys for known_hosts\n")
+	for _, key := range keys {
+		fmt.Fprintf(&b, "@cert-authority %s %s %s\n", hostPattern, authorizedKey(key.PublicKey), key.Comment())
+	}
+	return b.String()
+}
+
+// authorizedKey returns the key in authorized_keys format without a trailing newline
+func authorizedKey(pubKey ssh.PublicKey) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pubKey)))
+}
+
+// CheckSigningKey returns an error unless cert was signed by a CA key with one of the given
+// SHA256 fingerprints (as printed by ssh-keygen -l)
+func CheckSigningKey(cert *ssh.Certificate, fingerprints []string) error {
+	got := ssh.FingerprintSHA256(cert.SignatureKey)
+	for _, fp := range fingerprints {
+		if fp == got {
+			return nil
+		}
+	}
+	return fmt.Errorf("certificate was signed by untrusted CA key %s", got)
+}
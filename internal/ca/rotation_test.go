@@ -0,0 +1,192 @@
+package ca
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestCA(t *testing.T) *CertificateAuthority {
+	t.Helper()
+	certAuthority, err := NewCA(generateTestCAKey(t), 12, nil)
+	if err != nil {
+		t.Fatalf("NewCA() error = %v", err)
+	}
+	return certAuthority
+}
+
+func TestTrustedKeys(t *testing.T) {
+	certAuthority := newTestCA(t)
+	retired := newTestCA(t).PublicKey()
+	next := newTestCA(t).PublicKey()
+
+	// Added out of order; TrustedKeys lists active, next, retired
+	if err := certAuthority.AddTrustedKey(retired, KeyRetired); err != nil {
+		t.Fatalf("AddTrustedKey(retired) error = %v", err)
+	}
+	if err := certAuthority.AddTrustedKey(next, KeyNext); err != nil {
+		t.Fatalf("AddTrustedKey(next) error = %v", err)
+	}
+
+	keys := certAuthority.TrustedKeys()
+	want := []struct {
+		key   ssh.PublicKey
+		state string
+	}{
+		{certAuthority.PublicKey(), KeyActive},
+		{next, KeyNext},
+		{retired, KeyRetired},
+	}
+	if len(keys) != len(want) {
+		t.Fatalf("TrustedKeys() = %d keys, want %d", len(keys), len(want))
+	}
+	for i, w := range want {
+		if keys[i].State != w.state || ssh.FingerprintSHA256(keys[i].PublicKey) != ssh.FingerprintSHA256(w.key) {
+			t.Errorf("TrustedKeys()[%d] = %s %s, want %s", i, keys[i].State, ssh.FingerprintSHA256(keys[i].PublicKey), w.state)
+		}
+	}
+	if got := certAuthority.RotatedKeys(); len(got) != 2 {
+		t.Errorf("RotatedKeys() = %d keys, want 2", len(got))
+	}
+}
+
+func TestAddTrustedKeyRejects(t *testing.T) {
+	certAuthority := newTestCA(t)
+	other := newTestCA(t).PublicKey()
+
+	tests := []struct {
+		name  string
+		key   ssh.PublicKey
+		state string
+	}{
+		{name: "active key", key: certAuthority.PublicKey(), state: KeyRetired},
+		{name: "second active", key: other, state: KeyActive},
+		{name: "unknown state", key: other, state: "standby"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := certAuthority.AddTrustedKey(tt.key, tt.state); err == nil {
+				t.Error("AddTrustedKey() should fail")
+			}
+		})
+	}
+
+	if err := certAuthority.AddTrustedKey(other, KeyNext); err != nil {
+		t.Fatalf("AddTrustedKey() error = %v", err)
+	}
+	if err := certAuthority.AddTrustedKey(other, KeyRetired); err == nil {
+		t.Error("AddTrustedKey() should reject a key that's already trusted")
+	}
+}
+
+func TestIsAuthorityForRotatedKey(t *testing.T) {
+	userPub, _ := generateTestUserKey(t)
+
+	// A cert from the old CA stays valid after cutover while that key is retired
+	oldCA := newTestCA(t)
+	cert, err := oldCA.SignPublicKey(userPub, "cassh:alice", "alice")
+	if err != nil {
+		t.Fatalf("SignPublicKey() error = %v", err)
+	}
+
+	newCA := newTestCA(t)
+	if newCA.IsAuthorityFor(cert) {
+		t.Fatal("IsAuthorityFor() = true for a cert from an untrusted CA")
+	}
+	if err := newCA.AddTrustedKey(oldCA.PublicKey(), KeyRetired); err != nil {
+		t.Fatalf("AddTrustedKey() error = %v", err)
+	}
+	if !newCA.IsAuthorityFor(cert) {
+		t.Error("IsAuthorityFor() = false for a cert from a retired CA key")
+	}
+}
+
+func TestFormatBundles(t *testing.T) {
+	certAuthority := newTestCA(t)
+	next := newTestCA(t).PublicKey()
+	if err := certAuthority.AddTrustedKey(next, KeyNext); err != nil {
+		t.Fatalf("AddTrustedKey() error = %v", err)
+	}
+	keys := certAuthority.TrustedKeys()
+	activeLine := authorizedKey(certAuthority.PublicKey())
+	nextLine := authorizedKey(next)
+
+	tests := []struct {
+		name string
+		got  string
+		want []string
+	}{
+		{
+			name: "TrustedUserCAKeys",
+			got:  FormatTrustedUserCAKeys(keys),
+			want: []string{activeLine + " cassh-ca-active", nextLine + " cassh-ca-next"},
+		},
+		{
+			name: "cert-authority default pattern",
+			got:  FormatCertAuthority(keys, ""),
+			want: []string{"@cert-authority * " + activeLine + " cassh-ca-active", "@cert-authority * " + nextLine + " cassh-ca-next"},
+		},
+		{
+			name: "cert-authority host pattern",
+			got:  FormatCertAuthority(keys, "*.corp.example.com"),
+			want: []string{"@cert-authority *.corp.example.com " + activeLine + " cassh-ca-active"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, line := range tt.want {
+				if !strings.Contains(tt.got, line+"\n") {
+					t.Errorf("bundle missing line %q:\n%s", line, tt.got)
+				}
+			}
+
+			// Every non-comment line must parse as a key (what sshd and ssh will do with it)
+			for _, line := range strings.Split(strings.TrimSpace(tt.got), "\n") {
+				if strings.HasPrefix(line, "#") {
+					continue
+				}
+				if strings.HasPrefix(line, "@cert-authority") {
+					if _, _, _, _, _, err := ssh.ParseKnownHosts([]byte(line)); err != nil {
+						t.Errorf("ParseKnownHosts(%q) error = %v", line, err)
+					}
+				} else if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line)); err != nil {
+					t.Errorf("ParseAuthorizedKey(%q) error = %v", line, err)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckSigningKey(t *testing.T) {
+	certAuthority := newTestCA(t)
+	userPub, _ := generateTestUserKey(t)
+	cert, err := certAuthority.SignPublicKey(userPub, "cassh:alice", "alice")
+	if err != nil {
+		t.Fatalf("SignPublicKey() error = %v", err)
+	}
+	fingerprint := ssh.FingerprintSHA256(certAuthority.PublicKey())
+	other := ssh.FingerprintSHA256(newTestCA(t).PublicKey())
+
+	tests := []struct {
+		name         string
+		fingerprints []string
+		wantErr      bool
+	}{
+		{name: "trusted", fingerprints: []string{fingerprint}},
+		{name: "trusted mid-rotation", fingerprints: []string{other, fingerprint}},
+		{name: "untrusted", fingerprints: []string{other}, wantErr: true},
+		{name: "none", fingerprints: nil, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckSigningKey(cert, tt.fingerprints)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckSigningKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package ca
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// CA signer backends
+const (
+	SignerFile   = "file"   // PEM private key (in process memory)
+	SignerPKCS11 = "pkcs11" // Key on an HSM/token; needs a build with -tags pkcs11
+	SignerAgent  = "agent"  // Key held by an ssh-agent compatible signing service
+)
+
+// SignerConfig selects where the CA private key lives
+type SignerConfig struct {
+	Type string // file (default), pkcs11 or agent
+
+	// file: PEM key content (e.g., read from private_key_path)
+	PrivateKeyPEM []byte
+
+	// pkcs11
+	PKCS11 PKCS11Config
+
+	// agent: socket of an ssh-agent protocol server (e.g., a signing sidecar)
+	AgentSocket string
+
+	// agent: which key to use when the agent holds several
+	// An authorized_keys line or SHA256 fingerprint; empty requires exactly one key
+	PublicKey string
+}
+
+// PKCS11Config locates a CA key on a PKCS#11 token
+type PKCS11Config struct {
+	Module     string `toml:"module"`      // Path to the PKCS#11 library (e.g., /usr/lib/softhsm/libsofthsm2.so)
+	TokenLabel string `toml:"token_label"` // Token holding the key
+	KeyLabel   string `toml:"key_label"`   // CKA_LABEL of the private key (and its public key)
+	PIN        string `toml:"pin"`         // User PIN; prefer CASSH_CA_PKCS11_PIN
+}
+
+// NewSigner opens the configured CA signer
+// The returned closer releases HSM sessions; it's a no-op for other backends
+func NewSigner(cfg SignerConfig) (ssh.Signer, io.Closer, error) {
+	switch cfg.Type {
+	case SignerFile, "":
+		if len(cfg.PrivateKeyPEM) == 0 {
+			return nil, nil, fmt.Errorf("CA private key is required for the file signer")
+		}
+		signer, err := ssh.ParsePrivateKey(cfg.PrivateKeyPEM)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse CA private key: %w", err)
+		}
+		return signer, nopCloser{}, nil
+	case SignerPKCS11:
+		return newPKCS11Signer(cfg.PKCS11)
+	case SignerAgent:
+		signer, err := NewAgentSigner(cfg.AgentSocket, cfg.PublicKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		return signer, nopCloser{}, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown CA signer %q (use %q, %q or %q)", cfg.Type, SignerFile, SignerPKCS11, SignerAgent)
+	}
+}
+
+// AgentSigner signs with a key held by an ssh-agent protocol server
+// Each signature opens a new connection, so the agent can restart without restarting cassh
+type AgentSigner struct {
+	socket string
+	pubKey ssh.PublicKey
+}
+
+// NewAgentSigner connects to the agent at socket and selects the CA key
+func NewAgentSigner(socket, publicKey string) (*AgentSigner, error) {
+	if socket == "" {
+		return nil, fmt.Errorf("agent signer requires a socket path")
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to signing agent: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	keys, err := agent.NewClient(conn).List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list signing agent keys: %w", err)
+	}
+
+	var matches []ssh.PublicKey
+	for _, key := range keys {
+		if publicKey == "" || matchesPublicKey(key, publicKey) {
+			matches = append(matches, key)
+		}
+	}
+	switch {
+	case len(matches) == 0 && publicKey != "":
+		return nil, fmt.Errorf("signing agent doesn't hold CA key %s", publicKey)
+	case len(matches) == 0:
+		return nil, fmt.Errorf("signing agent holds no keys")
+	case len(matches) > 1:
+		return nil, fmt.Errorf("signing agent holds %d keys; set the CA public key to pick one", len(matches))
+	}
+
+	pubKey, err := ssh.ParsePublicKey(matches[0].Marshal())
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing agent key: %w", err)
+	}
+	return &AgentSigner{socket: socket, pubKey: pubKey}, nil
+}
+
+// matchesPublicKey compares an agent key to an authorized_keys line or SHA256 fingerprint
+func matchesPublicKey(key *agent.Key, want string) bool {
+	want = strings.TrimSpace(want)
+	if strings.HasPrefix(want, "SHA256:") {
+		return ssh.FingerprintSHA256(key) == want
+	}
+	parsed, _, _, _, err := ssh.ParseAuthorizedKey([]byte(want))
+	return err == nil && string(parsed.Marshal()) == string(key.Marshal())
+}
+
+// PublicKey implements ssh.Signer
+func (s *AgentSigner) PublicKey() ssh.PublicKey {
+	return s.pubKey
+}
+
+// Sign implements ssh.Signer
+func (s *AgentSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	return s.SignWithAlgorithm(rand, data, "")
+}
+
+// SignWithAlgorithm implements ssh.AlgorithmSigner, so RSA CA keys sign with SHA-2
+func (s *AgentSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	var flags agent.SignatureFlags
+	switch algorithm {
+	case ssh.KeyAlgoRSASHA256:
+		flags = agent.SignatureFlagRsaSha256
+	case ssh.KeyAlgoRSASHA512:
+		flags = agent.SignatureFlagRsaSha512
+	}
+
+	conn, err := net.Dial("unix", s.socket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to signing agent: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	return agent.NewClient(conn).SignWithFlags(s.pubKey, data, flags)
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
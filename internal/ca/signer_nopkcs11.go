@@ -0,0 +1,15 @@
+//go:build !pkcs11
+
+package ca
+
+import (
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// PKCS#11 needs cgo, so it's opt-in: go build -tags pkcs11
+func newPKCS11Signer(PKCS11Config) (ssh.Signer, io.Closer, error) {
+	return nil, nil, fmt.Errorf("this cassh-server was built without PKCS#11 support; rebuild with -tags pkcs11")
+}
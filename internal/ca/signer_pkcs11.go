@@ -0,0 +1,261 @@
+//go:build pkcs11
+
+package ca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+	"golang.org/x/crypto/ssh"
+)
+
+// pkcs11Signer is a crypto.Signer whose private key stays on the token
+type pkcs11Signer struct {
+	mu      sync.Mutex // PKCS#11 sessions aren't safe for concurrent use
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	key     pkcs11.ObjectHandle
+	pub     crypto.PublicKey
+}
+
+func newPKCS11Signer(cfg PKCS11Config) (ssh.Signer, io.Closer, error) {
+	if cfg.Module == "" || cfg.KeyLabel == "" {
+		return nil, nil, fmt.Errorf("pkcs11 signer requires a module and key_label")
+	}
+
+	p := pkcs11.New(cfg.Module)
+	if p == nil {
+		return nil, nil, fmt.Errorf("failed to load PKCS#11 module %s", cfg.Module)
+	}
+	if err := p.Initialize(); err != nil {
+		p.Destroy()
+		return nil, nil, fmt.Errorf("failed to initialize PKCS#11 module: %w", err)
+	}
+
+	s := &pkcs11Signer{ctx: p}
+	if err := s.open(cfg); err != nil {
+		_ = s.Close()
+		return nil, nil, err
+	}
+
+	signer, err := ssh.NewSignerFromSigner(s)
+	if err != nil {
+		_ = s.Close()
+		return nil, nil, fmt.Errorf("unsupported PKCS#11 key: %w", err)
+	}
+	return signer, s, nil
+}
+
+// open logs in to the token and finds the key pair labelled cfg.KeyLabel
+func (s *pkcs11Signer) open(cfg PKCS11Config) error {
+	slot, err := s.findSlot(cfg.TokenLabel)
+	if err != nil {
+		return err
+	}
+
+	s.session, err = s.ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return fmt.Errorf("failed to open PKCS#11 session: %w", err)
+	}
+	if err := s.ctx.Login(s.session, pkcs11.CKU_USER, cfg.PIN); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN)) {
+		return fmt.Errorf("failed to log in to PKCS#11 token: %w", err)
+	}
+
+	s.key, err = s.findObject(pkcs11.CKO_PRIVATE_KEY, cfg.KeyLabel)
+	if err != nil {
+		return err
+	}
+	pubHandle, err := s.findObject(pkcs11.CKO_PUBLIC_KEY, cfg.KeyLabel)
+	if err != nil {
+		return err
+	}
+	s.pub, err = s.readPublicKey(pubHandle)
+	return err
+}
+
+func (s *pkcs11Signer) findSlot(tokenLabel string) (uint, error) {
+	slots, err := s.ctx.GetSlotList(true)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list PKCS#11 slots: %w", err)
+	}
+	for _, slot := range slots {
+		info, err := s.ctx.GetTokenInfo(slot)
+		if err != nil {
+			continue
+		}
+		if tokenLabel == "" || info.Label == tokenLabel {
+			return slot, nil
+		}
+	}
+	return 0, fmt.Errorf("PKCS#11 token %q not found", tokenLabel)
+}
+
+func (s *pkcs11Signer) findObject(class uint, label string) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+	if err := s.ctx.FindObjectsInit(s.session, template); err != nil {
+		return 0, fmt.Errorf("failed to search PKCS#11 token: %w", err)
+	}
+	objects, _, err := s.ctx.FindObjects(s.session, 1)
+	_ = s.ctx.FindObjectsFinal(s.session)
+	if err != nil {
+		return 0, fmt.Errorf("failed to search PKCS#11 token: %w", err)
+	}
+	if len(objects) == 0 {
+		kind := "private"
+		if class == pkcs11.CKO_PUBLIC_KEY {
+			kind = "public"
+		}
+		return 0, fmt.Errorf("PKCS#11 %s key %q not found", kind, label)
+	}
+	return objects[0], nil
+}
+
+// readPublicKey reads an RSA or ECDSA (P-256/384/521) public key object
+func (s *pkcs11Signer) readPublicKey(handle pkcs11.ObjectHandle) (crypto.PublicKey, error) {
+	attrs, err := s.ctx.GetAttributeValue(s.session, handle, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, nil),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PKCS#11 key type: %w", err)
+	}
+
+	switch keyType := ulong(attrs[0].Value); keyType {
+	case pkcs11.CKK_RSA:
+		attrs, err := s.ctx.GetAttributeValue(s.session, handle, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read PKCS#11 RSA key: %w", err)
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(attrs[0].Value),
+			E: int(new(big.Int).SetBytes(attrs[1].Value).Int64()),
+		}, nil
+	case pkcs11.CKK_EC:
+		attrs, err := s.ctx.GetAttributeValue(s.session, handle, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read PKCS#11 EC key: %w", err)
+		}
+		curve, err := curveFromParams(attrs[0].Value)
+		if err != nil {
+			return nil, err
+		}
+		// CKA_EC_POINT is a DER OCTET STRING wrapping the uncompressed point
+		var point []byte
+		if _, err := asn1.Unmarshal(attrs[1].Value, &point); err != nil {
+			point = attrs[1].Value
+		}
+		x, y := elliptic.Unmarshal(curve, point) //nolint:staticcheck // no ecdsa equivalent until go1.25
+		if x == nil {
+			return nil, fmt.Errorf("invalid PKCS#11 EC point")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported PKCS#11 key type %d (use RSA or ECDSA)", keyType)
+	}
+}
+
+// ulong decodes a CK_ULONG attribute (host byte order, 4 or 8 bytes)
+func ulong(b []byte) uint64 {
+	switch len(b) {
+	case 4:
+		return uint64(binary.NativeEndian.Uint32(b))
+	case 8:
+		return binary.NativeEndian.Uint64(b)
+	}
+	return 0
+}
+
+var curveOIDs = map[string]elliptic.Curve{
+	asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}.String(): elliptic.P256(),
+	asn1.ObjectIdentifier{1, 3, 132, 0, 34}.String():          elliptic.P384(),
+	asn1.ObjectIdentifier{1, 3, 132, 0, 35}.String():          elliptic.P521(),
+}
+
+func curveFromParams(params []byte) (elliptic.Curve, error) {
+	var oid asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(params, &oid); err != nil {
+		return nil, fmt.Errorf("invalid PKCS#11 EC params: %w", err)
+	}
+	curve, ok := curveOIDs[oid.String()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported PKCS#11 EC curve %s", oid)
+	}
+	return curve, nil
+}
+
+// Public implements crypto.Signer
+func (s *pkcs11Signer) Public() crypto.PublicKey {
+	return s.pub
+}
+
+// DigestInfo prefixes for RSA PKCS#1 v1.5 (RFC 8017 section 9.2)
+var digestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA1:   {0x30, 0x21, 0x30, 0x09, 0x06, 0x05, 0x2b, 0x0e, 0x03, 0x02, 0x1a, 0x05, 0x00, 0x04, 0x14},
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// Sign implements crypto.Signer; digest is already hashed with opts.HashFunc()
+func (s *pkcs11Signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch s.pub.(type) {
+	case *rsa.PublicKey:
+		prefix, ok := digestInfoPrefixes[opts.HashFunc()]
+		if !ok {
+			return nil, fmt.Errorf("unsupported hash %v for PKCS#11 RSA key", opts.HashFunc())
+		}
+		mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)}
+		if err := s.ctx.SignInit(s.session, mech, s.key); err != nil {
+			return nil, fmt.Errorf("PKCS#11 sign failed: %w", err)
+		}
+		return s.ctx.Sign(s.session, append(append([]byte{}, prefix...), digest...))
+	case *ecdsa.PublicKey:
+		mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)}
+		if err := s.ctx.SignInit(s.session, mech, s.key); err != nil {
+			return nil, fmt.Errorf("PKCS#11 sign failed: %w", err)
+		}
+		raw, err := s.ctx.Sign(s.session, digest)
+		if err != nil {
+			return nil, fmt.Errorf("PKCS#11 sign failed: %w", err)
+		}
+		// Tokens return r||s; crypto.Signer callers expect ASN.1
+		half := len(raw) / 2
+		return asn1.Marshal(struct{ R, S *big.Int }{
+			new(big.Int).SetBytes(raw[:half]),
+			new(big.Int).SetBytes(raw[half:]),
+		})
+	default:
+		return nil, fmt.Errorf("unsupported PKCS#11 key")
+	}
+}
+
+// Close logs out and unloads the module
+func (s *pkcs11Signer) Close() error {
+	if s.session != 0 {
+		_ = s.ctx.Logout(s.session)
+		_ = s.ctx.CloseSession(s.session)
+	}
+	_ = s.ctx.Finalize()
+	s.ctx.Destroy()
+	return nil
+}
@@ -0,0 +1,57 @@
+//go:build pkcs11
+
+package ca
+
+import (
+	"os"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// TestPKCS11Signer runs against a SoftHSM token prepared with, e.g.:
+//
+//	softhsm2-util --init-token --free --label cassh --pin 1234 --so-pin 1234
+//	pkcs11-tool --module $SOFTHSM2_MODULE --login --pin 1234 --token-label cassh \
+//	  --keypairgen --key-type EC:prime256v1 --label cassh-ca
+func TestPKCS11Signer(t *testing.T) {
+	module := os.Getenv("SOFTHSM2_MODULE")
+	if module == "" {
+		t.Skip("SOFTHSM2_MODULE not set")
+	}
+
+	signer, closer, err := NewSigner(SignerConfig{
+		Type: SignerPKCS11,
+		PKCS11: PKCS11Config{
+			Module:     module,
+			TokenLabel: envOr("CASSH_TEST_PKCS11_TOKEN", "cassh"),
+			KeyLabel:   envOr("CASSH_TEST_PKCS11_KEY", "cassh-ca"),
+			PIN:        envOr("CASSH_TEST_PKCS11_PIN", "1234"),
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewSigner() error = %v", err)
+	}
+	defer func() { _ = closer.Close() }()
+
+	ca := NewCAFromSigner(signer, 1, nil)
+	userPubKey, _ := generateTestUserKey(t)
+	cert, err := ca.SignPublicKey(userPubKey, "pkcs11-test", "alice")
+	if err != nil {
+		t.Fatalf("SignPublicKey() error = %v", err)
+	}
+
+	checker := &ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool { return ca.IsAuthorityFor(cert) },
+	}
+	if err := checker.CheckCert("alice", cert); err != nil {
+		t.Errorf("CheckCert() error = %v", err)
+	}
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,172 @@
+package ca
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// startTestAgent serves an ssh-agent holding keys on a unix socket
+func startTestAgent(t *testing.T, keys ...interface{}) string {
+	t.Helper()
+
+	keyring := agent.NewKeyring()
+	for _, key := range keys {
+		if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+			t.Fatalf("keyring.Add() error = %v", err)
+		}
+	}
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("net.Listen() error = %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				_ = agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+	return socket
+}
+
+func TestNewSigner(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     SignerConfig
+		wantErr string
+	}{
+		{name: "file", cfg: SignerConfig{PrivateKeyPEM: generateTestCAKey(t)}},
+		{name: "file explicit", cfg: SignerConfig{Type: SignerFile, PrivateKeyPEM: generateTestCAKey(t)}},
+		{name: "file missing key", cfg: SignerConfig{Type: SignerFile}, wantErr: "required"},
+		{name: "file invalid key", cfg: SignerConfig{PrivateKeyPEM: []byte("not a key")}, wantErr: "failed to parse"},
+		{name: "agent missing socket", cfg: SignerConfig{Type: SignerAgent}, wantErr: "socket"},
+		{name: "pkcs11 missing module", cfg: SignerConfig{Type: SignerPKCS11}, wantErr: "pkcs11"},
+		{name: "unknown", cfg: SignerConfig{Type: "kms"}, wantErr: "unknown CA signer"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, closer, err := NewSigner(tt.cfg)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(strings.ToLower(err.Error()), strings.ToLower(tt.wantErr)) {
+					t.Fatalf("NewSigner() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewSigner() error = %v", err)
+			}
+			defer func() { _ = closer.Close() }()
+			if signer.PublicKey() == nil {
+				t.Error("NewSigner() returned a signer with no public key")
+			}
+		})
+	}
+}
+
+func TestAgentSigner(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey() error = %v", err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		key     interface{}
+		wantAlg string
+	}{
+		{name: "ed25519", key: edKey, wantAlg: ssh.KeyAlgoED25519},
+		{name: "rsa signs with sha2", key: rsaKey, wantAlg: ssh.KeyAlgoRSASHA512},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			socket := startTestAgent(t, tt.key)
+			signer, closer, err := NewSigner(SignerConfig{Type: SignerAgent, AgentSocket: socket})
+			if err != nil {
+				t.Fatalf("NewSigner() error = %v", err)
+			}
+			defer func() { _ = closer.Close() }()
+
+			ca := NewCAFromSigner(signer, 1, nil)
+			userPubKey, _ := generateTestUserKey(t)
+			cert, err := ca.SignPublicKey(userPubKey, "agent-test", "alice")
+			if err != nil {
+				t.Fatalf("SignPublicKey() error = %v", err)
+			}
+
+			if cert.Signature.Format != tt.wantAlg {
+				t.Errorf("signature format = %q, want %q", cert.Signature.Format, tt.wantAlg)
+			}
+			checker := &ssh.CertChecker{
+				IsUserAuthority: func(auth ssh.PublicKey) bool { return ca.IsAuthorityFor(cert) },
+			}
+			if err := checker.CheckCert("alice", cert); err != nil {
+				t.Errorf("CheckCert() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestAgentSignerKeySelection(t *testing.T) {
+	_, key1, _ := ed25519.GenerateKey(rand.Reader)
+	_, key2, _ := ed25519.GenerateKey(rand.Reader)
+	pub2, err := ssh.NewPublicKey(key2.Public())
+	if err != nil {
+		t.Fatalf("ssh.NewPublicKey() error = %v", err)
+	}
+	_, other, _ := ed25519.GenerateKey(rand.Reader)
+	otherPub, _ := ssh.NewPublicKey(other.Public())
+
+	socket := startTestAgent(t, key1, key2)
+
+	tests := []struct {
+		name      string
+		publicKey string
+		wantErr   bool
+	}{
+		{name: "ambiguous", publicKey: "", wantErr: true},
+		{name: "by fingerprint", publicKey: ssh.FingerprintSHA256(pub2)},
+		{name: "by authorized key", publicKey: string(ssh.MarshalAuthorizedKey(pub2))},
+		{name: "not in agent", publicKey: ssh.FingerprintSHA256(otherPub), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, err := NewAgentSigner(socket, tt.publicKey)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("NewAgentSigner() should fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewAgentSigner() error = %v", err)
+			}
+			if got := ssh.FingerprintSHA256(signer.PublicKey()); got != ssh.FingerprintSHA256(pub2) {
+				t.Errorf("selected key = %s, want %s", got, ssh.FingerprintSHA256(pub2))
+			}
+		})
+	}
+}
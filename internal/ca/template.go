@@ -0,0 +1,143 @@
+package ca
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrPrincipalNotAllowed is returned when a request asks for a principal its template doesn't permit
+var ErrPrincipalNotAllowed = errors.New("principal not allowed by certificate template")
+
+// Extensions a template may grant
+var knownExtensions = map[string]bool{
+	"permit-X11-forwarding":   true,
+	"permit-agent-forwarding": true,
+	"permit-port-forwarding":  true,
+	"permit-pty":              true,
+	"permit-user-rc":          true,
+	"no-touch-required":       true,
+}
+
+// Template is a named cert profile (e.g., "github-only", "bastion", "break-glass")
+// It fixes the extensions and critical options and caps validity and principals
+type Template struct {
+	Name string `toml:"-"` // Set from the [templates.<name>] key
+
+	// Extensions to grant; nil uses DefaultExtensions, an empty list grants none
+	Extensions []string `toml:"extensions"`
+
+	// Critical options
+	ForceCommand  string   `toml:"force_command"`
+	SourceAddress []string `toml:"source_address"` // IPs or CIDRs the cert may be used from
+
+	// Upper bound on cert lifetime; 0 means the CA default
+	MaxValidityHours int `toml:"max_validity_hours"`
+
+	// Glob patterns every requested principal must match; empty allows any
+	AllowedPrincipals []string `toml:"allowed_principals"`
+
+	// Leave out the login@host extension (for certs not used with GitHub)
+	OmitGitHubLogin bool `toml:"omit_github_login"`
+}
+
+// Validate checks the template for unknown extensions and malformed options
+func (t *Template) Validate() error {
+	for _, ext := range t.Extensions {
+		if !knownExtensions[ext] {
+			return fmt.Errorf("template %q: unknown extension %q", t.Name, ext)
+		}
+	}
+	for _, addr := range t.SourceAddress {
+		if net.ParseIP(addr) != nil {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(addr); err != nil {
+			return fmt.Errorf("template %q: invalid source_address %q", t.Name, addr)
+		}
+	}
+	if t.MaxValidityHours < 0 {
+		return fmt.Errorf("template %q: max_validity_hours must not be negative", t.Name)
+	}
+	for _, pattern := range t.AllowedPrincipals {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("template %q: invalid allowed_principals pattern %q", t.Name, pattern)
+		}
+	}
+	return nil
+}
+
+// extensions returns the extensions to grant, narrowed to requested if it's non-nil
+func (t *Template) extensions(requested []string) []string {
+	granted := DefaultExtensions
+	if t != nil && t.Extensions != nil {
+		granted = t.Extensions
+	}
+	if requested == nil {
+		return granted
+	}
+
+	// A request (e.g., from an issuance rule) can only narrow what the template grants
+	var out []string
+	for _, ext := range requested {
+		for _, g := range granted {
+			if ext == g {
+				out = append(out, ext)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// criticalOptions returns the template's critical options
+func (t *Template) criticalOptions() map[string]string {
+	if t == nil {
+		return nil
+	}
+	opts := make(map[string]string)
+	if t.ForceCommand != "" {
+		opts["force-command"] = t.ForceCommand
+	}
+	if len(t.SourceAddress) > 0 {
+		opts["source-address"] = strings.Join(t.SourceAddress, ",")
+	}
+	if len(opts) == 0 {
+		return nil
+	}
+	return opts
+}
+
+// validity caps the requested lifetime at the template maximum
+func (t *Template) validity(requested time.Duration) time.Duration {
+	if t == nil || t.MaxValidityHours == 0 {
+		return requested
+	}
+	if max := time.Duration(t.MaxValidityHours) * time.Hour; requested > max {
+		return max
+	}
+	return requested
+}
+
+// checkPrincipals returns ErrPrincipalNotAllowed if any principal falls outside AllowedPrincipals
+func (t *Template) checkPrincipals(principals []string) error {
+	if t == nil || len(t.AllowedPrincipals) == 0 {
+		return nil
+	}
+	for _, p := range principals {
+		allowed := false
+		for _, pattern := range t.AllowedPrincipals {
+			if ok, _ := path.Match(pattern, p); ok {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("%w: %q (template %q)", ErrPrincipalNotAllowed, p, t.Name)
+		}
+	}
+	return nil
+}
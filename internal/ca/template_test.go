@@ -0,0 +1,190 @@
+package ca
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTemplateValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		tmpl    Template
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			tmpl: Template{
+				Name:              "bastion",
+				Extensions:        []string{"permit-pty", "permit-agent-forwarding"},
+				ForceCommand:      "/usr/bin/bastion-shell",
+				SourceAddress:     []string{"10.0.0.0/8", "192.168.1.10"},
+				MaxValidityHours:  1,
+				AllowedPrincipals: []string{"ops-*"},
+			},
+		},
+		{
+			name: "empty",
+			tmpl: Template{Name: "empty"},
+		},
+		{
+			name:    "unknown extension",
+			tmpl:    Template{Name: "bad", Extensions: []string{"permit-everything"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid source address",
+			tmpl:    Template{Name: "bad", SourceAddress: []string{"10.0.0.0/99"}},
+			wantErr: true,
+		},
+		{
+			name:    "negative validity",
+			tmpl:    Template{Name: "bad", MaxValidityHours: -1},
+			wantErr: true,
+		},
+		{
+			name:    "invalid principal pattern",
+			tmpl:    Template{Name: "bad", AllowedPrincipals: []string{"ops-["}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.tmpl.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSignWithTemplate(t *testing.T) {
+	ca, err := NewCA(generateTestCAKey(t), 12, nil)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+
+	userPub, _ := generateTestUserKey(t)
+
+	tmpl := &Template{
+		Name:              "bastion",
+		Extensions:        []string{"permit-pty", "permit-agent-forwarding"},
+		ForceCommand:      "/usr/bin/bastion-shell",
+		SourceAddress:     []string{"10.0.0.0/8", "192.168.1.10"},
+		MaxValidityHours:  1,
+		AllowedPrincipals: []string{"ops-*"},
+		OmitGitHubLogin:   true,
+	}
+
+	cert, err := ca.Sign(&CertRequest{
+		PublicKey:      userPub,
+		KeyID:          "test-key-id",
+		Principals:     []string{"ops-alice"},
+		GitHubUsername: "alice",
+		Extensions:     []string{"permit-pty", "permit-port-forwarding"},
+	}, tmpl)
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	// 12h CA default is capped at the template's 1h
+	if got := time.Duration(cert.ValidBefore-cert.ValidAfter) * time.Second; got != time.Hour {
+		t.Errorf("validity = %v, want 1h", got)
+	}
+
+	wantOptions := map[string]string{
+		"force-command":  "/usr/bin/bastion-shell",
+		"source-address": "10.0.0.0/8,192.168.1.10",
+	}
+	if len(cert.CriticalOptions) != len(wantOptions) {
+		t.Errorf("CriticalOptions = %v, want %v", cert.CriticalOptions, wantOptions)
+	}
+	for k, v := range wantOptions {
+		if got := cert.CriticalOptions[k]; got != v {
+			t.Errorf("CriticalOptions[%q] = %q, want %q", k, got, v)
+		}
+	}
+
+	// The request can only narrow the template's extensions, and login@ is omitted
+	if len(cert.Extensions) != 1 {
+		t.Errorf("Extensions = %v, want [permit-pty]", cert.Extensions)
+	}
+	if _, ok := cert.Extensions["permit-pty"]; !ok {
+		t.Errorf("Extensions = %v, want permit-pty", cert.Extensions)
+	}
+}
+
+func TestSignTemplateRejectsPrincipal(t *testing.T) {
+	ca, err := NewCA(generateTestCAKey(t), 12, nil)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+
+	userPub, _ := generateTestUserKey(t)
+
+	_, err = ca.Sign(&CertRequest{
+		PublicKey:  userPub,
+		KeyID:      "test-key-id",
+		Principals: []string{"ops-alice", "root"},
+	}, &Template{Name: "bastion", AllowedPrincipals: []string{"ops-*"}})
+	if !errors.Is(err, ErrPrincipalNotAllowed) {
+		t.Errorf("Sign() error = %v, want ErrPrincipalNotAllowed", err)
+	}
+}
+
+func TestSignTemplateExtensions(t *testing.T) {
+	ca, err := NewCA(generateTestCAKey(t), 12, nil)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+
+	userPub, _ := generateTestUserKey(t)
+
+	tests := []struct {
+		name string
+		tmpl *Template
+		want []string
+	}{
+		{
+			name: "nil template uses defaults",
+			tmpl: nil,
+			want: append([]string{"login@github.com"}, DefaultExtensions...),
+		},
+		{
+			name: "nil extensions use defaults",
+			tmpl: &Template{Name: "github-only"},
+			want: append([]string{"login@github.com"}, DefaultExtensions...),
+		},
+		{
+			name: "empty extensions grant none",
+			tmpl: &Template{Name: "locked", Extensions: []string{}},
+			want: []string{"login@github.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert, err := ca.Sign(&CertRequest{
+				PublicKey:      userPub,
+				KeyID:          "test-key-id",
+				GitHubUsername: "alice",
+			}, tt.tmpl)
+			if err != nil {
+				t.Fatalf("Sign() error = %v", err)
+			}
+
+			if len(cert.Extensions) != len(tt.want) {
+				t.Errorf("Extensions = %v, want %v", cert.Extensions, tt.want)
+			}
+			for _, ext := range tt.want {
+				if _, ok := cert.Extensions[ext]; !ok {
+					t.Errorf("Extensions missing %q", ext)
+				}
+			}
+			if len(cert.CriticalOptions) != 0 {
+				t.Errorf("CriticalOptions = %v, want none", cert.CriticalOptions)
+			}
+		})
+	}
+}
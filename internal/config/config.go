@@ -4,23 +4,27 @@
 package config
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
 	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
-	"strconv"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/pelletier/go-toml/v2"
+	"github.com/shawntz/cassh/internal/ca"
+	"github.com/shawntz/cassh/internal/hosts"
+	"github.com/shawntz/cassh/internal/policy"
+	"github.com/shawntz/cassh/internal/workload"
+	"golang.org/x/crypto/ssh"
 )
 
 // PolicyConfig contains IT-controlled settings that users can't modify
 // This is bundled inside the signed app bundle
 type PolicyConfig struct {
 	// CA configuration
-	CAPublicKey     string `toml:"ca_public_key"`
+	CAPublicKey      string `toml:"ca_public_key"`
 	CAKeyFingerprint string `toml:"ca_key_fingerprint"`
 
 	// Certificate settings
@@ -38,7 +42,10 @@ type PolicyConfig struct {
 	OIDCClientID string `toml:"oidc_client_id"`
 	OIDCTenantID string `toml:"oidc_tenant_id"`
 
-	// Policy integrity
+	// Key ID format the server issues certs with, so `cassh-cli --status` can decode them (empty: ca.DefaultKeyIDFormat)
+	KeyIDFormat string `toml:"key_id_format"`
+
+	// Policy integrity: policy_signature is set by `cassh policy sign`
 	PolicyVersion   string `toml:"policy_version"`
 	PolicySignature string `toml:"policy_signature"`
 
@@ -46,9 +53,31 @@ type PolicyConfig struct {
 	DevMode bool `toml:"dev_mode"`
 }
 
+// TrustedCAFingerprints returns the SHA256 fingerprints of the CA keys certs may be signed by
+// ca_key_fingerprint can list several (comma-separated) while a CA key rotation is in progress
+func (p *PolicyConfig) TrustedCAFingerprints() []string {
+	fingerprints := splitList(p.CAKeyFingerprint)
+	if p.CAPublicKey != "" {
+		if key, err := ca.ParsePublicKey([]byte(p.CAPublicKey)); err == nil {
+			fingerprints = append(fingerprints, ssh.FingerprintSHA256(key))
+		}
+	}
+	return fingerprints
+}
+
+// CheckCertAuthority rejects certs signed by a CA key the policy doesn't trust
+// Policies without ca_public_key or ca_key_fingerprint accept any CA
+func (p *PolicyConfig) CheckCertAuthority(cert *ssh.Certificate) error {
+	fingerprints := p.TrustedCAFingerprints()
+	if len(fingerprints) == 0 {
+		return nil
+	}
+	return ca.CheckSigningKey(cert, fingerprints)
+}
+
 // IsDevMode returns true if running in devel mode
 func (p *PolicyConfig) IsDevMode() bool {
-	return p.DevMode || p.OIDCTenantID == ""
+	return p.DevMode || (p.OIDCTenantID == "" && p.OIDCIssuer == "")
 }
 
 // ConnectionType identifies the type of GitHub connection
@@ -147,178 +176,508 @@ func (u *UserConfig) RemoveConnection(id string) bool {
 }
 
 // MergedConfig is the final runtime config
+// Once goroutines share it, access Policy and User through its methods
 type MergedConfig struct {
 	Policy PolicyConfig
 	User   UserConfig
+
+	mu sync.RWMutex
+}
+
+// Read calls fn with the config locked for reading
+// fn must not keep pointers into the config or call other MergedConfig methods
+func (m *MergedConfig) Read(fn func(policy *PolicyConfig, user *UserConfig)) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	fn(&m.Policy, &m.User)
+}
+
+// UpdateUser calls fn with the config locked for writing, saving the user config if fn returns true
+func (m *MergedConfig) UpdateUser(fn func(user *UserConfig) bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !fn(&m.User) {
+		return nil
+	}
+	return SaveUserConfig(&m.User)
+}
+
+// Connections returns a copy of the user's connections
+func (m *MergedConfig) Connections() []Connection {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]Connection(nil), m.User.Connections...)
+}
+
+// Connection returns a copy of the connection with the given ID
+func (m *MergedConfig) Connection(id string) (Connection, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if conn := m.User.GetConnection(id); conn != nil {
+		return *conn, true
+	}
+	return Connection{}, false
 }
 
 // ServerConfig contains all server-side configs (including secrets)
 // Extends PolicyConfig with server-only fields
+// Fields are set from the file's tables and CASSH_* vars by serverOptions and serverSections, not decoded directly
 type ServerConfig struct {
 	// Base settings
-	ServerBaseURL     string `toml:"server_base_url"`
-	CertValidityHours int    `toml:"cert_validity_hours"`
+	ListenAddr        string
+	ServerBaseURL     string
+	CertValidityHours int
+
+	// Key ID of user certs, e.g. "{sub}/{email}/{device}/{serial}" (see ca.KeyIDFieldNames)
+	KeyIDFormat string
 
 	// OIDC settings
-	OIDCClientID     string `toml:"oidc_client_id"`
-	OIDCClientSecret string `toml:"oidc_client_secret"`
-	OIDCTenant       string `toml:"oidc_tenant"`
-	OIDCRedirectURL  string `toml:"oidc_redirect_url"`
+	// Set OIDCIssuer for any OIDC provider (Okta, Google, Keycloak), or OIDCTenant for the Entra ID preset
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCTenant       string
+	OIDCRedirectURL  string
+	OIDCScopes       []string // Default: openid, email, profile
+
+	// Public client that cassh-cli may sign in with directly (loopback redirect + PKCE); empty disables it
+	OIDCNativeClientID string
+
+	// ID token claim names (empty = standard claim)
+	OIDCEmailClaim    string
+	OIDCNameClaim     string
+	OIDCUsernameClaim string
+
+	// Where auth flows wait for the OIDC callback: "memory" (default), "sqlite", "redis" or "cookie"
+	// Anything but memory lets the callback land on a different replica than /auth/start
+	OIDCStateDriver    string
+	OIDCStatePath      string // SQLite database (sqlite)
+	OIDCStateURL       string // redis:// or rediss:// URL (redis)
+	OIDCStateCookieKey string // Base64 32-byte key shared by replicas (cookie)
+
+	// New sign-ins are refused with 429 while this many wait for a callback (0 = no cap)
+	OIDCStateMaxPending int
+
+	// New device authorizations are refused with 429 while this many wait for approval (0 = no cap)
+	DeviceMaxPending int
 
 	// CA settings
-	CAPrivateKeyPath string `toml:"ca_private_key_path"`
-	CAPrivateKey     string `toml:"-"` // Loaded from file or env, never from TOML directly
+	CAPrivateKeyPath string
+	CAPrivateKey     string // Loaded from file or env, never from TOML directly
+
+	// Where the CA key lives: "file" (default, uses CAPrivateKey), "pkcs11" or "agent"
+	// pkcs11 and agent keep the private key out of the server's memory
+	CASigner         string
+	CAPKCS11         ca.PKCS11Config
+	CAAgentSocket    string // ssh-agent protocol socket (agent)
+	CAAgentPublicKey string // Picks the key when the agent holds several
+
+	// CA key rotation: public keys trusted alongside the active key, in authorized_keys format
+	// Published in /ca-bundle so hosts and GitHub trust the next key before cutover
+	CANextPublicKeys    []string
+	CARetiredPublicKeys []string
+
+	// Additional named CAs ([cas.<name>]) that issuance rules can route signing to
+	CAs map[string]CAConfig
 
 	// GitHub settings
-	GitHubEnterpriseURL string   `toml:"github_enterprise_url"`
-	GitHubAllowedOrgs   []string `toml:"github_allowed_orgs"`
-					PrincipalSource string   `toml:"principal_source"`
-	// PrincipalSource determines how to derive the SSH certificate principal from OIDC claims
-	// Options: "email_prefix" (default), "email", "username", or a custom claim name
-	GitHubPrincipalSource string `toml:"github_principal_source"`
+	GitHubEnterpriseURL string
+
+	// Users must be active, unsuspended members of one of these orgs, checked with the GitHub API before signing
+	GitHubAllowedOrgs []string
+
+	// GitHub API access for the org check: a token, or a GitHub App installation
+	// GitHubAPIURL defaults to the API of GitHubEnterpriseURL
+	GitHubAPIURL            string
+	GitHubToken             string
+	GitHubAppID             int
+	GitHubAppInstallationID int
+	GitHubAppPrivateKeyPath string
+	GitHubAppPrivateKey     string // Loaded from file or env, never from TOML directly
+	GitHubCacheSeconds      int    // How long membership results are reused
+
+	// PrincipalSource determines how to find the GitHub login certs are issued for
+	// Options: "email_prefix" (default), "email", "username" (from OIDC claims),
+	// "mapping" (GitHubPrincipalMappingPath) or "scim" (the GitHub account linked to the user's SCIM identity)
+	// Anything else is a claim name (e.g., "github_login") or a principal template (e.g., "{claim.upn | lower}")
+	GitHubPrincipalSource      string
+	GitHubPrincipalMappingPath string // CSV of identity,login
+
+	// Enterprise slug or org whose SCIM/SAML identities the scim principal source searches
+	GitHubSCIMEnterprise string
+	GitHubSCIMOrg        string
+
+	// Issuance policy: ordered rules on groups/roles claims (see internal/policy)
+	Issuance policy.Config
+
+	// Named cert templates ([templates.<name>]) selectable by request and policy
+	Templates map[string]ca.Template
+
+	// Trusted CI token issuers ([[workload.issuers]]) for workload cert issuance
+	WorkloadIssuers []workload.IssuerConfig
+
+	// Machines that get host certs ([hosts], [[hosts.machines]])
+	Hosts hosts.Config
+
+	// Key IDs of workload and host certs, like KeyIDFormat for user certs
+	WorkloadKeyIDFormat string
+	HostKeyIDFormat     string
+
+	// Client policy served at /.well-known/cassh-policy.toml, signed offline with `cassh policy sign`
+	// ClientPolicySigningKey (public key or SHA256 fingerprint) checks the signature at startup
+	ClientPolicyPath       string
+	ClientPolicySigningKey string
+
+	// Ledger of issued certs and revocations
+	// StoreDriver is "sqlite" (default) or "file"; an empty StorePath keeps it in memory
+	StoreDriver string
+	StorePath   string
+
+	// Bearer token for /admin endpoints (empty disables them)
+	AdminToken string
+
+	// Audit log sink: "stdout" (default), "file" or "syslog"
+	AuditSink string
+	AuditPath string // Required for the file sink
+
+	// Trust X-Forwarded-For when deriving client IPs (only behind a trusted proxy)
+	TrustProxyHeaders bool
+
+	// Token-bucket limits answered with 429: sign-in and cert endpoints per client IP,
+	// and certs signed per identity (user, workload or host); 0 per minute disables a limit
+	RateLimitAuthPerMinute int
+	RateLimitAuthBurst     int
+	RateLimitSignPerMinute int
+	RateLimitSignBurst     int
 
 	// Devel mode
-	DevMode bool `toml:"dev_mode"`
+	DevMode bool
+
+	// Where each key was set in the config file, for problem line numbers
+	lines keyLines
+
+	// Config file read (empty if none) and where each option's value came from, for --print-config
+	path    string
+	sources map[string]string
 }
 
+// DefaultCAName names the top-level [ca] in logs and cert key IDs
+const DefaultCAName = "default"
+
 // LoadServerConfig loads server config from file, with env var overrides
-// Env vars take precedence over file values
-//
-// Supported env vars:
-//   - CASSH_SERVER_URL
-//   - CASSH_CERT_VALIDITY_HOURS
-//   - CASSH_OIDC_CLIENT_ID
-//   - CASSH_OIDC_CLIENT_SECRET
-//   - CASSH_OIDC_TENANT
-//   - CASSH_OIDC_REDIRECT_URL
-//   - CASSH_CA_PRIVATE_KEY (raw key content)
-//   - CASSH_CA_PRIVATE_KEY_PATH (path to key file)
-//   - CASSH_GITHUB_ENTERPRISE_URL
-//   - CASSH_GITHUB_PRINCIPAL_SOURCE (email_prefix, email, username)
-//   - CASSH_DEV_MODE
+// Env vars take precedence over file values; serverOptions lists every setting and its CASSH_* variable
 func LoadServerConfig(policyPath string) (*ServerConfig, error) {
-	config := &ServerConfig{
-		CertValidityHours: 12, // Default
+	config, problems, err := loadServerConfig(policyPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid config file %s: %w", policyPath, problems)
+	}
+	return config, nil
+}
+
+// loadServerConfig is LoadServerConfig, returning problems in the file (unknown keys, wrong types) for lint
+func loadServerConfig(policyPath string) (config *ServerConfig, problems Problems, err error) {
+	config = &ServerConfig{sources: map[string]string{}}
+	for _, opt := range serverOptions {
+		if opt.def != "" {
+			if err := opt.parse(config, opt.def); err != nil {
+				return nil, nil, fmt.Errorf("default %s: %w", opt.name(), err)
+			}
+			config.sources[opt.name()] = SourceDefault
+		}
 	}
 
-	// Try to load from file first
+	// A missing file is fine; everything can come from env vars
 	if policyPath != "" {
 		if data, err := os.ReadFile(policyPath); err == nil {
-			// Parse TOML - supports nested [oidc], [ca], [github] sections
-			var fileConfig struct {
-				ServerBaseURL     string `toml:"server_base_url"`
-				CertValidityHours int    `toml:"cert_validity_hours"`
-				DevMode           bool   `toml:"dev_mode"`
-				OIDC              struct {
-					ClientID     string `toml:"client_id"`
-					ClientSecret string `toml:"client_secret"`
-					Tenant       string `toml:"tenant"`
-					RedirectURL  string `toml:"redirect_url"`
-				} `toml:"oidc"`
-				CA struct {
-					PrivateKeyPath string `toml:"private_key_path"`
-				} `toml:"ca"`
-				GitHub struct {
-					EnterpriseURL string   `toml:"enterprise_url"`
-					AllowedOrgs   []string `toml:"allowed_orgs"`
-					PrincipalSource string   `toml:"principal_source"`
-				} `toml:"github"`
-			}
-
-			if err := toml.Unmarshal(data, &fileConfig); err != nil {
-				return nil, fmt.Errorf("failed to parse config file: %w", err)
+			config.path = policyPath
+			if problems, err = decodeServerFile(data, config); err != nil {
+				return nil, nil, fmt.Errorf("failed to parse config file: %w", err)
 			}
+		}
+	}
 
-			config.ServerBaseURL = fileConfig.ServerBaseURL
-			if fileConfig.CertValidityHours > 0 {
-				config.CertValidityHours = fileConfig.CertValidityHours
+	for _, opt := range serverOptions {
+		if opt.env == "" {
+			continue
+		}
+		if v := os.Getenv(opt.env); v != "" {
+			if err := opt.parse(config, v); err != nil {
+				return nil, nil, fmt.Errorf("invalid %s: %w", opt.env, err)
 			}
-			config.DevMode = fileConfig.DevMode
-			config.OIDCClientID = fileConfig.OIDC.ClientID
-			config.OIDCClientSecret = fileConfig.OIDC.ClientSecret
-			config.OIDCTenant = fileConfig.OIDC.Tenant
-			config.OIDCRedirectURL = fileConfig.OIDC.RedirectURL
-			config.CAPrivateKeyPath = fileConfig.CA.PrivateKeyPath
-			config.GitHubEnterpriseURL = fileConfig.GitHub.EnterpriseURL
-			config.GitHubAllowedOrgs = fileConfig.GitHub.AllowedOrgs
-			config.GitHubPrincipalSource = fileConfig.GitHub.PrincipalSource
+			config.sources[opt.name()] = opt.env
 		}
 	}
+	problems = append(problems, config.shadowProblems()...)
 
-	// Override with env vars
-	if v := os.Getenv("CASSH_SERVER_URL"); v != "" {
-		config.ServerBaseURL = v
-	}
-	if v := os.Getenv("CASSH_CERT_VALIDITY_HOURS"); v != "" {
-		if hours, err := strconv.Atoi(v); err == nil {
-			config.CertValidityHours = hours
+	// Load named CA private keys from their files
+	for name, caCfg := range config.CAs {
+		if !caCfg.usesFileSigner() || caCfg.PrivateKeyPath == "" {
+			continue
 		}
+		keyData, err := os.ReadFile(caCfg.PrivateKeyPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read CA %q private key from %s: %w", name, caCfg.PrivateKeyPath, err)
+		}
+		caCfg.PrivateKey = string(keyData)
+		config.CAs[name] = caCfg
 	}
-	if v := os.Getenv("CASSH_OIDC_CLIENT_ID"); v != "" {
-		config.OIDCClientID = v
-	}
-	if v := os.Getenv("CASSH_OIDC_CLIENT_SECRET"); v != "" {
-		config.OIDCClientSecret = v
-	}
-	if v := os.Getenv("CASSH_OIDC_TENANT"); v != "" {
-		config.OIDCTenant = v
-	}
-	if v := os.Getenv("CASSH_OIDC_REDIRECT_URL"); v != "" {
-		config.OIDCRedirectURL = v
-	}
-	if v := os.Getenv("CASSH_CA_PRIVATE_KEY_PATH"); v != "" {
-		config.CAPrivateKeyPath = v
-	}
-	if v := os.Getenv("CASSH_GITHUB_ENTERPRISE_URL"); v != "" {
-		config.GitHubEnterpriseURL = v
-	}
-	if v := os.Getenv("CASSH_GITHUB_PRINCIPAL_SOURCE"); v != "" {
-		config.GitHubPrincipalSource = v
-	}
-	if v := os.Getenv("CASSH_DEV_MODE"); v == "true" || v == "1" {
-		config.DevMode = true
+
+	if config.GitHubAppPrivateKey == "" && config.GitHubAppPrivateKeyPath != "" {
+		keyData, err := os.ReadFile(config.GitHubAppPrivateKeyPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read GitHub App private key from %s: %w", config.GitHubAppPrivateKeyPath, err)
+		}
+		config.GitHubAppPrivateKey = string(keyData)
+		config.sources["CASSH_GITHUB_APP_PRIVATE_KEY"] = "github.app_private_key_path"
 	}
 
-	// Load CA private key - from env var or file
-	if v := os.Getenv("CASSH_CA_PRIVATE_KEY"); v != "" {
-		// Handle escaped newlines from cloud platform env vars
-		config.CAPrivateKey = strings.ReplaceAll(v, "\\n", "\n")
-	} else if config.CAPrivateKeyPath != "" {
+	// Load CA private key from its file unless CASSH_CA_PRIVATE_KEY set it
+	// HSM and agent signers never read it
+	if !config.usesFileSigner() {
+		config.CAPrivateKey = ""
+		return config, problems, nil
+	}
+	if config.CAPrivateKey == "" && config.CAPrivateKeyPath != "" {
 		keyData, err := os.ReadFile(config.CAPrivateKeyPath)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read CA private key from %s: %w", config.CAPrivateKeyPath, err)
+			return nil, nil, fmt.Errorf("failed to read CA private key from %s: %w", config.CAPrivateKeyPath, err)
 		}
 		config.CAPrivateKey = string(keyData)
+		config.sources["CASSH_CA_PRIVATE_KEY"] = "ca.private_key_path"
 	}
 
-	return config, nil
+	return config, problems, nil
+}
+
+func (c *ServerConfig) usesFileSigner() bool {
+	return c.CASigner == "" || c.CASigner == ca.SignerFile
+}
+
+// CASignerConfig returns the settings for ca.NewSigner
+func (c *ServerConfig) CASignerConfig() ca.SignerConfig {
+	return ca.SignerConfig{
+		Type:          c.CASigner,
+		PrivateKeyPEM: []byte(c.CAPrivateKey),
+		PKCS11:        c.CAPKCS11,
+		AgentSocket:   c.CAAgentSocket,
+		PublicKey:     c.CAAgentPublicKey,
+	}
+}
+
+// CAConfig is a named CA ([cas.<name>]) alongside the top-level [ca]
+// Issuance rules pick it with ca = "<name>"
+type CAConfig struct {
+	PrivateKeyPath string          `toml:"private_key_path"`
+	PrivateKey     string          `toml:"-"` // Loaded from PrivateKeyPath
+	Signer         string          `toml:"signer"`
+	PKCS11         ca.PKCS11Config `toml:"pkcs11"`
+	Agent          struct {
+		Socket    string `toml:"socket"`
+		PublicKey string `toml:"public_key"`
+	} `toml:"agent"`
+
+	// Rotation keys trusted alongside this CA's active key
+	NextPublicKeys    []string `toml:"next_public_keys"`
+	RetiredPublicKeys []string `toml:"retired_public_keys"`
+
+	// Default cert lifetime and GHE host for certs this CA signs; 0 and empty use the top-level settings
+	CertValidityHours   int    `toml:"cert_validity_hours"`
+	GitHubEnterpriseURL string `toml:"github_enterprise_url"`
+}
+
+func (c *CAConfig) usesFileSigner() bool {
+	return c.Signer == "" || c.Signer == ca.SignerFile
+}
+
+// SignerConfig returns the settings for ca.NewSigner
+func (c *CAConfig) SignerConfig() ca.SignerConfig {
+	return ca.SignerConfig{
+		Type:          c.Signer,
+		PrivateKeyPEM: []byte(c.PrivateKey),
+		PKCS11:        c.PKCS11,
+		AgentSocket:   c.Agent.Socket,
+		PublicKey:     c.Agent.PublicKey,
+	}
+}
+
+// check adds a problem for each mistake in the CA defined at key ("cas.<name>")
+func (c *CAConfig) check(problems *Problems, lines keyLines, key string) {
+	switch c.Signer {
+	case "", ca.SignerFile:
+		if c.PrivateKeyPath == "" {
+			problems.add(lines, key, "[%s]: file signer requires private_key_path", key)
+		} else {
+			problems.checkKeyFile(lines, key+".private_key_path", c.PrivateKeyPath)
+		}
+	case ca.SignerPKCS11:
+		if c.PKCS11.Module == "" || c.PKCS11.KeyLabel == "" {
+			problems.add(lines, key+".pkcs11", "[%s]: pkcs11 signer requires pkcs11 module and key_label", key)
+		}
+	case ca.SignerAgent:
+		if c.Agent.Socket == "" {
+			problems.add(lines, key+".agent", "[%s]: agent signer requires agent socket", key)
+		}
+	default:
+		problems.add(lines, key+".signer", "[%s]: unknown signer %q (use file, pkcs11 or agent)", key, c.Signer)
+	}
+	if c.CertValidityHours != 0 {
+		problems.checkValidity(lines, key+".cert_validity_hours", c.CertValidityHours)
+	}
+	if c.GitHubEnterpriseURL != "" {
+		problems.checkURL(lines, key+".github_enterprise_url", c.GitHubEnterpriseURL, "https", "http")
+	}
 }
 
 // IsDevMode returns true if running in devel mode
+// Without an OIDC issuer or Entra tenant there is nothing to authenticate against
 func (c *ServerConfig) IsDevMode() bool {
-	return c.DevMode || c.OIDCTenant == ""
+	return c.DevMode || (c.OIDCTenant == "" && c.OIDCIssuer == "")
 }
 
-// Validate checks that required config is present
+// Validate checks that required config is present and well-formed
+// The error is Problems, listing every mistake with its line in the config file
+// Checks that need the GitHub API or principal packages are in config/serverlint, so clients don't link them
 func (c *ServerConfig) Validate() error {
+	return c.problems().Err()
+}
+
+// Problem returns a problem with key, at its line in the config file
+func (c *ServerConfig) Problem(key, format string, args ...interface{}) Problem {
+	var problems Problems
+	problems.add(c.lines, key, format, args...)
+	return problems[0]
+}
+
+func (c *ServerConfig) problems() Problems {
+	var problems Problems
+	lines := c.lines
+
 	if c.ServerBaseURL == "" {
-		return fmt.Errorf("server_base_url is required (set CASSH_SERVER_URL)")
+		problems.add(lines, "server_base_url", "server_base_url is required (set CASSH_SERVER_URL)")
+	} else {
+		problems.checkURL(lines, "server_base_url", c.ServerBaseURL, "https", "http")
+	}
+	// Loaded configs always have a value (default 12); an explicit 0 is a mistake
+	if c.CertValidityHours != 0 || c.sources["cert_validity_hours"] != "" {
+		problems.checkValidity(lines, "cert_validity_hours", c.CertValidityHours)
+	}
+
+	for _, f := range []struct{ key, format string }{
+		{"key_id_format", c.KeyIDFormat},
+		{"workload.key_id_format", c.WorkloadKeyIDFormat},
+		{"hosts.key_id_format", c.HostKeyIDFormat},
+	} {
+		if f.format == "" {
+			continue
+		}
+		if _, err := ca.ParseKeyIDFormat(f.format); err != nil {
+			problems.add(lines, f.key, "%v", err)
+		}
 	}
+
 	if !c.IsDevMode() {
 		if c.OIDCClientID == "" {
-			return fmt.Errorf("OIDC client_id is required (set CASSH_OIDC_CLIENT_ID)")
+			problems.add(lines, "oidc.client_id", "OIDC client_id is required (set CASSH_OIDC_CLIENT_ID)")
+		}
+		if c.usesFileSigner() && c.CAPrivateKey == "" {
+			problems.add(lines, "ca.private_key_path", "CA private key is required (set CASSH_CA_PRIVATE_KEY or CASSH_CA_PRIVATE_KEY_PATH)")
+		}
+	}
+	if c.OIDCIssuer != "" {
+		problems.checkURL(lines, "oidc.issuer", c.OIDCIssuer, "https", "http")
+	}
+	if c.OIDCRedirectURL != "" {
+		problems.checkURL(lines, "oidc.redirect_url", c.OIDCRedirectURL, "https", "http")
+	}
+	if c.OIDCStateURL != "" {
+		problems.checkURL(lines, "oidc.state.url", c.OIDCStateURL, "redis", "rediss")
+	}
+	if c.OIDCStateMaxPending < 0 {
+		problems.add(lines, "oidc.state.max_pending", "oidc.state.max_pending must not be negative")
+	}
+	if c.DeviceMaxPending < 0 {
+		problems.add(lines, "device.max_pending", "device.max_pending must not be negative")
+	}
+	for _, limit := range []struct {
+		key   string
+		value int
+	}{
+		{"rate_limit.auth_per_minute", c.RateLimitAuthPerMinute},
+		{"rate_limit.auth_burst", c.RateLimitAuthBurst},
+		{"rate_limit.sign_per_minute", c.RateLimitSignPerMinute},
+		{"rate_limit.sign_burst", c.RateLimitSignBurst},
+	} {
+		if limit.value < 0 {
+			problems.add(lines, limit.key, "%s must not be negative", limit.key)
+		}
+	}
+	if c.GitHubEnterpriseURL != "" {
+		problems.checkURL(lines, "github.enterprise_url", c.GitHubEnterpriseURL, "https", "http")
+	}
+	if c.GitHubAPIURL != "" {
+		problems.checkURL(lines, "github.api_url", c.GitHubAPIURL, "https", "http")
+	}
+	if c.GitHubCacheSeconds < 0 {
+		problems.add(lines, "github.cache_seconds", "github.cache_seconds must not be negative")
+	}
+	if c.GitHubAppPrivateKeyPath != "" && c.sources["CASSH_GITHUB_APP_PRIVATE_KEY"] != "CASSH_GITHUB_APP_PRIVATE_KEY" {
+		problems.checkKeyFile(lines, "github.app_private_key_path", c.GitHubAppPrivateKeyPath)
+	}
+
+	switch c.CASigner {
+	case "", ca.SignerFile:
+		// An inline CASSH_CA_PRIVATE_KEY wins over the file, so only check the file when it was read
+		if c.CAPrivateKeyPath != "" && c.sources["CASSH_CA_PRIVATE_KEY"] != "CASSH_CA_PRIVATE_KEY" {
+			problems.checkKeyFile(lines, "ca.private_key_path", c.CAPrivateKeyPath)
 		}
-		if c.OIDCClientSecret == "" {
-			return fmt.Errorf("OIDC client_secret is required (set CASSH_OIDC_CLIENT_SECRET)")
+	case ca.SignerPKCS11:
+		if c.CAPKCS11.Module == "" || c.CAPKCS11.KeyLabel == "" {
+			problems.add(lines, "ca.pkcs11", "pkcs11 CA signer requires [ca.pkcs11] module and key_label (set CASSH_CA_PKCS11_MODULE and CASSH_CA_PKCS11_KEY_LABEL)")
 		}
-		if c.OIDCTenant == "" {
-			return fmt.Errorf("OIDC tenant is required (set CASSH_OIDC_TENANT)")
+	case ca.SignerAgent:
+		if c.CAAgentSocket == "" {
+			problems.add(lines, "ca.agent", "agent CA signer requires [ca.agent] socket (set CASSH_CA_AGENT_SOCKET)")
 		}
-		if c.CAPrivateKey == "" {
-			return fmt.Errorf("CA private key is required (set CASSH_CA_PRIVATE_KEY or CASSH_CA_PRIVATE_KEY_PATH)")
+	default:
+		problems.add(lines, "ca.signer", "unknown CA signer %q (use file, pkcs11 or agent)", c.CASigner)
+	}
+
+	names := make([]string, 0, len(c.CAs))
+	for name := range c.CAs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		key := "cas." + name
+		if name == "" || name == DefaultCAName {
+			problems.add(lines, key, "[%s]: %q is reserved for the top-level [ca]", key, name)
+			continue
 		}
+		caCfg := c.CAs[name]
+		caCfg.check(&problems, lines, key)
 	}
-	return nil
+	return problems
+}
+
+// splitLines splits a newline-separated env var value (escaped "\n" included)
+func splitLines(v string) []string {
+	var lines []string
+	for _, line := range strings.Split(strings.ReplaceAll(v, "\\n", "\n"), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// splitList splits a comma- or space-separated env var value
+func splitList(v string) []string {
+	return strings.FieldsFunc(v, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
 }
 
 // DefaultUserConfig returns sensible defaults for user prefs
@@ -340,14 +699,28 @@ func LoadPolicy(policyPath string) (*PolicyConfig, error) {
 		return nil, fmt.Errorf("failed to read policy file: %w", err)
 	}
 
+	// Builds with a pinned signing key only accept policies signed by it; others still reject a broken signature
+	if err := checkLocalPolicy(data); err != nil {
+		return nil, err
+	}
+
+	return parsePolicy(data)
+}
+
+func parsePolicy(data []byte) (*PolicyConfig, error) {
 	// Use intermediate struct to handle nested sections
 	var fileConfig struct {
 		PolicyConfig
 		GitHub struct {
-			EnterpriseURL string   `toml:"enterprise_url"`
-			AllowedOrgs   []string `toml:"allowed_orgs"`
-					PrincipalSource string   `toml:"principal_source"`
+			EnterpriseURL   string   `toml:"enterprise_url"`
+			AllowedOrgs     []string `toml:"allowed_orgs"`
+			PrincipalSource string   `toml:"principal_source"`
 		} `toml:"github"`
+		OIDC struct {
+			Issuer   string `toml:"issuer"`
+			ClientID string `toml:"client_id"`
+			Tenant   string `toml:"tenant"`
+		} `toml:"oidc"`
 	}
 
 	if err := toml.Unmarshal(data, &fileConfig); err != nil {
@@ -356,10 +729,19 @@ func LoadPolicy(policyPath string) (*PolicyConfig, error) {
 
 	policy := fileConfig.PolicyConfig
 
-	// Map nested [github] section to flat fields
+	// Map nested [github] and [oidc] sections to flat fields
 	if fileConfig.GitHub.EnterpriseURL != "" {
 		policy.GitHubEnterpriseURL = fileConfig.GitHub.EnterpriseURL
 	}
+	if fileConfig.OIDC.Issuer != "" {
+		policy.OIDCIssuer = fileConfig.OIDC.Issuer
+	}
+	if fileConfig.OIDC.ClientID != "" {
+		policy.OIDCClientID = fileConfig.OIDC.ClientID
+	}
+	if fileConfig.OIDC.Tenant != "" {
+		policy.OIDCTenantID = fileConfig.OIDC.Tenant
+	}
 
 	return &policy, nil
 }
@@ -509,29 +891,13 @@ func PolicyPath() string {
 		return bundlePath
 	}
 
-	// Fallback for devel / OSS mode
-	return "cassh.policy.toml"
-}
-
-// VerifyPolicyIntegrity checks that policy hasn't been tampered with
-// TODO: In prod should verify a cryptographic signature
-func VerifyPolicyIntegrity(policy *PolicyConfig, expectedFingerprint string) error {
-	// Simple hash check for integrity
-	policyData := fmt.Sprintf("%s:%s:%d:%s",
-		policy.CAPublicKey,
-		policy.ServerBaseURL,
-		policy.CertValidityHours,
-		policy.OIDCTenantID,
-	)
-
-	hash := sha256.Sum256([]byte(policyData))
-	fingerprint := hex.EncodeToString(hash[:8])
-
-	if policy.PolicySignature != "" && fingerprint != policy.PolicySignature {
-		return fmt.Errorf("policy integrity check failed - contact IT")
+	// Same override as cassh-server, so CLI tools read the file the server does
+	if path := os.Getenv("CASSH_POLICY_PATH"); path != "" {
+		return path
 	}
 
-	return nil
+	// Fallback for devel / OSS mode
+	return "cassh.policy.toml"
 }
 
 // MergeConfigs creates the final runtime config
@@ -569,6 +935,9 @@ func IsEnterpriseMode(policy *PolicyConfig) bool {
 	return policy != nil && policy.ServerBaseURL != ""
 }
 
+// EnterpriseConnectionID is the connection created from the policy's server and GHE host
+const EnterpriseConnectionID = "enterprise-default"
+
 // CreateEnterpriseConnectionFromPolicy creates a Connection from the bundled policy
 // This is used for enterprise deployments where IT bundles the config
 func CreateEnterpriseConnectionFromPolicy(policy *PolicyConfig) *Connection {
@@ -579,7 +948,7 @@ func CreateEnterpriseConnectionFromPolicy(policy *PolicyConfig) *Connection {
 	homeDir, _ := os.UserHomeDir()
 
 	return &Connection{
-		ID:          "enterprise-default",
+		ID:          EnterpriseConnectionID,
 		Type:        ConnectionTypeEnterprise,
 		Name:        "GitHub Enterprise",
 		ServerURL:   policy.ServerBaseURL,
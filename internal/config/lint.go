@@ -0,0 +1,351 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pelletier/go-toml/v2/unstable"
+	"github.com/shawntz/cassh/internal/ca"
+)
+
+// maxCertValidityHours caps cert_validity_hours; longer-lived certs defeat the point of a CA
+const maxCertValidityHours = 24 * 365
+
+// maxKeyRotationHours is the longest personal key rotation period (90 days)
+const maxKeyRotationHours = 90 * 24
+
+// Problem is one mistake found in a config file
+type Problem struct {
+	Line    int    // 1-based line in the file, 0 if the key isn't in it (missing or set by env var)
+	Key     string // Dotted TOML key, e.g. "github.principal_source"
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("line %d: %s", p.Line, p.Message)
+	}
+	return p.Message
+}
+
+// Problems are all the mistakes found in a config; returned as the error from Validate
+type Problems []Problem
+
+func (p Problems) Error() string {
+	msgs := make([]string, len(p))
+	for i, problem := range p {
+		msgs[i] = problem.String()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// add records a problem with key, looking up its line in lines
+func (p *Problems) add(lines keyLines, key, format string, args ...interface{}) {
+	*p = append(*p, Problem{Line: lines.line(key), Key: key, Message: fmt.Sprintf(format, args...)})
+}
+
+// ByLine orders p by line, with problems not tied to a line last
+func (p Problems) ByLine() Problems {
+	sort.SliceStable(p, func(i, j int) bool {
+		a, b := p[i].Line, p[j].Line
+		return a != 0 && (b == 0 || a < b)
+	})
+	return p
+}
+
+// Err returns p as an error, or nil if there are no problems
+func (p Problems) Err() error {
+	if len(p) == 0 {
+		return nil
+	}
+	return p
+}
+
+// keyLines maps dotted TOML keys to the line they're set on
+// Array table entries are indexed, e.g. "connections[1].id"
+type keyLines map[string]int
+
+// indexKeyLines records the line of every key and table header in data
+// It stops at the first syntax error; decoding reports that separately
+func indexKeyLines(data []byte) keyLines {
+	lines := keyLines{}
+	arrayCounts := map[string]int{}
+	prefix := ""
+
+	var p unstable.Parser
+	p.Reset(data)
+	for p.NextExpression() {
+		expr := p.Expression()
+		switch expr.Kind {
+		case unstable.Table, unstable.ArrayTable:
+			key, line := nodeKey(&p, expr)
+			if expr.Kind == unstable.ArrayTable {
+				index := arrayCounts[key]
+				arrayCounts[key]++
+				key = fmt.Sprintf("%s[%d]", key, index)
+			}
+			lines.set(key, line)
+			prefix = key + "."
+		case unstable.KeyValue:
+			key, line := nodeKey(&p, expr)
+			lines.set(prefix+key, line)
+		}
+	}
+	return lines
+}
+
+func nodeKey(p *unstable.Parser, expr *unstable.Node) (string, int) {
+	var parts []string
+	line := 0
+	it := expr.Key()
+	for it.Next() {
+		node := it.Node()
+		if line == 0 {
+			line = p.Shape(node.Raw).Start.Line
+		}
+		parts = append(parts, string(node.Data))
+	}
+	return strings.Join(parts, "."), line
+}
+
+func (l keyLines) set(key string, line int) {
+	if _, ok := l[key]; !ok {
+		l[key] = line
+	}
+}
+
+// line returns where key is set, falling back to its enclosing table, or 0
+func (l keyLines) line(key string) int {
+	for key != "" {
+		if line, ok := l[key]; ok {
+			return line
+		}
+		cut := strings.LastIndexAny(key, ".[")
+		if cut < 0 {
+			break
+		}
+		key = key[:cut]
+	}
+	return 0
+}
+
+// decodeStrict decodes data into v and returns a problem for every key v has no field for
+func decodeStrict(data []byte, v interface{}) (Problems, error) {
+	dec := toml.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(v)
+	var missing *toml.StrictMissingError
+	if !errors.As(err, &missing) {
+		return nil, err
+	}
+
+	var problems Problems
+	for _, e := range missing.Errors {
+		line, _ := e.Position()
+		key := strings.Join(e.Key(), ".")
+		problems = append(problems, Problem{Line: line, Key: key, Message: fmt.Sprintf("unknown key %q", key)})
+	}
+	return problems, nil
+}
+
+// syntaxProblem turns a TOML syntax error into a problem at its line
+func syntaxProblem(err error) Problems {
+	var decodeErr *toml.DecodeError
+	if errors.As(err, &decodeErr) {
+		line, _ := decodeErr.Position()
+		return Problems{{Line: line, Message: fmt.Sprintf("invalid TOML: %v", decodeErr)}}
+	}
+	return Problems{{Message: err.Error()}}
+}
+
+// checkURL adds a problem unless value is an absolute URL with one of schemes
+func (p *Problems) checkURL(lines keyLines, key, value string, schemes ...string) {
+	u, err := url.Parse(value)
+	if err != nil {
+		p.add(lines, key, "%s is not a valid URL: %v", key, err)
+		return
+	}
+	for _, scheme := range schemes {
+		if u.Scheme == scheme && u.Host != "" {
+			return
+		}
+	}
+	p.add(lines, key, "%s must be an absolute %s URL, got %q", key, strings.Join(schemes, " or "), value)
+}
+
+// checkValidity adds a problem unless hours is within 1 and maxCertValidityHours
+func (p *Problems) checkValidity(lines keyLines, key string, hours int) {
+	if hours < 1 || hours > maxCertValidityHours {
+		p.add(lines, key, "%s must be between 1 and %d, got %d", key, maxCertValidityHours, hours)
+	}
+}
+
+// checkKeyFile adds a problem if the private key at path can be read by other users
+// Like OpenSSH, a CA key readable by group or others is refused
+func (p *Problems) checkKeyFile(lines keyLines, key, path string) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return // Reported when the key is read
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		p.add(lines, key, "%s %s is accessible by group or others (mode %04o); chmod 600 it", key, path, perm)
+	}
+}
+
+// LintServerConfig loads the cassh-server config at path, applying env vars as the server would,
+// and returns every problem in it, including those found by checks
+func LintServerConfig(path string, checks ...func(*ServerConfig) Problems) (Problems, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+
+	config, unknown, err := loadServerConfig(path)
+	if err != nil {
+		return syntaxProblem(err), nil
+	}
+	problems := append(unknown, config.problems()...)
+	for _, check := range checks {
+		problems = append(problems, check(config)...)
+	}
+	return problems.ByLine(), nil
+}
+
+// LintPolicy returns every problem in the policy file at path
+// Keys cassh-server reads are allowed, since one file often configures both
+func LintPolicy(path string) (Problems, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &ServerConfig{sources: map[string]string{}}
+	problems, err := decodeServerFile(data, server)
+	if err != nil {
+		return syntaxProblem(err), nil
+	}
+
+	lines := server.lines
+	if err := checkLocalPolicy(data); err != nil {
+		problems.add(lines, "policy_signature", "%v", err)
+	}
+
+	policy, err := parsePolicy(data)
+	if err != nil {
+		return append(problems, Problem{Message: err.Error()}), nil
+	}
+	return append(problems, policy.problems(lines)...).ByLine(), nil
+}
+
+// LintUserConfig returns every problem in the user config at path
+func LintUserConfig(path string) (Problems, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	user := DefaultUserConfig()
+	problems, err := decodeStrict(data, &user)
+	if err != nil {
+		return syntaxProblem(err), nil
+	}
+	return append(problems, user.problems(indexKeyLines(data))...).ByLine(), nil
+}
+
+func (p *PolicyConfig) problems(lines keyLines) Problems {
+	var problems Problems
+	if p.ServerBaseURL != "" {
+		problems.checkURL(lines, "server_base_url", p.ServerBaseURL, "https", "http")
+	}
+	if p.GitHubEnterpriseURL != "" {
+		problems.checkURL(lines, "github_enterprise_url", p.GitHubEnterpriseURL, "https", "http")
+	}
+	if p.CertValidityHours != 0 {
+		problems.checkValidity(lines, "cert_validity_hours", p.CertValidityHours)
+	}
+	if p.CAPublicKey != "" {
+		if _, err := ca.ParsePublicKey([]byte(p.CAPublicKey)); err != nil {
+			problems.add(lines, "ca_public_key", "ca_public_key is not a valid public key: %v", err)
+		}
+	}
+	if p.KeyIDFormat != "" {
+		if _, err := ca.ParseKeyIDFormat(p.KeyIDFormat); err != nil {
+			problems.add(lines, "key_id_format", "%v", err)
+		}
+	}
+	for _, fingerprint := range splitList(p.CAKeyFingerprint) {
+		if !strings.HasPrefix(fingerprint, "SHA256:") {
+			problems.add(lines, "ca_key_fingerprint", "ca_key_fingerprint %q is not a SHA256 fingerprint (SHA256:...)", fingerprint)
+		}
+	}
+	return problems
+}
+
+func (u *UserConfig) problems(lines keyLines) Problems {
+	var problems Problems
+	if u.RefreshIntervalSeconds < 0 {
+		problems.add(lines, "refresh_interval_seconds", "refresh_interval_seconds must not be negative")
+	}
+
+	ids := map[string]int{}
+	paths := map[string]string{} // Cleaned key or cert path -> the key that claimed it
+	for i, conn := range u.Connections {
+		key := fmt.Sprintf("connections[%d]", i)
+
+		switch {
+		case conn.ID == "":
+			problems.add(lines, key, "%s: id is required", key)
+		case ids[conn.ID] > 0:
+			problems.add(lines, key+".id", "%s: duplicate connection id %q (also connections[%d])", key, conn.ID, ids[conn.ID]-1)
+		default:
+			ids[conn.ID] = i + 1
+		}
+
+		switch conn.Type {
+		case ConnectionTypeEnterprise:
+			if conn.ServerURL == "" {
+				problems.add(lines, key, "%s: enterprise connections require server_url", key)
+			} else {
+				problems.checkURL(lines, key+".server_url", conn.ServerURL, "https", "http")
+			}
+		case ConnectionTypePersonal:
+		default:
+			problems.add(lines, key+".type", "%s: unknown type %q (use enterprise or personal)", key, conn.Type)
+		}
+		if conn.KeyRotationHours < 0 || conn.KeyRotationHours > maxKeyRotationHours {
+			problems.add(lines, key+".key_rotation_hours", "%s: key_rotation_hours must be between 0 (disabled) and %d", key, maxKeyRotationHours)
+		}
+
+		// Two connections sharing a key file would overwrite each other's keys and certs
+		for _, field := range []struct{ name, path string }{
+			{"ssh_key_path", conn.SSHKeyPath},
+			{"ssh_cert_path", conn.SSHCertPath},
+		} {
+			if field.path == "" {
+				if field.name == "ssh_key_path" {
+					problems.add(lines, key, "%s: ssh_key_path is required", key)
+				}
+				continue
+			}
+			fieldKey := key + "." + field.name
+			path := filepath.Clean(field.path)
+			if other, ok := paths[path]; ok {
+				problems.add(lines, fieldKey, "%s %s is also used by %s", fieldKey, field.path, other)
+				continue
+			}
+			paths[path] = fieldKey
+		}
+	}
+	return problems
+}
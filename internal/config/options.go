@@ -0,0 +1,450 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/shawntz/cassh/internal/ca"
+	"github.com/shawntz/cassh/internal/hosts"
+	"github.com/shawntz/cassh/internal/policy"
+	"github.com/shawntz/cassh/internal/workload"
+)
+
+// Where a setting's value came from; settings from env vars record the variable's name
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+)
+
+// serverOption is one cassh-server setting: where it lives in the config file, its env var and default
+type serverOption struct {
+	key       string // Dotted TOML key; empty for env-only settings
+	env       string // CASSH_* override
+	field     string // ServerConfig field, dotted for nested structs
+	def       string // Default, in env var syntax
+	secret    bool   // Redacted by --print-config
+	multiline bool   // Env value may escape newlines as \n; lists take one item per line rather than commas
+}
+
+// serverOptions are every scalar and list setting of cassh-server
+// Named and repeated sections (cas, templates, issuance.rules, ...) are file-only, see serverSections
+var serverOptions = []serverOption{
+	{key: "listen_addr", env: "CASSH_LISTEN_ADDR", field: "ListenAddr", def: ":8080"},
+	{key: "server_base_url", env: "CASSH_SERVER_URL", field: "ServerBaseURL"},
+	{key: "cert_validity_hours", env: "CASSH_CERT_VALIDITY_HOURS", field: "CertValidityHours", def: "12"},
+	{key: "key_id_format", env: "CASSH_KEY_ID_FORMAT", field: "KeyIDFormat", def: ca.DefaultKeyIDFormat},
+	{key: "dev_mode", env: "CASSH_DEV_MODE", field: "DevMode"},
+	{key: "trust_proxy_headers", env: "CASSH_TRUST_PROXY_HEADERS", field: "TrustProxyHeaders"},
+	{key: "rate_limit.auth_per_minute", env: "CASSH_RATE_LIMIT_AUTH_PER_MINUTE", field: "RateLimitAuthPerMinute", def: "60"},
+	{key: "rate_limit.auth_burst", env: "CASSH_RATE_LIMIT_AUTH_BURST", field: "RateLimitAuthBurst", def: "20"},
+	{key: "rate_limit.sign_per_minute", env: "CASSH_RATE_LIMIT_SIGN_PER_MINUTE", field: "RateLimitSignPerMinute", def: "10"},
+	{key: "rate_limit.sign_burst", env: "CASSH_RATE_LIMIT_SIGN_BURST", field: "RateLimitSignBurst", def: "20"},
+
+	{key: "oidc.issuer", env: "CASSH_OIDC_ISSUER", field: "OIDCIssuer"},
+	{key: "oidc.client_id", env: "CASSH_OIDC_CLIENT_ID", field: "OIDCClientID"},
+	{key: "oidc.client_secret", env: "CASSH_OIDC_CLIENT_SECRET", field: "OIDCClientSecret", secret: true},
+	{key: "oidc.tenant", env: "CASSH_OIDC_TENANT", field: "OIDCTenant"},
+	{key: "oidc.redirect_url", env: "CASSH_OIDC_REDIRECT_URL", field: "OIDCRedirectURL"},
+	{key: "oidc.scopes", env: "CASSH_OIDC_SCOPES", field: "OIDCScopes"},
+	{key: "oidc.native_client_id", env: "CASSH_OIDC_NATIVE_CLIENT_ID", field: "OIDCNativeClientID"},
+	{key: "oidc.claims.email", env: "CASSH_OIDC_EMAIL_CLAIM", field: "OIDCEmailClaim"},
+	{key: "oidc.claims.name", env: "CASSH_OIDC_NAME_CLAIM", field: "OIDCNameClaim"},
+	{key: "oidc.claims.username", env: "CASSH_OIDC_USERNAME_CLAIM", field: "OIDCUsernameClaim"},
+	{key: "oidc.state.driver", env: "CASSH_OIDC_STATE_DRIVER", field: "OIDCStateDriver"},
+	{key: "oidc.state.path", env: "CASSH_OIDC_STATE_PATH", field: "OIDCStatePath"},
+	{key: "oidc.state.url", env: "CASSH_OIDC_STATE_URL", field: "OIDCStateURL"},
+	{key: "oidc.state.cookie_key", env: "CASSH_OIDC_STATE_COOKIE_KEY", field: "OIDCStateCookieKey", secret: true},
+	{key: "oidc.state.max_pending", env: "CASSH_OIDC_STATE_MAX_PENDING", field: "OIDCStateMaxPending", def: "10000"},
+	{key: "device.max_pending", env: "CASSH_DEVICE_MAX_PENDING", field: "DeviceMaxPending", def: "10000"},
+
+	{env: "CASSH_CA_PRIVATE_KEY", field: "CAPrivateKey", secret: true, multiline: true},
+	{key: "ca.private_key_path", env: "CASSH_CA_PRIVATE_KEY_PATH", field: "CAPrivateKeyPath"},
+	{key: "ca.signer", env: "CASSH_CA_SIGNER", field: "CASigner"},
+	{key: "ca.pkcs11.module", env: "CASSH_CA_PKCS11_MODULE", field: "CAPKCS11.Module"},
+	{key: "ca.pkcs11.token_label", env: "CASSH_CA_PKCS11_TOKEN_LABEL", field: "CAPKCS11.TokenLabel"},
+	{key: "ca.pkcs11.key_label", env: "CASSH_CA_PKCS11_KEY_LABEL", field: "CAPKCS11.KeyLabel"},
+	{key: "ca.pkcs11.pin", env: "CASSH_CA_PKCS11_PIN", field: "CAPKCS11.PIN", secret: true},
+	{key: "ca.agent.socket", env: "CASSH_CA_AGENT_SOCKET", field: "CAAgentSocket"},
+	{key: "ca.agent.public_key", env: "CASSH_CA_AGENT_PUBLIC_KEY", field: "CAAgentPublicKey"},
+	{key: "ca.next_public_keys", env: "CASSH_CA_NEXT_PUBLIC_KEYS", field: "CANextPublicKeys", multiline: true},
+	{key: "ca.retired_public_keys", env: "CASSH_CA_RETIRED_PUBLIC_KEYS", field: "CARetiredPublicKeys", multiline: true},
+
+	{key: "github.enterprise_url", env: "CASSH_GITHUB_ENTERPRISE_URL", field: "GitHubEnterpriseURL"},
+	{key: "github.allowed_orgs", env: "CASSH_GITHUB_ALLOWED_ORGS", field: "GitHubAllowedOrgs"},
+	{key: "github.principal_source", env: "CASSH_GITHUB_PRINCIPAL_SOURCE", field: "GitHubPrincipalSource"},
+	{key: "github.principal_mapping_path", env: "CASSH_GITHUB_PRINCIPAL_MAPPING_PATH", field: "GitHubPrincipalMappingPath"},
+	{key: "github.scim_enterprise", env: "CASSH_GITHUB_SCIM_ENTERPRISE", field: "GitHubSCIMEnterprise"},
+	{key: "github.scim_org", env: "CASSH_GITHUB_SCIM_ORG", field: "GitHubSCIMOrg"},
+	{key: "github.api_url", env: "CASSH_GITHUB_API_URL", field: "GitHubAPIURL"},
+	{key: "github.token", env: "CASSH_GITHUB_TOKEN", field: "GitHubToken", secret: true},
+	{key: "github.app_id", env: "CASSH_GITHUB_APP_ID", field: "GitHubAppID"},
+	{key: "github.app_installation_id", env: "CASSH_GITHUB_APP_INSTALLATION_ID", field: "GitHubAppInstallationID"},
+	{env: "CASSH_GITHUB_APP_PRIVATE_KEY", field: "GitHubAppPrivateKey", secret: true, multiline: true},
+	{key: "github.app_private_key_path", env: "CASSH_GITHUB_APP_PRIVATE_KEY_PATH", field: "GitHubAppPrivateKeyPath"},
+	{key: "github.cache_seconds", env: "CASSH_GITHUB_CACHE_SECONDS", field: "GitHubCacheSeconds", def: "300"},
+
+	{key: "issuance.groups_claim", env: "CASSH_ISSUANCE_GROUPS_CLAIM", field: "Issuance.GroupsClaim"},
+	{key: "issuance.roles_claim", env: "CASSH_ISSUANCE_ROLES_CLAIM", field: "Issuance.RolesClaim"},
+	{key: "issuance.default_action", env: "CASSH_ISSUANCE_DEFAULT_ACTION", field: "Issuance.DefaultAction"},
+	{key: "issuance.default_template", env: "CASSH_ISSUANCE_DEFAULT_TEMPLATE", field: "Issuance.DefaultTemplate"},
+	{key: "issuance.default_ca", env: "CASSH_ISSUANCE_DEFAULT_CA", field: "Issuance.DefaultCA"},
+	{key: "hosts.validity_hours", env: "CASSH_HOSTS_VALIDITY_HOURS", field: "Hosts.ValidityHours"},
+	{key: "hosts.ca", env: "CASSH_HOSTS_CA", field: "Hosts.CA"},
+	{key: "hosts.key_id_format", env: "CASSH_HOSTS_KEY_ID_FORMAT", field: "HostKeyIDFormat", def: ca.DefaultHostKeyIDFormat},
+	{key: "workload.key_id_format", env: "CASSH_WORKLOAD_KEY_ID_FORMAT", field: "WorkloadKeyIDFormat", def: ca.DefaultWorkloadKeyIDFormat},
+
+	{key: "client_policy.path", env: "CASSH_CLIENT_POLICY_PATH", field: "ClientPolicyPath"},
+	{key: "client_policy.signing_key", env: "CASSH_CLIENT_POLICY_SIGNING_KEY", field: "ClientPolicySigningKey"},
+	{key: "store.driver", env: "CASSH_STORE_DRIVER", field: "StoreDriver"},
+	{key: "store.path", env: "CASSH_STORE_PATH", field: "StorePath"},
+	{key: "admin.token", env: "CASSH_ADMIN_TOKEN", field: "AdminToken", secret: true},
+	{key: "audit.sink", env: "CASSH_AUDIT_SINK", field: "AuditSink"},
+	{key: "audit.path", env: "CASSH_AUDIT_PATH", field: "AuditPath"},
+}
+
+// serverSections are the named and repeated config file sections, decoded as a whole
+type serverSections struct {
+	CAs      map[string]CAConfig `toml:"cas"`
+	Issuance struct {
+		Rules []policy.Rule `toml:"rules"`
+	} `toml:"issuance"`
+	Templates map[string]ca.Template `toml:"templates"`
+	Workload  struct {
+		Issuers []workload.IssuerConfig `toml:"issuers"`
+	} `toml:"workload"`
+	Hosts struct {
+		Machines []hosts.Machine `toml:"machines"`
+	} `toml:"hosts"`
+}
+
+// sectionKeys are the keys serverSections decodes
+var sectionKeys = []string{"cas", "issuance.rules", "templates", "workload.issuers", "hosts.machines"}
+
+func inSection(key string) bool {
+	for _, section := range sectionKeys {
+		if key == section || strings.HasPrefix(key, section+".") || strings.HasPrefix(key, section+"[") {
+			return true
+		}
+	}
+	return false
+}
+
+// clientPolicyKeys are the PolicyConfig keys, allowed in the server file so one file can configure both
+var clientPolicyKeys = func() map[string]bool {
+	keys := map[string]bool{}
+	t := reflect.TypeOf(PolicyConfig{})
+	for i := 0; i < t.NumField(); i++ {
+		if tag, _, _ := strings.Cut(t.Field(i).Tag.Get("toml"), ","); tag != "" && tag != "-" {
+			keys[tag] = true
+		}
+	}
+	return keys
+}()
+
+// shadowedClientKeys maps client policy keys to the cassh-server option they look like
+// cassh-server ignores them, so a flat oidc_issuer alone leaves it without OIDC, in dev mode
+var shadowedClientKeys = map[string]string{
+	"oidc_issuer":           "oidc.issuer",
+	"oidc_client_id":        "oidc.client_id",
+	"oidc_tenant_id":        "oidc.tenant",
+	"github_enterprise_url": "github.enterprise_url",
+}
+
+// flatServerKeys maps flat spellings of table options (e.g. ca_private_key_path) to their dotted keys
+var flatServerKeys = func() map[string]string {
+	keys := map[string]string{}
+	for _, opt := range serverOptions {
+		if flat := strings.ReplaceAll(opt.key, ".", "_"); flat != opt.key {
+			keys[flat] = opt.key
+		}
+	}
+	return keys
+}()
+
+// shadowProblems returns a problem for every shadowed client key in the file whose server option isn't set
+// Run it once env vars are applied; a file that also sets the server option configures both
+func (c *ServerConfig) shadowProblems() Problems {
+	clientKeys := make([]string, 0, len(shadowedClientKeys))
+	for key := range shadowedClientKeys {
+		clientKeys = append(clientKeys, key)
+	}
+	sort.Strings(clientKeys)
+
+	var problems Problems
+	for _, key := range clientKeys {
+		serverKey := shadowedClientKeys[key]
+		if _, ok := c.lines[key]; !ok {
+			continue
+		}
+		if source := c.sources[serverKey]; source != "" && source != SourceDefault {
+			continue
+		}
+		problems.add(c.lines, key, "%s is a client policy key that cassh-server ignores; set %s for the server", key, serverKey)
+	}
+	return problems
+}
+
+func (o serverOption) name() string {
+	if o.key != "" {
+		return o.key
+	}
+	return o.env
+}
+
+// value returns the ServerConfig field o sets
+func (o serverOption) value(config *ServerConfig) reflect.Value {
+	v := reflect.ValueOf(config).Elem()
+	for _, name := range strings.Split(o.field, ".") {
+		v = v.FieldByName(name)
+	}
+	return v
+}
+
+// parse sets o from an env var or default
+func (o serverOption) parse(config *ServerConfig, s string) error {
+	v := o.value(config)
+	switch v.Kind() {
+	case reflect.String:
+		if o.multiline {
+			// Handle escaped newlines from cloud platform env vars
+			s = strings.ReplaceAll(s, "\\n", "\n")
+		}
+		v.SetString(s)
+	case reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number", s)
+		}
+		v.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", s)
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		if o.multiline {
+			v.Set(reflect.ValueOf(splitLines(s)))
+		} else {
+			v.Set(reflect.ValueOf(splitList(s)))
+		}
+	}
+	return nil
+}
+
+// set sets o from a decoded TOML value
+func (o serverOption) set(config *ServerConfig, value interface{}) error {
+	v := o.value(config)
+	switch v.Kind() {
+	case reflect.String:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s must be a string", o.key)
+		}
+		v.SetString(s)
+	case reflect.Int:
+		n, ok := value.(int64)
+		if !ok {
+			return fmt.Errorf("%s must be an integer", o.key)
+		}
+		v.SetInt(n)
+	case reflect.Bool:
+		b, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("%s must be true or false", o.key)
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		list, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be a list of strings", o.key)
+		}
+		items := make([]string, len(list))
+		for i, item := range list {
+			if items[i], ok = item.(string); !ok {
+				return fmt.Errorf("%s must be a list of strings", o.key)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	}
+	return nil
+}
+
+// display formats o's value for --print-config, redacting secrets and URL passwords
+func (o serverOption) display(config *ServerConfig) string {
+	v := o.value(config)
+	switch {
+	case v.IsZero():
+		return ""
+	case o.secret:
+		return "<redacted>"
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return redactURL(v.String())
+	case reflect.Slice:
+		return strings.Join(v.Interface().([]string), ", ")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); !ok {
+		return s
+	}
+	return u.Redacted()
+}
+
+// decodeServerFile sets config from the server config file in data
+// It returns a problem for every unknown key and every value of the wrong type
+func decodeServerFile(data []byte, config *ServerConfig) (Problems, error) {
+	var raw map[string]interface{}
+	if err := toml.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	var sections serverSections
+	sectionProblems, err := decodeStrict(data, &sections)
+	if err != nil {
+		return nil, err
+	}
+	config.lines = indexKeyLines(data)
+	config.CAs = sections.CAs
+	config.Issuance.Rules = sections.Issuance.Rules
+	config.Templates = sections.Templates
+	config.WorkloadIssuers = sections.Workload.Issuers
+	config.Hosts.Machines = sections.Hosts.Machines
+
+	// serverSections rejects every key outside the sections; only its complaints about keys inside them count
+	var problems Problems
+	for _, problem := range sectionProblems {
+		if inSection(problem.Key) {
+			problems = append(problems, problem)
+		}
+	}
+
+	options := map[string]serverOption{}
+	for _, opt := range serverOptions {
+		if opt.key != "" {
+			options[opt.key] = opt
+		}
+	}
+	walkKeys(raw, "", func(key string, value interface{}) {
+		if opt, ok := options[key]; ok {
+			if err := opt.set(config, value); err != nil {
+				problems.add(config.lines, key, "%v", err)
+				return
+			}
+			config.sources[key] = SourceFile
+			return
+		}
+		switch {
+		case inSection(key), clientPolicyKeys[key]:
+		case flatServerKeys[key] != "":
+			problems.add(config.lines, key, "unknown key %q, did you mean %s?", key, flatServerKeys[key])
+		default:
+			problems.add(config.lines, key, "unknown key %q", key)
+		}
+	})
+	return problems, nil
+}
+
+// walkKeys calls fn with the dotted key of every value in table, descending into subtables other than sections
+func walkKeys(table map[string]interface{}, prefix string, fn func(key string, value interface{})) {
+	names := make([]string, 0, len(table))
+	for name := range table {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		key := prefix + name
+		if sub, ok := table[name].(map[string]interface{}); ok && !inSection(key) {
+			walkKeys(sub, key+".", fn)
+			continue
+		}
+		fn(key, table[name])
+	}
+}
+
+// ServerConfigPath returns the cassh-server config file: CASSH_POLICY_PATH, or ./cassh.policy.toml if present
+func ServerConfigPath() string {
+	if path := os.Getenv("CASSH_POLICY_PATH"); path != "" {
+		return path
+	}
+	if _, err := os.Stat("cassh.policy.toml"); err == nil {
+		return "cassh.policy.toml"
+	}
+	return ""
+}
+
+// Setting is one effective cassh-server setting, as shown by --print-config
+type Setting struct {
+	Key    string // Dotted TOML key, or the env var for env-only settings
+	Env    string // Env var that overrides it; empty for file-only sections
+	Value  string // Secrets and URL passwords redacted
+	Source string // SourceDefault, SourceFile, the env var or key file that set it, or empty if unset
+	Line   int    // Line in the config file, for SourceFile
+}
+
+// Settings lists every setting with its effective value and where it came from
+// Named and repeated sections are summarized by their names
+func (c *ServerConfig) Settings() []Setting {
+	var settings []Setting
+	for _, opt := range serverOptions {
+		setting := Setting{Key: opt.name(), Env: opt.env, Value: opt.display(c), Source: c.sources[opt.name()]}
+		if setting.Source == SourceFile {
+			setting.Line = c.lines.line(opt.key)
+		}
+		settings = append(settings, setting)
+	}
+
+	caNames := make([]string, 0, len(c.CAs))
+	for name := range c.CAs {
+		caNames = append(caNames, name)
+	}
+	templateNames := make([]string, 0, len(c.Templates))
+	for name := range c.Templates {
+		templateNames = append(templateNames, name)
+	}
+	var ruleNames, issuerNames, machineNames []string
+	for _, rule := range c.Issuance.Rules {
+		ruleNames = append(ruleNames, rule.Name)
+	}
+	for _, issuer := range c.WorkloadIssuers {
+		issuerNames = append(issuerNames, issuer.Name)
+	}
+	for _, machine := range c.Hosts.Machines {
+		machineNames = append(machineNames, machine.Name)
+	}
+
+	for _, section := range []struct {
+		key   string
+		names []string
+	}{
+		{"cas", caNames},
+		{"issuance.rules", ruleNames},
+		{"templates", templateNames},
+		{"workload.issuers", issuerNames},
+		{"hosts.machines", machineNames},
+	} {
+		setting := Setting{Key: section.key}
+		if len(section.names) > 0 {
+			sort.Strings(section.names)
+			setting.Value = strings.Join(section.names, ", ")
+			setting.Source = SourceFile
+		}
+		settings = append(settings, setting)
+	}
+	return settings
+}
@@ -0,0 +1,174 @@
+package config
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/shawntz/cassh/internal/sshsig"
+	"golang.org/x/crypto/ssh"
+)
+
+// PolicySignatureNamespace is the SSHSIG namespace policy signatures are made in
+const PolicySignatureNamespace = "cassh-policy"
+
+// PolicySigningKey pins the key bundled policies must be signed with: an authorized-keys line or SHA256 fingerprint
+// Set at build time: go build -ldflags "-X github.com/shawntz/cassh/internal/config.PolicySigningKey=SHA256:..."
+// Builds without it (OSS, development) accept unsigned policies, but not a signature that doesn't verify
+var PolicySigningKey string
+
+// ErrPolicySignature is returned when a policy's signature is missing or doesn't verify against the pinned key
+var ErrPolicySignature = errors.New("policy integrity check failed - contact IT")
+
+const policySignatureKey = "policy_signature"
+
+// CanonicalPolicy returns the bytes a policy signature covers: every setting in the file except
+// policy_signature, as JSON with sorted keys, so comments and formatting can change but values can't
+func CanonicalPolicy(data []byte) ([]byte, error) {
+	var doc map[string]interface{}
+	if err := toml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file: %w", err)
+	}
+	delete(doc, policySignatureKey)
+
+	canonical, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to canonicalize policy: %w", err)
+	}
+	return canonical, nil
+}
+
+// SignPolicy signs the policy file with signer and returns it with policy_signature set
+// The signature is a base64 SSHSIG blob; any existing signature is replaced
+func SignPolicy(data []byte, signer ssh.Signer) ([]byte, error) {
+	canonical, err := CanonicalPolicy(data)
+	if err != nil {
+		return nil, err
+	}
+
+	armored, err := sshsig.Sign(signer, PolicySignatureNamespace, canonical)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(armored)
+	line := fmt.Sprintf("%s = %q", policySignatureKey, base64.StdEncoding.EncodeToString(block.Bytes))
+
+	signed := setTopLevelLine(data, line)
+
+	// Make sure the edit didn't land somewhere TOML reads differently
+	if _, err := VerifyPolicyIntegrity(signed, ssh.FingerprintSHA256(signer.PublicKey())); err != nil {
+		return nil, fmt.Errorf("failed to embed signature: %w", err)
+	}
+	return signed, nil
+}
+
+// VerifyPolicyIntegrity checks the policy file's signature against pinnedKey
+// pinnedKey is an authorized-keys line or SHA256 fingerprint; returns the key that signed the policy
+func VerifyPolicyIntegrity(data []byte, pinnedKey string) (ssh.PublicKey, error) {
+	signedBy, err := checkPolicySignature(data)
+	if err != nil {
+		return nil, err
+	}
+	if signedBy == nil {
+		return nil, fmt.Errorf("%w: policy is not signed", ErrPolicySignature)
+	}
+
+	if !matchesPinnedKey(signedBy, pinnedKey) {
+		return nil, fmt.Errorf("%w: signed by untrusted key %s", ErrPolicySignature, ssh.FingerprintSHA256(signedBy))
+	}
+	return signedBy, nil
+}
+
+// checkPolicySignature verifies policy_signature against the policy, trusting whichever key made it
+// It returns a nil key for an unsigned policy, so builds without a pinned key still catch edits to a signed one
+func checkPolicySignature(data []byte) (ssh.PublicKey, error) {
+	var file struct {
+		PolicySignature string `toml:"policy_signature"`
+	}
+	if err := toml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file: %w", err)
+	}
+	if file.PolicySignature == "" {
+		return nil, nil
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(file.PolicySignature)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid signature encoding", ErrPolicySignature)
+	}
+
+	canonical, err := CanonicalPolicy(data)
+	if err != nil {
+		return nil, err
+	}
+
+	armored := pem.EncodeToMemory(&pem.Block{Type: "SSH SIGNATURE", Bytes: blob})
+	signedBy, err := sshsig.Verify(armored, PolicySignatureNamespace, canonical)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPolicySignature, err)
+	}
+	return signedBy, nil
+}
+
+// checkLocalPolicy verifies the policy as LoadPolicy does: against PolicySigningKey when the build pins one,
+// otherwise only a signature that's present
+func checkLocalPolicy(data []byte) error {
+	if PolicySigningKey != "" {
+		_, err := VerifyPolicyIntegrity(data, PolicySigningKey)
+		return err
+	}
+	_, err := checkPolicySignature(data)
+	return err
+}
+
+func matchesPinnedKey(key ssh.PublicKey, pinned string) bool {
+	pinned = strings.TrimSpace(pinned)
+	if pinned == "" {
+		return false
+	}
+	if strings.HasPrefix(pinned, "SHA256:") {
+		return ssh.FingerprintSHA256(key) == pinned
+	}
+	pinnedKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(pinned))
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(pinnedKey.Marshal(), key.Marshal())
+}
+
+// setTopLevelLine replaces the top-level policy_signature line, or adds line above the first table
+// The comments directly above that table stay with it
+func setTopLevelLine(data []byte, line string) []byte {
+	lines := strings.Split(string(data), "\n")
+
+	firstTable := len(lines)
+	for i, l := range lines {
+		trimmed := strings.TrimSpace(l)
+		if strings.HasPrefix(trimmed, "[") {
+			firstTable = i
+			break
+		}
+		if key, _, ok := strings.Cut(trimmed, "="); ok && strings.TrimSpace(key) == policySignatureKey {
+			lines[i] = line
+			return []byte(strings.Join(lines, "\n"))
+		}
+	}
+
+	if firstTable == len(lines) {
+		// No tables: append, keeping the trailing newline
+		return []byte(strings.TrimRight(string(data), "\n") + "\n" + line + "\n")
+	}
+
+	insert := firstTable
+	for insert > 0 && strings.HasPrefix(strings.TrimSpace(lines[insert-1]), "#") {
+		insert--
+	}
+	out := append(lines[:insert:insert], line, "")
+	out = append(out, lines[insert:]...)
+	return []byte(strings.Join(out, "\n"))
+}
@@ -0,0 +1,200 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PolicyWellKnownPath is where cassh-server publishes the signed client policy
+const PolicyWellKnownPath = "/.well-known/cassh-policy.toml"
+
+// PolicyRefreshInterval is how often long-running clients check the server for a new policy
+const PolicyRefreshInterval = time.Hour
+
+// maxPolicySize caps how much of a server policy response is read
+const maxPolicySize = 1 << 20
+
+// PolicyCachePath returns where the last verified server policy is kept
+func PolicyCachePath() string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		homeDir, _ := os.UserHomeDir()
+		cacheDir = filepath.Join(homeDir, ".cache")
+	}
+	return filepath.Join(cacheDir, "cassh", "policy.toml")
+}
+
+// LoadEffectivePolicy returns the cached server policy, or the bundled one at bundledPath
+// The cache is only used by builds with a pinned signing key, when it verifies and isn't older than the bundle
+func LoadEffectivePolicy(bundledPath string) (*PolicyConfig, error) {
+	bundled, bundledErr := LoadPolicy(bundledPath)
+	if errors.Is(bundledErr, ErrPolicySignature) {
+		return nil, bundledErr
+	}
+
+	cached, err := loadCachedPolicy()
+	if err != nil {
+		return bundled, bundledErr
+	}
+	if bundled != nil && comparePolicyVersions(cached.PolicyVersion, bundled.PolicyVersion) < 0 {
+		return bundled, nil
+	}
+	return cached, nil
+}
+
+// RefreshPolicy fetches the signed policy serverURL publishes and caches it if it verifies
+// Returns true when the cached policy changed; servers that don't publish one are not an error
+func RefreshPolicy(ctx context.Context, serverURL string) (bool, error) {
+	if PolicySigningKey == "" || serverURL == "" {
+		return false, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(serverURL, "/")+PolicyWellKnownPath, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch policy: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("failed to fetch policy: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxPolicySize))
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch policy: %w", err)
+	}
+	return cachePolicy(data)
+}
+
+// cachePolicy verifies data and writes it to the cache, refusing to roll back to an older version
+func cachePolicy(data []byte) (bool, error) {
+	if _, err := VerifyPolicyIntegrity(data, PolicySigningKey); err != nil {
+		return false, err
+	}
+	fetched, err := parsePolicy(data)
+	if err != nil {
+		return false, err
+	}
+
+	cachePath := PolicyCachePath()
+	if existing, err := os.ReadFile(cachePath); err == nil {
+		if bytes.Equal(existing, data) {
+			return false, nil
+		}
+		if cached, err := loadCachedPolicy(); err == nil && comparePolicyVersions(fetched.PolicyVersion, cached.PolicyVersion) < 0 {
+			return false, fmt.Errorf("server policy version %q is older than cached version %q", fetched.PolicyVersion, cached.PolicyVersion)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0700); err != nil {
+		return false, fmt.Errorf("failed to create policy cache directory: %w", err)
+	}
+	// Write then rename so a crash never leaves a half-written cache
+	tmpPath := cachePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return false, fmt.Errorf("failed to write policy cache: %w", err)
+	}
+	if err := os.Rename(tmpPath, cachePath); err != nil {
+		return false, fmt.Errorf("failed to write policy cache: %w", err)
+	}
+	return true, nil
+}
+
+func loadCachedPolicy() (*PolicyConfig, error) {
+	if PolicySigningKey == "" {
+		return nil, fmt.Errorf("no policy signing key pinned")
+	}
+	return LoadPolicy(PolicyCachePath())
+}
+
+// comparePolicyVersions orders policy_version values like "2026.10.1" segment by segment
+// Numeric segments compare as numbers, others as strings; an empty version is the oldest
+func comparePolicyVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	if a == "" {
+		as = nil
+	}
+	if b == "" {
+		bs = nil
+	}
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		if i >= len(as) {
+			return -1
+		}
+		if i >= len(bs) {
+			return 1
+		}
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		switch {
+		case aErr == nil && bErr == nil && an != bn:
+			if an < bn {
+				return -1
+			}
+			return 1
+		case (aErr != nil || bErr != nil) && as[i] != bs[i]:
+			return strings.Compare(as[i], bs[i])
+		}
+	}
+	return 0
+}
+
+// ApplyPolicy points the enterprise connection at the policy's server and GHE host
+// Returns true if the connection changed and the user config should be saved
+func (u *UserConfig) ApplyPolicy(policy *PolicyConfig) bool {
+	conn := u.GetConnection(EnterpriseConnectionID)
+	if conn == nil || policy.ServerBaseURL == "" {
+		return false
+	}
+
+	host := ExtractHostFromURL(policy.GitHubEnterpriseURL)
+	if conn.ServerURL == policy.ServerBaseURL && (host == "" || conn.GitHubHost == host) {
+		return false
+	}
+	conn.ServerURL = policy.ServerBaseURL
+	if host != "" {
+		conn.GitHubHost = host
+	}
+	return true
+}
+
+// RefreshPolicy refreshes the cached server policy and swaps it in if it changed
+// The enterprise connection follows the new policy and is saved if it moved
+func (m *MergedConfig) RefreshPolicy(ctx context.Context, bundledPath string) (bool, error) {
+	m.mu.RLock()
+	serverURL := m.Policy.ServerBaseURL
+	m.mu.RUnlock()
+
+	changed, err := RefreshPolicy(ctx, serverURL)
+	if err != nil || !changed {
+		return false, err
+	}
+	policy, err := LoadEffectivePolicy(bundledPath)
+	if err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Policy = *policy
+	if m.User.ApplyPolicy(policy) {
+		return true, SaveUserConfig(&m.User)
+	}
+	return true, nil
+}
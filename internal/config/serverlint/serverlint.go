@@ -0,0 +1,75 @@
+// Checks cassh-server config that needs the GitHub API client and principal sources to validate
+// Kept out of config so cassh-cli and cassh.app don't link server-only code
+package serverlint
+
+import (
+	"errors"
+	"time"
+
+	"github.com/shawntz/cassh/internal/config"
+	"github.com/shawntz/cassh/internal/github"
+	"github.com/shawntz/cassh/internal/principal"
+)
+
+// GitHubConfig returns the settings for github.New
+func GitHubConfig(c *config.ServerConfig) github.Config {
+	apiURL := c.GitHubAPIURL
+	if apiURL == "" {
+		apiURL = github.APIURL(c.GitHubEnterpriseURL)
+	}
+	return github.Config{
+		APIURL:         apiURL,
+		Token:          c.GitHubToken,
+		AppID:          int64(c.GitHubAppID),
+		InstallationID: int64(c.GitHubAppInstallationID),
+		AppPrivateKey:  []byte(c.GitHubAppPrivateKey),
+		CacheTTL:       time.Duration(c.GitHubCacheSeconds) * time.Second,
+		SCIMEnterprise: c.GitHubSCIMEnterprise,
+		SCIMOrg:        c.GitHubSCIMOrg,
+	}
+}
+
+// Validate runs config's own checks and Check, returning every problem as config.Problems
+func Validate(c *config.ServerConfig) error {
+	var problems config.Problems
+	if err := c.Validate(); err != nil && !errors.As(err, &problems) {
+		return err
+	}
+	return append(problems, Check(c)...).ByLine().Err()
+}
+
+// Lint is config.LintServerConfig with Check
+func Lint(path string) (config.Problems, error) {
+	return config.LintServerConfig(path, Check)
+}
+
+// Check returns problems with GitHub API access and the principal source
+func Check(c *config.ServerConfig) config.Problems {
+	var problems config.Problems
+	if len(c.GitHubAllowedOrgs) > 0 {
+		// The org check fails closed, so credentials that can't work are a config error
+		if _, err := github.New(GitHubConfig(c)); err != nil {
+			problems = append(problems, c.Problem("github.allowed_orgs", "github.allowed_orgs needs GitHub API access: %v (set CASSH_GITHUB_TOKEN or a GitHub App)", err))
+		}
+	}
+
+	switch c.GitHubPrincipalSource {
+	case principal.SourceMapping:
+		if c.GitHubPrincipalMappingPath == "" {
+			problems = append(problems, c.Problem("github.principal_source", "principal_source %q requires github.principal_mapping_path (set CASSH_GITHUB_PRINCIPAL_MAPPING_PATH)", c.GitHubPrincipalSource))
+		} else if _, err := principal.LoadMapping(c.GitHubPrincipalMappingPath); err != nil {
+			problems = append(problems, c.Problem("github.principal_mapping_path", "%v", err))
+		}
+	case principal.SourceSCIM:
+		if c.GitHubSCIMEnterprise == "" && c.GitHubSCIMOrg == "" {
+			problems = append(problems, c.Problem("github.principal_source", "principal_source %q requires github.scim_enterprise or github.scim_org", c.GitHubPrincipalSource))
+		} else if _, err := github.New(GitHubConfig(c)); err != nil {
+			problems = append(problems, c.Problem("github.principal_source", "principal_source %q needs GitHub API access: %v (set CASSH_GITHUB_TOKEN or a GitHub App)", c.GitHubPrincipalSource, err))
+		}
+	default:
+		if _, err := principal.New(principal.Config{Source: c.GitHubPrincipalSource}, nil); err != nil {
+			problems = append(problems, c.Problem("github.principal_source", "%v", err))
+		}
+	}
+	return problems
+}
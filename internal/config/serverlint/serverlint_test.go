@@ -0,0 +1,145 @@
+package serverlint
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shawntz/cassh/internal/config"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  config.ServerConfig
+		wantErr bool
+	}{
+		{
+			name: "Allowed orgs without GitHub credentials",
+			config: config.ServerConfig{
+				GitHubAllowedOrgs: []string{"eng"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Allowed orgs with a GitHub token",
+			config: config.ServerConfig{
+				GitHubAllowedOrgs: []string{"eng"},
+				GitHubToken:       "ghp_test",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Allowed orgs with an incomplete GitHub App",
+			config: config.ServerConfig{
+				GitHubAllowedOrgs: []string{"eng"},
+				GitHubAppID:       1234,
+			},
+			wantErr: true,
+		},
+		{
+			name: "Mapping principal source without a file",
+			config: config.ServerConfig{
+				GitHubPrincipalSource: "mapping",
+			},
+			wantErr: true,
+		},
+		{
+			name: "SCIM principal source without an enterprise or org",
+			config: config.ServerConfig{
+				GitHubPrincipalSource: "scim",
+				GitHubToken:           "ghp_test",
+			},
+			wantErr: true,
+		},
+		{
+			name: "SCIM principal source",
+			config: config.ServerConfig{
+				GitHubPrincipalSource: "scim",
+				GitHubSCIMEnterprise:  "corp",
+				GitHubToken:           "ghp_test",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Custom claim principal source",
+			config: config.ServerConfig{
+				GitHubPrincipalSource: "github_login",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Invalid principal source template",
+			config: config.ServerConfig{
+				GitHubPrincipalSource: "{claim.upn | shout}",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Field check in config",
+			config: config.ServerConfig{
+				RateLimitSignBurst: -1,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.ServerBaseURL = "https://cassh.example.com"
+			tt.config.DevMode = true
+			err := Validate(&tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLint(t *testing.T) {
+	tmpDir := t.TempDir()
+	mappingPath := filepath.Join(tmpDir, "logins.csv")
+	if err := os.WriteFile(mappingPath, []byte("alice@example.com,alice\nbob@example.com,not a login\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(tmpDir, "cassh.policy.toml")
+	configContent := `server_base_url = "https://cassh.example.com"
+dev_mode = true
+cert_validity_hours = 0
+
+[github]
+allowed_orgs = ["eng"]
+principal_source = "mapping"
+principal_mapping_path = "` + mappingPath + `"
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"CASSH_SERVER_URL", "CASSH_CERT_VALIDITY_HOURS", "CASSH_GITHUB_TOKEN", "CASSH_GITHUB_APP_ID", "CASSH_GITHUB_ALLOWED_ORGS", "CASSH_GITHUB_PRINCIPAL_SOURCE", "CASSH_GITHUB_PRINCIPAL_MAPPING_PATH"} {
+		t.Setenv(v, "")
+		_ = os.Unsetenv(v)
+	}
+
+	problems, err := Lint(configPath)
+	if err != nil {
+		t.Fatalf("Lint() error = %v", err)
+	}
+
+	// config's own checks and these are reported together, in line order
+	want := []struct {
+		key  string
+		line int
+	}{
+		{"cert_validity_hours", 3},
+		{"github.allowed_orgs", 6},
+		{"github.principal_mapping_path", 8},
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("Lint() = %v, want %d problems", problems, len(want))
+	}
+	for i, p := range problems {
+		if p.Key != want[i].key || p.Line != want[i].line {
+			t.Errorf("problem %d = %+v, want %s on line %d", i, p, want[i].key, want[i].line)
+		}
+	}
+}
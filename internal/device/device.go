@@ -0,0 +1,296 @@
+// Implements the OAuth 2.0 device authorization grant (RFC 8628) for cert issuance
+// A headless client starts an authorization with its public key, the user approves it in any
+// browser by entering the user code, and the client polls for the signed cert
+package device
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Poll errors; the messages are the RFC 8628 error codes returned to clients
+var (
+	ErrAuthorizationPending = errors.New("authorization_pending")
+	ErrSlowDown             = errors.New("slow_down")
+	ErrAccessDenied         = errors.New("access_denied")
+	ErrExpiredToken         = errors.New("expired_token")
+	ErrInvalidGrant         = errors.New("invalid_grant") // Unknown or already-redeemed device code
+)
+
+// ErrTooManyPending is returned by Start while the manager's cap of pending authorizations is reached
+var ErrTooManyPending = errors.New("too many pending device authorizations")
+
+// Defaults for NewManager
+const (
+	DefaultExpiry   = 10 * time.Minute
+	DefaultInterval = 5 * time.Second
+)
+
+// User codes use consonants only (no vowels means no accidental words) and are
+// case-insensitive, as RFC 8628 section 6.1 recommends
+const (
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+)
+
+// Request is what the client asked for when it started the authorization
+type Request struct {
+	PubKey   string // User's SSH public key
+	Template string // Requested cert template (optional)
+	Target   string // Requested target, e.g. a GHE org (optional)
+	ClientIP string // Where the request came from, shown to the approving user
+
+	// What the client reported about itself, for the cert's key ID
+	DeviceName    string
+	ClientVersion string
+}
+
+// Authorization is a pending device authorization
+type Authorization struct {
+	DeviceCode string // Secret polled by the client
+	UserCode   string // Short code the user enters in the browser (e.g., "BDFG-HJKL")
+	Request    Request
+	ExpiresAt  time.Time
+	Interval   time.Duration // Minimum time between polls
+
+	cert     string
+	denied   string
+	lastPoll time.Time
+}
+
+// Manager holds pending device authorizations in memory
+type Manager struct {
+	mu       sync.Mutex
+	byDevice map[string]*Authorization
+	byUser   map[string]*Authorization
+	expiry   time.Duration
+	interval time.Duration
+	max      int // Pending authorizations Start allows (0 = no cap)
+	now      func() time.Time
+}
+
+// NewManager creates a manager whose authorizations expire after expiry and may be polled every interval
+// Zero values use DefaultExpiry and DefaultInterval; Start refuses new authorizations while maxPending
+// are waiting, unless maxPending is 0
+func NewManager(expiry, interval time.Duration, maxPending int) *Manager {
+	if expiry == 0 {
+		expiry = DefaultExpiry
+	}
+	if interval == 0 {
+		interval = DefaultInterval
+	}
+	return &Manager{
+		byDevice: make(map[string]*Authorization),
+		byUser:   make(map[string]*Authorization),
+		expiry:   expiry,
+		interval: interval,
+		max:      maxPending,
+		now:      time.Now,
+	}
+}
+
+// Start creates a new pending authorization for req, or returns ErrTooManyPending at the cap
+func (m *Manager) Start(req Request) (*Authorization, error) {
+	deviceCode, err := generateDeviceCode()
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.cleanup()
+	if m.max > 0 && len(m.byDevice) >= m.max {
+		return nil, ErrTooManyPending
+	}
+
+	// Retry on the (unlikely) collision with a live user code
+	var userCode string
+	for {
+		userCode, err = generateUserCode()
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := m.byUser[userCode]; !exists {
+			break
+		}
+	}
+
+	auth := &Authorization{
+		DeviceCode: deviceCode,
+		UserCode:   userCode,
+		Request:    req,
+		ExpiresAt:  m.now().Add(m.expiry),
+		Interval:   m.interval,
+	}
+	m.byDevice[deviceCode] = auth
+	m.byUser[userCode] = auth
+
+	copied := *auth
+	return &copied, nil
+}
+
+// Lookup returns the pending authorization for a user code
+// The code is matched case-insensitively, ignoring dashes and spaces
+func (m *Manager) Lookup(userCode string) (*Authorization, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	auth, err := m.pending(userCode)
+	if err != nil {
+		return nil, err
+	}
+	copied := *auth
+	return &copied, nil
+}
+
+// Approve completes the authorization with the signed cert; the next poll receives it
+func (m *Manager) Approve(userCode, cert string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	auth, err := m.pending(userCode)
+	if err != nil {
+		return err
+	}
+	auth.cert = cert
+	return nil
+}
+
+// Deny completes the authorization without a cert; the next poll receives ErrAccessDenied wrapping reason
+func (m *Manager) Deny(userCode, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	auth, err := m.pending(userCode)
+	if err != nil {
+		return err
+	}
+	if reason == "" {
+		reason = "denied"
+	}
+	auth.denied = reason
+	return nil
+}
+
+// Poll returns the cert once the authorization is approved
+// Until then it returns ErrAuthorizationPending, or ErrSlowDown if the client polls faster than Interval
+// A completed authorization is removed, so the cert is handed out exactly once
+func (m *Manager) Poll(deviceCode string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	auth, ok := m.byDevice[deviceCode]
+	if !ok {
+		return "", ErrInvalidGrant
+	}
+
+	now := m.now()
+	if now.After(auth.ExpiresAt) {
+		m.remove(auth)
+		return "", ErrExpiredToken
+	}
+
+	switch {
+	case auth.cert != "":
+		m.remove(auth)
+		return auth.cert, nil
+	case auth.denied != "":
+		m.remove(auth)
+		return "", fmt.Errorf("%w: %s", ErrAccessDenied, auth.denied)
+	}
+
+	if !auth.lastPoll.IsZero() && now.Sub(auth.lastPoll) < auth.Interval {
+		auth.lastPoll = now
+		return "", ErrSlowDown
+	}
+	auth.lastPoll = now
+	return "", ErrAuthorizationPending
+}
+
+// Pending returns the number of authorizations waiting to be approved
+func (m *Manager) Pending() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.byDevice)
+}
+
+// pending returns the live, undecided authorization for a user code
+// Callers must hold m.mu
+func (m *Manager) pending(userCode string) (*Authorization, error) {
+	auth, ok := m.byUser[NormalizeUserCode(userCode)]
+	if !ok {
+		return nil, ErrInvalidGrant
+	}
+	if m.now().After(auth.ExpiresAt) {
+		// Left for Poll to report, so the client sees expired_token
+		return nil, ErrExpiredToken
+	}
+	if auth.cert != "" || auth.denied != "" {
+		// Already decided; a user code can't be approved twice
+		return nil, ErrInvalidGrant
+	}
+	return auth, nil
+}
+
+// cleanup removes expired authorizations
+// Callers must hold m.mu
+func (m *Manager) cleanup() {
+	now := m.now()
+	for _, auth := range m.byDevice {
+		if now.After(auth.ExpiresAt) {
+			m.remove(auth)
+		}
+	}
+}
+
+func (m *Manager) remove(auth *Authorization) {
+	delete(m.byDevice, auth.DeviceCode)
+	delete(m.byUser, auth.UserCode)
+}
+
+// NormalizeUserCode uppercases a user code and strips separators
+func NormalizeUserCode(code string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(code) {
+		if r == '-' || r == ' ' {
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// FormatUserCode splits a user code in half for display (e.g., "BDFG-HJKL")
+func FormatUserCode(code string) string {
+	if len(code) != userCodeLength {
+		return code
+	}
+	return code[:userCodeLength/2] + "-" + code[userCodeLength/2:]
+}
+
+func generateUserCode() (string, error) {
+	bytes := make([]byte, userCodeLength)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	code := make([]byte, userCodeLength)
+	for i, b := range bytes {
+		// 256 is not a multiple of 20, so this has a slight bias; fine for a short-lived code
+		code[i] = userCodeAlphabet[int(b)%len(userCodeAlphabet)]
+	}
+	return string(code), nil
+}
+
+func generateDeviceCode() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
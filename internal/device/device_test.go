@@ -0,0 +1,191 @@
+package device
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestManager returns a manager with a controllable clock
+func newTestManager(t *testing.T) (*Manager, *time.Time) {
+	t.Helper()
+	now := time.Date(2025, 1, 7, 10, 0, 0, 0, time.UTC)
+	m := NewManager(10*time.Minute, 5*time.Second, 0)
+	m.now = func() time.Time { return now }
+	return m, &now
+}
+
+func TestApproveFlow(t *testing.T) {
+	m, now := newTestManager(t)
+
+	auth, err := m.Start(Request{PubKey: "ssh-ed25519 AAAA test", Template: "bastion"})
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	if len(auth.UserCode) != userCodeLength || strings.Trim(auth.UserCode, userCodeAlphabet) != "" {
+		t.Errorf("UserCode = %q, want %d chars from %q", auth.UserCode, userCodeLength, userCodeAlphabet)
+	}
+	if auth.DeviceCode == "" || auth.DeviceCode == auth.UserCode {
+		t.Errorf("DeviceCode = %q, want a separate secret", auth.DeviceCode)
+	}
+
+	if _, err := m.Poll(auth.DeviceCode); !errors.Is(err, ErrAuthorizationPending) {
+		t.Fatalf("Poll() error = %v, want ErrAuthorizationPending", err)
+	}
+
+	// Polling again within the interval is throttled
+	*now = now.Add(time.Second)
+	if _, err := m.Poll(auth.DeviceCode); !errors.Is(err, ErrSlowDown) {
+		t.Fatalf("Poll() error = %v, want ErrSlowDown", err)
+	}
+
+	// The user enters the code in display form, in lower case
+	got, err := m.Lookup(strings.ToLower(FormatUserCode(auth.UserCode)))
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	if got.Request.Template != "bastion" || got.Request.PubKey != "ssh-ed25519 AAAA test" {
+		t.Errorf("Lookup().Request = %+v, want the started request", got.Request)
+	}
+
+	if err := m.Approve(auth.UserCode, "cert-data"); err != nil {
+		t.Fatalf("Approve() error = %v", err)
+	}
+	if err := m.Approve(auth.UserCode, "other-cert"); !errors.Is(err, ErrInvalidGrant) {
+		t.Errorf("second Approve() error = %v, want ErrInvalidGrant", err)
+	}
+
+	*now = now.Add(5 * time.Second)
+	cert, err := m.Poll(auth.DeviceCode)
+	if err != nil {
+		t.Fatalf("Poll() error = %v", err)
+	}
+	if cert != "cert-data" {
+		t.Errorf("Poll() = %q, want %q", cert, "cert-data")
+	}
+
+	// The cert is handed out once
+	if _, err := m.Poll(auth.DeviceCode); !errors.Is(err, ErrInvalidGrant) {
+		t.Errorf("Poll() after redeem error = %v, want ErrInvalidGrant", err)
+	}
+	if n := m.Pending(); n != 0 {
+		t.Errorf("Pending() = %d, want 0", n)
+	}
+}
+
+func TestDeny(t *testing.T) {
+	m, _ := newTestManager(t)
+
+	auth, err := m.Start(Request{PubKey: "key"})
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	if err := m.Deny(auth.UserCode, "denied by rule \"contractors\""); err != nil {
+		t.Fatalf("Deny() error = %v", err)
+	}
+
+	_, err = m.Poll(auth.DeviceCode)
+	if !errors.Is(err, ErrAccessDenied) {
+		t.Fatalf("Poll() error = %v, want ErrAccessDenied", err)
+	}
+	if !strings.Contains(err.Error(), "contractors") {
+		t.Errorf("Poll() error = %q, want the denial reason", err)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	m, now := newTestManager(t)
+
+	auth, err := m.Start(Request{PubKey: "key"})
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	*now = now.Add(11 * time.Minute)
+
+	if _, err := m.Lookup(auth.UserCode); !errors.Is(err, ErrExpiredToken) {
+		t.Errorf("Lookup() error = %v, want ErrExpiredToken", err)
+	}
+	if err := m.Approve(auth.UserCode, "cert"); !errors.Is(err, ErrExpiredToken) {
+		t.Errorf("Approve() error = %v, want ErrExpiredToken", err)
+	}
+	if _, err := m.Poll(auth.DeviceCode); !errors.Is(err, ErrExpiredToken) {
+		t.Errorf("Poll() error = %v, want ErrExpiredToken", err)
+	}
+	if n := m.Pending(); n != 0 {
+		t.Errorf("Pending() = %d, want 0", n)
+	}
+}
+
+func TestMaxPending(t *testing.T) {
+	m, now := newTestManager(t)
+	m.max = 2
+
+	var first *Authorization
+	for i := 0; i < 2; i++ {
+		auth, err := m.Start(Request{PubKey: "key"})
+		if err != nil {
+			t.Fatalf("Start() %d error = %v", i+1, err)
+		}
+		if first == nil {
+			first = auth
+		}
+	}
+	if _, err := m.Start(Request{PubKey: "key"}); !errors.Is(err, ErrTooManyPending) {
+		t.Fatalf("Start() at the cap error = %v, want ErrTooManyPending", err)
+	}
+
+	// A redeemed authorization frees its slot
+	if err := m.Approve(first.UserCode, "cert"); err != nil {
+		t.Fatalf("Approve() error = %v", err)
+	}
+	if _, err := m.Poll(first.DeviceCode); err != nil {
+		t.Fatalf("Poll() error = %v", err)
+	}
+	if _, err := m.Start(Request{PubKey: "key"}); err != nil {
+		t.Fatalf("Start() after a redeemed authorization error = %v", err)
+	}
+
+	// So do expired ones
+	*now = now.Add(11 * time.Minute)
+	if _, err := m.Start(Request{PubKey: "key"}); err != nil {
+		t.Errorf("Start() after the others expired error = %v", err)
+	}
+}
+
+func TestUnknownCodes(t *testing.T) {
+	m, _ := newTestManager(t)
+
+	if _, err := m.Lookup("BCDF-GHJK"); !errors.Is(err, ErrInvalidGrant) {
+		t.Errorf("Lookup() error = %v, want ErrInvalidGrant", err)
+	}
+	if _, err := m.Poll("nope"); !errors.Is(err, ErrInvalidGrant) {
+		t.Errorf("Poll() error = %v, want ErrInvalidGrant", err)
+	}
+}
+
+func TestUserCodeFormatting(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"BCDFGHJK", "BCDFGHJK"},
+		{"bcdf-ghjk", "BCDFGHJK"},
+		{" BCDF GHJK ", "BCDFGHJK"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := NormalizeUserCode(tt.in); got != tt.want {
+				t.Errorf("NormalizeUserCode(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+
+	if got := FormatUserCode("BCDFGHJK"); got != "BCDF-GHJK" {
+		t.Errorf("FormatUserCode() = %q, want %q", got, "BCDF-GHJK")
+	}
+}
@@ -0,0 +1,491 @@
+// Checks users against the GitHub (Enterprise) API before certs are signed
+// Verifies org membership and looks up the GitHub login linked to a user's SCIM identity
+// Authenticates with a token or as a GitHub App installation
+package github
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCacheTTL is how long membership and login lookups are reused when Config.CacheTTL is 0
+const DefaultCacheTTL = 5 * time.Minute
+
+var (
+	// ErrNotMember is returned for users who aren't an active member of any allowed org
+	ErrNotMember = errors.New("not an active member of an allowed GitHub org")
+
+	// ErrSuspended is returned for suspended GitHub accounts
+	ErrSuspended = errors.New("GitHub account is suspended")
+)
+
+// Config is how to reach and authenticate to the GitHub API
+// Set Token, or AppID, InstallationID and AppPrivateKey to act as a GitHub App installation
+type Config struct {
+	APIURL string // e.g. https://github.corp.com/api/v3; see APIURL
+
+	Token string
+
+	AppID          int64
+	InstallationID int64
+	AppPrivateKey  []byte // PEM RSA key downloaded from the app's settings
+
+	// Where LookupLogin finds SCIM/SAML identities: an enterprise slug or an org
+	SCIMEnterprise string
+	SCIMOrg        string
+
+	CacheTTL   time.Duration // How long results are reused (default DefaultCacheTTL)
+	HTTPClient *http.Client  // Default http.DefaultClient
+}
+
+// Client checks org membership and looks up logins, caching results briefly
+type Client struct {
+	apiURL   string
+	http     *http.Client
+	cacheTTL time.Duration
+
+	scimEnterprise string
+	scimOrg        string
+
+	token    string
+	appID    int64
+	install  int64
+	appKey   *rsa.PrivateKey
+	tokenMu  sync.Mutex
+	appToken string
+	tokenExp time.Time
+
+	mu    sync.Mutex
+	cache map[string]cached
+}
+
+type cached struct {
+	login   string // LookupLogin result
+	err     error  // CheckMember result: nil, ErrNotMember or ErrSuspended (wrapped)
+	expires time.Time
+}
+
+// APIURL returns the REST API root for a GitHub host URL
+// github.com and GHE.com use api.<host>; GitHub Enterprise Server uses <host>/api/v3
+func APIURL(enterpriseURL string) string {
+	u, err := url.Parse(enterpriseURL)
+	if err != nil || u.Host == "" {
+		return "https://api.github.com"
+	}
+	switch {
+	case u.Host == "github.com":
+		return "https://api.github.com"
+	case strings.HasSuffix(u.Host, ".ghe.com"):
+		return u.Scheme + "://api." + u.Host
+	}
+	return u.Scheme + "://" + u.Host + "/api/v3"
+}
+
+// New validates cfg and creates a client
+func New(cfg Config) (*Client, error) {
+	if cfg.APIURL == "" {
+		return nil, fmt.Errorf("GitHub API URL is required")
+	}
+	c := &Client{
+		apiURL:   strings.TrimSuffix(cfg.APIURL, "/"),
+		http:     cfg.HTTPClient,
+		cacheTTL: cfg.CacheTTL,
+		token:    cfg.Token,
+		appID:    cfg.AppID,
+		install:  cfg.InstallationID,
+		cache:    make(map[string]cached),
+
+		scimEnterprise: cfg.SCIMEnterprise,
+		scimOrg:        cfg.SCIMOrg,
+	}
+	if c.http == nil {
+		c.http = http.DefaultClient
+	}
+	if c.cacheTTL == 0 {
+		c.cacheTTL = DefaultCacheTTL
+	}
+
+	if cfg.SCIMEnterprise != "" && cfg.SCIMOrg != "" {
+		return nil, fmt.Errorf("set either a SCIM enterprise or a SCIM org, not both")
+	}
+
+	switch {
+	case cfg.Token != "" && cfg.AppID != 0:
+		return nil, fmt.Errorf("set either a GitHub token or a GitHub App, not both")
+	case cfg.Token != "":
+	case cfg.AppID != 0:
+		if cfg.InstallationID == 0 || len(cfg.AppPrivateKey) == 0 {
+			return nil, fmt.Errorf("GitHub App requires an installation ID and private key")
+		}
+		key, err := parseAppKey(cfg.AppPrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		c.appKey = key
+	default:
+		return nil, fmt.Errorf("a GitHub token or GitHub App is required")
+	}
+	return c, nil
+}
+
+func parseAppKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("GitHub App private key is not PEM")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse GitHub App private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("GitHub App private key must be RSA")
+	}
+	return key, nil
+}
+
+// CheckMember returns nil if username is an active member of at least one of orgs and isn't suspended
+// Refusals wrap ErrNotMember or ErrSuspended and are cached along with successes; API errors aren't
+func (c *Client) CheckMember(ctx context.Context, username string, orgs []string) error {
+	key := "member\x00" + username + "\x00" + strings.Join(orgs, ",")
+	if entry, ok := c.cached(key); ok {
+		return entry.err
+	}
+
+	err := c.checkMember(ctx, username, orgs)
+	if err == nil || errors.Is(err, ErrNotMember) || errors.Is(err, ErrSuspended) {
+		c.store(key, cached{err: err})
+	}
+	return err
+}
+
+func (c *Client) cached(key string) (cached, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.cache[key]
+	if !ok || time.Now().After(entry.expires) {
+		return cached{}, false
+	}
+	return entry, true
+}
+
+func (c *Client) store(key string, entry cached) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry.expires = time.Now().Add(c.cacheTTL)
+	c.cache[key] = entry
+}
+
+func (c *Client) checkMember(ctx context.Context, username string, orgs []string) error {
+	if username == "" {
+		return fmt.Errorf("%w: no GitHub username", ErrNotMember)
+	}
+
+	// suspended_at is only set on GitHub Enterprise Server, and only visible to site admins
+	var user struct {
+		SuspendedAt *time.Time `json:"suspended_at"`
+	}
+	found, err := c.get(ctx, "/users/"+url.PathEscape(username), &user)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%w: GitHub user %q does not exist", ErrNotMember, username)
+	}
+	if user.SuspendedAt != nil {
+		return fmt.Errorf("%w: %s since %s", ErrSuspended, username, user.SuspendedAt.Format(time.RFC3339))
+	}
+
+	for _, org := range orgs {
+		var membership struct {
+			State string `json:"state"` // "active" or "pending" (invited)
+		}
+		found, err := c.get(ctx, "/orgs/"+url.PathEscape(org)+"/memberships/"+url.PathEscape(username), &membership)
+		if err != nil {
+			return err
+		}
+		if found && membership.State == "active" {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s is not an active member of %s", ErrNotMember, username, strings.Join(orgs, ", "))
+}
+
+// SCIMConfigured reports whether LookupLogin has an enterprise or org to search
+func (c *Client) SCIMConfigured() bool {
+	return c.scimEnterprise != "" || c.scimOrg != ""
+}
+
+// externalIdentities finds identities by their SCIM userName / SAML NameID; user is null until the account is linked
+const externalIdentities = `samlIdentityProvider {
+      externalIdentities(userName: $userName, first: 2) { nodes { user { login } } }
+    }`
+
+var (
+	enterpriseIdentitiesQuery = `query($owner: String!, $userName: String!) {
+  enterprise(slug: $owner) { ownerInfo { ` + externalIdentities + ` } }
+}`
+	orgIdentitiesQuery = `query($owner: String!, $userName: String!) {
+  organization(login: $owner) { ` + externalIdentities + ` }
+}`
+)
+
+// LookupLogin returns the GitHub login linked to the SCIM/SAML identity with idpUsername, or "" if there is none
+// Searches Config.SCIMEnterprise (which needs an enterprise owner's token) or Config.SCIMOrg
+func (c *Client) LookupLogin(ctx context.Context, idpUsername string) (string, error) {
+	key := "login\x00" + strings.ToLower(idpUsername)
+	if entry, ok := c.cached(key); ok {
+		return entry.login, nil
+	}
+
+	query, owner := orgIdentitiesQuery, c.scimOrg
+	if c.scimEnterprise != "" {
+		query, owner = enterpriseIdentitiesQuery, c.scimEnterprise
+	}
+	if owner == "" {
+		return "", fmt.Errorf("no SCIM enterprise or org configured")
+	}
+
+	type identityProvider struct {
+		ExternalIdentities struct {
+			Nodes []struct {
+				User *struct {
+					Login string `json:"login"`
+				} `json:"user"`
+			} `json:"nodes"`
+		} `json:"externalIdentities"`
+	}
+	var data struct {
+		Enterprise *struct {
+			OwnerInfo *struct {
+				SAMLIdentityProvider *identityProvider `json:"samlIdentityProvider"`
+			} `json:"ownerInfo"`
+		} `json:"enterprise"`
+		Organization *struct {
+			SAMLIdentityProvider *identityProvider `json:"samlIdentityProvider"`
+		} `json:"organization"`
+	}
+	if err := c.graphQL(ctx, query, map[string]string{"owner": owner, "userName": idpUsername}, &data); err != nil {
+		return "", err
+	}
+
+	var idp *identityProvider
+	switch {
+	case data.Enterprise != nil && data.Enterprise.OwnerInfo != nil:
+		idp = data.Enterprise.OwnerInfo.SAMLIdentityProvider
+	case data.Organization != nil:
+		idp = data.Organization.SAMLIdentityProvider
+	default:
+		return "", fmt.Errorf("GitHub %s %q not found or not visible to the configured credentials", ownerKind(c.scimEnterprise), owner)
+	}
+	if idp == nil {
+		return "", fmt.Errorf("GitHub %s %q has no SAML/SCIM identity provider", ownerKind(c.scimEnterprise), owner)
+	}
+
+	var login string
+	for _, node := range idp.ExternalIdentities.Nodes {
+		if node.User == nil || node.User.Login == "" {
+			continue
+		}
+		if login != "" && login != node.User.Login {
+			return "", fmt.Errorf("%s is linked to more than one GitHub account (%s, %s)", idpUsername, login, node.User.Login)
+		}
+		login = node.User.Login
+	}
+	c.store(key, cached{login: login})
+	return login, nil
+}
+
+func ownerKind(enterprise string) string {
+	if enterprise != "" {
+		return "enterprise"
+	}
+	return "org"
+}
+
+// GraphQLURL returns the GraphQL endpoint for a REST API root
+// GitHub Enterprise Server serves it at /api/graphql rather than under /api/v3
+func GraphQLURL(apiURL string) string {
+	if base, ok := strings.CutSuffix(strings.TrimSuffix(apiURL, "/"), "/api/v3"); ok {
+		return base + "/api/graphql"
+	}
+	return strings.TrimSuffix(apiURL, "/") + "/graphql"
+}
+
+// graphQL runs query with variables, decoding its data into v
+func (c *Client) graphQL(ctx context.Context, query string, variables map[string]string, v interface{}) error {
+	token, err := c.authToken(ctx)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, GraphQLURL(c.apiURL), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	setHeaders(req, token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("GitHub API request failed: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return apiError("graphql", resp)
+	}
+
+	var result struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("invalid GitHub GraphQL response: %w", err)
+	}
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("GitHub GraphQL: %s", result.Errors[0].Message)
+	}
+	if err := json.Unmarshal(result.Data, v); err != nil {
+		return fmt.Errorf("invalid GitHub GraphQL response: %w", err)
+	}
+	return nil
+}
+
+// get fetches path into v, reporting false for 404
+func (c *Client) get(ctx context.Context, path string, v interface{}) (bool, error) {
+	token, err := c.authToken(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.apiURL+path, nil)
+	if err != nil {
+		return false, err
+	}
+	setHeaders(req, token)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("GitHub API request failed: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			return false, fmt.Errorf("invalid GitHub API response for %s: %w", path, err)
+		}
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, apiError(path, resp)
+	}
+}
+
+func setHeaders(req *http.Request, token string) {
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	req.Header.Set("Authorization", "Bearer "+token)
+}
+
+func apiError(path string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	var msg struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &msg) == nil && msg.Message != "" {
+		return fmt.Errorf("GitHub API %s: %s: %s", path, resp.Status, msg.Message)
+	}
+	return fmt.Errorf("GitHub API %s: %s", path, resp.Status)
+}
+
+// authToken returns the configured token, or a GitHub App installation token (refreshed before it expires)
+func (c *Client) authToken(ctx context.Context) (string, error) {
+	if c.appKey == nil {
+		return c.token, nil
+	}
+
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	if c.appToken != "" && time.Until(c.tokenExp) > time.Minute {
+		return c.appToken, nil
+	}
+
+	jwt, err := c.appJWT()
+	if err != nil {
+		return "", err
+	}
+	path := fmt.Sprintf("/app/installations/%d/access_tokens", c.install)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.apiURL+path, bytes.NewReader(nil))
+	if err != nil {
+		return "", err
+	}
+	setHeaders(req, jwt)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("GitHub App token request failed: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusCreated {
+		return "", apiError(path, resp)
+	}
+
+	var token struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil || token.Token == "" {
+		return "", fmt.Errorf("invalid GitHub App token response")
+	}
+	c.appToken, c.tokenExp = token.Token, token.ExpiresAt
+	return c.appToken, nil
+}
+
+// appJWT signs the short-lived JWT a GitHub App authenticates as itself with
+func (c *Client) appJWT() (string, error) {
+	now := time.Now()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]int64{
+		"iat": now.Add(-time.Minute).Unix(), // Allow for clock drift
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": c.appID,
+	})
+	if err != nil {
+		return "", err
+	}
+	signingInput := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, c.appKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign GitHub App JWT: %w", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
@@ -0,0 +1,230 @@
+package github
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAPIURL(t *testing.T) {
+	tests := []struct {
+		enterpriseURL string
+		want          string
+	}{
+		{"", "https://api.github.com"},
+		{"https://github.com", "https://api.github.com"},
+		{"https://octocorp.ghe.com", "https://api.octocorp.ghe.com"},
+		{"https://github.corp.com/", "https://github.corp.com/api/v3"},
+	}
+	for _, tt := range tests {
+		if got := APIURL(tt.enterpriseURL); got != tt.want {
+			t.Errorf("APIURL(%q) = %q, want %q", tt.enterpriseURL, got, tt.want)
+		}
+	}
+}
+
+func TestGraphQLURL(t *testing.T) {
+	tests := []struct {
+		apiURL string
+		want   string
+	}{
+		{"https://api.github.com", "https://api.github.com/graphql"},
+		{"https://github.corp.com/api/v3", "https://github.corp.com/api/graphql"},
+		{"https://github.corp.com/api/v3/", "https://github.corp.com/api/graphql"},
+	}
+	for _, tt := range tests {
+		if got := GraphQLURL(tt.apiURL); got != tt.want {
+			t.Errorf("GraphQLURL(%q) = %q, want %q", tt.apiURL, got, tt.want)
+		}
+	}
+}
+
+func TestNewRequiresCredentials(t *testing.T) {
+	if _, err := New(Config{APIURL: "https://api.github.com"}); err == nil {
+		t.Error("New() without a token or app should fail")
+	}
+	if _, err := New(Config{APIURL: "https://api.github.com", AppID: 1}); err == nil {
+		t.Error("New() with an app but no key should fail")
+	}
+}
+
+func TestCheckMember(t *testing.T) {
+	api := newMockAPI(t, "ghp_test")
+	suspended := time.Now().Add(-time.Hour)
+	api.addUser("alice", nil)
+	api.addUser("bob", nil)
+	api.addUser("carol", nil)
+	api.addUser("mallory", &suspended)
+	api.addMember("eng", "alice", "active")
+	api.addMember("eng", "bob", "pending")
+	api.addMember("ops", "carol", "active")
+	api.addMember("eng", "mallory", "active")
+
+	client, err := New(Config{APIURL: api.URL, Token: "ghp_test"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		user    string
+		wantErr error
+	}{
+		{name: "active member", user: "alice"},
+		{name: "member of second org", user: "carol"},
+		{name: "pending invite", user: "bob", wantErr: ErrNotMember},
+		{name: "no such user", user: "dave", wantErr: ErrNotMember},
+		{name: "suspended", user: "mallory", wantErr: ErrSuspended},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := client.CheckMember(context.Background(), tt.user, []string{"eng", "ops"})
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("CheckMember() error = %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("CheckMember() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckMemberCache(t *testing.T) {
+	api := newMockAPI(t, "ghp_test")
+	api.addUser("alice", nil)
+	api.addMember("eng", "alice", "active")
+
+	client, err := New(Config{APIURL: api.URL, Token: "ghp_test", CacheTTL: time.Hour})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if err := client.CheckMember(ctx, "alice", []string{"eng"}); err != nil {
+			t.Fatalf("CheckMember() error = %v", err)
+		}
+	}
+	if got := api.requestCount(); got != 2 {
+		t.Errorf("API requests = %d, want 2 (user and membership, then cached)", got)
+	}
+
+	// Refusals are cached too
+	for i := 0; i < 2; i++ {
+		if err := client.CheckMember(ctx, "bob", []string{"eng"}); !errors.Is(err, ErrNotMember) {
+			t.Fatalf("CheckMember() error = %v, want ErrNotMember", err)
+		}
+	}
+	if got := api.requestCount(); got != 3 {
+		t.Errorf("API requests = %d, want 3", got)
+	}
+}
+
+func TestCheckMemberAPIErrorNotCached(t *testing.T) {
+	api := newMockAPI(t, "ghp_test")
+	api.addUser("alice", nil)
+	api.addMember("eng", "alice", "active")
+
+	client, err := New(Config{APIURL: api.URL, Token: "ghp_wrong", CacheTTL: time.Hour})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	ctx := context.Background()
+
+	err = client.CheckMember(ctx, "alice", []string{"eng"})
+	if err == nil || errors.Is(err, ErrNotMember) {
+		t.Fatalf("CheckMember() with bad credentials error = %v, want an API error", err)
+	}
+	client.token = "ghp_test"
+	if err := client.CheckMember(ctx, "alice", []string{"eng"}); err != nil {
+		t.Errorf("CheckMember() after the API recovered error = %v", err)
+	}
+}
+
+func TestCheckMemberGitHubApp(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() error = %v", err)
+	}
+	api := newMockAPI(t, "")
+	api.appKey, api.appID = &key.PublicKey, 1234
+	api.addUser("alice", nil)
+	api.addMember("eng", "alice", "active")
+
+	client, err := New(Config{
+		APIURL:         api.URL,
+		AppID:          1234,
+		InstallationID: 99,
+		AppPrivateKey:  pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+		CacheTTL:       time.Nanosecond,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := client.CheckMember(context.Background(), "alice", []string{"eng"}); err != nil {
+			t.Fatalf("CheckMember() error = %v", err)
+		}
+	}
+	if api.tokens != 1 {
+		t.Errorf("installation tokens issued = %d, want 1 (reused until expiry)", api.tokens)
+	}
+}
+
+func TestLookupLogin(t *testing.T) {
+	api := newMockAPI(t, "ghp_test")
+	api.addIdentity("corp", "alice@corp.com", "alice_corp")
+	api.addIdentity("corp", "bob@corp.com", "") // Provisioned but not linked yet
+
+	for _, cfg := range []Config{
+		{APIURL: api.URL, Token: "ghp_test", SCIMEnterprise: "corp"},
+		{APIURL: api.URL, Token: "ghp_test", SCIMOrg: "corp"},
+	} {
+		client, err := New(cfg)
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		ctx := context.Background()
+
+		login, err := client.LookupLogin(ctx, "alice@corp.com")
+		if err != nil || login != "alice_corp" {
+			t.Errorf("LookupLogin(alice) = %q, %v, want alice_corp", login, err)
+		}
+		for _, user := range []string{"bob@corp.com", "carol@corp.com"} {
+			if login, err := client.LookupLogin(ctx, user); err != nil || login != "" {
+				t.Errorf("LookupLogin(%s) = %q, %v, want no login", user, login, err)
+			}
+		}
+	}
+
+	// Lookups are cached, including misses
+	client, err := New(Config{APIURL: api.URL, Token: "ghp_test", SCIMOrg: "corp", CacheTTL: time.Hour})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	before := api.requestCount()
+	for i := 0; i < 3; i++ {
+		_, _ = client.LookupLogin(context.Background(), "Alice@corp.com")
+		_, _ = client.LookupLogin(context.Background(), "carol@corp.com")
+	}
+	if got := api.requestCount() - before; got != 2 {
+		t.Errorf("API requests = %d, want 2", got)
+	}
+}
+
+func TestLookupLoginUnknownOwner(t *testing.T) {
+	api := newMockAPI(t, "ghp_test")
+	client, err := New(Config{APIURL: api.URL, Token: "ghp_test", SCIMEnterprise: "nope"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, err := client.LookupLogin(context.Background(), "alice@corp.com"); err == nil {
+		t.Error("LookupLogin() in an unknown enterprise should fail")
+	}
+}
@@ -0,0 +1,248 @@
+package github
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockAPI is a minimal GitHub API for tests
+// It serves users, org memberships, GitHub App installation tokens and GraphQL external identity lookups
+type mockAPI struct {
+	*httptest.Server
+	t *testing.T
+
+	token  string         // Bearer token required on API requests
+	appKey *rsa.PublicKey // If set, /app/installations/{id}/access_tokens verifies JWTs with it
+	appID  int64
+
+	mu          sync.Mutex
+	users       map[string]mockUser
+	memberships map[string]string // "org/user" -> state
+	identities  map[string]string // "owner/idp username" -> linked login ("" if unlinked)
+	requests    int               // API requests received, excluding token exchanges
+	tokens      int               // Installation tokens issued
+}
+
+type mockUser struct {
+	suspendedAt *time.Time
+}
+
+func newMockAPI(t *testing.T, token string) *mockAPI {
+	t.Helper()
+
+	m := &mockAPI{
+		t:           t,
+		token:       token,
+		users:       make(map[string]mockUser),
+		memberships: make(map[string]string),
+		identities:  make(map[string]string),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users/", m.handleUser)
+	mux.HandleFunc("/orgs/", m.handleMembership)
+	mux.HandleFunc("/app/installations/", m.handleAccessToken)
+	mux.HandleFunc("/graphql", m.handleGraphQL)
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *mockAPI) addUser(login string, suspendedAt *time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.users[login] = mockUser{suspendedAt: suspendedAt}
+}
+
+func (m *mockAPI) addMember(org, login, state string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.memberships[org+"/"+login] = state
+}
+
+// addIdentity registers a SCIM identity of an enterprise or org; an empty login is an identity not yet linked to an account
+func (m *mockAPI) addIdentity(owner, idpUsername, login string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.identities[owner+"/"+idpUsername] = login
+}
+
+func (m *mockAPI) requestCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.requests
+}
+
+func (m *mockAPI) authorized(w http.ResponseWriter, r *http.Request) bool {
+	m.mu.Lock()
+	m.requests++
+	token := m.token
+	m.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer "+token {
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(map[string]string{"message": "Bad credentials"})
+		return false
+	}
+	if r.Header.Get("X-GitHub-Api-Version") == "" {
+		m.t.Errorf("%s: missing X-GitHub-Api-Version", r.URL.Path)
+	}
+	return true
+}
+
+func (m *mockAPI) handleUser(w http.ResponseWriter, r *http.Request) {
+	if !m.authorized(w, r) {
+		return
+	}
+	login := strings.TrimPrefix(r.URL.Path, "/users/")
+
+	m.mu.Lock()
+	user, ok := m.users[login]
+	m.mu.Unlock()
+	if !ok {
+		http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"login": login, "suspended_at": user.suspendedAt})
+}
+
+func (m *mockAPI) handleMembership(w http.ResponseWriter, r *http.Request) {
+	if !m.authorized(w, r) {
+		return
+	}
+	// /orgs/{org}/memberships/{user}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/orgs/"), "/")
+	if len(parts) != 3 || parts[1] != "memberships" {
+		http.NotFound(w, r)
+		return
+	}
+
+	m.mu.Lock()
+	state, ok := m.memberships[parts[0]+"/"+parts[2]]
+	m.mu.Unlock()
+	if !ok {
+		http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"state": state, "role": "member"})
+}
+
+// handleGraphQL answers the externalIdentities queries of LookupLogin
+// Owners without identities aren't found, like enterprises the token can't see
+func (m *mockAPI) handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	if !m.authorized(w, r) {
+		return
+	}
+	var req struct {
+		Query     string            `json:"query"`
+		Variables map[string]string `json:"variables"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	owner, userName := req.Variables["owner"], req.Variables["userName"]
+
+	m.mu.Lock()
+	login, linked := m.identities[owner+"/"+userName]
+	known := false
+	for key := range m.identities {
+		known = known || strings.HasPrefix(key, owner+"/")
+	}
+	m.mu.Unlock()
+
+	if !known {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"data":   map[string]interface{}{"enterprise": nil, "organization": nil},
+			"errors": []map[string]string{{"type": "NOT_FOUND", "message": "Could not resolve to an owner with the login of '" + owner + "'."}},
+		})
+		return
+	}
+
+	nodes := []interface{}{}
+	if linked {
+		var user interface{}
+		if login != "" {
+			user = map[string]string{"login": login}
+		}
+		nodes = append(nodes, map[string]interface{}{"user": user})
+	}
+	idp := map[string]interface{}{"externalIdentities": map[string]interface{}{"nodes": nodes}}
+	data := map[string]interface{}{"organization": map[string]interface{}{"samlIdentityProvider": idp}}
+	if strings.Contains(req.Query, "enterprise(") {
+		data = map[string]interface{}{"enterprise": map[string]interface{}{"ownerInfo": map[string]interface{}{"samlIdentityProvider": idp}}}
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+func (m *mockAPI) handleAccessToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || m.appKey == nil {
+		http.NotFound(w, r)
+		return
+	}
+	jwt := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if err := m.verifyAppJWT(jwt); err != nil {
+		m.t.Errorf("app JWT: %v", err)
+		http.Error(w, `{"message":"A JSON web token could not be decoded"}`, http.StatusUnauthorized)
+		return
+	}
+
+	m.mu.Lock()
+	m.tokens++
+	m.token = fmt.Sprintf("ghs_installation%d", m.tokens)
+	token := m.token
+	m.mu.Unlock()
+
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":      token,
+		"expires_at": time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+	})
+}
+
+func (m *mockAPI) verifyAppJWT(jwt string) error {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return errors.New("malformed JWT")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(m.appKey, crypto.SHA256, digest[:], sig); err != nil {
+		return err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return err
+	}
+	var claims struct {
+		Iss int64 `json:"iss"`
+		Iat int64 `json:"iat"`
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	switch {
+	case claims.Iss != m.appID:
+		return fmt.Errorf("iss = %d, want %d", claims.Iss, m.appID)
+	case claims.Iat > now || claims.Exp <= now || claims.Exp-claims.Iat > 600:
+		return errors.New("iat/exp outside the allowed window")
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+// Registers the machines cassh-server issues host certificates to
+// A machine enrolls with its bootstrap token, then renews with its current host cert
+package hosts
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// DefaultValidity is the host cert lifetime when [hosts] doesn't set validity_hours
+const DefaultValidity = 30 * 24 * time.Hour
+
+var (
+	// ErrUnknownToken is returned for bootstrap tokens no machine is registered with
+	ErrUnknownToken = errors.New("unknown bootstrap token")
+
+	// ErrHostnameNotAllowed is returned when a machine asks for a hostname it isn't registered for
+	ErrHostnameNotAllowed = errors.New("hostname not registered for this machine")
+)
+
+// Config is the [hosts] section of the server config
+type Config struct {
+	// Host cert lifetime (default 720 = 30 days); hosts renew with `cassh-cli host renew`
+	ValidityHours int `toml:"validity_hours"`
+
+	// Named CA ([cas.<name>]) that signs host certs; empty is the top-level [ca]
+	CA string `toml:"ca"`
+
+	Machines []Machine `toml:"machines"`
+}
+
+// Machine is a [[hosts.machines]] entry
+type Machine struct {
+	Name string `toml:"name"`
+
+	// Hostnames the machine may put in its cert; globs allowed (e.g., "*.web.corp.example.com")
+	Hostnames []string `toml:"hostnames"`
+
+	// Secret the machine enrolls with; empty means it can only renew an existing cert
+	BootstrapToken string `toml:"bootstrap_token"`
+}
+
+// Registry holds the validated machine registrations
+type Registry struct {
+	cfg Config
+}
+
+// New validates cfg and returns a registry for it
+func New(cfg Config) (*Registry, error) {
+	if cfg.ValidityHours < 0 {
+		return nil, fmt.Errorf("hosts.validity_hours must not be negative")
+	}
+
+	names := make(map[string]bool, len(cfg.Machines))
+	tokens := make(map[string]bool, len(cfg.Machines))
+	for _, m := range cfg.Machines {
+		if m.Name == "" {
+			return nil, fmt.Errorf("host machine is missing a name")
+		}
+		if names[m.Name] {
+			return nil, fmt.Errorf("host machine %q is defined twice", m.Name)
+		}
+		names[m.Name] = true
+
+		if len(m.Hostnames) == 0 {
+			return nil, fmt.Errorf("host machine %q has no hostnames", m.Name)
+		}
+		for _, pattern := range m.Hostnames {
+			if pattern == "" || strings.ContainsAny(pattern, " \t\r\n,") {
+				return nil, fmt.Errorf("host machine %q: invalid hostname %q", m.Name, pattern)
+			}
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("host machine %q: invalid hostname pattern %q: %w", m.Name, pattern, err)
+			}
+		}
+
+		if m.BootstrapToken != "" {
+			if tokens[m.BootstrapToken] {
+				return nil, fmt.Errorf("host machine %q reuses another machine's bootstrap token", m.Name)
+			}
+			tokens[m.BootstrapToken] = true
+		}
+	}
+
+	cfg.Machines = append([]Machine(nil), cfg.Machines...)
+	return &Registry{cfg: cfg}, nil
+}
+
+// Enabled reports whether any machines are registered
+func (r *Registry) Enabled() bool {
+	return len(r.cfg.Machines) > 0
+}
+
+// Machines returns the registered machines
+func (r *Registry) Machines() []Machine {
+	return r.cfg.Machines
+}
+
+// CA returns the named CA that signs host certs (empty for the top-level CA)
+func (r *Registry) CA() string {
+	return r.cfg.CA
+}
+
+// Validity returns the host cert lifetime
+func (r *Registry) Validity() time.Duration {
+	if r.cfg.ValidityHours == 0 {
+		return DefaultValidity
+	}
+	return time.Duration(r.cfg.ValidityHours) * time.Hour
+}
+
+// Authenticate returns the machine registered with a bootstrap token
+func (r *Registry) Authenticate(token string) (*Machine, error) {
+	if token == "" {
+		return nil, ErrUnknownToken
+	}
+	var found *Machine
+	for i := range r.cfg.Machines {
+		m := &r.cfg.Machines[i]
+		// Compare against every machine so timing doesn't reveal which token matched
+		if m.BootstrapToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(m.BootstrapToken)) == 1 {
+			found = m
+		}
+	}
+	if found == nil {
+		return nil, ErrUnknownToken
+	}
+	return found, nil
+}
+
+// CheckHostnames checks requested hostnames against the machine's registration
+// With none requested, the machine gets every registered hostname that isn't a glob
+func (m *Machine) CheckHostnames(requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		for _, pattern := range m.Hostnames {
+			if !isGlob(pattern) {
+				requested = append(requested, pattern)
+			}
+		}
+		if len(requested) == 0 {
+			return nil, fmt.Errorf("machine %q only has hostname patterns; request specific hostnames", m.Name)
+		}
+	}
+
+	out := make([]string, 0, len(requested))
+	seen := make(map[string]bool, len(requested))
+	for _, hostname := range requested {
+		hostname = strings.ToLower(strings.TrimSpace(hostname))
+		if !m.allows(hostname) {
+			return nil, fmt.Errorf("%w: %q", ErrHostnameNotAllowed, hostname)
+		}
+		if !seen[hostname] {
+			seen[hostname] = true
+			out = append(out, hostname)
+		}
+	}
+	return out, nil
+}
+
+// ForHostnames returns the first machine registered for all of hostnames, or nil
+// Renewals use it to check that the hostnames in the current cert are still registered
+func (r *Registry) ForHostnames(hostnames []string) *Machine {
+	if len(hostnames) == 0 {
+		return nil
+	}
+	for i := range r.cfg.Machines {
+		m := &r.cfg.Machines[i]
+		if _, err := m.CheckHostnames(hostnames); err == nil {
+			return m
+		}
+	}
+	return nil
+}
+
+func (m *Machine) allows(hostname string) bool {
+	if hostname == "" {
+		return false
+	}
+	for _, pattern := range m.Hostnames {
+		if ok, _ := path.Match(strings.ToLower(pattern), hostname); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
@@ -0,0 +1,136 @@
+package hosts
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestRegistry(t *testing.T) *Registry {
+	t.Helper()
+	r, err := New(Config{
+		Machines: []Machine{
+			{Name: "bastion", Hostnames: []string{"bastion.corp.example.com", "bastion", "10.0.0.5"}, BootstrapToken: "bastion-token"},
+			{Name: "web-fleet", Hostnames: []string{"*.web.corp.example.com"}, BootstrapToken: "web-token"},
+			{Name: "legacy", Hostnames: []string{"legacy.corp.example.com"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return r
+}
+
+func TestAuthenticate(t *testing.T) {
+	r := newTestRegistry(t)
+
+	m, err := r.Authenticate("web-token")
+	if err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	if m.Name != "web-fleet" {
+		t.Errorf("Authenticate() = %q, want web-fleet", m.Name)
+	}
+
+	for _, token := range []string{"", "wrong", "bastion-token "} {
+		if _, err := r.Authenticate(token); !errors.Is(err, ErrUnknownToken) {
+			t.Errorf("Authenticate(%q) error = %v, want ErrUnknownToken", token, err)
+		}
+	}
+}
+
+func TestCheckHostnames(t *testing.T) {
+	r := newTestRegistry(t)
+	bastion, _ := r.Authenticate("bastion-token")
+	web, _ := r.Authenticate("web-token")
+
+	tests := []struct {
+		name      string
+		machine   *Machine
+		requested []string
+		want      []string
+		wantErr   bool
+	}{
+		{"defaults to registered hostnames", bastion, nil, []string{"bastion.corp.example.com", "bastion", "10.0.0.5"}, false},
+		{"subset, normalized and deduplicated", bastion, []string{"Bastion.corp.example.com", "bastion.corp.example.com"}, []string{"bastion.corp.example.com"}, false},
+		{"unregistered hostname", bastion, []string{"bastion", "db.corp.example.com"}, nil, true},
+		{"glob match", web, []string{"web-1.web.corp.example.com"}, []string{"web-1.web.corp.example.com"}, false},
+		{"glob only needs explicit hostnames", web, nil, nil, true},
+		{"glob mismatch", web, []string{"web.corp.example.com"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.machine.CheckHostnames(tt.requested)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckHostnames() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CheckHostnames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForHostnames(t *testing.T) {
+	r := newTestRegistry(t)
+
+	if m := r.ForHostnames([]string{"legacy.corp.example.com"}); m == nil || m.Name != "legacy" {
+		t.Errorf("ForHostnames(legacy) = %v, want legacy", m)
+	}
+	if m := r.ForHostnames([]string{"a.web.corp.example.com", "b.web.corp.example.com"}); m == nil || m.Name != "web-fleet" {
+		t.Errorf("ForHostnames(web) = %v, want web-fleet", m)
+	}
+	// Hostnames spanning two registrations don't renew
+	if m := r.ForHostnames([]string{"bastion", "legacy.corp.example.com"}); m != nil {
+		t.Errorf("ForHostnames(mixed) = %q, want nil", m.Name)
+	}
+	if m := r.ForHostnames(nil); m != nil {
+		t.Errorf("ForHostnames(nil) = %q, want nil", m.Name)
+	}
+}
+
+func TestValidity(t *testing.T) {
+	r := newTestRegistry(t)
+	if got := r.Validity(); got != DefaultValidity {
+		t.Errorf("Validity() = %s, want %s", got, DefaultValidity)
+	}
+
+	r, err := New(Config{ValidityHours: 24})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if got := r.Validity(); got != 24*time.Hour {
+		t.Errorf("Validity() = %s, want 24h", got)
+	}
+	if r.Enabled() {
+		t.Error("Enabled() = true with no machines")
+	}
+}
+
+func TestNewValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{"negative validity", Config{ValidityHours: -1}},
+		{"missing name", Config{Machines: []Machine{{Hostnames: []string{"a"}}}}},
+		{"duplicate name", Config{Machines: []Machine{{Name: "a", Hostnames: []string{"a"}}, {Name: "a", Hostnames: []string{"b"}}}}},
+		{"no hostnames", Config{Machines: []Machine{{Name: "a"}}}},
+		{"bad hostname", Config{Machines: []Machine{{Name: "a", Hostnames: []string{"a b"}}}}},
+		{"bad glob", Config{Machines: []Machine{{Name: "a", Hostnames: []string{"[a"}}}}},
+		{"shared token", Config{Machines: []Machine{
+			{Name: "a", Hostnames: []string{"a"}, BootstrapToken: "t"},
+			{Name: "b", Hostnames: []string{"b"}, BootstrapToken: "t"},
+		}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.cfg); err == nil {
+				t.Error("New() error = nil, want error")
+			}
+		})
+	}
+}
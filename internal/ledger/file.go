@@ -0,0 +1,149 @@
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// FileStore keeps the ledger in a single JSON file
+// With an empty path it is purely in-memory (useful for dev mode and tests)
+type FileStore struct {
+	path string
+
+	mu   sync.RWMutex
+	data fileData
+}
+
+type fileData struct {
+	Records     []*Record    `json:"records"`
+	Revocations []Revocation `json:"revocations"`
+}
+
+// OpenFileStore loads (or creates) a JSON ledger at path
+func OpenFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ledger: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &s.data); err != nil {
+		return nil, fmt.Errorf("failed to parse ledger %s: %w", path, err)
+	}
+
+	return s, nil
+}
+
+// RecordIssued implements Store
+func (s *FileStore) RecordIssued(ctx context.Context, rec *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.Records = append(s.data.Records, rec)
+	if err := s.save(); err != nil {
+		s.data.Records = s.data.Records[:len(s.data.Records)-1]
+		return err
+	}
+	return nil
+}
+
+// Lookup implements Store
+func (s *FileStore) Lookup(ctx context.Context, serial uint64) (*Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, rec := range s.data.Records {
+		if rec.Serial == serial {
+			return rec, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Query implements Store
+func (s *FileStore) Query(ctx context.Context, q Query) ([]*Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var out []*Record
+	for _, rec := range s.data.Records {
+		if q.Matches(rec) {
+			out = append(out, rec)
+		}
+	}
+
+	sort.SliceStable(out, func(i, j int) bool { return out[i].IssuedAt.After(out[j].IssuedAt) })
+	if q.Limit > 0 && len(out) > q.Limit {
+		out = out[:q.Limit]
+	}
+	return out, nil
+}
+
+// Revoke implements Store
+func (s *FileStore) Revoke(ctx context.Context, rev Revocation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.data.Revocations {
+		if existing.Kind == rev.Kind && existing.Value == rev.Value {
+			return nil
+		}
+	}
+
+	s.data.Revocations = append(s.data.Revocations, rev)
+	if err := s.save(); err != nil {
+		s.data.Revocations = s.data.Revocations[:len(s.data.Revocations)-1]
+		return err
+	}
+	return nil
+}
+
+// Revocations implements Store
+func (s *FileStore) Revocations(ctx context.Context) ([]Revocation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]Revocation(nil), s.data.Revocations...), nil
+}
+
+// Close implements Store
+func (s *FileStore) Close() error {
+	return nil
+}
+
+// save writes the ledger atomically; caller must hold the write lock
+func (s *FileStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(&s.data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize ledger: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("failed to create ledger directory: %w", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write ledger: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to write ledger: %w", err)
+	}
+	return nil
+}
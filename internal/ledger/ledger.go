@@ -0,0 +1,168 @@
+// Records issued certificates and revocations
+// The server writes every signed cert here so it can later be looked up and revoked
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrNotFound is returned when a record doesn't exist
+var ErrNotFound = errors.New("not found")
+
+// Record describes a single certificate issued by the CA
+type Record struct {
+	Serial      uint64    `json:"serial,string"`
+	KeyID       string    `json:"key_id"`
+	User        string    `json:"user"` // Identity that requested the cert (e.g., OIDC email)
+	Principals  []string  `json:"principals"`
+	PublicKey   string    `json:"public_key"`  // authorized_keys format, without the cert
+	Fingerprint string    `json:"fingerprint"` // SHA256 fingerprint of PublicKey
+	RemoteAddr  string    `json:"remote_addr,omitempty"`
+	ValidAfter  time.Time `json:"valid_after"`
+	ValidBefore time.Time `json:"valid_before"`
+	IssuedAt    time.Time `json:"issued_at"`
+}
+
+// UnmarshalJSON accepts the serial as a string or, as older JSON ledgers wrote it, a number
+func (r *Record) UnmarshalJSON(data []byte) error {
+	type record Record
+	aux := struct {
+		*record
+		Serial json.Number `json:"serial"`
+	}{record: (*record)(r)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if aux.Serial == "" {
+		return nil
+	}
+	serial, err := strconv.ParseUint(aux.Serial.String(), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid serial %q: %w", aux.Serial, err)
+	}
+	r.Serial = serial
+	return nil
+}
+
+// NewRecord builds a ledger record from a freshly signed cert
+func NewRecord(cert *ssh.Certificate, user string, remoteAddr string) *Record {
+	return &Record{
+		Serial:      cert.Serial,
+		KeyID:       cert.KeyId,
+		User:        user,
+		Principals:  cert.ValidPrincipals,
+		PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(cert.Key))),
+		Fingerprint: ssh.FingerprintSHA256(cert.Key),
+		RemoteAddr:  remoteAddr,
+		ValidAfter:  time.Unix(int64(cert.ValidAfter), 0).UTC(),
+		ValidBefore: time.Unix(int64(cert.ValidBefore), 0).UTC(),
+		IssuedAt:    time.Now().UTC(),
+	}
+}
+
+// Query filters issuance records
+// Zero values match everything
+type Query struct {
+	// User matches the requesting identity or any principal on the cert
+	User string
+
+	// Since and Until select certs whose validity window overlaps [Since, Until]
+	Since time.Time
+	Until time.Time
+
+	// Limit caps the number of results (newest first); 0 means no limit
+	Limit int
+}
+
+// Matches returns true if rec satisfies the query
+func (q *Query) Matches(rec *Record) bool {
+	if q.User != "" && rec.User != q.User {
+		found := false
+		for _, p := range rec.Principals {
+			if p == q.User {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if !q.Since.IsZero() && rec.ValidBefore.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && rec.ValidAfter.After(q.Until) {
+		return false
+	}
+	return true
+}
+
+// RevocationKind identifies what a revocation matches on
+type RevocationKind string
+
+const (
+	RevokeBySerial    RevocationKind = "serial"
+	RevokeByKeyID     RevocationKind = "key_id"
+	RevokeByPublicKey RevocationKind = "public_key"
+)
+
+// Revocation is a single entry in the revocation list
+type Revocation struct {
+	Kind      RevocationKind `json:"kind"`
+	Value     string         `json:"value"` // decimal serial, key ID, or authorized_keys public key
+	Reason    string         `json:"reason,omitempty"`
+	RevokedBy string         `json:"revoked_by,omitempty"`
+	RevokedAt time.Time      `json:"revoked_at"`
+}
+
+// SerialRevocation builds a revocation for a cert serial
+func SerialRevocation(serial uint64) Revocation {
+	return Revocation{Kind: RevokeBySerial, Value: strconv.FormatUint(serial, 10)}
+}
+
+// Store persists issuance records and revocations
+type Store interface {
+	// RecordIssued stores a newly issued cert
+	RecordIssued(ctx context.Context, rec *Record) error
+
+	// Lookup returns the record for a serial, or ErrNotFound
+	Lookup(ctx context.Context, serial uint64) (*Record, error)
+
+	// Query returns matching records, newest first
+	Query(ctx context.Context, q Query) ([]*Record, error)
+
+	// Revoke adds a revocation; revoking the same value twice is a no-op
+	Revoke(ctx context.Context, rev Revocation) error
+
+	// Revocations returns all revocations, oldest first
+	Revocations(ctx context.Context) ([]Revocation, error)
+
+	Close() error
+}
+
+// Store drivers
+const (
+	DriverSQLite = "sqlite"
+	DriverFile   = "file"
+)
+
+// Open opens a store using the named driver
+// An empty path keeps the ledger in memory
+func Open(driver, path string) (Store, error) {
+	switch driver {
+	case DriverSQLite, "":
+		return OpenSQLiteStore(path)
+	case DriverFile:
+		return OpenFileStore(path)
+	default:
+		return nil, fmt.Errorf("unknown store driver %q (use %q or %q)", driver, DriverSQLite, DriverFile)
+	}
+}
@@ -0,0 +1,215 @@
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// storeOpeners returns a fresh, persistent store of each driver rooted at dir
+var storeOpeners = map[string]func(path string) (Store, error){
+	DriverSQLite: func(path string) (Store, error) { return OpenSQLiteStore(path) },
+	DriverFile:   func(path string) (Store, error) { return OpenFileStore(path) },
+}
+
+func forEachDriver(t *testing.T, fn func(t *testing.T, open func() (Store, error))) {
+	for name, opener := range storeOpeners {
+		opener := opener
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "ledger."+name)
+			fn(t, func() (Store, error) { return opener(path) })
+		})
+	}
+}
+
+func TestStorePersistence(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, open func() (Store, error)) {
+		ctx := context.Background()
+
+		store, err := open()
+		if err != nil {
+			t.Fatalf("open error = %v", err)
+		}
+
+		rec := &Record{
+			Serial:      18446744073709551615, // max uint64 must survive the round trip
+			KeyID:       "cassh:alice",
+			User:        "alice@example.com",
+			Principals:  []string{"alice", "alice_corp"},
+			ValidAfter:  time.Unix(1700000000, 0).UTC(),
+			ValidBefore: time.Unix(1700043200, 0).UTC(),
+			IssuedAt:    time.Now().UTC(),
+		}
+		if err := store.RecordIssued(ctx, rec); err != nil {
+			t.Fatalf("RecordIssued() error = %v", err)
+		}
+		if err := store.Revoke(ctx, SerialRevocation(rec.Serial)); err != nil {
+			t.Fatalf("Revoke() error = %v", err)
+		}
+		if err := store.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+
+		// Reopen and make sure everything survived
+		reopened, err := open()
+		if err != nil {
+			t.Fatalf("open error = %v", err)
+		}
+		defer func() { _ = reopened.Close() }()
+
+		got, err := reopened.Lookup(ctx, rec.Serial)
+		if err != nil {
+			t.Fatalf("Lookup() error = %v", err)
+		}
+		if got.KeyID != rec.KeyID || got.User != rec.User {
+			t.Errorf("Lookup() = %+v, want %+v", got, rec)
+		}
+		if len(got.Principals) != 2 || got.Principals[0] != "alice" || got.Principals[1] != "alice_corp" {
+			t.Errorf("Principals = %v, want [alice alice_corp]", got.Principals)
+		}
+		if !got.ValidBefore.Equal(rec.ValidBefore) {
+			t.Errorf("ValidBefore = %v, want %v", got.ValidBefore, rec.ValidBefore)
+		}
+
+		revs, err := reopened.Revocations(ctx)
+		if err != nil {
+			t.Fatalf("Revocations() error = %v", err)
+		}
+		if len(revs) != 1 || revs[0].Kind != RevokeBySerial || revs[0].Value != "18446744073709551615" {
+			t.Errorf("Revocations() = %+v, want one serial revocation", revs)
+		}
+	})
+}
+
+func TestStoreLookupNotFound(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, open func() (Store, error)) {
+		store, err := open()
+		if err != nil {
+			t.Fatalf("open error = %v", err)
+		}
+		defer func() { _ = store.Close() }()
+
+		if _, err := store.Lookup(context.Background(), 1); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Lookup() error = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestStoreRevokeIdempotent(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, open func() (Store, error)) {
+		ctx := context.Background()
+		store, err := open()
+		if err != nil {
+			t.Fatalf("open error = %v", err)
+		}
+		defer func() { _ = store.Close() }()
+
+		rev := Revocation{Kind: RevokeByKeyID, Value: "cassh:bob"}
+		for i := 0; i < 3; i++ {
+			if err := store.Revoke(ctx, rev); err != nil {
+				t.Fatalf("Revoke() error = %v", err)
+			}
+		}
+
+		revs, _ := store.Revocations(ctx)
+		if len(revs) != 1 {
+			t.Errorf("len(Revocations()) = %d, want 1", len(revs))
+		}
+	})
+}
+
+func TestStoreQuery(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2025, 1, d, 9, 0, 0, 0, time.UTC) }
+
+	records := []*Record{
+		{Serial: 1, User: "alice@example.com", Principals: []string{"alice"}, ValidAfter: day(6), ValidBefore: day(6).Add(12 * time.Hour), IssuedAt: day(6)},
+		{Serial: 2, User: "alice@example.com", Principals: []string{"alice"}, ValidAfter: day(7), ValidBefore: day(7).Add(12 * time.Hour), IssuedAt: day(7)},
+		{Serial: 3, User: "bob@example.com", Principals: []string{"bob"}, ValidAfter: day(7), ValidBefore: day(7).Add(12 * time.Hour), IssuedAt: day(7).Add(time.Minute)},
+		{Serial: 4, User: "svc@example.com", Principals: []string{"deploy", "alice"}, ValidAfter: day(9), ValidBefore: day(9).Add(time.Hour), IssuedAt: day(9)},
+	}
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []uint64
+	}{
+		{"All, newest first", Query{}, []uint64{4, 3, 2, 1}},
+		{"By user", Query{User: "alice@example.com"}, []uint64{2, 1}},
+		{"By principal", Query{User: "alice"}, []uint64{4, 2, 1}},
+		{"Valid on a given day", Query{User: "alice", Since: day(7), Until: day(7).Add(24 * time.Hour)}, []uint64{2}},
+		{"Since only", Query{Since: day(8)}, []uint64{4}},
+		{"Limit", Query{Limit: 2}, []uint64{4, 3}},
+		{"No match", Query{User: "mallory"}, nil},
+	}
+
+	forEachDriver(t, func(t *testing.T, open func() (Store, error)) {
+		ctx := context.Background()
+		store, err := open()
+		if err != nil {
+			t.Fatalf("open error = %v", err)
+		}
+		defer func() { _ = store.Close() }()
+
+		for _, rec := range records {
+			if err := store.RecordIssued(ctx, rec); err != nil {
+				t.Fatalf("RecordIssued() error = %v", err)
+			}
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				got, err := store.Query(ctx, tt.query)
+				if err != nil {
+					t.Fatalf("Query() error = %v", err)
+				}
+				if len(got) != len(tt.want) {
+					t.Fatalf("Query() returned %d records, want %v", len(got), tt.want)
+				}
+				for i, serial := range tt.want {
+					if got[i].Serial != serial {
+						t.Errorf("Query()[%d].Serial = %d, want %d", i, got[i].Serial, serial)
+					}
+				}
+			})
+		}
+	})
+}
+
+func TestRecordSerialJSON(t *testing.T) {
+	// Serials past 2^53 lose precision as JSON numbers, so they're written as strings
+	rec := &Record{Serial: 18446744073709551615, KeyID: "alice"}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+
+	// Older ledgers wrote the serial as a number, which still decodes
+	tests := map[string]uint64{
+		string(data):                       rec.Serial,
+		`{"serial":42,"key_id":"alice"}`:   42,
+		`{"serial":"42","key_id":"alice"}`: 42,
+	}
+	for in, want := range tests {
+		var got Record
+		if err := json.Unmarshal([]byte(in), &got); err != nil {
+			t.Fatalf("Unmarshal(%s) error = %v", in, err)
+		}
+		if got.Serial != want || got.KeyID != "alice" {
+			t.Errorf("Unmarshal(%s) = serial %d, key ID %q, want %d, alice", in, got.Serial, got.KeyID, want)
+		}
+	}
+
+	var got Record
+	if err := json.Unmarshal([]byte(`{"serial":"-1"}`), &got); err == nil {
+		t.Error("Unmarshal() accepted a negative serial")
+	}
+}
+
+func TestOpenUnknownDriver(t *testing.T) {
+	if _, err := Open("postgres", ""); err == nil {
+		t.Error("Open() expected error for unknown driver")
+	}
+}
@@ -0,0 +1,253 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // Pure-Go SQLite driver (no cgo, works in the static server image)
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS certificates (
+	serial       TEXT PRIMARY KEY,
+	key_id       TEXT NOT NULL,
+	user         TEXT NOT NULL,
+	public_key   TEXT NOT NULL,
+	fingerprint  TEXT NOT NULL,
+	remote_addr  TEXT NOT NULL,
+	valid_after  INTEGER NOT NULL,
+	valid_before INTEGER NOT NULL,
+	issued_at    INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS certificates_user ON certificates (user);
+CREATE INDEX IF NOT EXISTS certificates_validity ON certificates (valid_after, valid_before);
+
+CREATE TABLE IF NOT EXISTS certificate_principals (
+	serial    TEXT NOT NULL REFERENCES certificates (serial),
+	principal TEXT NOT NULL,
+	position  INTEGER NOT NULL,
+	PRIMARY KEY (serial, principal)
+);
+CREATE INDEX IF NOT EXISTS certificate_principals_principal ON certificate_principals (principal);
+
+CREATE TABLE IF NOT EXISTS revocations (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	kind       TEXT NOT NULL,
+	value      TEXT NOT NULL,
+	reason     TEXT NOT NULL,
+	revoked_by TEXT NOT NULL,
+	revoked_at INTEGER NOT NULL,
+	UNIQUE (kind, value)
+);
+`
+
+// SQLiteStore keeps the ledger in a SQLite database
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// OpenSQLiteStore opens (or creates) a SQLite ledger at path
+// An empty path uses a private in-memory database
+func OpenSQLiteStore(path string) (*SQLiteStore, error) {
+	dsn := ":memory:"
+	if path != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, fmt.Errorf("failed to create ledger directory: %w", err)
+		}
+		dsn = "file:" + path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)"
+	}
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ledger: %w", err)
+	}
+	// SQLite allows a single writer; one connection also keeps :memory: databases shared
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to initialize ledger schema: %w", err)
+	}
+
+	return &SQLiteStore{db: db}, nil
+}
+
+// RecordIssued implements Store
+func (s *SQLiteStore) RecordIssued(ctx context.Context, rec *Record) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	serial := strconv.FormatUint(rec.Serial, 10)
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO certificates (serial, key_id, user, public_key, fingerprint, remote_addr, valid_after, valid_before, issued_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		serial, rec.KeyID, rec.User, rec.PublicKey, rec.Fingerprint, rec.RemoteAddr,
+		rec.ValidAfter.Unix(), rec.ValidBefore.Unix(), rec.IssuedAt.UnixNano(),
+	); err != nil {
+		return fmt.Errorf("failed to insert certificate: %w", err)
+	}
+
+	for i, principal := range rec.Principals {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT OR IGNORE INTO certificate_principals (serial, principal, position) VALUES (?, ?, ?)`,
+			serial, principal, i,
+		); err != nil {
+			return fmt.Errorf("failed to insert principal: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Lookup implements Store
+func (s *SQLiteStore) Lookup(ctx context.Context, serial uint64) (*Record, error) {
+	recs, err := s.query(ctx, "WHERE serial = ?", []interface{}{strconv.FormatUint(serial, 10)})
+	if err != nil {
+		return nil, err
+	}
+	if len(recs) == 0 {
+		return nil, ErrNotFound
+	}
+	return recs[0], nil
+}
+
+// Query implements Store
+func (s *SQLiteStore) Query(ctx context.Context, q Query) ([]*Record, error) {
+	var where []string
+	var args []interface{}
+
+	if q.User != "" {
+		where = append(where, "(user = ? OR serial IN (SELECT serial FROM certificate_principals WHERE principal = ?))")
+		args = append(args, q.User, q.User)
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "valid_before >= ?")
+		args = append(args, q.Since.Unix())
+	}
+	if !q.Until.IsZero() {
+		where = append(where, "valid_after <= ?")
+		args = append(args, q.Until.Unix())
+	}
+
+	clause := ""
+	if len(where) > 0 {
+		clause = "WHERE " + strings.Join(where, " AND ")
+	}
+	clause += " ORDER BY issued_at DESC"
+	if q.Limit > 0 {
+		clause += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+
+	return s.query(ctx, clause, args)
+}
+
+func (s *SQLiteStore) query(ctx context.Context, clause string, args []interface{}) ([]*Record, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT serial, key_id, user, public_key, fingerprint, remote_addr, valid_after, valid_before, issued_at
+		 FROM certificates `+clause, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query certificates: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var recs []*Record
+	for rows.Next() {
+		var rec Record
+		var serial string
+		var validAfter, validBefore, issuedAt int64
+		if err := rows.Scan(&serial, &rec.KeyID, &rec.User, &rec.PublicKey, &rec.Fingerprint, &rec.RemoteAddr,
+			&validAfter, &validBefore, &issuedAt); err != nil {
+			return nil, err
+		}
+		if rec.Serial, err = strconv.ParseUint(serial, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid serial %q in ledger: %w", serial, err)
+		}
+		rec.ValidAfter = time.Unix(validAfter, 0).UTC()
+		rec.ValidBefore = time.Unix(validBefore, 0).UTC()
+		rec.IssuedAt = time.Unix(0, issuedAt).UTC()
+		recs = append(recs, &rec)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Principals are loaded after the cursor is closed; the pool only has one connection
+	_ = rows.Close()
+	for _, rec := range recs {
+		if rec.Principals, err = s.principals(ctx, rec.Serial); err != nil {
+			return nil, err
+		}
+	}
+
+	return recs, nil
+}
+
+func (s *SQLiteStore) principals(ctx context.Context, serial uint64) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT principal FROM certificate_principals WHERE serial = ? ORDER BY position`,
+		strconv.FormatUint(serial, 10))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query principals: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var principals []string
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		principals = append(principals, p)
+	}
+	return principals, rows.Err()
+}
+
+// Revoke implements Store
+func (s *SQLiteStore) Revoke(ctx context.Context, rev Revocation) error {
+	if _, err := s.db.ExecContext(ctx,
+		`INSERT OR IGNORE INTO revocations (kind, value, reason, revoked_by, revoked_at) VALUES (?, ?, ?, ?, ?)`,
+		string(rev.Kind), rev.Value, rev.Reason, rev.RevokedBy, rev.RevokedAt.UnixNano(),
+	); err != nil {
+		return fmt.Errorf("failed to insert revocation: %w", err)
+	}
+	return nil
+}
+
+// Revocations implements Store
+func (s *SQLiteStore) Revocations(ctx context.Context) ([]Revocation, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT kind, value, reason, revoked_by, revoked_at FROM revocations ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query revocations: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var revs []Revocation
+	for rows.Next() {
+		var rev Revocation
+		var kind string
+		var revokedAt int64
+		if err := rows.Scan(&kind, &rev.Value, &rev.Reason, &rev.RevokedBy, &revokedAt); err != nil {
+			return nil, err
+		}
+		rev.Kind = RevocationKind(kind)
+		rev.RevokedAt = time.Unix(0, revokedAt).UTC()
+		revs = append(revs, rev)
+	}
+	return revs, rows.Err()
+}
+
+// Close implements Store
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
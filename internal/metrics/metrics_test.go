@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func render(t *testing.T, reg *Registry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := reg.Write(&buf); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	return buf.String()
+}
+
+func TestCounter(t *testing.T) {
+	reg := NewRegistry()
+	plain := reg.NewCounter("test_plain_total", "Plain counter")
+	byReason := reg.NewCounter("test_failures_total", "Failures by reason", "reason")
+
+	byReason.Inc("nonce_mismatch")
+	byReason.Inc("invalid_state")
+	byReason.Add(2, "invalid_state")
+
+	want := `# HELP test_plain_total Plain counter
+# TYPE test_plain_total counter
+test_plain_total 0
+# HELP test_failures_total Failures by reason
+# TYPE test_failures_total counter
+test_failures_total{reason="invalid_state"} 3
+test_failures_total{reason="nonce_mismatch"} 1
+`
+	if got := render(t, reg); got != want {
+		t.Errorf("output mismatch\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	plain.Inc()
+	if got := plain.Value(); got != 1 {
+		t.Errorf("Value() = %v, want 1", got)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	reg := NewRegistry()
+	h := reg.NewHistogram("test_duration_seconds", "Durations", []float64{1, 0.1}, "route")
+
+	h.Observe(0.05, "/a")
+	h.Observe(0.1, "/a") // le is inclusive
+	h.Observe(0.5, "/a")
+	h.Observe(3, "/a")
+
+	want := `# HELP test_duration_seconds Durations
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{route="/a",le="0.1"} 2
+test_duration_seconds_bucket{route="/a",le="1"} 3
+test_duration_seconds_bucket{route="/a",le="+Inf"} 4
+test_duration_seconds_sum{route="/a"} 3.65
+test_duration_seconds_count{route="/a"} 4
+`
+	if got := render(t, reg); got != want {
+		t.Errorf("output mismatch\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestGaugeFunc(t *testing.T) {
+	reg := NewRegistry()
+	n := 0
+	reg.NewGaugeFunc("test_pending", "Pending things", func() float64 { return float64(n) })
+
+	n = 7
+	if got := render(t, reg); !strings.Contains(got, "test_pending 7\n") {
+		t.Errorf("gauge not evaluated at scrape time:\n%s", got)
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	reg := NewRegistry()
+	c := reg.NewCounter("test_total", "Help with \\ and\nnewline", "v")
+	c.Inc("a\"b\\c\nd")
+
+	got := render(t, reg)
+	if !strings.Contains(got, `# HELP test_total Help with \\ and\nnewline`) {
+		t.Errorf("help not escaped:\n%s", got)
+	}
+	if !strings.Contains(got, `test_total{v="a\"b\\c\nd"} 1`) {
+		t.Errorf("label not escaped:\n%s", got)
+	}
+}
+
+func TestWrongLabelCountPanics(t *testing.T) {
+	reg := NewRegistry()
+	c := reg.NewCounter("test_total", "Help", "a", "b")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for wrong number of label values")
+		}
+	}()
+	c.Inc("only-one")
+}
+
+func TestHandler(t *testing.T) {
+	reg := NewRegistry()
+	reg.NewCounter("test_total", "Help").Inc()
+
+	rec := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("Content-Type = %q, want %q", ct, ContentType)
+	}
+	body, _ := io.ReadAll(rec.Body)
+	if !strings.Contains(string(body), "test_total 1\n") {
+		t.Errorf("unexpected body:\n%s", body)
+	}
+}
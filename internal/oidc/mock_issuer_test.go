@@ -0,0 +1,200 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockIssuer is a minimal OIDC provider for tests
+// It serves discovery, JWKS and a token endpoint that exchanges codes registered with issueCode
+// /authorize signs the user in with loginClaims straight away and redirects back with a code
+type mockIssuer struct {
+	*httptest.Server
+	t        *testing.T
+	key      *rsa.PrivateKey
+	clientID string
+
+	mu          sync.Mutex
+	codes       map[string]mockCode
+	loginClaims map[string]interface{}
+	tokenForms  []url.Values // Token requests received, for checking client authentication
+}
+
+type mockCode struct {
+	claims    map[string]interface{}
+	challenge string // PKCE S256 challenge the token request's verifier must match (optional)
+}
+
+const mockKeyID = "test-key"
+
+func newMockIssuer(t *testing.T, clientID string) *mockIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() error = %v", err)
+	}
+
+	m := &mockIssuer{
+		t:        t,
+		key:      key,
+		clientID: clientID,
+		codes:    make(map[string]mockCode),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", m.handleDiscovery)
+	mux.HandleFunc("/keys", m.handleJWKS)
+	mux.HandleFunc("/authorize", m.handleAuthorize)
+	mux.HandleFunc("/token", m.handleToken)
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+
+	return m
+}
+
+// issueCode registers an authorization code that exchanges for an ID token with claims
+// iss, aud, iat and exp are filled in unless set
+func (m *mockIssuer) issueCode(code string, claims map[string]interface{}) {
+	m.issueCodeWithChallenge(code, "", claims)
+}
+
+// issueCodeWithChallenge registers a code bound to a PKCE S256 challenge
+func (m *mockIssuer) issueCodeWithChallenge(code, challenge string, claims map[string]interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.codes[code] = mockCode{claims: claims, challenge: challenge}
+}
+
+func (m *mockIssuer) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "PKCE required", http.StatusBadRequest)
+		return
+	}
+
+	claims := map[string]interface{}{"nonce": q.Get("nonce")}
+	m.mu.Lock()
+	for k, v := range m.loginClaims {
+		claims[k] = v
+	}
+	m.mu.Unlock()
+	if aud := q.Get("client_id"); aud != m.clientID {
+		claims["aud"] = aud
+	}
+
+	code := "code-" + q.Get("state")
+	m.issueCodeWithChallenge(code, q.Get("code_challenge"), claims)
+
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	redirect.RawQuery = url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (m *mockIssuer) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]interface{}{
+		"issuer":                                m.URL,
+		"authorization_endpoint":                m.URL + "/authorize",
+		"token_endpoint":                        m.URL + "/token",
+		"jwks_uri":                              m.URL + "/keys",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (m *mockIssuer) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	pub := m.key.PublicKey
+	writeJSON(w, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": mockKeyID,
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+func (m *mockIssuer) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	code, ok := m.codes[r.PostForm.Get("code")]
+	delete(m.codes, r.PostForm.Get("code"))
+	m.tokenForms = append(m.tokenForms, r.PostForm)
+	m.mu.Unlock()
+
+	if ok && code.challenge != "" {
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		ok = base64.RawURLEncoding.EncodeToString(sum[:]) == code.challenge
+	}
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"access_token": "mock-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     m.signIDToken(code.claims),
+	})
+}
+
+// signIDToken returns an RS256 JWT with the standard claims filled in
+func (m *mockIssuer) signIDToken(claims map[string]interface{}) string {
+	m.t.Helper()
+
+	now := time.Now()
+	full := map[string]interface{}{
+		"iss": m.URL,
+		"aud": m.clientID,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+	for k, v := range claims {
+		full[k] = v
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": mockKeyID})
+	payload, err := json.Marshal(full)
+	if err != nil {
+		m.t.Fatalf("json.Marshal() error = %v", err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, digest[:])
+	if err != nil {
+		m.t.Fatalf("rsa.SignPKCS1v15() error = %v", err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
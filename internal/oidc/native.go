@@ -0,0 +1,155 @@
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/oauth2"
+)
+
+// ErrNativeDisabled is returned when no native client is configured
+var ErrNativeDisabled = errors.New("native client sign-in is not enabled")
+
+// NativeClient is what cassh-cli needs to sign in with the provider itself
+// The server publishes it at /api/v1/oidc/native
+type NativeClient struct {
+	Issuer   string   `json:"issuer"`
+	ClientID string   `json:"client_id"`
+	Scopes   []string `json:"scopes"`
+}
+
+// NativeClient returns the public client config, or nil if it's disabled
+func (a *Authenticator) NativeClient() *NativeClient {
+	if a.nativeVerifier == nil {
+		return nil
+	}
+	return &NativeClient{
+		Issuer:   a.config.Issuer,
+		ClientID: a.config.NativeClientID,
+		Scopes:   scopes(a.config.Scopes),
+	}
+}
+
+// KeyNonce binds an ID token to an SSH key: native clients send it as the nonce,
+// so a leaked token can't be replayed to get a cert for a different key
+func KeyNonce(pubKey ssh.PublicKey) string {
+	sum := sha256.Sum256(pubKey.Marshal())
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// VerifyNativeToken checks an ID token obtained by the native client for pubKey
+func (a *Authenticator) VerifyNativeToken(ctx context.Context, rawIDToken string, pubKey ssh.PublicKey) (*UserInfo, error) {
+	if a.nativeVerifier == nil {
+		return nil, ErrNativeDisabled
+	}
+
+	idToken, err := a.nativeVerifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify ID token: %w", err)
+	}
+	if idToken.Nonce != KeyNonce(pubKey) {
+		return nil, ErrNonceMismatch
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("failed to parse claims: %w", err)
+	}
+
+	return NewUserInfo(claims, a.config.Claims), nil
+}
+
+// LoopbackLogin signs in as a native public client (RFC 8252): it listens on 127.0.0.1,
+// has open show the user the authorization URL, and returns the ID token from the PKCE code exchange
+func LoopbackLogin(ctx context.Context, client *NativeClient, nonce string, open func(authURL string)) (string, error) {
+	provider, err := oidc.NewProvider(ctx, client.Issuer)
+	if err != nil {
+		return "", fmt.Errorf("failed to discover provider: %w", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", fmt.Errorf("failed to start loopback listener: %w", err)
+	}
+	defer func() { _ = ln.Close() }()
+
+	conf := &oauth2.Config{
+		ClientID:    client.ClientID,
+		RedirectURL: fmt.Sprintf("http://127.0.0.1:%d/callback", ln.Addr().(*net.TCPAddr).Port),
+		Endpoint:    endpoint(provider, ""),
+		Scopes:      scopes(client.Scopes),
+	}
+
+	state, err := generateRandomString(32)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate state: %w", err)
+	}
+	codeVerifier := oauth2.GenerateVerifier()
+
+	type result struct {
+		code string
+		err  error
+	}
+	results := make(chan result, 1)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		var res result
+		switch {
+		case q.Get("state") != state:
+			res.err = ErrInvalidState
+		case q.Get("error") != "":
+			res.err = fmt.Errorf("sign-in failed: %s %s", q.Get("error"), q.Get("error_description"))
+		default:
+			res.code = q.Get("code")
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if res.err != nil {
+			fmt.Fprintf(w, "<p>cassh sign-in failed: %s</p>", html.EscapeString(res.err.Error()))
+		} else {
+			fmt.Fprint(w, "<p>Signed in to cassh. You can close this window.</p>")
+		}
+
+		select {
+		case results <- res:
+		default:
+		}
+	})
+
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() { _ = srv.Serve(ln) }()
+	defer func() { _ = srv.Close() }()
+
+	open(conf.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(codeVerifier)))
+
+	var res result
+	select {
+	case res = <-results:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	if res.err != nil {
+		return "", res.err
+	}
+
+	token, err := conf.Exchange(ctx, res.code, oauth2.VerifierOption(codeVerifier))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrExchangeFailed, err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return "", fmt.Errorf("no id_token in response")
+	}
+	return rawIDToken, nil
+}
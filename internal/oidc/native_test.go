@@ -0,0 +1,145 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const testNativeClientID = "cassh-cli"
+
+func newTestKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey() error = %v", err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("ssh.NewPublicKey() error = %v", err)
+	}
+	return key
+}
+
+// loopbackLogin runs LoopbackLogin against issuer, following the redirect like a browser would
+func loopbackLogin(t *testing.T, client *NativeClient, nonce string) (string, error) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return LoopbackLogin(ctx, client, nonce, func(authURL string) {
+		go func() {
+			resp, err := http.Get(authURL)
+			if err == nil {
+				_ = resp.Body.Close()
+			}
+		}()
+	})
+}
+
+func TestNativeLogin(t *testing.T) {
+	issuer := newMockIssuer(t, testClientID)
+	issuer.loginClaims = map[string]interface{}{"sub": "user-1", "email": "alice@example.com"}
+
+	auth, err := NewAuthenticator(context.Background(), &Config{
+		Issuer:         issuer.URL,
+		ClientID:       testClientID,
+		ClientSecret:   "secret",
+		NativeClientID: testNativeClientID,
+		Scopes:         []string{"email", "groups"},
+	})
+	if err != nil {
+		t.Fatalf("NewAuthenticator() error = %v", err)
+	}
+
+	client := auth.NativeClient()
+	if client == nil || client.ClientID != testNativeClientID || client.Issuer != issuer.URL {
+		t.Fatalf("NativeClient() = %+v, want the native client", client)
+	}
+
+	key := newTestKey(t)
+	rawIDToken, err := loopbackLogin(t, client, KeyNonce(key))
+	if err != nil {
+		t.Fatalf("LoopbackLogin() error = %v", err)
+	}
+
+	form := issuer.tokenForms[len(issuer.tokenForms)-1]
+	if form.Get("client_id") != testNativeClientID || form.Has("client_secret") || form.Get("code_verifier") == "" {
+		t.Errorf("token request = %v, want a public client with a PKCE verifier", form)
+	}
+
+	userInfo, err := auth.VerifyNativeToken(context.Background(), rawIDToken, key)
+	if err != nil {
+		t.Fatalf("VerifyNativeToken() error = %v", err)
+	}
+	if userInfo.Email != "alice@example.com" {
+		t.Errorf("VerifyNativeToken() email = %q, want alice@example.com", userInfo.Email)
+	}
+
+	// The token is bound to the key it was requested for
+	if _, err := auth.VerifyNativeToken(context.Background(), rawIDToken, newTestKey(t)); !errors.Is(err, ErrNonceMismatch) {
+		t.Errorf("VerifyNativeToken(other key) error = %v, want ErrNonceMismatch", err)
+	}
+}
+
+func TestVerifyNativeTokenRejects(t *testing.T) {
+	issuer := newMockIssuer(t, testClientID)
+	key := newTestKey(t)
+
+	disabled, err := NewAuthenticator(context.Background(), &Config{Issuer: issuer.URL, ClientID: testClientID})
+	if err != nil {
+		t.Fatalf("NewAuthenticator() error = %v", err)
+	}
+	if disabled.NativeClient() != nil {
+		t.Error("NativeClient() != nil without a native client ID")
+	}
+	if _, err := disabled.VerifyNativeToken(context.Background(), "token", key); !errors.Is(err, ErrNativeDisabled) {
+		t.Errorf("VerifyNativeToken() error = %v, want ErrNativeDisabled", err)
+	}
+
+	auth, err := NewAuthenticator(context.Background(), &Config{Issuer: issuer.URL, ClientID: testClientID, NativeClientID: testNativeClientID})
+	if err != nil {
+		t.Fatalf("NewAuthenticator() error = %v", err)
+	}
+
+	// A token issued to the web client isn't accepted from the native client
+	webToken := issuer.signIDToken(map[string]interface{}{"sub": "user-1", "nonce": KeyNonce(key)})
+	if _, err := auth.VerifyNativeToken(context.Background(), webToken, key); err == nil {
+		t.Error("VerifyNativeToken() accepted a token for another audience")
+	}
+}
+
+func TestLoopbackLoginRejectsWrongState(t *testing.T) {
+	issuer := newMockIssuer(t, testClientID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := LoopbackLogin(ctx, &NativeClient{Issuer: issuer.URL, ClientID: testNativeClientID}, "nonce", func(authURL string) {
+		u, err := url.Parse(authURL)
+		if err != nil {
+			t.Errorf("url.Parse() error = %v", err)
+			return
+		}
+		// A forged callback to the loopback listener, e.g. from a malicious page
+		forged := u.Query().Get("redirect_uri") + "?" + url.Values{"code": {"stolen"}, "state": {"forged"}}.Encode()
+		go func() {
+			resp, err := http.Get(forged)
+			if err == nil {
+				_ = resp.Body.Close()
+			}
+		}()
+	})
+	if !errors.Is(err, ErrInvalidState) {
+		t.Errorf("LoopbackLogin() error = %v, want ErrInvalidState", err)
+	}
+}
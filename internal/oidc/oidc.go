@@ -1,60 +1,121 @@
-// Handles Microsoft Entra ID (Azure AD) auth
+// Handles OpenID Connect auth against any standards-compliant provider
+// Microsoft Entra ID is a preset (EntraIssuer); Okta, Google Workspace and Keycloak use their issuer URL
 package oidc
 
 import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/http"
-	"sync"
 	"time"
 
 	"github.com/coreos/go-oidc/v3/oidc"
 	"golang.org/x/oauth2"
 )
 
-// EntraConfig holds Microsoft Entra ID configuration
-type EntraConfig struct {
-	TenantID     string
+// Callback failures that callers may want to tell apart (e.g., for metrics)
+var (
+	ErrInvalidState   = errors.New("invalid state - possible CSRF attack")
+	ErrNonceMismatch  = errors.New("invalid nonce - possible replay attack")
+	ErrExchangeFailed = errors.New("failed to exchange code")
+	ErrTooManyStates  = errors.New("too many sign-ins in progress")
+)
+
+// Config holds provider-neutral OIDC settings
+type Config struct {
+	Issuer       string // e.g., https://accounts.google.com or EntraIssuer(tenantID)
 	ClientID     string
-	ClientSecret string
+	ClientSecret string // Empty for a public client (PKCE only)
 	RedirectURL  string
+
+	// NativeClientID is a public client that cassh-cli signs in with directly (empty disables it)
+	// ID tokens it obtains are accepted by VerifyNativeToken
+	NativeClientID string
+
+	// Scopes to request; "openid" is always included
+	// Defaults to openid, email, profile
+	Scopes []string
+
+	// Claims maps ID token claims onto UserInfo fields
+	Claims ClaimMapping
+
+	// States holds auth flows until the callback (default: in memory)
+	States StateStore
+
+	// MaxPendingStates refuses new flows while this many wait for a callback (0 = no cap)
+	// Cookie stores keep nothing server-side, so they aren't capped
+	MaxPendingStates int
+}
+
+// ClaimMapping names the ID token claims used to populate UserInfo
+// Empty fields use the standard OIDC claim names
+type ClaimMapping struct {
+	Email    string // Default: "email"
+	Name     string // Default: "name"
+	Username string // Default: "preferred_username"
+}
+
+// Standard claim names
+const (
+	defaultEmailClaim    = "email"
+	defaultNameClaim     = "name"
+	defaultUsernameClaim = "preferred_username"
+)
+
+// EntraIssuer returns the OIDC issuer URL for a Microsoft Entra ID tenant
+func EntraIssuer(tenantID string) string {
+	return fmt.Sprintf("https://login.microsoftonline.com/%s/v2.0", tenantID)
 }
 
-// Authenticator handles OIDC authentication flow with Entra ID
+// Authenticator handles the OIDC authentication flow
 type Authenticator struct {
-	config   *EntraConfig
+	config   *Config
 	provider *oidc.Provider
 	oauth2   *oauth2.Config
 	verifier *oidc.IDTokenVerifier
 
+	// Verifies ID tokens issued to NativeClientID (nil if disabled)
+	nativeVerifier *oidc.IDTokenVerifier
+
 	// State management for CSRF protection
-	states     map[string]*authState
-	statesLock sync.RWMutex
+	states StateStore
 }
 
-type authState struct {
-	state     string
-	nonce     string
-	pubKey    string // User's SSH public key
-	createdAt time.Time
+// AuthRequest is what the client asked for when it started authentication
+// It's held server-side with the state and handed back on callback
+type AuthRequest struct {
+	PubKey   string `json:"pubkey"`              // User's SSH public key
+	Template string `json:"template,omitempty"`  // Requested cert template (optional)
+	Target   string `json:"target,omitempty"`    // Requested target, e.g. a GHE org (optional)
+	UserCode string `json:"user_code,omitempty"` // Device authorization being approved (optional)
+
+	// What the client reported about itself and where it asked from, for the cert's key ID
+	DeviceName    string `json:"device_name,omitempty"`
+	ClientVersion string `json:"client_version,omitempty"`
+	ClientIP      string `json:"client_ip,omitempty"`
 }
 
-// UserInfo contains verified user information from Entra ID
+// UserInfo contains verified user information from the ID token
 type UserInfo struct {
 	Subject       string `json:"sub"`
 	Email         string `json:"email"`
 	EmailVerified bool   `json:"email_verified"`
 	Name          string `json:"name"`
 	Username      string `json:"preferred_username"`
+
+	// All ID token claims, for policy decisions on groups, roles, etc.
+	Claims map[string]interface{} `json:"-"`
 }
 
-// NewAuthenticator creates a new Entra ID authenticator
-func NewAuthenticator(ctx context.Context, cfg *EntraConfig) (*Authenticator, error) {
-	issuerURL := fmt.Sprintf("https://login.microsoftonline.com/%s/v2.0", cfg.TenantID)
+// NewAuthenticator discovers the provider at cfg.Issuer and creates an authenticator
+func NewAuthenticator(ctx context.Context, cfg *Config) (*Authenticator, error) {
+	if cfg.Issuer == "" {
+		return nil, fmt.Errorf("OIDC issuer is required")
+	}
 
-	provider, err := oidc.NewProvider(ctx, issuerURL)
+	provider, err := oidc.NewProvider(ctx, cfg.Issuer)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create OIDC provider: %w", err)
 	}
@@ -63,26 +124,37 @@ func NewAuthenticator(ctx context.Context, cfg *EntraConfig) (*Authenticator, er
 		ClientID:     cfg.ClientID,
 		ClientSecret: cfg.ClientSecret,
 		RedirectURL:  cfg.RedirectURL,
-		Endpoint:     provider.Endpoint(),
-		Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+		Endpoint:     endpoint(provider, cfg.ClientSecret),
+		Scopes:       scopes(cfg.Scopes),
 	}
 
 	verifier := provider.Verifier(&oidc.Config{
 		ClientID: cfg.ClientID,
 	})
 
+	var nativeVerifier *oidc.IDTokenVerifier
+	if cfg.NativeClientID != "" {
+		nativeVerifier = provider.Verifier(&oidc.Config{ClientID: cfg.NativeClientID})
+	}
+
+	states := cfg.States
+	if states == nil {
+		states = NewMemoryStateStore()
+	}
+
 	return &Authenticator{
-		config:   cfg,
-		provider: provider,
-		oauth2:   oauth2Config,
-		verifier: verifier,
-		states:   make(map[string]*authState),
+		config:         cfg,
+		provider:       provider,
+		oauth2:         oauth2Config,
+		verifier:       verifier,
+		nativeVerifier: nativeVerifier,
+		states:         states,
 	}, nil
 }
 
 // StartAuth initiates the authentication flow
-// Returns the authorization URL to redirect the user to
-func (a *Authenticator) StartAuth(pubKey string) (string, error) {
+// Returns the authorization URL to redirect the user to; w receives the state cookie for cookie stores
+func (a *Authenticator) StartAuth(ctx context.Context, w http.ResponseWriter, req *AuthRequest) (string, error) {
 	state, err := generateRandomString(32)
 	if err != nil {
 		return "", fmt.Errorf("failed to generate state: %w", err)
@@ -93,95 +165,153 @@ func (a *Authenticator) StartAuth(pubKey string) (string, error) {
 		return "", fmt.Errorf("failed to generate nonce: %w", err)
 	}
 
+	// PKCE (S256): the code is useless without the verifier, which never leaves the server
+	codeVerifier := oauth2.GenerateVerifier()
+
 	// Store state for verification
-	a.statesLock.Lock()
-	a.states[state] = &authState{
-		state:     state,
-		nonce:     nonce,
-		pubKey:    pubKey,
-		createdAt: time.Now(),
+	err = a.saveState(ctx, w, &State{
+		Key:          state,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		Request:      *req,
+		CreatedAt:    time.Now(),
+	})
+	if err != nil {
+		return "", err
 	}
-	a.statesLock.Unlock()
-
-	// Clean up old states
-	go a.cleanupStates()
 
-	url := a.oauth2.AuthCodeURL(state, oidc.Nonce(nonce))
+	url := a.oauth2.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(codeVerifier))
 	return url, nil
 }
 
-// HandleCallback processes the OIDC callback and returns user info
-func (a *Authenticator) HandleCallback(ctx context.Context, r *http.Request) (*UserInfo, string, error) {
+// HandleCallback processes the OIDC callback and returns user info with the original request
+func (a *Authenticator) HandleCallback(ctx context.Context, w http.ResponseWriter, r *http.Request) (*UserInfo, *AuthRequest, error) {
 	state := r.URL.Query().Get("state")
 	code := r.URL.Query().Get("code")
 
 	if state == "" || code == "" {
-		return nil, "", fmt.Errorf("missing state or code")
+		return nil, nil, fmt.Errorf("missing state or code")
 	}
 
-	// Verify state
-	a.statesLock.RLock()
-	authState, exists := a.states[state]
-	a.statesLock.RUnlock()
-
-	if !exists {
-		return nil, "", fmt.Errorf("invalid state - possible CSRF attack")
+	// Verify and remove the state, so it can only be used once
+	authState, err := a.states.Take(ctx, w, r, state)
+	if err != nil {
+		return nil, nil, err
+	}
+	if authState.CodeVerifier == "" {
+		return nil, nil, ErrInvalidState
 	}
 
-	// Remove used state
-	a.statesLock.Lock()
-	delete(a.states, state)
-	a.statesLock.Unlock()
-
-	// Exchange code for token
-	token, err := a.oauth2.Exchange(ctx, code)
+	// Exchange code for token; the provider rejects it unless the verifier matches the challenge
+	token, err := a.oauth2.Exchange(ctx, code, oauth2.VerifierOption(authState.CodeVerifier))
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to exchange code: %w", err)
+		return nil, nil, fmt.Errorf("%w: %v", ErrExchangeFailed, err)
 	}
 
 	// Extract ID token
 	rawIDToken, ok := token.Extra("id_token").(string)
 	if !ok {
-		return nil, "", fmt.Errorf("no id_token in response")
+		return nil, nil, fmt.Errorf("no id_token in response")
 	}
 
 	// Verify ID token
 	idToken, err := a.verifier.Verify(ctx, rawIDToken)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to verify ID token: %w", err)
+		return nil, nil, fmt.Errorf("failed to verify ID token: %w", err)
 	}
 
 	// Verify nonce
-	var claims struct {
-		Nonce string `json:"nonce"`
+	if idToken.Nonce != authState.Nonce {
+		return nil, nil, ErrNonceMismatch
 	}
+
+	// Extract user info
+	var claims map[string]interface{}
 	if err := idToken.Claims(&claims); err != nil {
-		return nil, "", fmt.Errorf("failed to parse claims: %w", err)
+		return nil, nil, fmt.Errorf("failed to parse claims: %w", err)
 	}
-	if claims.Nonce != authState.nonce {
-		return nil, "", fmt.Errorf("invalid nonce - possible replay attack")
+
+	return NewUserInfo(claims, a.config.Claims), &authState.Request, nil
+}
+
+// NewUserInfo maps ID token claims onto UserInfo using the given claim names
+func NewUserInfo(claims map[string]interface{}, mapping ClaimMapping) *UserInfo {
+	return &UserInfo{
+		Subject:       stringClaim(claims, "sub", "sub"),
+		Email:         stringClaim(claims, mapping.Email, defaultEmailClaim),
+		EmailVerified: boolClaim(claims, "email_verified"),
+		Name:          stringClaim(claims, mapping.Name, defaultNameClaim),
+		Username:      stringClaim(claims, mapping.Username, defaultUsernameClaim),
+		Claims:        claims,
 	}
+}
 
-	// Extract user info
-	var userInfo UserInfo
-	if err := idToken.Claims(&userInfo); err != nil {
-		return nil, "", fmt.Errorf("failed to extract user info: %w", err)
+func stringClaim(claims map[string]interface{}, name, fallback string) string {
+	if name == "" {
+		name = fallback
+	}
+	v, _ := claims[name].(string)
+	return v
+}
+
+// boolClaim accepts both JSON booleans and "true" strings (some providers send the latter)
+func boolClaim(claims map[string]interface{}, name string) bool {
+	switch v := claims[name].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
 	}
+	return false
+}
 
-	return &userInfo, authState.pubKey, nil
+// endpoint returns the provider's endpoints
+// Public clients have no secret to send, so client_id goes in the request body
+func endpoint(provider *oidc.Provider, clientSecret string) oauth2.Endpoint {
+	ep := provider.Endpoint()
+	if clientSecret == "" {
+		ep.AuthStyle = oauth2.AuthStyleInParams
+	}
+	return ep
 }
 
-// cleanupStates removes expired auth states (older than 10 minutes)
-func (a *Authenticator) cleanupStates() {
-	a.statesLock.Lock()
-	defer a.statesLock.Unlock()
+// scopes returns the scopes to request, always including openid
+func scopes(configured []string) []string {
+	if len(configured) == 0 {
+		return []string{oidc.ScopeOpenID, "email", "profile"}
+	}
 
-	cutoff := time.Now().Add(-10 * time.Minute)
-	for key, state := range a.states {
-		if state.createdAt.Before(cutoff) {
-			delete(a.states, key)
+	out := []string{oidc.ScopeOpenID}
+	for _, scope := range configured {
+		if scope != oidc.ScopeOpenID {
+			out = append(out, scope)
 		}
 	}
+	return out
+}
+
+// saveState saves a new flow, returning ErrTooManyStates once MaxPendingStates flows are waiting
+// Cookie stores keep nothing server-side, so they're never capped
+func (a *Authenticator) saveState(ctx context.Context, w http.ResponseWriter, st *State) error {
+	counter, ok := a.states.(stateCounter)
+	if a.config.MaxPendingStates <= 0 || !ok {
+		return a.states.Save(ctx, w, st)
+	}
+	return counter.SaveCapped(ctx, w, st, a.config.MaxPendingStates)
+}
+
+// PendingStates returns the number of auth flows waiting for a callback
+// It's always 0 for cookie stores, which keep nothing server-side
+func (a *Authenticator) PendingStates(ctx context.Context) int {
+	counter, ok := a.states.(stateCounter)
+	if !ok {
+		return 0
+	}
+	n, err := counter.Pending(ctx)
+	if err != nil {
+		return 0
+	}
+	return n
 }
 
 func generateRandomString(length int) (string, error) {
@@ -0,0 +1,299 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+const testClientID = "cassh-test"
+
+// startAuth runs StartAuth and returns the state and nonce from the authorization URL
+// plus any cookies it set (cookie state stores)
+func startAuth(t *testing.T, auth *Authenticator, pubKey string) (state, nonce string, authURL *url.URL, cookies []*http.Cookie) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	raw, err := auth.StartAuth(context.Background(), w, &AuthRequest{PubKey: pubKey, Template: "bastion"})
+	if err != nil {
+		t.Fatalf("StartAuth() error = %v", err)
+	}
+	authURL, err = url.Parse(raw)
+	if err != nil {
+		t.Fatalf("url.Parse() error = %v", err)
+	}
+	return authURL.Query().Get("state"), authURL.Query().Get("nonce"), authURL, w.Result().Cookies()
+}
+
+// callbackRequest builds the provider's redirect back to the callback
+func callbackRequest(state, code string, cookies []*http.Cookie) *http.Request {
+	r := httptest.NewRequest("GET", "/auth/callback?state="+url.QueryEscape(state)+"&code="+url.QueryEscape(code), nil)
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+func newTestAuthenticator(t *testing.T, issuer *mockIssuer, claims ClaimMapping) *Authenticator {
+	t.Helper()
+
+	auth, err := NewAuthenticator(context.Background(), &Config{
+		Issuer:       issuer.URL,
+		ClientID:     testClientID,
+		ClientSecret: "secret",
+		RedirectURL:  "https://cassh.example.com/auth/callback",
+		Claims:       claims,
+	})
+	if err != nil {
+		t.Fatalf("NewAuthenticator() error = %v", err)
+	}
+	return auth
+}
+
+func TestHandleCallback(t *testing.T) {
+	tests := []struct {
+		name    string
+		mapping ClaimMapping
+		claims  map[string]interface{}
+		want    UserInfo
+	}{
+		{
+			name: "standard claims (Entra, Okta, Keycloak)",
+			claims: map[string]interface{}{
+				"sub":                "user-1",
+				"email":              "alice@example.com",
+				"email_verified":     true,
+				"name":               "Alice",
+				"preferred_username": "alice@example.com",
+			},
+			want: UserInfo{Subject: "user-1", Email: "alice@example.com", EmailVerified: true, Name: "Alice", Username: "alice@example.com"},
+		},
+		{
+			name: "no preferred_username (Google)",
+			claims: map[string]interface{}{
+				"sub":            "1234567890",
+				"email":          "bob@example.com",
+				"email_verified": "true",
+				"hd":             "example.com",
+			},
+			want: UserInfo{Subject: "1234567890", Email: "bob@example.com", EmailVerified: true},
+		},
+		{
+			name:    "custom claim mapping",
+			mapping: ClaimMapping{Email: "mail", Name: "display_name", Username: "login"},
+			claims: map[string]interface{}{
+				"sub":                "user-3",
+				"mail":               "carol@example.com",
+				"display_name":       "Carol",
+				"login":              "carol",
+				"preferred_username": "ignored",
+			},
+			want: UserInfo{Subject: "user-3", Email: "carol@example.com", Name: "Carol", Username: "carol"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newMockIssuer(t, testClientID)
+			auth := newTestAuthenticator(t, issuer, tt.mapping)
+
+			state, nonce, _, _ := startAuth(t, auth, "ssh-ed25519 AAAA test")
+			tt.claims["nonce"] = nonce
+			issuer.issueCode("code-1", tt.claims)
+
+			r := callbackRequest(state, "code-1", nil)
+			userInfo, req, err := auth.HandleCallback(context.Background(), httptest.NewRecorder(), r)
+			if err != nil {
+				t.Fatalf("HandleCallback() error = %v", err)
+			}
+
+			if req.PubKey != "ssh-ed25519 AAAA test" || req.Template != "bastion" {
+				t.Errorf("HandleCallback() request = %+v, want the one passed to StartAuth", req)
+			}
+			if userInfo.Claims["sub"] != tt.want.Subject {
+				t.Errorf("Claims[sub] = %v, want %q", userInfo.Claims["sub"], tt.want.Subject)
+			}
+			userInfo.Claims = nil
+			if !reflect.DeepEqual(*userInfo, tt.want) {
+				t.Errorf("HandleCallback() = %+v, want %+v", *userInfo, tt.want)
+			}
+			if n := auth.PendingStates(context.Background()); n != 0 {
+				t.Errorf("PendingStates() = %d after callback, want 0", n)
+			}
+		})
+	}
+}
+
+func TestHandleCallbackFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		state   func(state string) string
+		nonce   func(nonce string) string
+		code    string
+		wantErr error
+	}{
+		{
+			name:    "unknown state",
+			state:   func(string) string { return "forged" },
+			nonce:   func(n string) string { return n },
+			code:    "good",
+			wantErr: ErrInvalidState,
+		},
+		{
+			name:    "nonce mismatch",
+			state:   func(s string) string { return s },
+			nonce:   func(string) string { return "replayed" },
+			code:    "good",
+			wantErr: ErrNonceMismatch,
+		},
+		{
+			name:    "code rejected by provider",
+			state:   func(s string) string { return s },
+			nonce:   func(n string) string { return n },
+			code:    "bad",
+			wantErr: ErrExchangeFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newMockIssuer(t, testClientID)
+			auth := newTestAuthenticator(t, issuer, ClaimMapping{})
+
+			state, nonce, _, _ := startAuth(t, auth, "ssh-ed25519 AAAA test")
+			issuer.issueCode("good", map[string]interface{}{"sub": "user-1", "nonce": tt.nonce(nonce)})
+
+			r := callbackRequest(tt.state(state), tt.code, nil)
+			_, _, err := auth.HandleCallback(context.Background(), httptest.NewRecorder(), r)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("HandleCallback() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStartAuthScopes(t *testing.T) {
+	tests := []struct {
+		name   string
+		scopes []string
+		want   string
+	}{
+		{"default", nil, "openid email profile"},
+		{"custom scopes add openid", []string{"email", "groups"}, "openid email groups"},
+		{"openid not duplicated", []string{"openid", "profile"}, "openid profile"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newMockIssuer(t, testClientID)
+			auth, err := NewAuthenticator(context.Background(), &Config{
+				Issuer:   issuer.URL,
+				ClientID: testClientID,
+				Scopes:   tt.scopes,
+			})
+			if err != nil {
+				t.Fatalf("NewAuthenticator() error = %v", err)
+			}
+
+			_, _, authURL, _ := startAuth(t, auth, "key")
+			if got := authURL.Query().Get("scope"); got != tt.want {
+				t.Errorf("scope = %q, want %q", got, tt.want)
+			}
+			if got := authURL.Scheme + "://" + authURL.Host; got != issuer.URL {
+				t.Errorf("authorization endpoint host = %q, want issuer %q", got, issuer.URL)
+			}
+		})
+	}
+}
+
+func TestStartAuthMaxPendingStates(t *testing.T) {
+	issuer := newMockIssuer(t, testClientID)
+	auth, err := NewAuthenticator(context.Background(), &Config{
+		Issuer:           issuer.URL,
+		ClientID:         testClientID,
+		ClientSecret:     "secret",
+		RedirectURL:      "https://cassh.example.com/auth/callback",
+		MaxPendingStates: 2,
+	})
+	if err != nil {
+		t.Fatalf("NewAuthenticator() error = %v", err)
+	}
+
+	state, nonce, _, cookies := startAuth(t, auth, "key-1")
+	startAuth(t, auth, "key-2")
+	if _, err := auth.StartAuth(context.Background(), httptest.NewRecorder(), &AuthRequest{PubKey: "key-3"}); !errors.Is(err, ErrTooManyStates) {
+		t.Fatalf("StartAuth() over the cap error = %v, want ErrTooManyStates", err)
+	}
+
+	// Finishing a flow makes room for another
+	issuer.issueCode("code-1", map[string]interface{}{"sub": "user-1", "nonce": nonce})
+	if _, _, err := auth.HandleCallback(context.Background(), httptest.NewRecorder(), callbackRequest(state, "code-1", cookies)); err != nil {
+		t.Fatalf("HandleCallback() error = %v", err)
+	}
+	startAuth(t, auth, "key-3")
+}
+
+func TestNewAuthenticatorRequiresIssuer(t *testing.T) {
+	if _, err := NewAuthenticator(context.Background(), &Config{ClientID: testClientID}); err == nil {
+		t.Error("NewAuthenticator() with no issuer should fail")
+	}
+}
+
+func TestEntraIssuer(t *testing.T) {
+	want := "https://login.microsoftonline.com/tenant-123/v2.0"
+	if got := EntraIssuer("tenant-123"); got != want {
+		t.Errorf("EntraIssuer() = %q, want %q", got, want)
+	}
+}
+
+func TestHandleCallbackPKCE(t *testing.T) {
+	tests := []struct {
+		name         string
+		clientSecret string
+		otherFlow    bool
+		wantErr      error
+	}{
+		{name: "confidential client", clientSecret: "secret"},
+		{name: "public client", clientSecret: ""},
+		{name: "verifier from another flow", clientSecret: "secret", otherFlow: true, wantErr: ErrExchangeFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newMockIssuer(t, testClientID)
+			auth, err := NewAuthenticator(context.Background(), &Config{
+				Issuer:       issuer.URL,
+				ClientID:     testClientID,
+				ClientSecret: tt.clientSecret,
+			})
+			if err != nil {
+				t.Fatalf("NewAuthenticator() error = %v", err)
+			}
+
+			state, nonce, authURL, _ := startAuth(t, auth, "ssh-ed25519 AAAA test")
+			if m := authURL.Query().Get("code_challenge_method"); m != "S256" {
+				t.Fatalf("code_challenge_method = %q, want S256", m)
+			}
+			challenge := authURL.Query().Get("code_challenge")
+			if tt.otherFlow {
+				_, _, other, _ := startAuth(t, auth, "ssh-ed25519 AAAA test")
+				challenge = other.Query().Get("code_challenge")
+			}
+			issuer.issueCodeWithChallenge("code-1", challenge, map[string]interface{}{"sub": "user-1", "nonce": nonce})
+
+			_, _, err = auth.HandleCallback(context.Background(), httptest.NewRecorder(), callbackRequest(state, "code-1", nil))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("HandleCallback() error = %v, want %v", err, tt.wantErr)
+			}
+
+			form := issuer.tokenForms[len(issuer.tokenForms)-1]
+			if tt.clientSecret == "" && (form.Get("client_id") != testClientID || form.Has("client_secret")) {
+				t.Errorf("public client token request = %v, want client_id and no client_secret", form)
+			}
+		})
+	}
+}
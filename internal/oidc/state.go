@@ -0,0 +1,148 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// StateTTL is how long a user has to finish signing in at the provider
+const StateTTL = 10 * time.Minute
+
+// State is an auth flow in progress between StartAuth and the callback
+type State struct {
+	Key          string      `json:"key"` // The OAuth state parameter
+	Nonce        string      `json:"nonce"`
+	CodeVerifier string      `json:"code_verifier"` // PKCE verifier for the code exchange
+	Request      AuthRequest `json:"request"`
+	CreatedAt    time.Time   `json:"created_at"`
+}
+
+func (s *State) expired() bool {
+	return time.Since(s.CreatedAt) > StateTTL
+}
+
+// StateStore holds auth flows until the provider redirects back
+// Replicas behind a load balancer must share one, since the callback can land on any of them
+type StateStore interface {
+	// Save stores a new flow; w is only used by stores that keep it client-side
+	Save(ctx context.Context, w http.ResponseWriter, st *State) error
+
+	// Take returns and removes the flow for key
+	// Unknown or expired flows return ErrInvalidState
+	Take(ctx context.Context, w http.ResponseWriter, r *http.Request, key string) (*State, error)
+}
+
+// stateCounter is implemented by stores that keep flows server-side and can count them
+type stateCounter interface {
+	Pending(ctx context.Context) (int, error)
+
+	// SaveCapped is Save, but returns ErrTooManyStates instead once max flows are pending
+	// The count and the save happen as one step, so concurrent sign-ins can't overshoot max
+	SaveCapped(ctx context.Context, w http.ResponseWriter, st *State, max int) error
+}
+
+// State store drivers
+const (
+	StateDriverMemory = "memory"
+	StateDriverSQLite = "sqlite"
+	StateDriverRedis  = "redis"
+	StateDriverCookie = "cookie"
+)
+
+// StateStoreConfig selects and configures a StateStore
+type StateStoreConfig struct {
+	Driver    string // memory (default), sqlite, redis or cookie
+	Path      string // SQLite database (sqlite)
+	URL       string // redis://[user:password@]host:port[/db] or rediss:// (redis)
+	CookieKey string // Base64-encoded 32-byte key shared by all replicas (cookie)
+
+	// Set the Secure attribute on state cookies (the server is served over HTTPS)
+	SecureCookie bool
+}
+
+// OpenStateStore opens the configured state store
+func OpenStateStore(cfg StateStoreConfig) (StateStore, error) {
+	switch cfg.Driver {
+	case StateDriverMemory, "":
+		return NewMemoryStateStore(), nil
+	case StateDriverSQLite:
+		return OpenSQLiteStateStore(cfg.Path)
+	case StateDriverRedis:
+		return NewRedisStateStore(cfg.URL)
+	case StateDriverCookie:
+		return NewCookieStateStore(cfg.CookieKey, cfg.SecureCookie)
+	default:
+		return nil, fmt.Errorf("unknown state store driver %q (use %q, %q, %q or %q)",
+			cfg.Driver, StateDriverMemory, StateDriverSQLite, StateDriverRedis, StateDriverCookie)
+	}
+}
+
+// MemoryStateStore keeps flows in process memory
+// It only works with a single replica (or sticky sessions)
+type MemoryStateStore struct {
+	mu     sync.Mutex
+	states map[string]*State
+}
+
+// NewMemoryStateStore creates an empty in-memory store
+func NewMemoryStateStore() *MemoryStateStore {
+	return &MemoryStateStore{states: make(map[string]*State)}
+}
+
+// Save implements StateStore
+func (s *MemoryStateStore) Save(ctx context.Context, w http.ResponseWriter, st *State) error {
+	return s.SaveCapped(ctx, w, st, 0)
+}
+
+// SaveCapped saves st unless max flows are pending (0 = no cap)
+func (s *MemoryStateStore) SaveCapped(ctx context.Context, w http.ResponseWriter, st *State, max int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Clean up abandoned flows
+	for key, old := range s.states {
+		if old.expired() {
+			delete(s.states, key)
+		}
+	}
+
+	if max > 0 && len(s.states) >= max {
+		return ErrTooManyStates
+	}
+	s.states[st.Key] = st
+	return nil
+}
+
+// Take implements StateStore
+func (s *MemoryStateStore) Take(ctx context.Context, w http.ResponseWriter, r *http.Request, key string) (*State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.states[key]
+	if !ok {
+		return nil, ErrInvalidState
+	}
+	delete(s.states, key)
+
+	if st.expired() {
+		return nil, ErrInvalidState
+	}
+	return st, nil
+}
+
+// Pending returns the number of unexpired flows
+func (s *MemoryStateStore) Pending(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for _, st := range s.states {
+		if !st.expired() {
+			n++
+		}
+	}
+	return n, nil
+}
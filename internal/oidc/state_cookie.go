@@ -0,0 +1,107 @@
+package oidc
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// stateCookiePrefix names state cookies; the state key is appended so parallel logins don't collide
+const stateCookiePrefix = "cassh_oidc_"
+
+// CookieStateStore keeps no server-side state: flows are sealed with AES-GCM into a cookie
+// on the user's browser, so any replica holding the same key can finish the login
+type CookieStateStore struct {
+	aead   cipher.AEAD
+	secure bool
+}
+
+// NewCookieStateStore creates a store from a base64-encoded 32-byte key
+func NewCookieStateStore(key string, secure bool) (*CookieStateStore, error) {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(raw) != 32 {
+		return nil, fmt.Errorf("cookie state store requires a base64-encoded 32-byte key (generate one with: openssl rand -base64 32)")
+	}
+
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CookieStateStore{aead: aead, secure: secure}, nil
+}
+
+// Save implements StateStore by setting the sealed state cookie
+func (s *CookieStateStore) Save(ctx context.Context, w http.ResponseWriter, st *State) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	// The state key is bound as additional data, so a cookie can't be replayed under another state
+	sealed := s.aead.Seal(nonce, nonce, data, []byte(st.Key))
+
+	http.SetCookie(w, s.cookie(st.Key, base64.RawURLEncoding.EncodeToString(sealed), int(StateTTL.Seconds())))
+	return nil
+}
+
+// Take implements StateStore by opening the state cookie and clearing it
+func (s *CookieStateStore) Take(ctx context.Context, w http.ResponseWriter, r *http.Request, key string) (*State, error) {
+	c, err := r.Cookie(stateCookieName(key))
+	if err != nil {
+		return nil, ErrInvalidState
+	}
+	http.SetCookie(w, s.cookie(key, "", -1))
+
+	sealed, err := base64.RawURLEncoding.DecodeString(c.Value)
+	if err != nil || len(sealed) < s.aead.NonceSize() {
+		return nil, ErrInvalidState
+	}
+	data, err := s.aead.Open(nil, sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():], []byte(key))
+	if err != nil {
+		return nil, ErrInvalidState
+	}
+
+	var st State
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("failed to decode state: %w", err)
+	}
+	if st.Key != key || st.expired() {
+		return nil, ErrInvalidState
+	}
+	return &st, nil
+}
+
+func (s *CookieStateStore) cookie(key, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     stateCookieName(key),
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   s.secure,
+		// Lax still sends the cookie on the provider's top-level redirect back to the callback
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// stateCookieName derives the cookie name from the (URL-safe base64) state key
+func stateCookieName(key string) string {
+	if len(key) > 16 {
+		key = key[:16]
+	}
+	return stateCookiePrefix + key
+}
@@ -0,0 +1,346 @@
+package oidc
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redisKeyPrefix namespaces state keys so the Redis database can be shared
+const redisKeyPrefix = "cassh:oidc:state:"
+
+// redisPendingKey is a sorted set of pending state keys, scored by when they expire (Unix ms)
+// Counting it is O(log n), where counting state keys would scan the keyspace
+const redisPendingKey = "cassh:oidc:pending"
+
+// redisTimeout bounds each round trip when the context has no deadline
+const redisTimeout = 5 * time.Second
+
+// errRedisNil is a nil bulk reply (missing key)
+var errRedisNil = errors.New("redis: nil")
+
+// RedisStateStore keeps flows in Redis (or anything speaking its protocol: Valkey, KeyDB, ...)
+// Keys expire on their own; taking a state uses GETDEL, so Redis 6.2 or newer is required
+// Pending flows are also listed in redisPendingKey, which SaveCapped and Pending count
+type RedisStateStore struct {
+	addr     string
+	username string
+	password string
+	db       int
+	tls      *tls.Config
+
+	mu   sync.Mutex
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+// NewRedisStateStore parses a redis:// or rediss:// URL
+// The connection is made on first use
+func NewRedisStateStore(rawURL string) (*RedisStateStore, error) {
+	if rawURL == "" {
+		return nil, fmt.Errorf("redis state store requires a URL")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis URL: %w", err)
+	}
+
+	s := &RedisStateStore{addr: u.Host}
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		s.tls = &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+	default:
+		return nil, fmt.Errorf("invalid redis URL: scheme must be redis or rediss")
+	}
+	if u.Port() == "" {
+		s.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		s.username = u.User.Username()
+		s.password, _ = u.User.Password()
+		// redis://:password@host is the usual form for a password without ACL users
+		if s.username != "" && s.password == "" {
+			s.username, s.password = "", s.username
+		}
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if s.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid redis database %q", db)
+		}
+	}
+
+	return s, nil
+}
+
+// Save implements StateStore
+func (s *RedisStateStore) Save(ctx context.Context, w http.ResponseWriter, st *State) error {
+	return s.SaveCapped(ctx, w, st, 0)
+}
+
+// SaveCapped saves st unless max flows are pending (0 = no cap)
+// The flow is added to the pending set before it's counted, so two replicas racing for the
+// last slot can both be refused but never both admitted
+func (s *RedisStateStore) SaveCapped(ctx context.Context, w http.ResponseWriter, st *State, max int) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+
+	expiresAt := st.CreatedAt.Add(StateTTL)
+	ttl := strconv.FormatInt(time.Until(expiresAt).Milliseconds(), 10)
+	replies, err := s.pipeline(ctx,
+		// Clean up abandoned flows
+		[]string{"ZREMRANGEBYSCORE", redisPendingKey, "-inf", "(" + redisMillis(time.Now())},
+		[]string{"ZADD", redisPendingKey, redisMillis(expiresAt), st.Key},
+		// The newest flow expires last, so the set never outlives it
+		[]string{"PEXPIRE", redisPendingKey, ttl},
+		[]string{"ZCARD", redisPendingKey},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	if n, _ := replies[3].(int64); max > 0 && n > int64(max) {
+		s.unlist(ctx, st.Key)
+		return ErrTooManyStates
+	}
+
+	if _, err := s.do(ctx, "SET", redisKeyPrefix+st.Key, string(data), "PX", ttl); err != nil {
+		s.unlist(ctx, st.Key)
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	return nil
+}
+
+// Take implements StateStore
+func (s *RedisStateStore) Take(ctx context.Context, w http.ResponseWriter, r *http.Request, key string) (*State, error) {
+	reply, err := s.do(ctx, "GETDEL", redisKeyPrefix+key)
+	if errors.Is(err, errRedisNil) {
+		return nil, ErrInvalidState
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state: %w", err)
+	}
+	s.unlist(ctx, key)
+
+	data, _ := reply.(string)
+	var st State
+	if err := json.Unmarshal([]byte(data), &st); err != nil {
+		return nil, fmt.Errorf("failed to decode state: %w", err)
+	}
+	if st.expired() {
+		return nil, ErrInvalidState
+	}
+	return &st, nil
+}
+
+// Pending counts unexpired entries in the pending set
+func (s *RedisStateStore) Pending(ctx context.Context) (int, error) {
+	reply, err := s.do(ctx, "ZCOUNT", redisPendingKey, redisMillis(time.Now()), "+inf")
+	if err != nil {
+		return 0, err
+	}
+	n, _ := reply.(int64)
+	return int(n), nil
+}
+
+// unlist removes key from the pending set
+// Failures are ignored: a stale entry only counts against the cap until it expires
+func (s *RedisStateStore) unlist(ctx context.Context, key string) {
+	_, _ = s.do(ctx, "ZREM", redisPendingKey, key)
+}
+
+func redisMillis(t time.Time) string {
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}
+
+// Close closes the connection
+func (s *RedisStateStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// do sends one command and reads its reply, reconnecting if needed
+// Commands are serialized over a single connection; state traffic is a few round trips per login
+func (s *RedisStateStore) do(ctx context.Context, args ...string) (interface{}, error) {
+	replies, err := s.pipeline(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	return replies[0], nil
+}
+
+// pipeline sends cmds in one write and reads all their replies
+// The error is the first failed command's; nil replies are only an error for a single command
+func (s *RedisStateStore) pipeline(ctx context.Context, cmds ...[]string) ([]interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		if err := s.connect(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	replies, err := s.roundTrip(ctx, cmds...)
+	var redisErr redisError
+	if err != nil && !errors.Is(err, errRedisNil) && !errors.As(err, &redisErr) {
+		// The connection is in an unknown state; start over next time
+		_ = s.conn.Close()
+		s.conn = nil
+	}
+	return replies, err
+}
+
+func (s *RedisStateStore) connect(ctx context.Context) error {
+	dialer := &net.Dialer{Timeout: redisTimeout}
+	var conn net.Conn
+	var err error
+	if s.tls != nil {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: s.tls}).DialContext(ctx, "tcp", s.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", s.addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to redis: %w", err)
+	}
+	s.conn = conn
+	s.rd = bufio.NewReader(conn)
+
+	var setup [][]string
+	if s.password != "" {
+		if s.username != "" {
+			setup = append(setup, []string{"AUTH", s.username, s.password})
+		} else {
+			setup = append(setup, []string{"AUTH", s.password})
+		}
+	}
+	if s.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(s.db)})
+	}
+	for _, args := range setup {
+		if _, err := s.roundTrip(ctx, args); err != nil {
+			_ = conn.Close()
+			s.conn = nil
+			return fmt.Errorf("redis %s failed: %w", args[0], err)
+		}
+	}
+	return nil
+}
+
+func (s *RedisStateStore) roundTrip(ctx context.Context, cmds ...[]string) ([]interface{}, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(redisTimeout)
+	}
+	if err := s.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	// Commands are sent as arrays of bulk strings
+	var b strings.Builder
+	for _, args := range cmds {
+		fmt.Fprintf(&b, "*%d\r\n", len(args))
+		for _, arg := range args {
+			fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	if _, err := io.WriteString(s.conn, b.String()); err != nil {
+		return nil, err
+	}
+
+	// Read every reply, even after an error reply, so the connection stays in step
+	replies := make([]interface{}, len(cmds))
+	var firstErr error
+	for i := range cmds {
+		reply, err := readRESP(s.rd)
+		var redisErr redisError
+		switch {
+		case err == nil:
+			replies[i] = reply
+		case errors.Is(err, errRedisNil) && len(cmds) > 1:
+		case errors.Is(err, errRedisNil) || errors.As(err, &redisErr):
+			if firstErr == nil {
+				firstErr = err
+			}
+		default:
+			return nil, err
+		}
+	}
+	return replies, firstErr
+}
+
+// redisError is an error reply from the server (the connection is still usable)
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// readRESP reads one RESP2 reply: strings, integers, errors, bulk strings and arrays
+func readRESP(rd *bufio.Reader) (interface{}, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad bulk length %q", line)
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(rd, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad array length %q", line)
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		items := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			item, err := readRESP(rd)
+			if err != nil && !errors.Is(err, errRedisNil) {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
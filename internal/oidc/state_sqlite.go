@@ -0,0 +1,128 @@
+package oidc
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "modernc.org/sqlite" // Pure-Go SQLite driver
+)
+
+const stateSchema = `
+CREATE TABLE IF NOT EXISTS oidc_states (
+	key        TEXT PRIMARY KEY,
+	data       TEXT NOT NULL,
+	expires_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS oidc_states_expires_at ON oidc_states (expires_at);
+`
+
+// SQLiteStateStore keeps flows in a SQLite database
+// Replicas on one host (or a shared volume with working locks) can share the file
+type SQLiteStateStore struct {
+	db *sql.DB
+}
+
+// OpenSQLiteStateStore opens (or creates) a state database at path
+func OpenSQLiteStateStore(path string) (*SQLiteStateStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("sqlite state store requires a path")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create state store directory: %w", err)
+	}
+
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state store: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(stateSchema); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to initialize state store schema: %w", err)
+	}
+
+	return &SQLiteStateStore{db: db}, nil
+}
+
+// Save implements StateStore
+func (s *SQLiteStateStore) Save(ctx context.Context, w http.ResponseWriter, st *State) error {
+	return s.SaveCapped(ctx, w, st, 0)
+}
+
+// SaveCapped saves st unless max flows are pending (0 = no cap)
+// The count is part of the INSERT, so replicas sharing the file can't both take the last slot
+func (s *SQLiteStateStore) SaveCapped(ctx context.Context, w http.ResponseWriter, st *State, max int) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+
+	// Clean up abandoned flows
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM oidc_states WHERE expires_at < ?`, time.Now().Unix()); err != nil {
+		return fmt.Errorf("failed to expire states: %w", err)
+	}
+
+	if max <= 0 {
+		_, err = s.db.ExecContext(ctx, `INSERT INTO oidc_states (key, data, expires_at) VALUES (?, ?, ?)`,
+			st.Key, string(data), st.CreatedAt.Add(StateTTL).Unix())
+		if err != nil {
+			return fmt.Errorf("failed to save state: %w", err)
+		}
+		return nil
+	}
+
+	res, err := s.db.ExecContext(ctx, `INSERT INTO oidc_states (key, data, expires_at)
+		SELECT ?, ?, ? WHERE (SELECT COUNT(*) FROM oidc_states WHERE expires_at >= ?) < ?`,
+		st.Key, string(data), st.CreatedAt.Add(StateTTL).Unix(), time.Now().Unix(), max)
+	if err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	} else if n == 0 {
+		return ErrTooManyStates
+	}
+	return nil
+}
+
+// Take implements StateStore
+// DELETE ... RETURNING makes sure only one replica can use a state
+func (s *SQLiteStateStore) Take(ctx context.Context, w http.ResponseWriter, r *http.Request, key string) (*State, error) {
+	var data string
+	err := s.db.QueryRowContext(ctx, `DELETE FROM oidc_states WHERE key = ? RETURNING data`, key).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidState
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state: %w", err)
+	}
+
+	var st State
+	if err := json.Unmarshal([]byte(data), &st); err != nil {
+		return nil, fmt.Errorf("failed to decode state: %w", err)
+	}
+	if st.expired() {
+		return nil, ErrInvalidState
+	}
+	return &st, nil
+}
+
+// Pending returns the number of unexpired flows
+func (s *SQLiteStateStore) Pending(ctx context.Context) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM oidc_states WHERE expires_at >= ?`, time.Now().Unix()).Scan(&n)
+	return n, err
+}
+
+// Close closes the database
+func (s *SQLiteStateStore) Close() error {
+	return s.db.Close()
+}
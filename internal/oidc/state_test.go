@@ -0,0 +1,442 @@
+package oidc
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testCookieKey = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=" // 32 bytes
+
+// fakeRedis is a local Redis stand-in speaking just enough RESP for RedisStateStore
+type fakeRedis struct {
+	net.Listener
+	password string
+
+	mu    sync.Mutex
+	data  map[string]string
+	zsets map[string]map[string]float64
+	exp   map[string]time.Time
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() error = %v", err)
+	}
+	f := &fakeRedis{Listener: ln, password: password, data: map[string]string{}, zsets: map[string]map[string]float64{}, exp: map[string]time.Time{}}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) URL() string {
+	if f.password != "" {
+		return "redis://:" + f.password + "@" + f.Addr().String() + "/2"
+	}
+	return "redis://" + f.Addr().String()
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	rd := bufio.NewReader(conn)
+	authed := f.password == ""
+
+	for {
+		reply, err := readRESP(rd)
+		if err != nil {
+			return
+		}
+		items, _ := reply.([]interface{})
+		args := make([]string, len(items))
+		for i, item := range items {
+			args[i], _ = item.(string)
+		}
+		if len(args) == 0 {
+			return
+		}
+
+		cmd := strings.ToUpper(args[0])
+		if !authed && cmd != "AUTH" {
+			fmt.Fprint(conn, "-NOAUTH Authentication required.\r\n")
+			continue
+		}
+		fmt.Fprint(conn, f.exec(cmd, args[1:], &authed))
+	}
+}
+
+func (f *fakeRedis) exec(cmd string, args []string, authed *bool) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for key, exp := range f.exp {
+		if time.Now().After(exp) {
+			delete(f.data, key)
+			delete(f.zsets, key)
+			delete(f.exp, key)
+		}
+	}
+
+	switch cmd {
+	case "AUTH":
+		if args[len(args)-1] != f.password {
+			return "-WRONGPASS invalid password\r\n"
+		}
+		*authed = true
+		return "+OK\r\n"
+	case "SELECT", "PING":
+		return "+OK\r\n"
+	case "SET":
+		f.data[args[0]] = args[1]
+		if len(args) == 4 && strings.ToUpper(args[2]) == "PX" {
+			ms, _ := strconv.Atoi(args[3])
+			f.exp[args[0]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		return "+OK\r\n"
+	case "GETDEL":
+		v, ok := f.data[args[0]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		delete(f.data, args[0])
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+	case "PEXPIRE":
+		ms, _ := strconv.Atoi(args[1])
+		f.exp[args[0]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		return ":1\r\n"
+	case "ZADD":
+		if f.zsets[args[0]] == nil {
+			f.zsets[args[0]] = map[string]float64{}
+		}
+		f.zsets[args[0]][args[2]], _ = strconv.ParseFloat(args[1], 64)
+		return ":1\r\n"
+	case "ZREM":
+		delete(f.zsets[args[0]], args[1])
+		return ":1\r\n"
+	case "ZCARD":
+		return fmt.Sprintf(":%d\r\n", len(f.zsets[args[0]]))
+	case "ZCOUNT", "ZREMRANGEBYSCORE":
+		n := 0
+		for member, score := range f.zsets[args[0]] {
+			if inScoreRange(score, args[1], args[2]) {
+				n++
+				if cmd == "ZREMRANGEBYSCORE" {
+					delete(f.zsets[args[0]], member)
+				}
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", n)
+	default:
+		return "-ERR unknown command '" + cmd + "'\r\n"
+	}
+}
+
+// inScoreRange reports whether score is within Redis range bounds like -inf, (1700000000000 or 42
+func inScoreRange(score float64, min, max string) bool {
+	bound := func(s string) (float64, bool) {
+		exclusive := strings.HasPrefix(s, "(")
+		v, _ := strconv.ParseFloat(strings.TrimPrefix(s, "("), 64) // ParseFloat reads -inf and +inf
+		return v, exclusive
+	}
+	lo, loExcl := bound(min)
+	hi, hiExcl := bound(max)
+	return (score > lo || !loExcl && score == lo) && (score < hi || !hiExcl && score == hi)
+}
+
+// stateStores returns one store of each driver; replicas sharing a store are simulated by reusing it
+func stateStores(t *testing.T) map[string]StateStore {
+	t.Helper()
+
+	sqlite, err := OpenSQLiteStateStore(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatalf("OpenSQLiteStateStore() error = %v", err)
+	}
+	t.Cleanup(func() { _ = sqlite.Close() })
+
+	redis, err := NewRedisStateStore(newFakeRedis(t, "hunter2").URL())
+	if err != nil {
+		t.Fatalf("NewRedisStateStore() error = %v", err)
+	}
+	t.Cleanup(func() { _ = redis.Close() })
+
+	cookie, err := NewCookieStateStore(testCookieKey, true)
+	if err != nil {
+		t.Fatalf("NewCookieStateStore() error = %v", err)
+	}
+
+	return map[string]StateStore{
+		StateDriverMemory: NewMemoryStateStore(),
+		StateDriverSQLite: sqlite,
+		StateDriverRedis:  redis,
+		StateDriverCookie: cookie,
+	}
+}
+
+// saveState saves st and returns the cookies the callback request would carry
+func saveState(t *testing.T, store StateStore, st *State) []*http.Cookie {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	if err := store.Save(context.Background(), w, st); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	return w.Result().Cookies()
+}
+
+func TestStateStores(t *testing.T) {
+	for name, store := range stateStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			st := &State{
+				Key:       "state-" + name + "-0123456789",
+				Nonce:     "nonce-1",
+				Request:   AuthRequest{PubKey: "ssh-ed25519 AAAA test", Template: "bastion", UserCode: "BDFGHJKL"},
+				CreatedAt: time.Now(),
+			}
+			cookies := saveState(t, store, st)
+
+			if counter, ok := store.(stateCounter); ok {
+				if n, err := counter.Pending(ctx); err != nil || n != 1 {
+					t.Errorf("Pending() = %d, %v, want 1", n, err)
+				}
+			}
+
+			w := httptest.NewRecorder()
+			got, err := store.Take(ctx, w, callbackRequest(st.Key, "code", cookies), st.Key)
+			if err != nil {
+				t.Fatalf("Take() error = %v", err)
+			}
+			if got.Key != st.Key || got.Nonce != st.Nonce || got.Request != st.Request {
+				t.Errorf("Take() = %+v, want %+v", got, st)
+			}
+
+			// States are single use (cookie stores clear the cookie; the code is single use at the provider)
+			if name == StateDriverCookie {
+				if c := w.Result().Cookies(); len(c) != 1 || c[0].MaxAge >= 0 {
+					t.Errorf("Take() cookies = %v, want the state cookie cleared", c)
+				}
+			} else if _, err := store.Take(ctx, httptest.NewRecorder(), callbackRequest(st.Key, "code", cookies), st.Key); !errors.Is(err, ErrInvalidState) {
+				t.Errorf("second Take() error = %v, want ErrInvalidState", err)
+			}
+
+			if _, err := store.Take(ctx, httptest.NewRecorder(), callbackRequest("unknown", "code", cookies), "unknown"); !errors.Is(err, ErrInvalidState) {
+				t.Errorf("Take(unknown) error = %v, want ErrInvalidState", err)
+			}
+		})
+	}
+}
+
+func TestStateStoresExpire(t *testing.T) {
+	for name, store := range stateStores(t) {
+		if name == StateDriverRedis {
+			continue // Redis expires keys itself (PX)
+		}
+		t.Run(name, func(t *testing.T) {
+			st := &State{Key: "old-state-0123456789", Nonce: "n", CreatedAt: time.Now().Add(-StateTTL - time.Minute)}
+			cookies := saveState(t, store, st)
+
+			_, err := store.Take(context.Background(), httptest.NewRecorder(), callbackRequest(st.Key, "code", cookies), st.Key)
+			if !errors.Is(err, ErrInvalidState) {
+				t.Errorf("Take() error = %v, want ErrInvalidState", err)
+			}
+		})
+	}
+}
+
+func TestStateStoresSaveCapped(t *testing.T) {
+	for name, store := range stateStores(t) {
+		counter, ok := store.(stateCounter)
+		if !ok {
+			continue // Cookie stores keep nothing to count
+		}
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			const max = 5
+			newState := func(i int) *State {
+				return &State{Key: fmt.Sprintf("state-%s-%02d-0123456789", name, i), Nonce: "n", CreatedAt: time.Now()}
+			}
+
+			// Concurrent sign-ins never get past the cap
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			var saved []string
+			for i := 0; i < 4*max; i++ {
+				wg.Add(1)
+				go func(st *State) {
+					defer wg.Done()
+					err := counter.SaveCapped(ctx, httptest.NewRecorder(), st, max)
+					if err != nil && !errors.Is(err, ErrTooManyStates) {
+						t.Errorf("SaveCapped() error = %v", err)
+					}
+					if err == nil {
+						mu.Lock()
+						saved = append(saved, st.Key)
+						mu.Unlock()
+					}
+				}(newState(i))
+			}
+			wg.Wait()
+
+			n, err := counter.Pending(ctx)
+			if err != nil {
+				t.Fatalf("Pending() error = %v", err)
+			}
+			if len(saved) == 0 || len(saved) > max || n != len(saved) {
+				t.Fatalf("%d saves succeeded with %d pending, want between 1 and %d of each", len(saved), n, max)
+			}
+
+			// Once full, new flows are refused until one is taken
+			for i := len(saved); i < max; i++ {
+				if err := counter.SaveCapped(ctx, httptest.NewRecorder(), newState(100+i), max); err != nil {
+					t.Fatalf("SaveCapped() below the cap error = %v", err)
+				}
+			}
+			if err := counter.SaveCapped(ctx, httptest.NewRecorder(), newState(200), max); !errors.Is(err, ErrTooManyStates) {
+				t.Fatalf("SaveCapped() when full error = %v, want ErrTooManyStates", err)
+			}
+			if _, err := store.Take(ctx, httptest.NewRecorder(), callbackRequest(saved[0], "code", nil), saved[0]); err != nil {
+				t.Fatalf("Take() error = %v", err)
+			}
+			if err := counter.SaveCapped(ctx, httptest.NewRecorder(), newState(200), max); err != nil {
+				t.Errorf("SaveCapped() after Take() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestCookieStateStoreRejectsTampering(t *testing.T) {
+	store, err := NewCookieStateStore(testCookieKey, true)
+	if err != nil {
+		t.Fatalf("NewCookieStateStore() error = %v", err)
+	}
+	other, err := NewCookieStateStore("ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=", true)
+	if err != nil {
+		t.Fatalf("NewCookieStateStore() error = %v", err)
+	}
+
+	st := &State{Key: "state-abcdefghijklmnop", Nonce: "n", CreatedAt: time.Now()}
+	cookies := saveState(t, store, st)
+	if !cookies[0].HttpOnly || !cookies[0].Secure || cookies[0].SameSite != http.SameSiteLaxMode {
+		t.Errorf("state cookie = %+v, want HttpOnly, Secure and SameSite=Lax", cookies[0])
+	}
+
+	tampered := *cookies[0]
+	tampered.Value = "A" + tampered.Value[1:]
+
+	// Same prefix, so the cookie name matches, but the key is bound as additional data
+	moved := *cookies[0]
+	moved.Name = stateCookieName("state-abcdefghijklmnop-other")
+
+	tests := []struct {
+		name   string
+		store  *CookieStateStore
+		key    string
+		cookie *http.Cookie
+	}{
+		{"tampered", store, st.Key, &tampered},
+		{"different key", other, st.Key, cookies[0]},
+		{"other state", store, "state-abcdefghijklmnop-other", &moved},
+		{"no cookie", store, st.Key, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cookies []*http.Cookie
+			if tt.cookie != nil {
+				cookies = []*http.Cookie{tt.cookie}
+			}
+			_, err := tt.store.Take(context.Background(), httptest.NewRecorder(), callbackRequest(tt.key, "code", cookies), tt.key)
+			if !errors.Is(err, ErrInvalidState) {
+				t.Errorf("Take() error = %v, want ErrInvalidState", err)
+			}
+		})
+	}
+}
+
+// A callback landing on a different replica than /auth/start works with a shared store
+func TestHandleCallbackOtherReplica(t *testing.T) {
+	for name, store := range stateStores(t) {
+		if name == StateDriverMemory {
+			continue
+		}
+		t.Run(name, func(t *testing.T) {
+			issuer := newMockIssuer(t, testClientID)
+			replicas := make([]*Authenticator, 2)
+			for i := range replicas {
+				// Each replica opens its own handle on the shared backend
+				replicaStore := store
+				if name == StateDriverCookie {
+					replicaStore, _ = NewCookieStateStore(testCookieKey, true)
+				}
+				auth, err := NewAuthenticator(context.Background(), &Config{
+					Issuer:   issuer.URL,
+					ClientID: testClientID,
+					States:   replicaStore,
+				})
+				if err != nil {
+					t.Fatalf("NewAuthenticator() error = %v", err)
+				}
+				replicas[i] = auth
+			}
+
+			state, nonce, _, cookies := startAuth(t, replicas[0], "ssh-ed25519 AAAA test")
+			issuer.issueCode("code-1", map[string]interface{}{"sub": "user-1", "nonce": nonce})
+
+			_, req, err := replicas[1].HandleCallback(context.Background(), httptest.NewRecorder(), callbackRequest(state, "code-1", cookies))
+			if err != nil {
+				t.Fatalf("HandleCallback() error = %v", err)
+			}
+			if req.PubKey != "ssh-ed25519 AAAA test" {
+				t.Errorf("HandleCallback() request = %+v, want the one passed to StartAuth", req)
+			}
+		})
+	}
+}
+
+func TestOpenStateStore(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     StateStoreConfig
+		wantErr bool
+	}{
+		{"default", StateStoreConfig{}, false},
+		{"sqlite without path", StateStoreConfig{Driver: StateDriverSQLite}, true},
+		{"redis without url", StateStoreConfig{Driver: StateDriverRedis}, true},
+		{"redis bad scheme", StateStoreConfig{Driver: StateDriverRedis, URL: "http://localhost:6379"}, true},
+		{"redis bad db", StateStoreConfig{Driver: StateDriverRedis, URL: "redis://localhost/x"}, true},
+		{"redis", StateStoreConfig{Driver: StateDriverRedis, URL: "rediss://user:pw@redis.internal"}, false},
+		{"cookie short key", StateStoreConfig{Driver: StateDriverCookie, CookieKey: "c2hvcnQ="}, true},
+		{"cookie", StateStoreConfig{Driver: StateDriverCookie, CookieKey: testCookieKey}, false},
+		{"unknown", StateStoreConfig{Driver: "etcd"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := OpenStateStore(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("OpenStateStore() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
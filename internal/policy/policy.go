@@ -0,0 +1,408 @@
+// Decides whether and how to issue a certificate from ID token claims
+// Rules are evaluated in order; the first rule whose conditions all match decides
+package policy
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/shawntz/cassh/internal/principal"
+)
+
+// Actions a rule can take
+const (
+	ActionAllow = "allow"
+	ActionDeny  = "deny"
+)
+
+// Default claim names for group and role membership
+const (
+	DefaultGroupsClaim = "groups"
+	DefaultRolesClaim  = "roles"
+)
+
+// Config is the [issuance] section of the server config
+type Config struct {
+	// Claims holding group and role membership (defaults: "groups", "roles")
+	GroupsClaim string `toml:"groups_claim"`
+	RolesClaim  string `toml:"roles_claim"`
+
+	// Action when rules are configured but none match: "deny" (default) or "allow"
+	// With no rules at all, every authenticated user is allowed (the pre-policy behavior)
+	DefaultAction string `toml:"default_action"`
+
+	// Cert template used when a rule doesn't list templates; empty is the built-in default
+	DefaultTemplate string `toml:"default_template"`
+
+	// CA that signs when a rule doesn't name one; empty is the top-level [ca]
+	DefaultCA string `toml:"default_ca"`
+
+	Rules []Rule `toml:"rules"`
+}
+
+// Rule matches users by claims and decides what to issue
+// Every non-empty condition must match; within a condition any listed value matches
+type Rule struct {
+	Name string `toml:"name"`
+
+	// Conditions
+	Groups []string `toml:"groups"` // Member of any of these groups
+	Roles  []string `toml:"roles"`  // Has any of these roles
+	Emails []string `toml:"emails"` // Email matches any glob (e.g., "*@corp.com")
+
+	// Each claim must match one of its globs (e.g., repository = ["acme/*"]); "*" doesn't cross "/"
+	Claims map[string][]string `toml:"claims"`
+
+	// Target the client asked for (e.g., a GHE org) must match one of these globs
+	Targets []string `toml:"targets"`
+
+	// Workload issuers ([[workload.issuers]] names, or "*") this rule applies to
+	// Rules without workloads only match users, and workload tokens only match rules that name their issuer
+	Workloads []string `toml:"workloads"`
+
+	// Outcome
+	Action string `toml:"action"` // "allow" (default) or "deny"
+
+	// Principals to put in the cert, as principal templates (e.g., "{principal}", "ci-{claim.repository_owner | lower}")
+	// A principal naming a claim the token doesn't have is dropped, and array claims give one principal per value
+	// Empty means just the derived principal (the token subject for workloads)
+	Principals []string `toml:"principals"`
+	templates  []*principal.Template
+
+	// Cert lifetime; 0 uses the server's cert_validity_hours
+	ValidityHours int `toml:"validity_hours"`
+
+	// permit-* extensions to grant (e.g., ["permit-pty"]); empty grants what the template allows
+	// The GitHub login@ extension is always added unless the template omits it
+	Extensions []string `toml:"extensions"`
+
+	// Cert templates users matching this rule may request; the first is the default
+	// Empty means only issuance.default_template
+	Templates []string `toml:"templates"`
+
+	// Named CA ([cas.<name>]) that signs for this rule; empty is issuance.default_ca
+	CA string `toml:"ca"`
+}
+
+// Input is what the engine knows about the authenticated user
+type Input struct {
+	Subject   string
+	Email     string
+	Name      string
+	Username  string
+	Principal string // Derived from principal_source
+	Claims    map[string]interface{}
+	Template  string // Cert template the client asked for, if any
+	Target    string // Target the client asked for (e.g., a GHE org), if any
+	Workload  string // Workload issuer name for CI tokens; empty for users
+}
+
+// Decision is the result of evaluating the rules for a user
+type Decision struct {
+	Allowed    bool
+	Rule       string // Name of the deciding rule; empty for the default
+	Reason     string
+	Principals []string
+	Validity   time.Duration // 0 = server default
+	Extensions []string
+	Template   string   // Cert template to sign with; empty is the built-in default
+	CA         string   // Named CA to sign with; empty is the top-level CA
+	Groups     []string // Groups read from the token (for dry runs and audit)
+	Roles      []string
+}
+
+// Engine evaluates a validated policy
+type Engine struct {
+	cfg Config
+}
+
+// New validates cfg and returns an engine for it
+func New(cfg Config) (*Engine, error) {
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = DefaultGroupsClaim
+	}
+	if cfg.RolesClaim == "" {
+		cfg.RolesClaim = DefaultRolesClaim
+	}
+
+	switch cfg.DefaultAction {
+	case "":
+		cfg.DefaultAction = ActionDeny
+	case ActionAllow, ActionDeny:
+	default:
+		return nil, fmt.Errorf("issuance.default_action must be %q or %q, got %q", ActionAllow, ActionDeny, cfg.DefaultAction)
+	}
+
+	// Copy so defaults filled in below don't leak into the caller's config
+	cfg.Rules = append([]Rule(nil), cfg.Rules...)
+	for i := range cfg.Rules {
+		rule := &cfg.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("issuance rule %q: %w", rule.Name, err)
+		}
+	}
+
+	return &Engine{cfg: cfg}, nil
+}
+
+func (r *Rule) validate() error {
+	switch r.Action {
+	case "":
+		r.Action = ActionAllow
+	case ActionAllow, ActionDeny:
+	default:
+		return fmt.Errorf("action must be %q or %q, got %q", ActionAllow, ActionDeny, r.Action)
+	}
+
+	for _, pattern := range r.Emails {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid email pattern %q: %w", pattern, err)
+		}
+	}
+	for claim, patterns := range r.Claims {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q for claim %q: %w", pattern, claim, err)
+			}
+		}
+	}
+	for _, pattern := range r.Targets {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid target pattern %q: %w", pattern, err)
+		}
+	}
+	for _, name := range r.Workloads {
+		if name == "" {
+			return fmt.Errorf("workloads must not contain empty names")
+		}
+	}
+	r.templates = nil
+	for _, p := range r.Principals {
+		tmpl, err := principal.ParseTemplate(p)
+		if err != nil {
+			return err
+		}
+		r.templates = append(r.templates, tmpl)
+	}
+	if r.ValidityHours < 0 {
+		return fmt.Errorf("validity_hours must not be negative")
+	}
+	for _, ext := range r.Extensions {
+		if !strings.HasPrefix(ext, "permit-") && ext != "no-touch-required" {
+			return fmt.Errorf("unsupported extension %q", ext)
+		}
+	}
+	return nil
+}
+
+// Rules returns the validated rules in evaluation order
+func (e *Engine) Rules() []Rule {
+	return e.cfg.Rules
+}
+
+// Evaluate decides whether to issue a cert to the user and with what settings
+func (e *Engine) Evaluate(in Input) Decision {
+	groups := claimStrings(in.Claims, e.cfg.GroupsClaim)
+	roles := claimStrings(in.Claims, e.cfg.RolesClaim)
+
+	decision := e.evaluate(in, groups, roles)
+	decision.Groups = groups
+	decision.Roles = roles
+	return decision
+}
+
+func (e *Engine) evaluate(in Input, groups, roles []string) Decision {
+	if len(e.cfg.Rules) == 0 {
+		if in.Workload != "" {
+			// Shared issuers sign tokens for anyone (every GitHub repo), so workloads always need a rule
+			return Decision{Reason: "no issuance rules configured (workloads need a matching rule)"}
+		}
+		return e.allow(in, nil, "no issuance rules configured")
+	}
+
+	for _, rule := range e.cfg.Rules {
+		if !rule.matches(in, groups, roles) {
+			continue
+		}
+
+		if rule.Action == ActionDeny {
+			return Decision{
+				Rule:   rule.Name,
+				Reason: fmt.Sprintf("denied by rule %q", rule.Name),
+			}
+		}
+		return e.allow(in, &rule, fmt.Sprintf("allowed by rule %q", rule.Name))
+	}
+
+	if e.cfg.DefaultAction == ActionAllow && in.Workload == "" {
+		return e.allow(in, nil, "no rule matched (default allow)")
+	}
+	return Decision{Reason: "no rule matched (default deny)"}
+}
+
+// allow builds an allow decision from rule (nil for the defaults), checking the requested template
+func (e *Engine) allow(in Input, rule *Rule, reason string) Decision {
+	d := Decision{
+		Allowed:    true,
+		Reason:     reason,
+		Principals: []string{in.Principal},
+		CA:         e.cfg.DefaultCA,
+	}
+
+	templates := []string{e.cfg.DefaultTemplate}
+	if rule != nil {
+		d.Rule = rule.Name
+		if rule.CA != "" {
+			d.CA = rule.CA
+		}
+		d.Principals = rule.expandPrincipals(in)
+		if len(d.Principals) == 0 {
+			// Signing would fall back to the CA's default principals; the rule asked for claim-derived ones
+			return Decision{
+				Rule:   rule.Name,
+				Reason: fmt.Sprintf("no principals derived from claims (%s)", reason),
+			}
+		}
+		d.Validity = time.Duration(rule.ValidityHours) * time.Hour
+		d.Extensions = rule.Extensions
+		if len(rule.Templates) > 0 {
+			templates = rule.Templates
+		}
+	}
+
+	// No template requested: use the first one this rule allows
+	if in.Template == "" {
+		d.Template = templates[0]
+		return d
+	}
+	for _, name := range templates {
+		if name == in.Template {
+			d.Template = name
+			return d
+		}
+	}
+
+	return Decision{
+		Rule:   d.Rule,
+		Reason: fmt.Sprintf("template %q not permitted (%s)", in.Template, reason),
+	}
+}
+
+func (r *Rule) matches(in Input, groups, roles []string) bool {
+	if !r.matchesWorkload(in.Workload) {
+		return false
+	}
+	if len(r.Groups) > 0 && !intersects(r.Groups, groups) {
+		return false
+	}
+	if len(r.Roles) > 0 && !intersects(r.Roles, roles) {
+		return false
+	}
+	if len(r.Emails) > 0 {
+		email := strings.ToLower(in.Email)
+		matched := false
+		for _, pattern := range r.Emails {
+			if ok, _ := path.Match(strings.ToLower(pattern), email); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	for claim, patterns := range r.Claims {
+		if !matchesAny(patterns, claimStrings(in.Claims, claim)) {
+			return false
+		}
+	}
+	if len(r.Targets) > 0 && !matchesAny(r.Targets, []string{in.Target}) {
+		return false
+	}
+	return true
+}
+
+func (r *Rule) matchesWorkload(workload string) bool {
+	if workload == "" {
+		return len(r.Workloads) == 0
+	}
+	for _, name := range r.Workloads {
+		if name == "*" || name == workload {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAny reports whether any value matches any glob
+func matchesAny(patterns, values []string) bool {
+	for _, pattern := range patterns {
+		for _, v := range values {
+			if ok, _ := path.Match(pattern, v); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *Rule) expandPrincipals(in Input) []string {
+	if len(r.templates) == 0 {
+		return []string{in.Principal}
+	}
+
+	user := principal.User{
+		Subject:  in.Subject,
+		Email:    in.Email,
+		Name:     in.Name,
+		Username: in.Username,
+		Claims:   in.Claims,
+	}
+
+	var out []string
+	seen := make(map[string]bool)
+	for _, tmpl := range r.templates {
+		for _, p := range tmpl.Expand(user, in.Principal) {
+			if !seen[p] {
+				seen[p] = true
+				out = append(out, p)
+			}
+		}
+	}
+	return out
+}
+
+// claimStrings reads a claim that may be a string or an array of strings
+func claimStrings(claims map[string]interface{}, name string) []string {
+	switch v := claims[name].(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func intersects(want, have []string) bool {
+	for _, w := range want {
+		for _, h := range have {
+			if w == h {
+				return true
+			}
+		}
+	}
+	return false
+}
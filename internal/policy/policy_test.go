@@ -0,0 +1,460 @@
+package policy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEvaluate(t *testing.T) {
+	cfg := Config{
+		Rules: []Rule{
+			{
+				Name:   "contractors-denied",
+				Groups: []string{"contractors"},
+				Action: ActionDeny,
+			},
+			{
+				Name:          "break-glass",
+				Roles:         []string{"sre-oncall"},
+				Principals:    []string{"{principal}", "root"},
+				ValidityHours: 1,
+				Extensions:    []string{"permit-pty"},
+			},
+			{
+				Name:   "engineering",
+				Groups: []string{"engineering", "platform"},
+				Emails: []string{"*@corp.com"},
+			},
+		},
+	}
+
+	engine, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		input      Input
+		wantAllow  bool
+		wantRule   string
+		principals []string
+		validity   time.Duration
+		extensions []string
+	}{
+		{
+			name: "first matching rule wins over later allow",
+			input: Input{
+				Email:     "eve@corp.com",
+				Principal: "eve",
+				Claims:    map[string]interface{}{"groups": []interface{}{"engineering", "contractors"}},
+			},
+			wantAllow: false,
+			wantRule:  "contractors-denied",
+		},
+		{
+			name: "role grants principals, validity and extensions",
+			input: Input{
+				Email:     "sam@corp.com",
+				Principal: "sam",
+				Claims:    map[string]interface{}{"roles": []interface{}{"sre-oncall"}},
+			},
+			wantAllow:  true,
+			wantRule:   "break-glass",
+			principals: []string{"sam", "root"},
+			validity:   time.Hour,
+			extensions: []string{"permit-pty"},
+		},
+		{
+			name: "group and email both required",
+			input: Input{
+				Email:     "Alice@Corp.com",
+				Principal: "alice",
+				Claims:    map[string]interface{}{"groups": []interface{}{"platform"}},
+			},
+			wantAllow:  true,
+			wantRule:   "engineering",
+			principals: []string{"alice"},
+		},
+		{
+			name: "group without matching email",
+			input: Input{
+				Email:     "alice@gmail.com",
+				Principal: "alice",
+				Claims:    map[string]interface{}{"groups": []interface{}{"platform"}},
+			},
+			wantAllow: false,
+		},
+		{
+			name: "single string group claim",
+			input: Input{
+				Email:     "bob@corp.com",
+				Principal: "bob",
+				Claims:    map[string]interface{}{"groups": "engineering"},
+			},
+			wantAllow:  true,
+			wantRule:   "engineering",
+			principals: []string{"bob"},
+		},
+		{
+			name:      "no groups claim falls through to default deny",
+			input:     Input{Email: "carol@corp.com", Principal: "carol"},
+			wantAllow: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := engine.Evaluate(tt.input)
+			if got.Allowed != tt.wantAllow {
+				t.Fatalf("Allowed = %v, want %v (reason: %s)", got.Allowed, tt.wantAllow, got.Reason)
+			}
+			if got.Rule != tt.wantRule {
+				t.Errorf("Rule = %q, want %q", got.Rule, tt.wantRule)
+			}
+			if !reflect.DeepEqual(got.Principals, tt.principals) {
+				t.Errorf("Principals = %v, want %v", got.Principals, tt.principals)
+			}
+			if got.Validity != tt.validity {
+				t.Errorf("Validity = %v, want %v", got.Validity, tt.validity)
+			}
+			if !reflect.DeepEqual(got.Extensions, tt.extensions) {
+				t.Errorf("Extensions = %v, want %v", got.Extensions, tt.extensions)
+			}
+		})
+	}
+}
+
+func TestEvaluateDefaults(t *testing.T) {
+	in := Input{Email: "dana@corp.com", Principal: "dana"}
+
+	tests := []struct {
+		name      string
+		cfg       Config
+		wantAllow bool
+	}{
+		{"no rules allows everyone", Config{}, true},
+		{"unmatched rules deny by default", Config{Rules: []Rule{{Groups: []string{"admins"}}}}, false},
+		{"default_action allow", Config{DefaultAction: ActionAllow, Rules: []Rule{{Groups: []string{"admins"}}}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine, err := New(tt.cfg)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			got := engine.Evaluate(in)
+			if got.Allowed != tt.wantAllow {
+				t.Errorf("Allowed = %v, want %v (reason: %s)", got.Allowed, tt.wantAllow, got.Reason)
+			}
+			if got.Allowed && !reflect.DeepEqual(got.Principals, []string{"dana"}) {
+				t.Errorf("Principals = %v, want [dana]", got.Principals)
+			}
+		})
+	}
+}
+
+func TestCustomClaimNames(t *testing.T) {
+	engine, err := New(Config{
+		GroupsClaim: "cognito:groups",
+		RolesClaim:  "realm_roles",
+		Rules: []Rule{
+			{Name: "admins", Groups: []string{"admins"}, Roles: []string{"ssh"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	got := engine.Evaluate(Input{
+		Principal: "erin",
+		Claims: map[string]interface{}{
+			"cognito:groups": []interface{}{"admins"},
+			"realm_roles":    []interface{}{"ssh"},
+		},
+	})
+	if !got.Allowed || got.Rule != "admins" {
+		t.Errorf("Evaluate() = %+v, want allowed by admins", got)
+	}
+}
+
+func TestExpandPrincipals(t *testing.T) {
+	rule := Rule{Principals: []string{"{principal}", "{username}", "{email}", "deploy", "{principal}", "{missing}"}}
+	in := Input{Principal: "frank", Username: "frank", Email: "frank@corp.com"}
+	if err := rule.validate(); err != nil {
+		t.Fatalf("validate() error = %v", err)
+	}
+
+	want := []string{"frank", "frank@corp.com", "deploy", "{missing}"}
+	if got := rule.expandPrincipals(in); !reflect.DeepEqual(got, want) {
+		t.Errorf("expandPrincipals() = %v, want %v", got, want)
+	}
+}
+
+func TestExpandPrincipalTemplates(t *testing.T) {
+	engine, err := New(Config{Rules: []Rule{{
+		Name: "eng",
+		Principals: []string{
+			"{principal}",
+			"{claim.upn | lower | replace \"@.*$\" \"\" | suffix \"_corp\"}",
+			"team-{claim.groups | lower}",
+			"{sub}",
+		},
+	}}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	got := engine.Evaluate(Input{
+		Subject:   "00u1",
+		Principal: "alice",
+		Claims: map[string]interface{}{
+			"upn":    "Alice@Corp.com",
+			"groups": []interface{}{"Eng", "SRE", "eng"},
+		},
+	})
+	want := []string{"alice", "alice_corp", "team-eng", "team-sre", "00u1"}
+	if !got.Allowed || !reflect.DeepEqual(got.Principals, want) {
+		t.Errorf("Evaluate() = %v %v, want allowed %v", got.Allowed, got.Principals, want)
+	}
+}
+
+func TestEvaluateNoDerivedPrincipals(t *testing.T) {
+	engine, err := New(Config{Rules: []Rule{{
+		Name:       "eng",
+		Emails:     []string{"*@corp.com"},
+		Principals: []string{"{claim.upn | lower}", "team-{claim.groups}"},
+	}}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	// Without the claims there's nothing to sign for; the CA's default principals mustn't stand in
+	got := engine.Evaluate(Input{Email: "alice@corp.com", Principal: "alice"})
+	if got.Allowed || len(got.Principals) != 0 {
+		t.Errorf("Evaluate() = %v %v, want denied with no principals", got.Allowed, got.Principals)
+	}
+	if got.Rule != "eng" || !strings.Contains(got.Reason, "no principals derived from claims") {
+		t.Errorf("Evaluate() rule %q reason %q, want eng with no principals derived from claims", got.Rule, got.Reason)
+	}
+
+	got = engine.Evaluate(Input{Email: "alice@corp.com", Principal: "alice", Claims: map[string]interface{}{"upn": "Alice"}})
+	if !got.Allowed || !reflect.DeepEqual(got.Principals, []string{"alice"}) {
+		t.Errorf("Evaluate() with a upn claim = %v %v, want allowed [alice]", got.Allowed, got.Principals)
+	}
+}
+
+func TestNewValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{"valid", Config{Rules: []Rule{{Name: "a", Emails: []string{"*@corp.com"}}}}, false},
+		{"bad default action", Config{DefaultAction: "maybe"}, true},
+		{"bad rule action", Config{Rules: []Rule{{Action: "permit"}}}, true},
+		{"bad email glob", Config{Rules: []Rule{{Emails: []string{"[corp"}}}}, true},
+		{"negative validity", Config{Rules: []Rule{{ValidityHours: -1}}}, true},
+		{"unknown extension", Config{Rules: []Rule{{Extensions: []string{"force-command"}}}}, true},
+		{"bad claim glob", Config{Rules: []Rule{{Claims: map[string][]string{"repository": {"[acme"}}}}}, true},
+		{"empty workload name", Config{Rules: []Rule{{Workloads: []string{""}}}}, true},
+		{"bad target glob", Config{Rules: []Rule{{Targets: []string{"[org"}}}}, true},
+		{"bad principal template", Config{Rules: []Rule{{Principals: []string{"{email | shout}"}}}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRuleNamesDefaulted(t *testing.T) {
+	engine, err := New(Config{Rules: []Rule{{Name: "named"}, {}}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if got := engine.Rules()[1].Name; got != "rule-2" {
+		t.Errorf("Rules()[1].Name = %q, want %q", got, "rule-2")
+	}
+}
+
+func TestEvaluateTemplates(t *testing.T) {
+	engine, err := New(Config{
+		DefaultTemplate: "github-only",
+		Rules: []Rule{
+			{Name: "sre", Groups: []string{"sre"}, Templates: []string{"bastion", "break-glass"}},
+			{Name: "everyone", Emails: []string{"*@corp.com"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	sre := map[string]interface{}{"groups": []interface{}{"sre"}}
+
+	tests := []struct {
+		name         string
+		input        Input
+		wantAllow    bool
+		wantTemplate string
+	}{
+		{"rule default is first template", Input{Email: "sam@corp.com", Claims: sre}, true, "bastion"},
+		{"rule allows requested template", Input{Email: "sam@corp.com", Claims: sre, Template: "break-glass"}, true, "break-glass"},
+		{"rule denies unlisted template", Input{Email: "sam@corp.com", Claims: sre, Template: "github-only"}, false, ""},
+		{"default template without rule templates", Input{Email: "dana@corp.com"}, true, "github-only"},
+		{"default template can be requested", Input{Email: "dana@corp.com", Template: "github-only"}, true, "github-only"},
+		{"other templates denied", Input{Email: "dana@corp.com", Template: "bastion"}, false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := engine.Evaluate(tt.input)
+			if got.Allowed != tt.wantAllow {
+				t.Fatalf("Allowed = %v, want %v (reason: %s)", got.Allowed, tt.wantAllow, got.Reason)
+			}
+			if got.Template != tt.wantTemplate {
+				t.Errorf("Template = %q, want %q", got.Template, tt.wantTemplate)
+			}
+		})
+	}
+}
+
+func TestEvaluateCA(t *testing.T) {
+	engine, err := New(Config{
+		DefaultCA: "corp",
+		Rules: []Rule{
+			{Name: "org-b", Targets: []string{"org-b", "org-b-*"}, CA: "org-b"},
+			{Name: "platform", Groups: []string{"platform"}, CA: "platform"},
+			{Name: "everyone", Emails: []string{"*@corp.com"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	platform := map[string]interface{}{"groups": []interface{}{"platform"}}
+
+	tests := []struct {
+		name     string
+		input    Input
+		wantRule string
+		wantCA   string
+	}{
+		{"target selects CA", Input{Email: "dana@corp.com", Target: "org-b"}, "org-b", "org-b"},
+		{"target glob selects CA", Input{Email: "dana@corp.com", Target: "org-b-infra"}, "org-b", "org-b"},
+		{"group selects CA", Input{Email: "sam@corp.com", Claims: platform}, "platform", "platform"},
+		{"target wins over group", Input{Email: "sam@corp.com", Claims: platform, Target: "org-b"}, "org-b", "org-b"},
+		{"other targets use the default CA", Input{Email: "dana@corp.com", Target: "org-a"}, "everyone", "corp"},
+		{"no target uses the default CA", Input{Email: "dana@corp.com"}, "everyone", "corp"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := engine.Evaluate(tt.input)
+			if !got.Allowed {
+				t.Fatalf("Allowed = false (reason: %s)", got.Reason)
+			}
+			if got.Rule != tt.wantRule {
+				t.Errorf("Rule = %q, want %q", got.Rule, tt.wantRule)
+			}
+			if got.CA != tt.wantCA {
+				t.Errorf("CA = %q, want %q", got.CA, tt.wantCA)
+			}
+		})
+	}
+}
+
+func TestEvaluateWorkloads(t *testing.T) {
+	engine, err := New(Config{
+		DefaultAction: ActionAllow,
+		Rules: []Rule{
+			{
+				Name:       "deploy-main",
+				Workloads:  []string{"github-actions"},
+				Claims:     map[string][]string{"repository": {"acme/*"}, "ref": {"refs/heads/main"}},
+				Principals: []string{"deploy", "ci-{claim.repository_owner}", "{claim.missing}"},
+			},
+			{
+				Name:   "everyone",
+				Emails: []string{"*@corp.com"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	ghClaims := func(repo, ref string) map[string]interface{} {
+		return map[string]interface{}{"repository": repo, "repository_owner": "acme", "ref": ref}
+	}
+
+	tests := []struct {
+		name       string
+		input      Input
+		wantAllow  bool
+		wantRule   string
+		principals []string
+	}{
+		{
+			name:       "matching workload",
+			input:      Input{Workload: "github-actions", Principal: "repo:acme/api", Claims: ghClaims("acme/api", "refs/heads/main")},
+			wantAllow:  true,
+			wantRule:   "deploy-main",
+			principals: []string{"deploy", "ci-acme"},
+		},
+		{
+			name:  "wrong branch falls through and default allow doesn't apply",
+			input: Input{Workload: "github-actions", Claims: ghClaims("acme/api", "refs/heads/feature")},
+		},
+		{
+			name:  "glob doesn't cross slashes",
+			input: Input{Workload: "github-actions", Claims: ghClaims("acme/api/extra", "refs/heads/main")},
+		},
+		{
+			name:  "other workload issuer",
+			input: Input{Workload: "gitlab", Claims: ghClaims("acme/api", "refs/heads/main")},
+		},
+		{
+			name:  "user rules don't match workloads",
+			input: Input{Workload: "github-actions", Email: "bot@corp.com"},
+		},
+		{
+			name:       "workload rules don't match users",
+			input:      Input{Email: "dana@other.com", Principal: "dana", Claims: ghClaims("acme/api", "refs/heads/main")},
+			wantAllow:  true,
+			principals: []string{"dana"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := engine.Evaluate(tt.input)
+			if got.Allowed != tt.wantAllow {
+				t.Fatalf("Allowed = %v, want %v (reason: %s)", got.Allowed, tt.wantAllow, got.Reason)
+			}
+			if got.Rule != tt.wantRule {
+				t.Errorf("Rule = %q, want %q", got.Rule, tt.wantRule)
+			}
+			if tt.wantAllow && !reflect.DeepEqual(got.Principals, tt.principals) {
+				t.Errorf("Principals = %v, want %v", got.Principals, tt.principals)
+			}
+		})
+	}
+}
+
+func TestWorkloadsNeedRules(t *testing.T) {
+	engine, err := New(Config{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if got := engine.Evaluate(Input{Workload: "github-actions", Principal: "repo:acme/api"}); got.Allowed {
+		t.Errorf("Evaluate() allowed a workload with no rules configured")
+	}
+}
@@ -0,0 +1,234 @@
+// Resolves the GitHub login a signed-in user's certs are issued for
+// The login is derived from ID token claims (optionally through a template), read from a mapping file, or looked up in GitHub's SCIM identities
+package principal
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Sources of the principal, set with github.principal_source
+const (
+	SourceEmailPrefix = "email_prefix" // Local part of the username (UPN) or email
+	SourceEmail       = "email"
+	SourceUsername    = "username"
+	SourceMapping     = "mapping" // Mapping file of identity,login rows
+	SourceSCIM        = "scim"    // The GitHub account linked to the user's IdP identity
+)
+
+// Any other source names a claim (e.g., "github_login" or "extension_attrs.gh"), or is a template containing {}
+
+// ErrNotFound is returned when a mapping or SCIM lookup has no GitHub login for the user
+var ErrNotFound = errors.New("no GitHub login found")
+
+// User is who signed in, from the ID token
+type User struct {
+	Subject  string
+	Email    string
+	Name     string
+	Username string // preferred_username or the configured claim; often the UPN
+	Claims   map[string]interface{}
+}
+
+// Resolver finds the GitHub login for a user
+type Resolver interface {
+	Resolve(ctx context.Context, user User) (string, error)
+}
+
+// Directory looks up the GitHub login linked to an IdP username, returning "" if there is none
+type Directory interface {
+	LookupLogin(ctx context.Context, idpUsername string) (string, error)
+}
+
+// Config picks how principals are resolved
+type Config struct {
+	Source      string
+	MappingPath string // CSV of identity,login (mapping)
+}
+
+// New builds the resolver for cfg; dir is required for the scim source
+func New(cfg Config, dir Directory) (Resolver, error) {
+	switch cfg.Source {
+	case SourceMapping:
+		if cfg.MappingPath == "" {
+			return nil, fmt.Errorf("principal source %q requires a mapping file", cfg.Source)
+		}
+		return LoadMapping(cfg.MappingPath)
+	case SourceSCIM:
+		if dir == nil {
+			return nil, fmt.Errorf("principal source %q requires GitHub SCIM access", cfg.Source)
+		}
+		return scimResolver{dir: dir}, nil
+	case SourceEmailPrefix, SourceEmail, SourceUsername, "":
+		return Claims(cfg.Source), nil
+	}
+
+	text := cfg.Source
+	if !strings.Contains(text, "{") {
+		text = "{claim." + text + "}"
+	}
+	tmpl, err := ParseTemplate(text)
+	if err != nil {
+		return nil, err
+	}
+	if tmpl.Uses("principal") {
+		return nil, fmt.Errorf("principal source %q can't use {principal}, which it defines", cfg.Source)
+	}
+	return templateResolver{tmpl: tmpl}, nil
+}
+
+// Claims derives the principal from ID token claims: email_prefix (default), email or username
+type Claims string
+
+// Resolve returns the claim-derived principal, which may be empty
+func (c Claims) Resolve(_ context.Context, user User) (string, error) {
+	switch string(c) {
+	case SourceEmail:
+		return user.Email, nil
+	case SourceUsername:
+		return user.Username, nil
+	default:
+		emailOrUPN := user.Username
+		if emailOrUPN == "" {
+			emailOrUPN = user.Email
+		}
+		return localPart(emailOrUPN), nil
+	}
+}
+
+// templateResolver builds the principal from a custom claim or template, using its first value
+type templateResolver struct {
+	tmpl *Template
+}
+
+func (t templateResolver) Resolve(_ context.Context, user User) (string, error) {
+	values := t.tmpl.Expand(user, "")
+	if len(values) == 0 {
+		return "", fmt.Errorf("%w: %s is empty for %s", ErrNotFound, t.tmpl, describe(user))
+	}
+	return values[0], nil
+}
+
+func localPart(emailOrUPN string) string {
+	if idx := strings.Index(emailOrUPN, "@"); idx != -1 {
+		return emailOrUPN[:idx]
+	}
+	return emailOrUPN
+}
+
+// loginPattern matches GitHub logins, including managed users' _shortcode suffix
+var loginPattern = regexp.MustCompile(`^[A-Za-z0-9](?:[A-Za-z0-9_-]*[A-Za-z0-9])?$`)
+
+// Mapping is a static table of identities to GitHub logins
+type Mapping struct {
+	logins map[string]string // Lowercased identity -> login
+}
+
+// LoadMapping reads a mapping file
+func LoadMapping(path string) (*Mapping, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read principal mapping: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	m, err := ParseMapping(f)
+	if err != nil {
+		return nil, fmt.Errorf("principal mapping %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// ParseMapping reads CSV rows of identity,login
+// The identity is matched against the user's email, username or sub, ignoring case
+// Lines starting with # are comments, and an identity,login header row is skipped
+func ParseMapping(r io.Reader) (*Mapping, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+
+	m := &Mapping{logins: make(map[string]string)}
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		identity, login := strings.ToLower(strings.TrimSpace(record[0])), strings.TrimSpace(record[1])
+		line, _ := reader.FieldPos(0)
+		if first && identity == "identity" && strings.EqualFold(login, "login") {
+			continue
+		}
+		if identity == "" {
+			return nil, fmt.Errorf("line %d: empty identity", line)
+		}
+		if !loginPattern.MatchString(login) {
+			return nil, fmt.Errorf("line %d: %q is not a valid GitHub login", line, login)
+		}
+		if prev, ok := m.logins[identity]; ok && prev != login {
+			return nil, fmt.Errorf("line %d: %s is mapped to both %s and %s", line, identity, prev, login)
+		}
+		m.logins[identity] = login
+	}
+	return m, nil
+}
+
+// Len returns the number of mapped identities
+func (m *Mapping) Len() int {
+	return len(m.logins)
+}
+
+// Resolve returns the login of the first of the user's email, username and sub that's mapped
+func (m *Mapping) Resolve(_ context.Context, user User) (string, error) {
+	for _, identity := range []string{user.Email, user.Username, user.Subject} {
+		if identity == "" {
+			continue
+		}
+		if login, ok := m.logins[strings.ToLower(identity)]; ok {
+			return login, nil
+		}
+	}
+	return "", fmt.Errorf("%w in the mapping for %s", ErrNotFound, describe(user))
+}
+
+// scimResolver looks up the GitHub account linked to the user's IdP username (falling back to email)
+type scimResolver struct {
+	dir Directory
+}
+
+func (s scimResolver) Resolve(ctx context.Context, user User) (string, error) {
+	idpUsername := user.Username
+	if idpUsername == "" {
+		idpUsername = user.Email
+	}
+	if idpUsername == "" {
+		return "", fmt.Errorf("%w: no username or email to look up", ErrNotFound)
+	}
+	login, err := s.dir.LookupLogin(ctx, idpUsername)
+	if err != nil {
+		return "", err
+	}
+	if login == "" {
+		return "", fmt.Errorf("%w: no GitHub account is linked to %s", ErrNotFound, idpUsername)
+	}
+	return login, nil
+}
+
+func describe(user User) string {
+	if user.Email != "" {
+		return user.Email
+	}
+	if user.Username != "" {
+		return user.Username
+	}
+	return user.Subject
+}
@@ -0,0 +1,186 @@
+package principal
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestClaims(t *testing.T) {
+	user := User{Subject: "00u1", Email: "alice@corp.com", Username: "alice.smith@corp.onmicrosoft.com"}
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"", "alice.smith"},
+		{SourceEmailPrefix, "alice.smith"},
+		{SourceEmail, "alice@corp.com"},
+		{SourceUsername, "alice.smith@corp.onmicrosoft.com"},
+	}
+	for _, tt := range tests {
+		got, err := Claims(tt.source).Resolve(context.Background(), user)
+		if err != nil || got != tt.want {
+			t.Errorf("Claims(%q).Resolve() = %q, %v, want %q", tt.source, got, err, tt.want)
+		}
+	}
+
+	// email_prefix falls back to the email without a username
+	got, _ := Claims(SourceEmailPrefix).Resolve(context.Background(), User{Email: "bob@corp.com"})
+	if got != "bob" {
+		t.Errorf("Claims(email_prefix) without username = %q, want bob", got)
+	}
+}
+
+func TestParseMapping(t *testing.T) {
+	m, err := ParseMapping(strings.NewReader(`identity,login
+# Managed users
+Alice@Corp.com, alice_corp
+00u2bob,bob_corp
+carol.smith@corp.onmicrosoft.com,carol-s_corp
+`))
+	if err != nil {
+		t.Fatalf("ParseMapping() error = %v", err)
+	}
+	if m.Len() != 3 {
+		t.Errorf("Len() = %d, want 3", m.Len())
+	}
+
+	tests := []struct {
+		name string
+		user User
+		want string
+	}{
+		{name: "email ignoring case", user: User{Email: "alice@corp.com"}, want: "alice_corp"},
+		{name: "sub", user: User{Subject: "00u2bob", Email: "bob@corp.com"}, want: "bob_corp"},
+		{name: "username", user: User{Email: "carol@corp.com", Username: "carol.smith@corp.onmicrosoft.com"}, want: "carol-s_corp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := m.Resolve(context.Background(), tt.user)
+			if err != nil || got != tt.want {
+				t.Errorf("Resolve() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+
+	if _, err := m.Resolve(context.Background(), User{Email: "mallory@corp.com"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Resolve() of an unmapped user error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestParseMappingInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "invalid login", data: "alice@corp.com,alice corp\n"},
+		{name: "conflicting logins", data: "alice@corp.com,alice\nALICE@corp.com,alice2\n"},
+		{name: "missing login", data: "alice@corp.com\n"},
+		{name: "empty identity", data: ",alice\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseMapping(strings.NewReader(tt.data)); err == nil {
+				t.Error("ParseMapping() should fail")
+			}
+		})
+	}
+}
+
+type fakeDirectory map[string]string
+
+func (d fakeDirectory) LookupLogin(_ context.Context, idpUsername string) (string, error) {
+	if idpUsername == "broken@corp.com" {
+		return "", errors.New("GitHub API unavailable")
+	}
+	return d[idpUsername], nil
+}
+
+func TestNew(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logins.csv")
+	if err := os.WriteFile(path, []byte("alice@corp.com,alice_corp\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dir := fakeDirectory{"alice@corp.onmicrosoft.com": "alice_corp"}
+	ctx := context.Background()
+
+	mapping, err := New(Config{Source: SourceMapping, MappingPath: path}, nil)
+	if err != nil {
+		t.Fatalf("New(mapping) error = %v", err)
+	}
+	if got, err := mapping.Resolve(ctx, User{Email: "alice@corp.com"}); err != nil || got != "alice_corp" {
+		t.Errorf("mapping Resolve() = %q, %v", got, err)
+	}
+
+	scim, err := New(Config{Source: SourceSCIM}, dir)
+	if err != nil {
+		t.Fatalf("New(scim) error = %v", err)
+	}
+	if got, err := scim.Resolve(ctx, User{Email: "alice@corp.com", Username: "alice@corp.onmicrosoft.com"}); err != nil || got != "alice_corp" {
+		t.Errorf("scim Resolve() = %q, %v", got, err)
+	}
+	if _, err := scim.Resolve(ctx, User{Email: "bob@corp.com"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("scim Resolve() of an unlinked user error = %v, want ErrNotFound", err)
+	}
+	if _, err := scim.Resolve(ctx, User{Email: "broken@corp.com"}); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("scim Resolve() with the API down error = %v, want an API error", err)
+	}
+
+	if _, err := New(Config{Source: SourceMapping}, nil); err == nil {
+		t.Error("New(mapping) without a file should fail")
+	}
+	if _, err := New(Config{Source: SourceSCIM}, nil); err == nil {
+		t.Error("New(scim) without a directory should fail")
+	}
+}
+
+func TestNewClaimSources(t *testing.T) {
+	user := User{
+		Subject:  "00u1",
+		Email:    "Alice.Smith@Corp.com",
+		Username: "alice.smith@corp.onmicrosoft.com",
+		Claims: map[string]interface{}{
+			"github_login": "alice-corp",
+			"ext":          map[string]interface{}{"gh": "alice-ext"},
+			"logins":       []interface{}{"alice-a", "alice-b"},
+		},
+	}
+	tests := []struct {
+		source  string
+		want    string
+		missing bool
+	}{
+		{source: "github_login", want: "alice-corp"},
+		{source: "ext.gh", want: "alice-ext"},
+		{source: "logins", want: "alice-a"},
+		{source: "{email | lower | replace \"@.*$\" \"\" | replace \"\\\\.\" \"-\"}", want: "alice-smith"},
+		{source: "{claim.github_login | suffix \"_corp\"}", want: "alice-corp_corp"},
+		{source: "no_such_claim", missing: true},
+		{source: "{claim.no_such_claim | lower}", missing: true},
+	}
+	for _, tt := range tests {
+		r, err := New(Config{Source: tt.source}, nil)
+		if err != nil {
+			t.Fatalf("New(%q) error = %v", tt.source, err)
+		}
+		got, err := r.Resolve(context.Background(), user)
+		if tt.missing {
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("New(%q).Resolve() = %q, %v, want ErrNotFound", tt.source, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("New(%q).Resolve() = %q, %v, want %q", tt.source, got, err, tt.want)
+		}
+	}
+
+	for _, source := range []string{"{principal}", "{email | shout}", "{claim.x | replace \"(\" \"\"}"} {
+		if _, err := New(Config{Source: source}, nil); err == nil {
+			t.Errorf("New(%q) should fail", source)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package principal
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Variables a template can reference besides {claim.PATH}
+var templateVars = []string{"principal", "email", "username", "name", "sub"}
+
+// Template builds principals from a user, e.g. `{claim.upn | lower | replace "@.*$" "" | suffix "_corp"}`
+// Each {expression} names a variable or claim, optionally piped through functions:
+//
+//	lower, upper           change case
+//	prefix "s", suffix "s" add text
+//	replace "re" "repl"    regexp replace all ($1 refers to groups)
+//
+// Claims that are arrays give one principal per element; a missing or empty value gives none
+type Template struct {
+	text  string
+	parts []templatePart
+}
+
+// templatePart is literal text or, if expr is set, an expression
+type templatePart struct {
+	literal string
+	expr    *expression
+}
+
+type expression struct {
+	source string // A templateVars name or "claim.PATH"
+	funcs  []func(string) string
+}
+
+// ParseTemplate parses a principal template
+// Braces around anything but a variable or claim, with no functions, are left as literal text
+func ParseTemplate(text string) (*Template, error) {
+	t := &Template{text: text}
+	rest := text
+	for {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			break
+		}
+		t.addLiteral(rest[:start])
+
+		expr, n, err := parseExpression(rest[start+1:])
+		if err != nil {
+			return nil, fmt.Errorf("principal template %q: %w", text, err)
+		}
+		if expr == nil {
+			t.addLiteral(rest[start : start+1+n])
+		} else {
+			t.parts = append(t.parts, templatePart{expr: expr})
+		}
+		rest = rest[start+1+n:]
+	}
+	t.addLiteral(rest)
+	return t, nil
+}
+
+func (t *Template) addLiteral(s string) {
+	if s == "" {
+		return
+	}
+	if n := len(t.parts); n > 0 && t.parts[n-1].expr == nil {
+		t.parts[n-1].literal += s
+		return
+	}
+	t.parts = append(t.parts, templatePart{literal: s})
+}
+
+func (t *Template) String() string {
+	return t.text
+}
+
+// Uses reports whether the template references a variable (e.g., "principal")
+func (t *Template) Uses(name string) bool {
+	for _, part := range t.parts {
+		if part.expr != nil && part.expr.source == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseExpression parses s after a "{" up to its closing "}", returning the bytes consumed (including "}")
+// expr is nil for braces that aren't an expression, such as "{missing}"
+func parseExpression(s string) (expr *expression, n int, err error) {
+	tokens, n, err := tokenize(s)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(tokens) == 0 || tokens[0].quoted || tokens[0].text == "|" {
+		return nil, 0, fmt.Errorf("{%s: expected a variable or claim.NAME", s[:n])
+	}
+
+	source := tokens[0].text
+	known := strings.HasPrefix(source, "claim.") && len(source) > len("claim.")
+	for _, name := range templateVars {
+		known = known || source == name
+	}
+	if !known {
+		if len(tokens) == 1 {
+			return nil, n, nil
+		}
+		return nil, 0, fmt.Errorf("unknown variable %q (use %s or claim.NAME)", source, strings.Join(templateVars, ", "))
+	}
+
+	expr = &expression{source: source}
+	for i := 1; i < len(tokens); {
+		if tokens[i].text != "|" || tokens[i].quoted {
+			return nil, 0, fmt.Errorf("{%s: expected | before %q", s[:n], tokens[i].text)
+		}
+		i++
+		if i == len(tokens) || tokens[i].quoted {
+			return nil, 0, fmt.Errorf("{%s: expected a function after |", s[:n])
+		}
+		name := tokens[i].text
+		i++
+		var args []string
+		for ; i < len(tokens) && tokens[i].quoted; i++ {
+			args = append(args, tokens[i].text)
+		}
+		fn, err := templateFunc(name, args)
+		if err != nil {
+			return nil, 0, err
+		}
+		expr.funcs = append(expr.funcs, fn)
+	}
+	return expr, n, nil
+}
+
+type token struct {
+	text   string
+	quoted bool
+}
+
+// tokenize splits an expression into words, "|" and quoted strings (with Go escapes) up to the closing "}"
+func tokenize(s string) ([]token, int, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == '}':
+			return tokens, i + 1, nil
+		case c == ' ' || c == '\t':
+			i++
+		case c == '|':
+			tokens = append(tokens, token{text: "|"})
+			i++
+		case c == '"':
+			end := i + 1
+			for ; end < len(s) && s[end] != '"'; end++ {
+				if s[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(s) {
+				return nil, 0, fmt.Errorf("unterminated string in {%s", s)
+			}
+			text, err := strconv.Unquote(s[i : end+1])
+			if err != nil {
+				return nil, 0, fmt.Errorf("invalid string %s: %w", s[i:end+1], err)
+			}
+			tokens = append(tokens, token{text: text, quoted: true})
+			i = end + 1
+		default:
+			end := i
+			for end < len(s) && !strings.ContainsRune(" \t|\"}", rune(s[end])) {
+				end++
+			}
+			tokens = append(tokens, token{text: s[i:end]})
+			i = end
+		}
+	}
+	return nil, 0, fmt.Errorf("unclosed { in {%s", s)
+}
+
+func templateFunc(name string, args []string) (func(string) string, error) {
+	want := map[string]int{"lower": 0, "upper": 0, "prefix": 1, "suffix": 1, "replace": 2}
+	n, ok := want[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q (use lower, upper, prefix, suffix or replace)", name)
+	}
+	if len(args) != n {
+		return nil, fmt.Errorf("%s takes %d quoted argument(s), got %d", name, n, len(args))
+	}
+
+	switch name {
+	case "lower":
+		return strings.ToLower, nil
+	case "upper":
+		return strings.ToUpper, nil
+	case "prefix":
+		return func(s string) string { return args[0] + s }, nil
+	case "suffix":
+		return func(s string) string { return s + args[0] }, nil
+	default:
+		re, err := regexp.Compile(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("replace: %w", err)
+		}
+		return func(s string) string { return re.ReplaceAllString(s, args[1]) }, nil
+	}
+}
+
+// Expand builds the template's principals for user, with login as {principal}
+// Array claims give one principal per element (every combination, for several); empty results are dropped
+func (t *Template) Expand(user User, login string) []string {
+	results := []string{""}
+	for _, part := range t.parts {
+		if part.expr == nil {
+			for i := range results {
+				results[i] += part.literal
+			}
+			continue
+		}
+
+		var values []string
+		for _, v := range part.expr.values(user, login) {
+			for _, fn := range part.expr.funcs {
+				v = fn(v)
+			}
+			if v != "" {
+				values = append(values, v)
+			}
+		}
+
+		var next []string
+		for _, prefix := range results {
+			for _, v := range values {
+				next = append(next, prefix+v)
+			}
+		}
+		results = next
+	}
+
+	var out []string
+	for _, r := range results {
+		if r != "" {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+func (e *expression) values(user User, login string) []string {
+	var v string
+	switch e.source {
+	case "principal":
+		v = login
+	case "email":
+		v = user.Email
+	case "username":
+		v = user.Username
+	case "name":
+		v = user.Name
+	case "sub":
+		v = user.Subject
+	default:
+		return ClaimValues(user.Claims, strings.TrimPrefix(e.source, "claim."))
+	}
+	if v == "" {
+		return nil
+	}
+	return []string{v}
+}
+
+// ClaimValues reads a claim as strings
+// path may be nested ("github.login"), index arrays ("emails.0") or pick a field of every element ("accounts.login")
+// A key containing dots (e.g., "https://corp.com/claims") matches as a whole before being split
+func ClaimValues(claims map[string]interface{}, path string) []string {
+	return claimValues(claims, path)
+}
+
+func claimValues(v interface{}, path string) []string {
+	if path == "" {
+		return scalarStrings(v)
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if child, ok := v[path]; ok {
+			return scalarStrings(child)
+		}
+		for i := 0; i < len(path); i++ {
+			if path[i] != '.' {
+				continue
+			}
+			if child, ok := v[path[:i]]; ok {
+				if out := claimValues(child, path[i+1:]); len(out) > 0 {
+					return out
+				}
+			}
+		}
+	case []interface{}:
+		segment, rest, _ := strings.Cut(path, ".")
+		if idx, err := strconv.Atoi(segment); err == nil {
+			if idx >= 0 && idx < len(v) {
+				return claimValues(v[idx], rest)
+			}
+			return nil
+		}
+		var out []string
+		for _, item := range v {
+			out = append(out, claimValues(item, path)...)
+		}
+		return out
+	}
+	return nil
+}
+
+// scalarStrings formats a string, number or boolean claim, or an array of them
+func scalarStrings(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		if v != "" {
+			return []string{v}
+		}
+	case []string:
+		return v
+	case float64:
+		return []string{strconv.FormatFloat(v, 'f', -1, 64)}
+	case json.Number:
+		return []string{v.String()}
+	case bool:
+		return []string{strconv.FormatBool(v)}
+	case []interface{}:
+		var out []string
+		for _, item := range v {
+			if _, nested := item.([]interface{}); !nested {
+				out = append(out, scalarStrings(item)...)
+			}
+		}
+		return out
+	}
+	return nil
+}
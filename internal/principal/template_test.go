@@ -0,0 +1,115 @@
+package principal
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func testClaims(t *testing.T) map[string]interface{} {
+	t.Helper()
+	var claims map[string]interface{}
+	err := json.Unmarshal([]byte(`{
+		"upn": "Alice.Smith@Corp.com",
+		"employee_id": 4021,
+		"admin": true,
+		"groups": ["eng", "ops"],
+		"github": {"login": "alice-gh", "orgs": [{"name": "acme"}, {"name": "tools"}]},
+		"https://corp.com/claims": {"team": "infra"},
+		"empty": ""
+	}`), &claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return claims
+}
+
+func TestClaimValues(t *testing.T) {
+	claims := testClaims(t)
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"upn", []string{"Alice.Smith@Corp.com"}},
+		{"employee_id", []string{"4021"}},
+		{"admin", []string{"true"}},
+		{"groups", []string{"eng", "ops"}},
+		{"groups.1", []string{"ops"}},
+		{"groups.5", nil},
+		{"github.login", []string{"alice-gh"}},
+		{"github.orgs.name", []string{"acme", "tools"}},
+		{"github.orgs.0.name", []string{"acme"}},
+		{"https://corp.com/claims.team", []string{"infra"}},
+		{"empty", nil},
+		{"missing", nil},
+		{"github.missing", nil},
+	}
+	for _, tt := range tests {
+		if got := ClaimValues(claims, tt.path); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ClaimValues(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestTemplateExpand(t *testing.T) {
+	user := User{
+		Subject:  "00u1",
+		Email:    "alice@corp.com",
+		Name:     "Alice Smith",
+		Username: "alice.smith@corp.onmicrosoft.com",
+		Claims:   testClaims(t),
+	}
+	tests := []struct {
+		template string
+		want     []string
+	}{
+		{"deploy", []string{"deploy"}},
+		{"{principal}", []string{"alice_corp"}},
+		{"{sub}/{email}", []string{"00u1/alice@corp.com"}},
+		{"{name | lower | replace \" \" \".\"}", []string{"alice.smith"}},
+		{"{username | upper}", []string{"ALICE.SMITH@CORP.ONMICROSOFT.COM"}},
+		{"{claim.upn | lower | replace \"^([^@]+)@.*$\" \"$1\" | prefix \"u-\" | suffix \"_corp\"}", []string{"u-alice.smith_corp"}},
+		{"{claim.upn | replace \"[a-z]{3}\" \"x\"}", []string{"Axe.Sxh@Cx.x"}},
+		{"team-{claim.groups}", []string{"team-eng", "team-ops"}},
+		{"{claim.groups}@{claim.github.orgs.name}", []string{"eng@acme", "eng@tools", "ops@acme", "ops@tools"}},
+		{"emp-{claim.employee_id}", []string{"emp-4021"}},
+		{"ci-{claim.missing}", nil},
+		{"{claim.empty}", nil},
+		{"{email | replace \".*\" \"\"}", nil},
+		{"{missing}", []string{"{missing}"}},
+		{"literal {braces} and {principal}", []string{"literal {braces} and alice_corp"}},
+	}
+	for _, tt := range tests {
+		tmpl, err := ParseTemplate(tt.template)
+		if err != nil {
+			t.Fatalf("ParseTemplate(%q) error = %v", tt.template, err)
+		}
+		if got := tmpl.Expand(user, "alice_corp"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseTemplate(%q).Expand() = %v, want %v", tt.template, got, tt.want)
+		}
+	}
+}
+
+func TestParseTemplateInvalid(t *testing.T) {
+	tests := []string{
+		"{email",
+		"{}",
+		"{| lower}",
+		"{\"email\"}",
+		"{email lower}",
+		"{email |}",
+		"{email | shout}",
+		"{email | lower \"x\"}",
+		"{email | suffix}",
+		"{email | replace \"x\"}",
+		"{email | replace \"(\" \"\"}",
+		"{email | suffix \"unterminated}",
+		"{nope | lower}",
+		"{claim. | lower}",
+	}
+	for _, text := range tests {
+		if _, err := ParseTemplate(text); err == nil {
+			t.Errorf("ParseTemplate(%q) should fail", text)
+		}
+	}
+}
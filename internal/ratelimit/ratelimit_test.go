@@ -0,0 +1,99 @@
+package ratelimit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// newTestLimiter returns a limiter with a controllable clock
+func newTestLimiter(perMinute, burst int) (*Limiter, *time.Time) {
+	now := time.Date(2025, 1, 7, 10, 0, 0, 0, time.UTC)
+	l := New(perMinute, burst)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestAllow(t *testing.T) {
+	l, now := newTestLimiter(6, 3) // One token every 10s
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("10.0.0.1"); !ok {
+			t.Fatalf("request %d within the burst was limited", i+1)
+		}
+	}
+	ok, wait := l.Allow("10.0.0.1")
+	if ok || wait != 10*time.Second {
+		t.Fatalf("Allow() after the burst = %v, %v, want false, 10s", ok, wait)
+	}
+
+	// Other keys have their own bucket
+	if ok, _ := l.Allow("10.0.0.2"); !ok {
+		t.Error("a different key was limited")
+	}
+
+	// Tokens refill over time
+	*now = now.Add(4 * time.Second)
+	if ok, wait := l.Allow("10.0.0.1"); ok || wait != 6*time.Second {
+		t.Errorf("Allow() after 4s = %v, %v, want false, 6s", ok, wait)
+	}
+	*now = now.Add(6 * time.Second)
+	if ok, _ := l.Allow("10.0.0.1"); !ok {
+		t.Error("Allow() after a token refilled was limited")
+	}
+	if ok, _ := l.Allow("10.0.0.1"); ok {
+		t.Error("Allow() took more tokens than refilled")
+	}
+
+	// Refilling stops at the burst
+	*now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("10.0.0.1"); !ok {
+			t.Fatalf("request %d after an hour was limited", i+1)
+		}
+	}
+	if ok, _ := l.Allow("10.0.0.1"); ok {
+		t.Error("burst grew past its size while idle")
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	var l *Limiter = New(0, 10)
+	if l != nil {
+		t.Fatal("New(0, 10) should return nil")
+	}
+	for i := 0; i < 100; i++ {
+		if ok, _ := l.Allow("10.0.0.1"); !ok {
+			t.Fatal("a nil limiter limited a request")
+		}
+	}
+	if l.Len() != 0 {
+		t.Errorf("Len() = %d, want 0", l.Len())
+	}
+}
+
+func TestMaxKeys(t *testing.T) {
+	l, now := newTestLimiter(60, 1)
+	l.maxKeys = 10
+
+	for i := 0; i < 10; i++ {
+		l.Allow(fmt.Sprintf("10.0.0.%d", i))
+	}
+
+	// Refilled buckets are dropped first
+	*now = now.Add(time.Second)
+	if ok, _ := l.Allow("10.0.1.1"); !ok {
+		t.Error("a new key was limited")
+	}
+	if l.Len() != 1 {
+		t.Errorf("Len() = %d, want 1 after evicting refilled buckets", l.Len())
+	}
+
+	// With every bucket still empty, the map stays at its cap
+	for i := 0; i < 50; i++ {
+		l.Allow(fmt.Sprintf("10.0.2.%d", i))
+	}
+	if l.Len() > 10 {
+		t.Errorf("Len() = %d, want at most 10", l.Len())
+	}
+}
@@ -0,0 +1,141 @@
+// Implements OpenSSH detached signatures (SSHSIG)
+// Signatures are compatible with `ssh-keygen -Y sign` / `ssh-keygen -Y verify`
+// See: https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.sshsig
+package sshsig
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	magicPreamble = "SSHSIG"
+	sigVersion    = 1
+	hashAlgorithm = "sha512"
+	pemType       = "SSH SIGNATURE"
+)
+
+// signedData is the blob that is actually signed by the key
+type signedData struct {
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Hash          string
+}
+
+// wrapper is the serialized signature (after the magic preamble)
+type wrapper struct {
+	Version       uint32
+	PublicKey     string
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Signature     string
+}
+
+// Sign creates an armored SSHSIG signature over message
+// The namespace binds the signature to a purpose (e.g., "cassh-krl") so it can't be replayed elsewhere
+func Sign(signer ssh.Signer, namespace string, message []byte) ([]byte, error) {
+	if namespace == "" {
+		return nil, fmt.Errorf("namespace is required")
+	}
+
+	data := buildSignedData(namespace, message)
+
+	var sig *ssh.Signature
+	var err error
+	// RSA keys must use SHA-2 signatures; ssh-rsa (SHA-1) is rejected by ssh-keygen
+	if algSigner, ok := signer.(ssh.AlgorithmSigner); ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		sig, err = algSigner.SignWithAlgorithm(rand.Reader, data, ssh.KeyAlgoRSASHA512)
+	} else {
+		sig, err = signer.Sign(rand.Reader, data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+
+	blob := append([]byte(magicPreamble), ssh.Marshal(wrapper{
+		Version:       sigVersion,
+		PublicKey:     string(signer.PublicKey().Marshal()),
+		Namespace:     namespace,
+		HashAlgorithm: hashAlgorithm,
+		Signature:     string(ssh.Marshal(sig)),
+	})...)
+
+	return armor(blob), nil
+}
+
+// Verify checks an armored SSHSIG signature over message
+// Returns the public key that made the signature; callers must check that it is trusted
+func Verify(armored []byte, namespace string, message []byte) (ssh.PublicKey, error) {
+	block, _ := pem.Decode(bytes.TrimSpace(armored))
+	if block == nil || block.Type != pemType {
+		return nil, fmt.Errorf("not an SSH signature")
+	}
+
+	blob := block.Bytes
+	if !bytes.HasPrefix(blob, []byte(magicPreamble)) {
+		return nil, fmt.Errorf("invalid signature preamble")
+	}
+
+	var w wrapper
+	if err := ssh.Unmarshal(blob[len(magicPreamble):], &w); err != nil {
+		return nil, fmt.Errorf("failed to parse signature: %w", err)
+	}
+	if w.Version != sigVersion {
+		return nil, fmt.Errorf("unsupported signature version %d", w.Version)
+	}
+	if w.Namespace != namespace {
+		return nil, fmt.Errorf("signature namespace %q does not match %q", w.Namespace, namespace)
+	}
+	if w.HashAlgorithm != hashAlgorithm {
+		return nil, fmt.Errorf("unsupported hash algorithm %q", w.HashAlgorithm)
+	}
+
+	pub, err := ssh.ParsePublicKey([]byte(w.PublicKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key: %w", err)
+	}
+
+	var sig ssh.Signature
+	if err := ssh.Unmarshal([]byte(w.Signature), &sig); err != nil {
+		return nil, fmt.Errorf("failed to parse signature blob: %w", err)
+	}
+
+	if err := pub.Verify(buildSignedData(namespace, message), &sig); err != nil {
+		return nil, fmt.Errorf("signature verification failed: %w", err)
+	}
+
+	return pub, nil
+}
+
+func buildSignedData(namespace string, message []byte) []byte {
+	hash := sha512.Sum512(message)
+	return append([]byte(magicPreamble), ssh.Marshal(signedData{
+		Namespace:     namespace,
+		HashAlgorithm: hashAlgorithm,
+		Hash:          string(hash[:]),
+	})...)
+}
+
+// armor wraps the signature blob at 70 columns, matching ssh-keygen output
+func armor(blob []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(blob)
+
+	var buf bytes.Buffer
+	buf.WriteString("-----BEGIN SSH SIGNATURE-----\n")
+	for len(encoded) > 70 {
+		buf.WriteString(encoded[:70])
+		buf.WriteByte('\n')
+		encoded = encoded[70:]
+	}
+	buf.WriteString(encoded)
+	buf.WriteString("\n-----END SSH SIGNATURE-----\n")
+	return buf.Bytes()
+}
@@ -0,0 +1,101 @@
+package sshsig
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	return signer
+}
+
+func TestSignVerify(t *testing.T) {
+	signer := newTestSigner(t)
+	message := []byte("hello world")
+
+	sig, err := Sign(signer, "test", message)
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	if !strings.HasPrefix(string(sig), "-----BEGIN SSH SIGNATURE-----\n") {
+		t.Errorf("signature not armored: %q", sig)
+	}
+
+	pub, err := Verify(sig, "test", message)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if string(pub.Marshal()) != string(signer.PublicKey().Marshal()) {
+		t.Error("Verify() returned a different public key")
+	}
+}
+
+func TestSignVerifyRSA(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+
+	sig, err := Sign(signer, "test", []byte("data"))
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	if _, err := Verify(sig, "test", []byte("data")); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	signer := newTestSigner(t)
+	message := []byte("hello world")
+
+	sig, err := Sign(signer, "test", message)
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		sig       []byte
+		namespace string
+		message   []byte
+	}{
+		{"Wrong namespace", sig, "other", message},
+		{"Tampered message", sig, "test", []byte("hello world!")},
+		{"Not a signature", []byte("garbage"), "test", message},
+		{"Empty", nil, "test", message},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Verify(tt.sig, tt.namespace, tt.message); err == nil {
+				t.Error("Verify() expected error")
+			}
+		})
+	}
+}
+
+func TestSignRequiresNamespace(t *testing.T) {
+	if _, err := Sign(newTestSigner(t), "", []byte("data")); err == nil {
+		t.Error("Sign() expected error for empty namespace")
+	}
+}
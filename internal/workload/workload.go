@@ -0,0 +1,166 @@
+// Verifies workload identity tokens (GitHub Actions, GitLab CI, Kubernetes service accounts)
+// CI jobs present a JWT from a trusted issuer instead of a human SSO login
+package workload
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+)
+
+// DefaultMaxValidity caps workload certs when an issuer doesn't set max_validity_minutes
+const DefaultMaxValidity = 15 * time.Minute
+
+// ErrUnknownIssuer is returned for tokens whose iss isn't a configured workload issuer
+var ErrUnknownIssuer = errors.New("token is not from a trusted workload issuer")
+
+// IssuerConfig is a [[workload.issuers]] entry
+type IssuerConfig struct {
+	Name     string `toml:"name"`     // Referenced by issuance rules (e.g., "github-actions")
+	Issuer   string `toml:"issuer"`   // e.g., https://token.actions.githubusercontent.com
+	Audience string `toml:"audience"` // Required aud claim; have CI request tokens for this audience
+
+	// JWKS to verify signatures with; empty uses OIDC discovery on Issuer
+	// Set it for Kubernetes clusters whose discovery document isn't reachable
+	JWKSURL string `toml:"jwks_url"`
+
+	// Upper bound on cert lifetime (default 15)
+	MaxValidityMinutes int `toml:"max_validity_minutes"`
+}
+
+// Identity is a verified workload
+type Identity struct {
+	Issuer      string // Name of the trusted issuer
+	Subject     string // sub claim (e.g., "repo:org/repo:ref:refs/heads/main")
+	Claims      map[string]interface{}
+	MaxValidity time.Duration
+}
+
+// Verifier checks tokens against the configured workload issuers
+type Verifier struct {
+	issuers map[string]*issuer // By issuer URL
+}
+
+type issuer struct {
+	cfg      IssuerConfig
+	verifier *oidc.IDTokenVerifier
+}
+
+// NewVerifier validates cfgs and sets up key sets for each issuer
+// Issuers without a jwks_url are discovered now; ctx must outlive the Verifier since keys are refreshed with it
+func NewVerifier(ctx context.Context, cfgs []IssuerConfig) (*Verifier, error) {
+	v := &Verifier{issuers: make(map[string]*issuer)}
+	names := make(map[string]bool)
+
+	for _, cfg := range cfgs {
+		if err := cfg.validate(); err != nil {
+			return nil, err
+		}
+		if names[cfg.Name] {
+			return nil, fmt.Errorf("workload issuer %q is defined twice", cfg.Name)
+		}
+		if _, exists := v.issuers[cfg.Issuer]; exists {
+			return nil, fmt.Errorf("workload issuer %q: issuer %s is already configured", cfg.Name, cfg.Issuer)
+		}
+		names[cfg.Name] = true
+
+		oidcConfig := &oidc.Config{ClientID: cfg.Audience}
+		var verifier *oidc.IDTokenVerifier
+		if cfg.JWKSURL != "" {
+			verifier = oidc.NewVerifier(cfg.Issuer, oidc.NewRemoteKeySet(ctx, cfg.JWKSURL), oidcConfig)
+		} else {
+			provider, err := oidc.NewProvider(ctx, cfg.Issuer)
+			if err != nil {
+				return nil, fmt.Errorf("workload issuer %q: discovery failed: %w", cfg.Name, err)
+			}
+			verifier = provider.Verifier(oidcConfig)
+		}
+
+		v.issuers[cfg.Issuer] = &issuer{cfg: cfg, verifier: verifier}
+	}
+
+	return v, nil
+}
+
+func (c *IssuerConfig) validate() error {
+	switch {
+	case c.Name == "":
+		return fmt.Errorf("workload issuer %s: name is required", c.Issuer)
+	case c.Issuer == "":
+		return fmt.Errorf("workload issuer %q: issuer is required", c.Name)
+	case c.Audience == "":
+		// Without an audience, any token from a shared issuer (every GitHub repo) would be accepted
+		return fmt.Errorf("workload issuer %q: audience is required", c.Name)
+	case c.MaxValidityMinutes < 0:
+		return fmt.Errorf("workload issuer %q: max_validity_minutes must not be negative", c.Name)
+	}
+	return nil
+}
+
+// Enabled reports whether any workload issuers are configured
+func (v *Verifier) Enabled() bool {
+	return v != nil && len(v.issuers) > 0
+}
+
+// Verify checks a raw JWT's signature, issuer, audience and expiry
+func (v *Verifier) Verify(ctx context.Context, rawToken string) (*Identity, error) {
+	iss, err := unverifiedIssuer(rawToken)
+	if err != nil {
+		return nil, err
+	}
+
+	issuer, ok := v.issuers[iss]
+	if !ok {
+		return nil, ErrUnknownIssuer
+	}
+
+	token, err := issuer.verifier.Verify(ctx, rawToken)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s token: %w", issuer.cfg.Name, err)
+	}
+
+	var claims map[string]interface{}
+	if err := token.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("failed to parse claims: %w", err)
+	}
+
+	maxValidity := DefaultMaxValidity
+	if issuer.cfg.MaxValidityMinutes > 0 {
+		maxValidity = time.Duration(issuer.cfg.MaxValidityMinutes) * time.Minute
+	}
+
+	return &Identity{
+		Issuer:      issuer.cfg.Name,
+		Subject:     token.Subject,
+		Claims:      claims,
+		MaxValidity: maxValidity,
+	}, nil
+}
+
+// unverifiedIssuer reads iss from a JWT payload to pick the issuer to verify against
+// The value is only trusted after the matching verifier has checked the signature
+func unverifiedIssuer(rawToken string) (string, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("malformed token")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("malformed token payload: %w", err)
+	}
+
+	var claims struct {
+		Issuer string `json:"iss"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", fmt.Errorf("malformed token payload: %w", err)
+	}
+	return claims.Issuer, nil
+}
@@ -0,0 +1,226 @@
+package workload
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// mockIssuer serves discovery and JWKS, and signs tokens like a CI provider
+type mockIssuer struct {
+	*httptest.Server
+	t   *testing.T
+	key *rsa.PrivateKey
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() error = %v", err)
+	}
+
+	m := &mockIssuer{t: t, key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"issuer":                                m.URL,
+			"jwks_uri":                              m.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "ci-key",
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+			}},
+		})
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+
+	return m
+}
+
+// sign returns an RS256 JWT; iss, aud, iat and exp default to a valid token for audience "cassh"
+func (m *mockIssuer) sign(claims map[string]interface{}) string {
+	m.t.Helper()
+
+	now := time.Now()
+	full := map[string]interface{}{
+		"iss": m.URL,
+		"aud": "cassh",
+		"iat": now.Unix(),
+		"exp": now.Add(5 * time.Minute).Unix(),
+	}
+	for k, v := range claims {
+		full[k] = v
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": "ci-key"})
+	payload, err := json.Marshal(full)
+	if err != nil {
+		m.t.Fatalf("json.Marshal() error = %v", err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, digest[:])
+	if err != nil {
+		m.t.Fatalf("rsa.SignPKCS1v15() error = %v", err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	ci := newMockIssuer(t)
+
+	v, err := NewVerifier(ctx, []IssuerConfig{{Name: "github-actions", Issuer: ci.URL, Audience: "cassh"}})
+	if err != nil {
+		t.Fatalf("NewVerifier() error = %v", err)
+	}
+
+	id, err := v.Verify(ctx, ci.sign(map[string]interface{}{
+		"sub":        "repo:acme/api:ref:refs/heads/main",
+		"repository": "acme/api",
+		"ref":        "refs/heads/main",
+	}))
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+
+	if id.Issuer != "github-actions" {
+		t.Errorf("Issuer = %q, want %q", id.Issuer, "github-actions")
+	}
+	if id.Subject != "repo:acme/api:ref:refs/heads/main" {
+		t.Errorf("Subject = %q", id.Subject)
+	}
+	if id.Claims["repository"] != "acme/api" {
+		t.Errorf("Claims[repository] = %v, want acme/api", id.Claims["repository"])
+	}
+	if id.MaxValidity != DefaultMaxValidity {
+		t.Errorf("MaxValidity = %v, want %v", id.MaxValidity, DefaultMaxValidity)
+	}
+}
+
+func TestVerifyJWKSURL(t *testing.T) {
+	ctx := context.Background()
+	ci := newMockIssuer(t)
+
+	// Kubernetes-style: the issuer URL isn't served, keys come from jwks_url
+	v, err := NewVerifier(ctx, []IssuerConfig{{
+		Name:               "k8s",
+		Issuer:             "https://kubernetes.default.svc",
+		Audience:           "cassh",
+		JWKSURL:            ci.URL + "/keys",
+		MaxValidityMinutes: 5,
+	}})
+	if err != nil {
+		t.Fatalf("NewVerifier() error = %v", err)
+	}
+
+	id, err := v.Verify(ctx, ci.sign(map[string]interface{}{
+		"iss": "https://kubernetes.default.svc",
+		"sub": "system:serviceaccount:deploy:runner",
+	}))
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if id.MaxValidity != 5*time.Minute {
+		t.Errorf("MaxValidity = %v, want 5m", id.MaxValidity)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	ctx := context.Background()
+	ci := newMockIssuer(t)
+	other := newMockIssuer(t)
+
+	v, err := NewVerifier(ctx, []IssuerConfig{{Name: "github-actions", Issuer: ci.URL, Audience: "cassh"}})
+	if err != nil {
+		t.Fatalf("NewVerifier() error = %v", err)
+	}
+
+	// A token claiming ci's issuer but signed by another key
+	forged := other.sign(map[string]interface{}{"iss": ci.URL, "sub": "x"})
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"wrong audience", ci.sign(map[string]interface{}{"sub": "x", "aud": "someone-else"}), nil},
+		{"expired", ci.sign(map[string]interface{}{"sub": "x", "exp": time.Now().Add(-time.Minute).Unix()}), nil},
+		{"untrusted issuer", other.sign(map[string]interface{}{"sub": "x"}), ErrUnknownIssuer},
+		{"forged signature", forged, nil},
+		{"malformed", "not-a-jwt", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.Verify(ctx, tt.token)
+			if err == nil {
+				t.Fatal("Verify() error = nil, want error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewVerifierValidation(t *testing.T) {
+	valid := IssuerConfig{Name: "ci", Issuer: "https://ci.example.com", Audience: "cassh", JWKSURL: "https://ci.example.com/keys"}
+
+	tests := []struct {
+		name string
+		cfgs []IssuerConfig
+	}{
+		{"missing name", []IssuerConfig{{Issuer: "https://ci.example.com", Audience: "cassh", JWKSURL: "https://x"}}},
+		{"missing issuer", []IssuerConfig{{Name: "ci", Audience: "cassh", JWKSURL: "https://x"}}},
+		{"missing audience", []IssuerConfig{{Name: "ci", Issuer: "https://ci.example.com", JWKSURL: "https://x"}}},
+		{"negative validity", []IssuerConfig{{Name: "ci", Issuer: "https://ci.example.com", Audience: "cassh", JWKSURL: "https://x", MaxValidityMinutes: -1}}},
+		{"duplicate name", []IssuerConfig{valid, {Name: "ci", Issuer: "https://other.example.com", Audience: "cassh", JWKSURL: "https://x"}}},
+		{"duplicate issuer", []IssuerConfig{valid, {Name: "ci2", Issuer: valid.Issuer, Audience: "cassh", JWKSURL: "https://x"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewVerifier(context.Background(), tt.cfgs); err == nil {
+				t.Error("NewVerifier() error = nil, want error")
+			}
+		})
+	}
+
+	v, err := NewVerifier(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("NewVerifier(nil) error = %v", err)
+	}
+	if v.Enabled() {
+		t.Error("Enabled() = true with no issuers")
+	}
+}